@@ -0,0 +1,153 @@
+// Command mongoutils manages MongoDB databases using the go-mongoutils
+// packages, such as applying the manifests of collections and indexes, and
+// exporting and importing collections.
+//
+// Usage:
+//
+//	mongoutils <command> [flags]
+//
+// The Client is configured using the config-package, from the files
+// specified with "-config", and the environment-variables prefixed with
+// "MONGO_" (such as MONGO_HOSTS), or the connection-string set with "-uri".
+// Run "mongoutils <command> -h" for the command's flags.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/config"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+)
+
+// The exit-codes for commands.
+const (
+	exitOK = 0
+	// exitFailure indicates that the command ran, but its result requires
+	// attention, such as differences found by "diff".
+	exitFailure = 1
+	exitError   = 2
+)
+
+// command is a sub-command, such as "plan".
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout io.Writer) (int, error)
+}
+
+func commands() []command {
+	return []command{
+		{"plan", "Show the changes required to apply the manifest", runPlan},
+		{"apply", "Apply the manifest to server", runApply},
+		{"diff", "Show the differences between manifest and server", runDiff},
+		{"export", "Export a collection as Extended JSON, CSV, or BSON", runExport},
+		{"import", "Import documents into a collection", runImport},
+	}
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return exitError
+	}
+	for _, cmd := range commands() {
+		if cmd.name != args[0] {
+			continue
+		}
+		code, err := cmd.run(args[1:], stdout)
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "mongoutils %s: %s\n", cmd.name, err)
+		}
+		return code
+	}
+
+	fmt.Fprintf(stderr, "mongoutils: unknown command: %s\n", args[0])
+	usage(stderr)
+	return exitError
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: mongoutils <command> [flags]")
+	fmt.Fprintln(w, "\nCommands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+}
+
+// stringsFlag is a repeatable flag, such as: -f a.yaml -f b.yaml
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// connectionFlags are the flags for configuring the Client.
+type connectionFlags struct {
+	configFiles stringsFlag
+	envPrefix   string
+	uri         string
+	timeout     time.Duration
+}
+
+// newFlagSet creates the FlagSet for a command, along with the
+// connection-flags.
+func newFlagSet(name string) (*flag.FlagSet, *connectionFlags) {
+	flags := flag.NewFlagSet("mongoutils "+name, flag.ContinueOnError)
+	conn := &connectionFlags{}
+	flags.Var(
+		&conn.configFiles,
+		"config",
+		"Client config-file (YAML, JSON, or TOML), can be repeated",
+	)
+	flags.StringVar(
+		&conn.envPrefix,
+		"env-prefix",
+		"MONGO",
+		"Prefix of environment-variables for Client config",
+	)
+	flags.StringVar(&conn.uri, "uri", "", "Connection-string, such as mongodb://host")
+	flags.DurationVar(&conn.timeout, "timeout", time.Minute, "Timeout for the command")
+	return flags, conn
+}
+
+// connect creates the Client, and the context with command's timeout.
+func (f *connectionFlags) connect() (
+	*mongo.Client,
+	context.Context,
+	context.CancelFunc,
+	error,
+) {
+	settings, err := config.Load(config.Options{
+		Files:     f.configFiles,
+		EnvPrefix: f.envPrefix,
+		URI:       f.uri,
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	client, err := mongo.NewClient(settings.Client.ClientConfig())
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "Error Creating Client")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	return client, ctx, cancel, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/TerrexTech/go-mongoutils/manifest"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+)
+
+// manifestFlags are the flags shared by manifest-commands.
+type manifestFlags struct {
+	flags *flag.FlagSet
+	conn  *connectionFlags
+	files stringsFlag
+}
+
+func newManifestFlags(name string) *manifestFlags {
+	flags, conn := newFlagSet(name)
+	m := &manifestFlags{
+		flags: flags,
+		conn:  conn,
+	}
+	flags.Var(&m.files, "f", "Manifest-file (YAML or JSON), can be repeated")
+	return m
+}
+
+// manifestSession holds the connected Client, and the Plan for manifest.
+type manifestSession struct {
+	client *mongo.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	plan   *manifest.Plan
+}
+
+func (s *manifestSession) close() {
+	s.cancel()
+	s.client.Disconnect()
+}
+
+// load parses the flags, and creates the Plan for manifest.
+func (m *manifestFlags) load(args []string) (*manifestSession, error) {
+	err := m.flags.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.files) == 0 {
+		return nil, errors.New("at least one manifest-file is required (-f)")
+	}
+	mf, err := manifest.Load(m.files...)
+	if err != nil {
+		return nil, err
+	}
+
+	client, ctx, cancel, err := m.conn.connect()
+	if err != nil {
+		return nil, err
+	}
+	session := &manifestSession{
+		client: client,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	session.plan, err = manifest.NewPlan(ctx, client, mf)
+	if err != nil {
+		session.close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// changeSymbols prefix the Changes in output.
+var changeSymbols = map[manifest.Action]string{
+	manifest.ActionCreateCollection: "+",
+	manifest.ActionCreateIndex:      "+",
+	manifest.ActionUpdateValidator:  "~",
+	manifest.ActionRecreateIndex:    "~",
+	manifest.ActionDropIndex:        "-",
+	manifest.ActionConflict:         "!",
+}
+
+func printChange(w io.Writer, change manifest.Change) {
+	suffix := ""
+	if change.Destructive {
+		suffix = " (destructive)"
+	}
+	fmt.Fprintf(w, "%s %s%s\n", changeSymbols[change.Action], change, suffix)
+}
+
+// runPlan prints the Changes required to apply the manifest.
+func runPlan(args []string, stdout io.Writer) (int, error) {
+	session, err := newManifestFlags("plan").load(args)
+	if err != nil {
+		return exitError, err
+	}
+	defer session.close()
+	plan := session.plan
+
+	if len(plan.Changes) == 0 {
+		fmt.Fprintln(stdout, "No changes, the server matches the manifest.")
+		return exitOK, nil
+	}
+	for _, change := range plan.Changes {
+		printChange(stdout, change)
+	}
+	fmt.Fprintf(stdout, "\n%d change(s) planned.\n", len(plan.Changes))
+	return exitOK, nil
+}
+
+// runDiff prints the differences between manifest and server, and exits
+// with exitFailure if there are any, so it can be used to detect drift.
+func runDiff(args []string, stdout io.Writer) (int, error) {
+	session, err := newManifestFlags("diff").load(args)
+	if err != nil {
+		return exitError, err
+	}
+	defer session.close()
+	plan := session.plan
+
+	for _, change := range plan.Changes {
+		printChange(stdout, change)
+		if change.Actual != "" {
+			fmt.Fprintf(stdout, "    - server:   %s\n", change.Actual)
+		}
+		if change.Expected != "" {
+			fmt.Fprintf(stdout, "    + manifest: %s\n", change.Expected)
+		}
+	}
+	if len(plan.Changes) > 0 {
+		return exitFailure, nil
+	}
+	return exitOK, nil
+}
+
+// runApply applies the manifest, and exits with exitFailure if any
+// Changes were skipped.
+func runApply(args []string, stdout io.Writer) (int, error) {
+	m := newManifestFlags("apply")
+	allowDestructive := m.flags.Bool(
+		"allow-destructive",
+		false,
+		"Apply the changes that drop indexes",
+	)
+	session, err := m.load(args)
+	if err != nil {
+		return exitError, err
+	}
+	defer session.close()
+
+	opts := manifest.ApplyOptions{
+		AllowDestructive: *allowDestructive,
+		OnChange: func(change manifest.Change) {
+			printChange(stdout, change)
+		},
+	}
+	result, err := manifest.Apply(session.ctx, session.client, session.plan, opts)
+	if err != nil {
+		return exitError, err
+	}
+
+	for _, change := range result.Skipped {
+		fmt.Fprintf(stdout, "skipped: %s\n", change)
+	}
+	fmt.Fprintf(
+		stdout,
+		"\n%d change(s) applied, %d skipped.\n",
+		len(result.Applied),
+		len(result.Skipped),
+	)
+	if len(result.Skipped) > 0 {
+		return exitFailure, nil
+	}
+	return exitOK, nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/TerrexTech/go-mongoutils/extjson"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/TerrexTech/go-mongoutils/transfer"
+	"github.com/pkg/errors"
+)
+
+// collectionFlags are the flags shared by export and import.
+type collectionFlags struct {
+	flags      *flag.FlagSet
+	conn       *connectionFlags
+	database   string
+	collection string
+	format     string
+	file       string
+}
+
+func newCollectionFlags(name string, fileUsage string) *collectionFlags {
+	flags, conn := newFlagSet(name)
+	f := &collectionFlags{
+		flags: flags,
+		conn:  conn,
+	}
+	flags.StringVar(&f.database, "db", "", "Database-name")
+	flags.StringVar(&f.collection, "c", "", "Collection-name")
+	flags.StringVar(&f.format, "format", "json", "Format: json, csv, or bson")
+	flags.StringVar(&f.file, "file", "", fileUsage)
+	return f
+}
+
+// parse parses the flags, and validates the required ones.
+func (f *collectionFlags) parse(args []string) (transfer.Format, error) {
+	err := f.flags.Parse(args)
+	if err != nil {
+		return "", err
+	}
+	if f.database == "" || f.collection == "" {
+		return "", errors.New("database (-db) and collection (-c) are required")
+	}
+	return transfer.ParseFormat(f.format)
+}
+
+// ensureCollection creates the Collection for transfer. The collection has
+// no SchemaStruct, so the CSV-columns are specified using flags.
+func (f *collectionFlags) ensureCollection(client *mongo.Client) (
+	*mongo.Collection,
+	error,
+) {
+	return mongo.EnsureCollection(&mongo.Collection{
+		Connection: &mongo.ConnectionConfig{
+			Client:  client,
+			Timeout: uint32(f.conn.timeout.Seconds() * 1000),
+		},
+		Database:     f.database,
+		Name:         f.collection,
+		SchemaStruct: &struct{}{},
+	})
+}
+
+// runExport writes the documents to file, or to stdout if no file is
+// specified.
+func runExport(args []string, stdout io.Writer) (int, error) {
+	f := newCollectionFlags("export", "Output-file, defaults to stdout")
+	canonical := f.flags.Bool(
+		"canonical",
+		false,
+		"Write canonical Extended JSON, which retains the BSON-types",
+	)
+	filter := f.flags.String("filter", "", "Extended JSON filter, such as '{\"n\": 1}'")
+	fields := f.flags.String("fields", "", "Comma-separated CSV-columns")
+	format, err := f.parse(args)
+	if err != nil {
+		return exitError, err
+	}
+
+	opts := transfer.ExportOptions{
+		Format:    format,
+		Canonical: *canonical,
+	}
+	if *filter != "" {
+		opts.Filter, err = extjson.ParseDocument([]byte(*filter))
+		if err != nil {
+			return exitError, errors.Wrap(err, "invalid filter")
+		}
+	}
+	if *fields != "" {
+		opts.Columns = strings.Split(*fields, ",")
+	}
+
+	out := stdout
+	if f.file != "" {
+		file, err := os.Create(f.file)
+		if err != nil {
+			return exitError, err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	client, ctx, cancel, err := f.conn.connect()
+	if err != nil {
+		return exitError, err
+	}
+	defer client.Disconnect()
+	defer cancel()
+	c, err := f.ensureCollection(client)
+	if err != nil {
+		return exitError, err
+	}
+
+	count, err := transfer.Export(ctx, c, out, opts)
+	if err != nil {
+		return exitError, err
+	}
+	// The summary would be mixed with the documents on stdout
+	if f.file != "" {
+		fmt.Fprintf(stdout, "%d document(s) exported.\n", count)
+	}
+	return exitOK, nil
+}
+
+// runImport reads the documents from file, or from stdin if no file is
+// specified.
+func runImport(args []string, stdout io.Writer) (int, error) {
+	f := newCollectionFlags("import", "Input-file, defaults to stdin")
+	upsert := f.flags.Bool("upsert", false, "Replace the existing documents with same _id")
+	batchSize := f.flags.Int(
+		"batch-size",
+		transfer.DefaultBatchSize,
+		"Number of documents written per command",
+	)
+	format, err := f.parse(args)
+	if err != nil {
+		return exitError, err
+	}
+
+	var in io.Reader = os.Stdin
+	if f.file != "" {
+		file, err := os.Open(f.file)
+		if err != nil {
+			return exitError, err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	client, ctx, cancel, err := f.conn.connect()
+	if err != nil {
+		return exitError, err
+	}
+	defer client.Disconnect()
+	defer cancel()
+	c, err := f.ensureCollection(client)
+	if err != nil {
+		return exitError, err
+	}
+
+	result, err := transfer.Import(ctx, c, in, transfer.ImportOptions{
+		Format:    format,
+		Upsert:    *upsert,
+		BatchSize: *batchSize,
+	})
+	if result != nil {
+		fmt.Fprintf(
+			stdout,
+			"%d document(s) inserted, %d replaced.\n",
+			result.Inserted,
+			result.Replaced,
+		)
+	}
+	if err != nil {
+		return exitError, err
+	}
+	return exitOK, nil
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Options defines the sources to load configuration from.
+type Options struct {
+	// Files are the YAML (".yaml", ".yml"), JSON (".json"), or TOML (".toml")
+	// config-files. The same keys are used in all formats.
+	Files []string
+	// EnvPrefix is the prefix for environment-variables, such as "MONGO" for
+	// reading "MONGO_HOSTS". The environment is not read if this is empty.
+	EnvPrefix string
+	// URI is the connection-string, which overrides the URI from other sources.
+	URI string
+
+	// lookupEnv is replaced in tests. Defaults to os.LookupEnv.
+	lookupEnv func(key string) (string, bool)
+}
+
+// Load loads the Settings from the sources specified in Options.
+// A *ValidationError is returned listing all the problems found, such as
+// missing fields and unreadable files.
+func Load(opts Options) (*Settings, error) {
+	problems := []string{}
+	settings := &Settings{}
+
+	for _, path := range opts.Files {
+		fileSettings, err := readFile(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", path, err))
+			continue
+		}
+		mergeClient(&settings.Client, fileSettings.Client)
+		settings.Collections = mergeCollections(
+			settings.Collections,
+			fileSettings.Collections,
+		)
+	}
+
+	if opts.EnvPrefix != "" {
+		lookupEnv := opts.lookupEnv
+		if lookupEnv == nil {
+			lookupEnv = os.LookupEnv
+		}
+		envSettings, envProblems := readEnv(opts.EnvPrefix, lookupEnv)
+		problems = append(problems, envProblems...)
+		mergeClient(&settings.Client, envSettings)
+	}
+	if opts.URI != "" {
+		settings.Client.URI = opts.URI
+		settings.Client.URIFile = ""
+	}
+
+	problems = append(problems, resolveSecrets(&settings.Client)...)
+	if settings.Client.URI != "" {
+		uriSettings, uriProblems := parseURI(settings.Client.URI)
+		problems = append(problems, uriProblems...)
+		// Explicitly set fields override the URI
+		mergeClient(&uriSettings, settings.Client)
+		settings.Client = uriSettings
+	}
+
+	problems = append(problems, validate(settings)...)
+	if len(problems) > 0 {
+		return nil, &ValidationError{
+			Problems: problems,
+		}
+	}
+
+	for i := range settings.Collections {
+		if settings.Collections[i].TimeoutMilliseconds == 0 {
+			settings.Collections[i].TimeoutMilliseconds = settings.Client.TimeoutMilliseconds
+		}
+	}
+	return settings, nil
+}
+
+// readFile decodes the config-file using the format from its extension.
+// JSON is decoded as YAML, since YAML is a superset of JSON, and TOML is
+// converted to YAML so the same keys apply to all formats.
+func readFile(path string) (*Settings, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+	case ".toml":
+		tomlData := map[string]interface{}{}
+		_, err = toml.Decode(string(data), &tomlData)
+		if err != nil {
+			return nil, err
+		}
+		data, err = yaml.Marshal(tomlData)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown config-format: %s", filepath.Ext(path))
+	}
+
+	settings := &Settings{}
+	err = yaml.UnmarshalStrict(data, settings)
+	if err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// clientField is a ClientSettings-field with its name in environment-variables
+// (after the prefix) and the lowercase names of its URI-options.
+type clientField struct {
+	env   string
+	uri   []string
+	field interface{}
+}
+
+// clientFields returns the fields of the specified ClientSettings.
+func clientFields(s *ClientSettings) []clientField {
+	return []clientField{
+		{"URI", nil, &s.URI},
+		{"URI_FILE", nil, &s.URIFile},
+		{"HOSTS", nil, &s.Hosts},
+		{"USERNAME", nil, &s.Username},
+		{"USERNAME_FILE", nil, &s.UsernameFile},
+		{"PASSWORD", nil, &s.Password},
+		{"PASSWORD_FILE", nil, &s.PasswordFile},
+		{"TIMEOUT_MS", []string{"connecttimeoutms"}, &s.TimeoutMilliseconds},
+		{"AUTH_SOURCE", []string{"authsource"}, &s.AuthSource},
+		{"AUTH_MECHANISM", []string{"authmechanism"}, &s.AuthMechanism},
+		{"APP_NAME", []string{"appname"}, &s.AppName},
+		{"COMPRESSORS", []string{"compressors"}, &s.Compressors},
+		{
+			"MAX_POOL_SIZE",
+			[]string{"maxpoolsize", "maxconnsperhost"},
+			&s.MaxPoolSize,
+		},
+		{"MIN_POOL_SIZE", []string{"minpoolsize"}, &s.MinPoolSize},
+		{
+			"SERVER_SELECTION_TIMEOUT_MS",
+			[]string{"serverselectiontimeoutms"},
+			&s.ServerSelectionTimeoutMilliseconds,
+		},
+		{"TLS", []string{"tls", "ssl"}, &s.TLS},
+		{
+			"TLS_CA_FILE",
+			[]string{"tlscafile", "sslcertificateauthorityfile"},
+			&s.TLSCAFile,
+		},
+		{
+			"TLS_CERTIFICATE_KEY_FILE",
+			[]string{"tlscertificatekeyfile", "sslclientcertificatekeyfile"},
+			&s.TLSCertificateKeyFile,
+		},
+		{
+			"TLS_CERTIFICATE_KEY_PASSWORD",
+			[]string{
+				"tlscertificatekeyfilepassword",
+				"sslclientcertificatekeypassword",
+			},
+			&s.TLSCertificateKeyPassword,
+		},
+		{
+			"TLS_CERTIFICATE_KEY_PASSWORD_FILE",
+			nil,
+			&s.TLSCertificateKeyPasswordFile,
+		},
+		{"TLS_INSECURE", []string{"tlsinsecure", "sslinsecure"}, &s.TLSInsecure},
+	}
+}
+
+// readEnv reads the ClientSettings from environment-variables named
+// "<prefix>_<field>", such as "MONGO_HOSTS".
+func readEnv(
+	prefix string,
+	lookupEnv func(key string) (string, bool),
+) (ClientSettings, []string) {
+	problems := []string{}
+	settings := ClientSettings{}
+
+	for _, f := range clientFields(&settings) {
+		key := prefix + "_" + f.env
+		value, exists := lookupEnv(key)
+		if !exists || value == "" {
+			continue
+		}
+		err := setField(f.field, value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", key, err))
+		}
+	}
+	return settings, problems
+}
+
+// setField parses the value into the field-pointer.
+// Lists are comma-separated.
+func setField(field interface{}, value string) error {
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *[]string:
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				list = append(list, item)
+			}
+		}
+		*f = list
+	case *bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean: %s", value)
+		}
+		*f = b
+	case *uint16:
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid number: %s", value)
+		}
+		*f = uint16(n)
+	case *uint32:
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid number: %s", value)
+		}
+		*f = uint32(n)
+	default:
+		return fmt.Errorf("unsupported field-type: %T", field)
+	}
+	return nil
+}
+
+// resolveSecrets reads the "File"-suffixed fields into their respective
+// fields. The trailing newlines, which are usually added by editors, are
+// removed from the secrets.
+func resolveSecrets(s *ClientSettings) []string {
+	problems := []string{}
+	secrets := []struct {
+		name  string
+		file  *string
+		value *string
+	}{
+		{"client.uri", &s.URIFile, &s.URI},
+		{"client.username", &s.UsernameFile, &s.Username},
+		{"client.password", &s.PasswordFile, &s.Password},
+		{
+			"client.tlsCertificateKeyPassword",
+			&s.TLSCertificateKeyPasswordFile,
+			&s.TLSCertificateKeyPassword,
+		},
+	}
+
+	for _, secret := range secrets {
+		if *secret.file == "" {
+			continue
+		}
+		if *secret.value != "" {
+			problems = append(problems, fmt.Sprintf(
+				"%s and %sFile cannot both be set", secret.name, secret.name,
+			))
+			continue
+		}
+		data, err := ioutil.ReadFile(*secret.file)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%sFile: %s", secret.name, err))
+			continue
+		}
+		*secret.value = strings.TrimRight(string(data), "\r\n")
+		*secret.file = ""
+	}
+	return problems
+}
+
+// mergeClient sets the non-zero fields from src into dst.
+func mergeClient(dst *ClientSettings, src ClientSettings) {
+	dstValue := reflect.ValueOf(dst).Elem()
+	srcValue := reflect.ValueOf(src)
+	for i := 0; i < srcValue.NumField(); i++ {
+		field := srcValue.Field(i)
+		if !isZero(field) {
+			dstValue.Field(i).Set(field)
+		}
+	}
+}
+
+func isZero(value reflect.Value) bool {
+	if value.Kind() == reflect.Slice {
+		return value.Len() == 0
+	}
+	return value.Interface() == reflect.Zero(value.Type()).Interface()
+}
+
+// mergeCollections adds the Collections from src into dst, replacing the
+// Collections with same database and name.
+func mergeCollections(
+	dst []CollectionSettings,
+	src []CollectionSettings,
+) []CollectionSettings {
+	for _, srcColl := range src {
+		replaced := false
+		for i, dstColl := range dst {
+			if dstColl.Database == srcColl.Database && dstColl.Name == srcColl.Name {
+				dst[i] = srcColl
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			dst = append(dst, srcColl)
+		}
+	}
+	return dst
+}
+
+// validate returns the problems with missing or inconsistent fields.
+func validate(settings *Settings) []string {
+	problems := []string{}
+	client := settings.Client
+
+	if len(client.Hosts) == 0 {
+		problems = append(problems, "client.hosts is required")
+	}
+	if client.Password != "" && client.Username == "" {
+		problems = append(problems, "client.username is required with client.password")
+	}
+
+	for i, coll := range settings.Collections {
+		path := fmt.Sprintf("collections[%d]", i)
+		if coll.Database == "" {
+			problems = append(problems, path+".database is required")
+		}
+		if coll.Name == "" {
+			problems = append(problems, path+".name is required")
+		}
+		for j, index := range coll.Indexes {
+			indexPath := fmt.Sprintf("%s.indexes[%d]", path, j)
+			problems = append(problems, index.Validate(indexPath)...)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// parseURI parses the connection-string into ClientSettings.
+// The database in the URI's path is used as the authSource, unless the
+// authSource-option is specified.
+func parseURI(uri string) (ClientSettings, []string) {
+	problems := []string{}
+	settings := ClientSettings{}
+
+	const scheme = "mongodb://"
+	if !strings.HasPrefix(uri, scheme) {
+		problems = append(
+			problems,
+			fmt.Sprintf("client.uri must start with %q", scheme),
+		)
+		return settings, problems
+	}
+	rest := strings.TrimPrefix(uri, scheme)
+
+	// The credentials can contain "/" and "?" only when escaped,
+	// so the first of these ends the hosts-section.
+	hostsSection := rest
+	pathSection := ""
+	if i := strings.IndexAny(rest, "/?"); i >= 0 {
+		hostsSection = rest[:i]
+		pathSection = rest[i:]
+	}
+
+	if i := strings.LastIndex(hostsSection, "@"); i >= 0 {
+		userInfo := hostsSection[:i]
+		hostsSection = hostsSection[i+1:]
+
+		username := userInfo
+		password := ""
+		if j := strings.Index(userInfo, ":"); j >= 0 {
+			username = userInfo[:j]
+			password = userInfo[j+1:]
+		}
+		var err error
+		settings.Username, err = url.PathUnescape(username)
+		if err != nil {
+			problems = append(problems, "client.uri has invalid username-escaping")
+		}
+		settings.Password, err = url.PathUnescape(password)
+		if err != nil {
+			problems = append(problems, "client.uri has invalid password-escaping")
+		}
+	}
+	err := setField(&settings.Hosts, hostsSection)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("client.uri: %s", err))
+	}
+
+	pathSection = strings.TrimPrefix(pathSection, "/")
+	database := pathSection
+	rawQuery := ""
+	if i := strings.Index(pathSection, "?"); i >= 0 {
+		database = pathSection[:i]
+		rawQuery = pathSection[i+1:]
+	}
+	settings.AuthSource = database
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("client.uri: %s", err))
+		return settings, problems
+	}
+
+	fields := map[string]interface{}{}
+	for _, f := range clientFields(&settings) {
+		for _, name := range f.uri {
+			fields[name] = f.field
+		}
+	}
+	keys := []string{}
+	for key := range query {
+		keys = append(keys, key)
+	}
+	// Sorted for consistent order of problems
+	sort.Strings(keys)
+	for _, key := range keys {
+		field, isKnown := fields[strings.ToLower(key)]
+		if !isKnown {
+			problems = append(
+				problems,
+				fmt.Sprintf("client.uri: unsupported option %q", key),
+			)
+			continue
+		}
+		values := query[key]
+		err = setField(field, values[len(values)-1])
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("client.uri: %s: %s", key, err))
+		}
+	}
+	return settings, problems
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 
+	"github.com/TerrexTech/go-mongoutils/config"
 	"github.com/TerrexTech/go-mongoutils/mongo"
 	"github.com/mongodb/mongo-go-driver/bson"
 	"github.com/mongodb/mongo-go-driver/bson/objectid"
@@ -21,14 +22,20 @@ type item struct {
 }
 
 func main() {
+	log.Println("==========> Demonstrate Loading Configuration")
+	settings, err := loadSettings()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	log.Println("==========> Demonstrate Client Creation")
-	client, err := createClient()
+	client, err := createClient(settings)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
 	log.Println("==========> Demonstrate Collection Creation")
-	collection, err := createCollection(client)
+	collection, err := createCollection(client, settings)
 	if err != nil {
 		log.Fatalln(err, collection)
 	}
@@ -73,52 +80,41 @@ func main() {
 	aggregatePipeline(collection)
 }
 
-// createClient creates a MongoDB-Client.
-func createClient() (*mongo.Client, error) {
-	// Would ideally set these config-params as environment vars
-	config := mongo.ClientConfig{
-		Hosts:               []string{"localhost:27017"},
-		Username:            "root",
-		Password:            "root",
-		TimeoutMilliseconds: 3000,
-	}
+// loadSettings loads the Client and Collection configuration from
+// "examples/config.yaml", with the environment-variables prefixed by
+// "MONGO_" overriding the file's values.
+// Run this from repository-root, as: go run ./examples/example.go
+func loadSettings() (*config.Settings, error) {
+	// A *config.ValidationError lists all the missing or invalid settings
+	return config.Load(config.Options{
+		Files:     []string{"examples/config.yaml"},
+		EnvPrefix: "MONGO",
+	})
+}
 
+// createClient creates a MongoDB-Client.
+func createClient(settings *config.Settings) (*mongo.Client, error) {
 	// ====> MongoDB Client
-	client, err := mongo.NewClient(config)
+	client, err := mongo.NewClient(settings.Client.ClientConfig())
 	// Let the parent functions handle error, always -.-
 	// (Even though in these examples, we won't, for simplicity)
 	return client, err
 }
 
 // createCollection demonstrates creating the collection and the associated database.
-func createCollection(client *mongo.Client) (*mongo.Collection, error) {
+func createCollection(
+	client *mongo.Client,
+	settings *config.Settings,
+) (*mongo.Collection, error) {
 	// ====> Collection Configuration
-	conn := &mongo.ConnectionConfig{
-		Client:  client,
-		Timeout: 5000,
-	}
-	// Index Configuration
-	indexConfigs := []mongo.IndexConfig{
-		mongo.IndexConfig{
-			ColumnConfig: []mongo.IndexColumnConfig{
-				mongo.IndexColumnConfig{
-					Name:        "word",
-					IsDescOrder: true,
-				},
-			},
-			IsUnique: true,
-			Name:     "test_index",
-		},
+	// The Collection and its indexes are defined in config-file
+	collSettings := settings.Collection("test", "test_coll")
+	if collSettings == nil {
+		return nil, errors.New("Collection test.test_coll is not configured")
 	}
 
 	// ====> Create New Collection
-	c := &mongo.Collection{
-		Connection:   conn,
-		Name:         "test_coll",
-		Database:     "test",
-		SchemaStruct: &item{},
-		Indexes:      indexConfigs,
-	}
+	c := collSettings.Collection(client, &item{})
 	return mongo.EnsureCollection(c)
 }
 
@@ -0,0 +1,292 @@
+// Package extjson converts the documents decoded from JSON or YAML to BSON,
+// interpreting the MongoDB Extended JSON (v2) values, such as:
+//
+//	{"_id": {"$oid": "5b5b7d4e8d0a2b1c3e4f5a6b"}, "at": {"$date": "2018-07-27T00:00:00Z"}}
+//
+// The JSON is decoded as YAML, which retains the order of keys, so the
+// same documents can be written in YAML files.
+// See: https://docs.mongodb.com/manual/reference/mongodb-extended-json/
+package extjson
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/decimal"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// ParseDocument parses the JSON or YAML document to BSON.
+func ParseDocument(data []byte) (*bson.Document, error) {
+	mapping := yaml.MapSlice{}
+	err := yaml.Unmarshal(data, &mapping)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Parsing Document")
+	}
+	return ToDocument(mapping)
+}
+
+// ToDocument converts the mapping decoded from JSON or YAML to a
+// BSON-document, retaining the order of keys.
+func ToDocument(mapping yaml.MapSlice) (*bson.Document, error) {
+	doc := bson.NewDocument()
+	for _, item := range mapping {
+		key, ok := item.Key.(string)
+		if !ok {
+			return nil, fmt.Errorf("key %v is not a string", item.Key)
+		}
+		elem, err := ToElement(key, item.Value)
+		if err != nil {
+			return nil, err
+		}
+		doc.Append(elem)
+	}
+	return doc, nil
+}
+
+// ToElement converts the value decoded from JSON or YAML to a BSON-element.
+// The integers are stored as int32 where possible, as done by the server.
+func ToElement(key string, value interface{}) (*bson.Element, error) {
+	switch v := value.(type) {
+	case nil:
+		return bson.EC.Null(key), nil
+	case bool:
+		return bson.EC.Boolean(key, v), nil
+	case string:
+		return bson.EC.String(key, v), nil
+	case float64:
+		return bson.EC.Double(key, v), nil
+	case int:
+		return intElement(key, int64(v)), nil
+	case int64:
+		return intElement(key, v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil, fmt.Errorf("%s: %d is too large", key, v)
+		}
+		return intElement(key, int64(v)), nil
+
+	case yaml.MapSlice:
+		if len(v) == 1 {
+			wrapper, _ := v[0].Key.(string)
+			if convert, exists := wrappers[wrapper]; exists {
+				elem, err := convert(key, v[0].Value)
+				if err != nil {
+					return nil, errors.Wrapf(err, "%s: invalid %s", key, wrapper)
+				}
+				return elem, nil
+			}
+		}
+		doc, err := ToDocument(v)
+		if err != nil {
+			return nil, err
+		}
+		return bson.EC.SubDocument(key, doc), nil
+
+	case map[interface{}]interface{}:
+		// Sorted, since the order of map-keys is random
+		mapping := yaml.MapSlice{}
+		for k, item := range v {
+			mapping = append(mapping, yaml.MapItem{Key: k, Value: item})
+		}
+		sort.Slice(mapping, func(i, j int) bool {
+			return fmt.Sprint(mapping[i].Key) < fmt.Sprint(mapping[j].Key)
+		})
+		return ToElement(key, mapping)
+
+	case []interface{}:
+		values := []*bson.Value{}
+		for i, item := range v {
+			elem, err := ToElement(strconv.Itoa(i), item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elem.Value())
+		}
+		return bson.EC.Array(key, bson.NewArray(values...)), nil
+	}
+	return nil, fmt.Errorf("%s: unsupported value-type: %T", key, value)
+}
+
+func intElement(key string, value int64) *bson.Element {
+	if value >= math.MinInt32 && value <= math.MaxInt32 {
+		return bson.EC.Int32(key, int32(value))
+	}
+	return bson.EC.Int64(key, value)
+}
+
+// wrapperFunc converts the value of an Extended JSON wrapper, such as the
+// hex-string in: {"$oid": "..."}.
+type wrapperFunc func(key string, value interface{}) (*bson.Element, error)
+
+// wrappers are the supported Extended JSON wrappers. These do not overlap
+// with query-operators, so the query-documents are converted as is.
+// These are set in init, as some wrappers convert their values using
+// ToElement, which itself uses wrappers.
+var wrappers map[string]wrapperFunc
+
+func init() {
+	wrappers = map[string]wrapperFunc{
+		"$oid":               oidElement,
+		"$date":              dateElement,
+		"$numberInt":         int32Element,
+		"$numberLong":        int64Element,
+		"$numberDouble":      doubleElement,
+		"$numberDecimal":     decimalElement,
+		"$binary":            binaryElement,
+		"$timestamp":         timestampElement,
+		"$regularExpression": regexElement,
+		"$minKey": func(key string, _ interface{}) (*bson.Element, error) {
+			return bson.EC.MinKey(key), nil
+		},
+		"$maxKey": func(key string, _ interface{}) (*bson.Element, error) {
+			return bson.EC.MaxKey(key), nil
+		},
+		"$undefined": func(key string, _ interface{}) (*bson.Element, error) {
+			return bson.EC.Undefined(key), nil
+		},
+	}
+}
+
+func oidElement(key string, value interface{}) (*bson.Element, error) {
+	str, ok := value.(string)
+	if !ok {
+		return nil, errors.New("ObjectID must be a hex-string")
+	}
+	oid, err := objectid.FromHex(str)
+	if err != nil {
+		return nil, err
+	}
+	return bson.EC.ObjectID(key, oid), nil
+}
+
+// dateElement converts the relaxed ISO-8601 date, or the canonical
+// milliseconds since epoch, such as: {"$date": {"$numberLong": "0"}}.
+func dateElement(key string, value interface{}) (*bson.Element, error) {
+	switch v := value.(type) {
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return nil, err
+		}
+		return bson.EC.Time(key, t), nil
+	case int:
+		return bson.EC.DateTime(key, int64(v)), nil
+	case int64:
+		return bson.EC.DateTime(key, v), nil
+	case yaml.MapSlice:
+		millis, err := ToElement(key, v)
+		if err != nil {
+			return nil, err
+		}
+		if millis.Value().Type() != bson.TypeInt64 {
+			return nil, errors.New("date must be a $numberLong")
+		}
+		return bson.EC.DateTime(key, millis.Value().Int64()), nil
+	}
+	return nil, fmt.Errorf("unsupported date: %v", value)
+}
+
+// int32Element converts the number specified as a string to retain its
+// exact value, as are the other numbers, such as: {"$numberInt": "42"}.
+func int32Element(key string, value interface{}) (*bson.Element, error) {
+	i, err := strconv.ParseInt(fmt.Sprint(value), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return bson.EC.Int32(key, int32(i)), nil
+}
+
+func int64Element(key string, value interface{}) (*bson.Element, error) {
+	i, err := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return bson.EC.Int64(key, i), nil
+}
+
+// doubleElement also converts "Infinity", "-Infinity", and "NaN".
+func doubleElement(key string, value interface{}) (*bson.Element, error) {
+	f, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+	if err != nil {
+		return nil, err
+	}
+	return bson.EC.Double(key, f), nil
+}
+
+func decimalElement(key string, value interface{}) (*bson.Element, error) {
+	d, err := decimal.ParseDecimal128(fmt.Sprint(value))
+	if err != nil {
+		return nil, err
+	}
+	return bson.EC.Decimal128(key, d), nil
+}
+
+// binaryElement converts the binary with its hex-subtype, such as:
+// {"$binary": {"base64": "AQID", "subType": "00"}}.
+func binaryElement(key string, value interface{}) (*bson.Element, error) {
+	fields, ok := value.(yaml.MapSlice)
+	if !ok {
+		return nil, errors.New("binary must be a document")
+	}
+	data, err := base64.StdEncoding.DecodeString(stringField(fields, "base64"))
+	if err != nil {
+		return nil, err
+	}
+	subtype, err := hex.DecodeString(stringField(fields, "subType"))
+	if err != nil || len(subtype) != 1 {
+		return nil, errors.New("subType must be a 1-byte hex-string")
+	}
+	return bson.EC.BinaryWithSubtype(key, data, subtype[0]), nil
+}
+
+// timestampElement converts the timestamp, such as:
+// {"$timestamp": {"t": 1532649600, "i": 1}}.
+func timestampElement(key string, value interface{}) (*bson.Element, error) {
+	fields, ok := value.(yaml.MapSlice)
+	if !ok {
+		return nil, errors.New("timestamp must be a document")
+	}
+	t, tErr := strconv.ParseUint(fmt.Sprint(field(fields, "t")), 10, 32)
+	i, iErr := strconv.ParseUint(fmt.Sprint(field(fields, "i")), 10, 32)
+	if tErr != nil || iErr != nil {
+		return nil, errors.New("t and i must be unsigned 32-bit integers")
+	}
+	return bson.EC.Timestamp(key, uint32(t), uint32(i)), nil
+}
+
+// regexElement converts the regular expression, such as:
+// {"$regularExpression": {"pattern": "^a", "options": "i"}}.
+func regexElement(key string, value interface{}) (*bson.Element, error) {
+	fields, ok := value.(yaml.MapSlice)
+	if !ok {
+		return nil, errors.New("regularExpression must be a document")
+	}
+	return bson.EC.Regex(
+		key,
+		stringField(fields, "pattern"),
+		stringField(fields, "options"),
+	), nil
+}
+
+func field(fields yaml.MapSlice, key string) interface{} {
+	for _, item := range fields {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+func stringField(fields yaml.MapSlice, key string) string {
+	str, _ := field(fields, key).(string)
+	return str
+}
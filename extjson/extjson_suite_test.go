@@ -0,0 +1,13 @@
+package extjson
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestExtJSON(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ExtJSON Suite")
+}
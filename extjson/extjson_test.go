@@ -0,0 +1,121 @@
+package extjson
+
+import (
+	"math"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ExtJSON", func() {
+	Describe("ParseDocument", func() {
+		It("should convert the Extended JSON values", func() {
+			doc, err := ParseDocument([]byte(`{
+  "_id": {"$oid": "5b5b7d4e8d0a2b1c3e4f5a6b"},
+  "relaxedDate": {"$date": "2018-07-27T10:30:00.5Z"},
+  "canonicalDate": {"$date": {"$numberLong": "1532687400500"}},
+  "int": {"$numberInt": "42"},
+  "long": {"$numberLong": "42"},
+  "double": {"$numberDouble": "-Infinity"},
+  "decimal": {"$numberDecimal": "1.25"},
+  "binary": {"$binary": {"base64": "AQID", "subType": "80"}},
+  "timestamp": {"$timestamp": {"t": 1532687400, "i": 2}},
+  "regex": {"$regularExpression": {"pattern": "^a", "options": "i"}}
+}`))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(doc.Lookup("_id").ObjectID().Hex()).To(
+				Equal("5b5b7d4e8d0a2b1c3e4f5a6b"),
+			)
+			date := time.Date(2018, 7, 27, 10, 30, 0, 5e8, time.UTC)
+			Expect(doc.Lookup("relaxedDate").Time().Equal(date)).To(BeTrue())
+			Expect(doc.Lookup("canonicalDate").Time().Equal(date)).To(BeTrue())
+			Expect(doc.Lookup("int").Int32()).To(Equal(int32(42)))
+			Expect(doc.Lookup("long").Int64()).To(Equal(int64(42)))
+			Expect(math.IsInf(doc.Lookup("double").Double(), -1)).To(BeTrue())
+			Expect(doc.Lookup("decimal").Decimal128().String()).To(Equal("1.25"))
+
+			subtype, data := doc.Lookup("binary").Binary()
+			Expect(subtype).To(Equal(byte(0x80)))
+			Expect(data).To(Equal([]byte{1, 2, 3}))
+
+			t, i := doc.Lookup("timestamp").Timestamp()
+			Expect(t).To(Equal(uint32(1532687400)))
+			Expect(i).To(Equal(uint32(2)))
+
+			pattern, options := doc.Lookup("regex").Regex()
+			Expect(pattern).To(Equal("^a"))
+			Expect(options).To(Equal("i"))
+		})
+
+		It("should convert query-documents as is, retaining the order of keys", func() {
+			doc, err := ParseDocument([]byte(`
+hits: {$gt: 4, $lt: 9}
+tags: [a, b]
+`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(doc.ElementAt(0).Key()).To(Equal("hits"))
+
+			hits := doc.Lookup("hits").MutableDocument()
+			Expect(hits.ElementAt(0).Key()).To(Equal("$gt"))
+			Expect(hits.Lookup("$gt").Int32()).To(Equal(int32(4)))
+			Expect(doc.Lookup("tags").Type()).To(Equal(bson.TypeArray))
+		})
+
+		It("should return error for invalid Extended JSON values", func() {
+			_, err := ParseDocument([]byte(`{"_id": {"$oid": "not-hex"}}`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("MarshalDocument", func() {
+		var doc *bson.Document
+
+		BeforeEach(func() {
+			var err error
+			doc, err = ParseDocument([]byte(`{
+  "_id": {"$oid": "5b5b7d4e8d0a2b1c3e4f5a6b"},
+  "name": "Some \"Name\"",
+  "date": {"$date": "2018-07-27T10:30:00.500Z"},
+  "int": 42,
+  "long": {"$numberLong": "42"},
+  "double": 1.0,
+  "tags": ["a", {"$numberDouble": "NaN"}],
+  "binary": {"$binary": {"base64": "AQID", "subType": "80"}}
+}`))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should write the relaxed Extended JSON", func() {
+			relaxed, err := MarshalDocument(doc, false)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(relaxed)).To(Equal(
+				`{"_id":{"$oid":"5b5b7d4e8d0a2b1c3e4f5a6b"},"name":"Some \"Name\"",` +
+					`"date":{"$date":"2018-07-27T10:30:00.500Z"},"int":42,"long":42,` +
+					`"double":1.0,"tags":["a",{"$numberDouble":"NaN"}],` +
+					`"binary":{"$binary":{"base64":"AQID","subType":"80"}}}`,
+			))
+		})
+
+		It("should write the canonical Extended JSON, which is read back as is", func() {
+			canonical, err := MarshalDocument(doc, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(canonical)).To(ContainSubstring(
+				`"date":{"$date":{"$numberLong":"1532687400500"}},` +
+					`"int":{"$numberInt":"42"},"long":{"$numberLong":"42"},` +
+					`"double":{"$numberDouble":"1.0"}`,
+			))
+
+			parsed, err := ParseDocument(canonical)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(parsed.Lookup("long").Type()).To(Equal(bson.TypeInt64))
+			Expect(parsed.Lookup("double").Type()).To(Equal(bson.TypeDouble))
+
+			roundTrip, err := MarshalDocument(parsed, true)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(roundTrip)).To(Equal(string(canonical)))
+		})
+	})
+})
@@ -0,0 +1,190 @@
+package extjson
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+)
+
+// MarshalDocument writes the BSON-document as Extended JSON. The canonical
+// format retains the BSON-types, such as: {"count": {"$numberInt": "1"}}.
+// The relaxed format writes the numbers and dates as plain JSON where
+// possible, such as: {"count": 1}, which is easier to read and process,
+// but the integers are read back as int32 where possible.
+func MarshalDocument(doc *bson.Document, canonical bool) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := writeDocument(buf, doc, canonical)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalValue writes the BSON-value as Extended JSON.
+func MarshalValue(v *bson.Value, canonical bool) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := writeValue(buf, v, canonical)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeDocument(buf *bytes.Buffer, doc *bson.Document, canonical bool) error {
+	buf.WriteString("{")
+	iter := doc.Iterator()
+	for i := 0; iter.Next(); i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		elem := iter.Element()
+		writeString(buf, elem.Key())
+		buf.WriteString(":")
+		err := writeValue(buf, elem.Value(), canonical)
+		if err != nil {
+			return fmt.Errorf("%s: %s", elem.Key(), err)
+		}
+	}
+	if iter.Err() != nil {
+		return iter.Err()
+	}
+	buf.WriteString("}")
+	return nil
+}
+
+func writeArray(buf *bytes.Buffer, arr *bson.Array, canonical bool) error {
+	buf.WriteString("[")
+	for i := 0; i < arr.Len(); i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		v, err := arr.Lookup(uint(i))
+		if err != nil {
+			return err
+		}
+		err = writeValue(buf, v, canonical)
+		if err != nil {
+			return fmt.Errorf("%d: %s", i, err)
+		}
+	}
+	buf.WriteString("]")
+	return nil
+}
+
+// writeValue writes the value as per the Extended JSON (v2) specification.
+// See: https://github.com/mongodb/specifications/blob/master/source/extended-json.rst
+func writeValue(buf *bytes.Buffer, v *bson.Value, canonical bool) error {
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		return writeDocument(buf, v.MutableDocument(), canonical)
+	case bson.TypeArray:
+		return writeArray(buf, v.MutableArray(), canonical)
+	case bson.TypeString:
+		writeString(buf, v.StringValue())
+	case bson.TypeBoolean:
+		buf.WriteString(strconv.FormatBool(v.Boolean()))
+	case bson.TypeNull:
+		buf.WriteString("null")
+
+	case bson.TypeInt32:
+		if canonical {
+			writeWrapper(buf, "$numberInt", strconv.FormatInt(int64(v.Int32()), 10))
+		} else {
+			buf.WriteString(strconv.FormatInt(int64(v.Int32()), 10))
+		}
+	case bson.TypeInt64:
+		if canonical {
+			writeWrapper(buf, "$numberLong", strconv.FormatInt(v.Int64(), 10))
+		} else {
+			buf.WriteString(strconv.FormatInt(v.Int64(), 10))
+		}
+	case bson.TypeDouble:
+		f := v.Double()
+		if canonical || math.IsInf(f, 0) || math.IsNaN(f) {
+			writeWrapper(buf, "$numberDouble", formatDouble(f))
+		} else {
+			buf.WriteString(formatDouble(f))
+		}
+	case bson.TypeDecimal128:
+		writeWrapper(buf, "$numberDecimal", v.Decimal128().String())
+
+	case bson.TypeObjectID:
+		writeWrapper(buf, "$oid", v.ObjectID().Hex())
+	case bson.TypeDateTime:
+		millis := v.DateTime()
+		t := time.Unix(0, millis*int64(time.Millisecond)).UTC()
+		// The relaxed dates are limited to years 1970 through 9999
+		if canonical || millis < 0 || t.Year() > 9999 {
+			buf.WriteString(`{"$date":`)
+			writeWrapper(buf, "$numberLong", strconv.FormatInt(millis, 10))
+			buf.WriteString("}")
+		} else {
+			writeWrapper(buf, "$date", t.Format("2006-01-02T15:04:05.000Z07:00"))
+		}
+	case bson.TypeBinary:
+		subtype, data := v.Binary()
+		fmt.Fprintf(
+			buf,
+			`{"$binary":{"base64":"%s","subType":"%02x"}}`,
+			base64.StdEncoding.EncodeToString(data),
+			subtype,
+		)
+	case bson.TypeTimestamp:
+		t, i := v.Timestamp()
+		fmt.Fprintf(buf, `{"$timestamp":{"t":%d,"i":%d}}`, t, i)
+	case bson.TypeRegex:
+		pattern, options := v.Regex()
+		buf.WriteString(`{"$regularExpression":{"pattern":`)
+		writeString(buf, pattern)
+		buf.WriteString(`,"options":`)
+		writeString(buf, options)
+		buf.WriteString("}}")
+	case bson.TypeMinKey:
+		buf.WriteString(`{"$minKey":1}`)
+	case bson.TypeMaxKey:
+		buf.WriteString(`{"$maxKey":1}`)
+	case bson.TypeUndefined:
+		buf.WriteString(`{"$undefined":true}`)
+
+	default:
+		return fmt.Errorf("unsupported BSON-type: %s", v.Type())
+	}
+	return nil
+}
+
+func writeString(buf *bytes.Buffer, str string) {
+	// Marshalling a string cannot fail
+	encoded, _ := json.Marshal(str)
+	buf.Write(encoded)
+}
+
+func writeWrapper(buf *bytes.Buffer, wrapper string, value string) {
+	buf.WriteString(`{"` + wrapper + `":`)
+	writeString(buf, value)
+	buf.WriteString("}")
+}
+
+// formatDouble formats the double with a decimal point, so it is read back
+// as a double rather than an integer.
+func formatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	str := strconv.FormatFloat(f, 'g', -1, 64)
+	if !strings.ContainsAny(str, ".e") {
+		str += ".0"
+	}
+	return str
+}
@@ -0,0 +1,337 @@
+// Package fixtures loads the documents from JSON, YAML, or Extended JSON
+// files into Collections, for tests and local development.
+//
+// A fixture-file maps the collection-names to their named documents, such as:
+//
+//	users:
+//	  alice:
+//	    name: Alice
+//	    email: alice@example.com
+//	posts:
+//	  welcome:
+//	    title: Welcome
+//	    author: {$fixture: users.alice}
+//
+// The documents without an _id are assigned a generated ObjectID. A document
+// can reference another using {$fixture: "<collection>.<name>"}, which is
+// replaced by the referenced document's _id, or using
+// {$fixture: "<collection>.<name>.<field>"} for the value of a field.
+//
+// The documents are decoded into the Collection's SchemaStruct, and inserted
+// using Collection.InsertMany, so the schema-tags, encryption, and middlewares
+// apply as they do for other inserts.
+package fixtures
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/extjson"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// refKey is the key of references to other fixtures.
+const refKey = "$fixture"
+
+// Config defines the configuration for Fixtures.
+type Config struct {
+	// Collections to load the fixtures into, as returned by
+	// mongo.EnsureCollection. The fixture-files refer to these by Name.
+	Collections []*mongo.Collection
+	// Files are the fixture-files in YAML or JSON, including Extended JSON.
+	// The fixtures of each Collection are inserted in the order of files,
+	// and the order within these.
+	Files []string
+}
+
+// fixture is a named document in a collection.
+type fixture struct {
+	collection string
+	name       string
+	mapping    yaml.MapSlice
+}
+
+func (f *fixture) ref() string {
+	return f.collection + "." + f.name
+}
+
+// Fixtures loads the documents from fixture-files.
+type Fixtures struct {
+	collections map[string]*mongo.Collection
+	fixtures    []*fixture
+	// byRef maps the "<collection>.<name>" references to fixtures
+	byRef map[string]*fixture
+	// documents are the inserted SchemaStructs, by reference
+	documents map[string]interface{}
+}
+
+// New reads the fixture-files, and resolves the references between the
+// fixtures. The fixtures are inserted using Load.
+func New(config Config) (*Fixtures, error) {
+	f := &Fixtures{
+		collections: map[string]*mongo.Collection{},
+		byRef:       map[string]*fixture{},
+		documents:   map[string]interface{}{},
+	}
+	for _, c := range config.Collections {
+		if c == nil || c.SchemaStruct == nil {
+			return nil, errors.New("Collections must be created using EnsureCollection")
+		}
+		if f.collections[c.Name] != nil {
+			return nil, fmt.Errorf("Collection configured twice: %s", c.Name)
+		}
+		f.collections[c.Name] = c
+	}
+
+	for _, path := range config.Files {
+		err := f.readFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error Reading Fixture-File: %s", path)
+		}
+	}
+	for _, fx := range f.fixtures {
+		resolved, err := f.resolve(fx.mapping, map[string]bool{})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error Resolving Fixture: %s", fx.ref())
+		}
+		fx.mapping = resolved.(yaml.MapSlice)
+	}
+	return f, nil
+}
+
+// readFile adds the fixtures from file, generating the missing _ids.
+func (f *Fixtures) readFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	// JSON is a subset of YAML, so this decodes both
+	file := yaml.MapSlice{}
+	err = yaml.Unmarshal(data, &file)
+	if err != nil {
+		return err
+	}
+
+	for _, collItem := range file {
+		collection := fmt.Sprint(collItem.Key)
+		if f.collections[collection] == nil {
+			return fmt.Errorf("Collection is not configured: %s", collection)
+		}
+		docs, ok := collItem.Value.(yaml.MapSlice)
+		if !ok {
+			return fmt.Errorf("%s: must map the fixture-names to documents", collection)
+		}
+
+		for _, docItem := range docs {
+			fx := &fixture{
+				collection: collection,
+				name:       fmt.Sprint(docItem.Key),
+			}
+			fx.mapping, ok = docItem.Value.(yaml.MapSlice)
+			if !ok {
+				return fmt.Errorf("%s: must be a document", fx.ref())
+			}
+			if f.byRef[fx.ref()] != nil {
+				return fmt.Errorf("%s: fixture defined twice", fx.ref())
+			}
+			if lookup(fx.mapping, "_id") == nil {
+				id := yaml.MapItem{
+					Key:   "_id",
+					Value: yaml.MapSlice{{Key: "$oid", Value: objectid.New().Hex()}},
+				}
+				fx.mapping = append(yaml.MapSlice{id}, fx.mapping...)
+			}
+			f.byRef[fx.ref()] = fx
+			f.fixtures = append(f.fixtures, fx)
+		}
+	}
+	return nil
+}
+
+// resolve replaces the references in value by the referenced values.
+// The references being resolved are tracked to detect cycles.
+func (f *Fixtures) resolve(value interface{}, resolving map[string]bool) (
+	interface{},
+	error,
+) {
+	switch v := value.(type) {
+	case yaml.MapSlice:
+		if len(v) == 1 && v[0].Key == refKey {
+			ref := fmt.Sprint(v[0].Value)
+			if resolving[ref] {
+				return nil, fmt.Errorf("cyclic reference: %s", ref)
+			}
+			referenced, err := f.lookupRef(ref)
+			if err != nil {
+				return nil, err
+			}
+			resolving[ref] = true
+			defer delete(resolving, ref)
+			return f.resolve(referenced, resolving)
+		}
+
+		resolved := make(yaml.MapSlice, len(v))
+		for i, item := range v {
+			itemValue, err := f.resolve(item.Value, resolving)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = yaml.MapItem{Key: item.Key, Value: itemValue}
+		}
+		return resolved, nil
+
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, item := range v {
+			itemValue, err := f.resolve(item, resolving)
+			if err != nil {
+				return nil, err
+			}
+			resolved[i] = itemValue
+		}
+		return resolved, nil
+	}
+	return value, nil
+}
+
+// lookupRef returns the unresolved value referenced as
+// "<collection>.<name>" (the _id) or "<collection>.<name>.<field>".
+// The collection-names can contain dots, so the longest matching
+// fixture-reference is used.
+func (f *Fixtures) lookupRef(ref string) (interface{}, error) {
+	var fx *fixture
+	for fixtureRef, candidate := range f.byRef {
+		if ref != fixtureRef && !strings.HasPrefix(ref, fixtureRef+".") {
+			continue
+		}
+		if fx == nil || len(fixtureRef) > len(fx.ref()) {
+			fx = candidate
+		}
+	}
+	if fx == nil {
+		return nil, fmt.Errorf("unknown fixture: %s", ref)
+	}
+
+	path := strings.TrimPrefix(strings.TrimPrefix(ref, fx.ref()), ".")
+	if path == "" {
+		path = "_id"
+	}
+	var value interface{} = fx.mapping
+	for _, key := range strings.Split(path, ".") {
+		value = lookup(value, key)
+		if value == nil {
+			return nil, fmt.Errorf("unknown field: %s", ref)
+		}
+	}
+	return value, nil
+}
+
+// lookup returns the value of key, if value is a mapping.
+func lookup(value interface{}, key string) interface{} {
+	mapping, _ := value.(yaml.MapSlice)
+	for _, item := range mapping {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
+
+// Load inserts the fixtures into their Collections, using an InsertMany per
+// Collection. The Collections are loaded in the order of their first fixture.
+func (f *Fixtures) Load() error {
+	names := []string{}
+	items := map[string][]interface{}{}
+	refs := map[string][]string{}
+	for _, fx := range f.fixtures {
+		c := f.collections[fx.collection]
+		doc, err := extjson.ToDocument(fx.mapping)
+		if err != nil {
+			return errors.Wrapf(err, "Error Converting Fixture: %s", fx.ref())
+		}
+		docBytes, err := doc.MarshalBSON()
+		if err != nil {
+			return errors.Wrapf(err, "Error Converting Fixture: %s", fx.ref())
+		}
+		item := reflect.New(reflect.TypeOf(c.SchemaStruct).Elem()).Interface()
+		err = bson.Unmarshal(docBytes, item)
+		if err != nil {
+			return errors.Wrapf(err, "Error Decoding Fixture: %s", fx.ref())
+		}
+
+		if _, exists := items[fx.collection]; !exists {
+			names = append(names, fx.collection)
+		}
+		items[fx.collection] = append(items[fx.collection], item)
+		refs[fx.collection] = append(refs[fx.collection], fx.ref())
+	}
+
+	for _, name := range names {
+		_, err := f.collections[name].InsertMany(items[name])
+		if err != nil {
+			return errors.Wrapf(err, "Error Inserting Fixtures into Collection: %s", name)
+		}
+		for i, ref := range refs[name] {
+			f.documents[ref] = items[name][i]
+		}
+	}
+	return nil
+}
+
+// Clear deletes all documents from the Collections. This retains the
+// collections and their indexes, which is faster than dropping the
+// database and creating the Collections again.
+func (f *Fixtures) Clear() error {
+	for name, c := range f.collections {
+		ctx, cancel := context.WithTimeout(
+			context.Background(),
+			time.Duration(c.Connection.Timeout)*time.Millisecond,
+		)
+		_, err := c.Collection().DeleteMany(ctx, bson.NewDocument())
+		cancel()
+		if err != nil {
+			return errors.Wrapf(err, "Error Clearing Collection: %s", name)
+		}
+	}
+	f.documents = map[string]interface{}{}
+	return nil
+}
+
+// Reset clears the Collections, and loads the fixtures again, such as
+// before every test.
+func (f *Fixtures) Reset() error {
+	err := f.Clear()
+	if err != nil {
+		return err
+	}
+	return f.Load()
+}
+
+// ID returns the _id of fixture referenced as "<collection>.<name>",
+// such as an objectid.ObjectID. This returns nil for unknown fixtures.
+func (f *Fixtures) ID(ref string) interface{} {
+	fx := f.byRef[ref]
+	if fx == nil {
+		return nil
+	}
+	elem, err := extjson.ToElement("_id", lookup(fx.mapping, "_id"))
+	if err != nil {
+		return nil
+	}
+	return elem.Value().Interface()
+}
+
+// Document returns the inserted SchemaStruct of fixture referenced as
+// "<collection>.<name>", with the fields set on insert (such as the
+// "createdAt" fields). This returns nil if the fixture is not loaded.
+func (f *Fixtures) Document(ref string) interface{} {
+	return f.documents[ref]
+}
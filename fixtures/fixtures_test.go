@@ -0,0 +1,256 @@
+package fixtures
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/config"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fixtures", func() {
+	type user struct {
+		ID        objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		Name      string            `bson:"name,omitempty" json:"name,omitempty"`
+		Email     string            `bson:"email,omitempty" json:"email,omitempty"`
+		CreatedAt time.Time         `bson:"createdAt" mongoutils:"createdAt"`
+	}
+	type post struct {
+		ID          objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		Title       string            `bson:"title,omitempty" json:"title,omitempty"`
+		Author      objectid.ObjectID `bson:"author,omitempty" json:"author,omitempty"`
+		AuthorEmail string            `bson:"authorEmail,omitempty"`
+		Hits        int64             `bson:"hits,omitempty" json:"hits,omitempty"`
+	}
+
+	var (
+		dir   string
+		users *mongo.Collection
+		posts *mongo.Collection
+	)
+
+	// writeFile writes the content into a file in the temp-dir
+	// and returns the file's path.
+	writeFile := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		Expect(err).ToNot(HaveOccurred())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "mongoutils-fixtures")
+		Expect(err).ToNot(HaveOccurred())
+
+		users = &mongo.Collection{Name: "users", SchemaStruct: &user{}}
+		posts = &mongo.Collection{Name: "posts", SchemaStruct: &post{}}
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(dir)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("New", func() {
+		It("should generate the IDs, and resolve references", func() {
+			usersFile := writeFile("users.yaml", `
+users:
+  alice:
+    name: Alice
+    email: alice@example.com
+  bob:
+    _id: {$oid: 5b5b7d4e8d0a2b1c3e4f5a6b}
+    name: Bob
+`)
+			postsFile := writeFile("posts.json", `{
+  "posts": {
+    "welcome": {
+      "title": "Welcome",
+      "author": {"$fixture": "users.alice"},
+      "authorEmail": {"$fixture": "users.alice.email"},
+      "hits": {"$numberLong": "10"}
+    },
+    "reply": {"title": "Reply", "author": {"$fixture": "users.bob"}}
+  }
+}`)
+			f, err := New(Config{
+				Collections: []*mongo.Collection{users, posts},
+				Files:       []string{usersFile, postsFile},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(f.fixtures).To(HaveLen(4))
+
+			aliceID, ok := f.ID("users.alice").(objectid.ObjectID)
+			Expect(ok).To(BeTrue())
+			Expect(aliceID).ToNot(Equal(objectid.ObjectID{}))
+			Expect(f.ID("users.bob").(objectid.ObjectID).Hex()).To(
+				Equal("5b5b7d4e8d0a2b1c3e4f5a6b"),
+			)
+			Expect(f.ID("users.unknown")).To(BeNil())
+
+			welcome := f.byRef["posts.welcome"].mapping
+			Expect(lookup(welcome, "author")).To(Equal(lookup(
+				f.byRef["users.alice"].mapping, "_id",
+			)))
+			Expect(lookup(welcome, "authorEmail")).To(Equal("alice@example.com"))
+		})
+
+		It("should return error for unknown references and collections", func() {
+			file := writeFile("posts.yaml", `
+posts:
+  welcome:
+    author: {$fixture: users.unknown}
+`)
+			_, err := New(Config{
+				Collections: []*mongo.Collection{users, posts},
+				Files:       []string{file},
+			})
+			Expect(err).To(HaveOccurred())
+
+			_, err = New(Config{
+				Collections: []*mongo.Collection{users},
+				Files:       []string{file},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error for cyclic references", func() {
+			file := writeFile("users.yaml", `
+users:
+  alice:
+    name: {$fixture: users.bob.name}
+  bob:
+    name: {$fixture: users.alice.name}
+`)
+			_, err := New(Config{
+				Collections: []*mongo.Collection{users},
+				Files:       []string{file},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Load", func() {
+		var client *mongo.Client
+
+		BeforeEach(func() {
+			settings, err := config.Load(config.Options{
+				EnvPrefix: "MONGO_TEST",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			client, err = mongo.NewClient(settings.Client.ClientConfig())
+			Expect(err).ToNot(HaveOccurred())
+
+			timeout, err := strconv.Atoi(os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS"))
+			if err != nil {
+				timeout = 3000
+			}
+			conn := &mongo.ConnectionConfig{
+				Client:  client,
+				Timeout: uint32(timeout),
+			}
+			database := os.Getenv("MONGO_TEST_DATABASE")
+			for _, c := range []*mongo.Collection{users, posts} {
+				c.Connection = conn
+				c.Database = database
+				_, err = mongo.EnsureCollection(c)
+				Expect(err).ToNot(HaveOccurred())
+			}
+		})
+
+		AfterEach(func() {
+			err := client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should insert the fixtures, and reset these", func() {
+			file := writeFile("fixtures.yaml", `
+users:
+  alice:
+    name: Alice
+posts:
+  welcome:
+    title: Welcome
+    author: {$fixture: users.alice}
+`)
+			f, err := New(Config{
+				Collections: []*mongo.Collection{users, posts},
+				Files:       []string{file},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = f.Clear()
+			Expect(err).ToNot(HaveOccurred())
+			err = f.Load()
+			Expect(err).ToNot(HaveOccurred())
+
+			alice := f.Document("users.alice").(*user)
+			Expect(alice.CreatedAt.IsZero()).To(BeFalse())
+
+			result, err := posts.FindOne(&post{Title: "Welcome"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.(*post).Author).To(Equal(f.ID("users.alice")))
+
+			_, err = users.InsertOne(&user{Name: "Carol"})
+			Expect(err).ToNot(HaveOccurred())
+			err = f.Reset()
+			Expect(err).ToNot(HaveOccurred())
+
+			found, err := users.Find(map[string]interface{}{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(HaveLen(1))
+			Expect(found[0].(*user).ID).To(Equal(f.ID("users.alice")))
+		})
+
+		It("should insert the fixtures of each Collection at once, in order", func() {
+			first := writeFile("first.yaml", `
+users:
+  alice:
+    name: Alice
+  bob:
+    name: Bob
+posts:
+  welcome:
+    title: Welcome
+`)
+			second := writeFile("second.yaml", `
+users:
+  carol:
+    name: Carol
+`)
+			inserts := 0
+			names := []string{}
+			users.Use(func(next mongo.Handler) mongo.Handler {
+				return func(op *mongo.Operation) (interface{}, error) {
+					Expect(op.Name).To(Equal(mongo.OpInsertMany))
+					inserts++
+					for _, doc := range op.Documents {
+						names = append(names, doc.(*user).Name)
+					}
+					return next(op)
+				}
+			})
+
+			f, err := New(Config{
+				Collections: []*mongo.Collection{users, posts},
+				Files:       []string{first, second},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = f.Clear()
+			Expect(err).ToNot(HaveOccurred())
+			err = f.Load()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(inserts).To(Equal(1))
+			Expect(names).To(Equal([]string{"Alice", "Bob", "Carol"}))
+			Expect(f.Document("users.carol").(*user).CreatedAt.IsZero()).To(BeFalse())
+			Expect(f.Document("posts.welcome")).ToNot(BeNil())
+		})
+	})
+})
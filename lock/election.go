@@ -0,0 +1,208 @@
+package lock
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ElectionConfig defines the configuration for a leader-election.
+type ElectionConfig struct {
+	Locker *Locker
+	// Resource is the name of the lock contested for leadership.
+	Resource string
+	// Owner identifies this candidate. Defaults to NewOwnerID().
+	Owner string
+	// Lease is the duration after which the leadership expires if
+	// not renewed.
+	Lease time.Duration
+	// RetryInterval is the interval between acquisition-attempts, and also
+	// between renewals while this candidate is leader. Defaults to Lease/3.
+	RetryInterval time.Duration
+
+	// OnElected is called when this candidate gains leadership.
+	OnElected func()
+	// OnDemoted is called when this candidate loses leadership, including
+	// when the election is stopped.
+	OnDemoted func()
+	// OnError is called with any unexpected errors that occur while
+	// acquiring or renewing leadership.
+	OnError func(error)
+}
+
+// Election continuously contests the leadership among candidates
+// sharing the same resource. Only a single candidate is leader at a time.
+type Election struct {
+	config ElectionConfig
+
+	mutex    sync.Mutex
+	lock     *Lock
+	isLeader bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewElection creates a new Election. Use Election.Start to
+// begin contesting the leadership.
+func NewElection(config ElectionConfig) (*Election, error) {
+	if config.Locker == nil {
+		return nil, errors.New("ElectionConfig.Locker cannot be nil")
+	}
+	if config.Resource == "" {
+		return nil, errors.New("ElectionConfig.Resource cannot be blank")
+	}
+	if config.Lease <= 0 {
+		return nil, errors.New("ElectionConfig.Lease must be a positive duration")
+	}
+	if config.Owner == "" {
+		config.Owner = NewOwnerID()
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = config.Lease / 3
+	}
+
+	return &Election{
+		config: config,
+	}, nil
+}
+
+// Owner returns the owner-ID of this candidate.
+func (e *Election) Owner() string {
+	return e.config.Owner
+}
+
+// IsLeader returns true if this candidate currently holds the leadership.
+func (e *Election) IsLeader() bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.isLeader
+}
+
+// Start starts contesting the leadership in background.
+// This is a no-op if the election is already running.
+func (e *Election) Start() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if e.stop != nil {
+		return
+	}
+	e.stop = make(chan struct{})
+	e.done = make(chan struct{})
+	go e.run(e.stop, e.done)
+}
+
+// Stop stops contesting the leadership, and releases it if held.
+// This is a no-op if the election is not running.
+func (e *Election) Stop() error {
+	e.mutex.Lock()
+	stop := e.stop
+	done := e.done
+	e.stop = nil
+	e.done = nil
+	e.mutex.Unlock()
+
+	if stop == nil {
+		return nil
+	}
+	close(stop)
+	<-done
+
+	e.mutex.Lock()
+	lock := e.lock
+	e.lock = nil
+	e.mutex.Unlock()
+
+	if lock == nil {
+		return nil
+	}
+	e.setLeader(false)
+	err := lock.Release()
+	if err != nil && err != ErrLockNotHeld {
+		return errors.Wrap(err, "Election - Error Releasing Leadership")
+	}
+	return nil
+}
+
+func (e *Election) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(e.config.RetryInterval)
+	defer ticker.Stop()
+
+	for {
+		e.contest()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// contest acquires the leadership if not held, or renews it otherwise.
+func (e *Election) contest() {
+	e.mutex.Lock()
+	lock := e.lock
+	e.mutex.Unlock()
+
+	if lock != nil {
+		err := lock.Renew()
+		if err == nil {
+			return
+		}
+		e.mutex.Lock()
+		e.lock = nil
+		e.mutex.Unlock()
+		e.setLeader(false)
+
+		if err != ErrLockNotHeld {
+			e.reportError(err)
+		}
+		return
+	}
+
+	lock, err := e.config.Locker.Acquire(
+		e.config.Resource,
+		e.config.Owner,
+		e.config.Lease,
+	)
+	if err != nil {
+		if err != ErrLockHeld {
+			e.reportError(err)
+		}
+		return
+	}
+
+	e.mutex.Lock()
+	e.lock = lock
+	e.mutex.Unlock()
+	e.setLeader(true)
+}
+
+// setLeader updates the leadership-status and invokes the
+// callbacks if the status changed.
+func (e *Election) setLeader(isLeader bool) {
+	e.mutex.Lock()
+	changed := e.isLeader != isLeader
+	e.isLeader = isLeader
+	e.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+	if isLeader && e.config.OnElected != nil {
+		e.config.OnElected()
+	}
+	if !isLeader && e.config.OnDemoted != nil {
+		e.config.OnDemoted()
+	}
+}
+
+func (e *Election) reportError(err error) {
+	if e.config.OnError != nil {
+		e.config.OnError(err)
+	}
+}
@@ -0,0 +1,91 @@
+package lock
+
+import (
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Election", func() {
+	var locker *Locker
+
+	BeforeEach(func() {
+		locker = newTestLocker()
+	})
+
+	AfterEach(func() {
+		err := locker.collection.Connection.Client.Disconnect()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should return error if Lease is not specified", func() {
+		_, err := NewElection(ElectionConfig{
+			Locker:   locker,
+			Resource: "test-leader",
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should elect a single leader among candidates", func() {
+		var elected int32
+		newCandidate := func(owner string) *Election {
+			e, err := NewElection(ElectionConfig{
+				Locker:        locker,
+				Resource:      "test-leader",
+				Owner:         owner,
+				Lease:         time.Second,
+				RetryInterval: 50 * time.Millisecond,
+				OnElected: func() {
+					atomic.AddInt32(&elected, 1)
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return e
+		}
+
+		e1 := newCandidate("candidate1")
+		e2 := newCandidate("candidate2")
+		e1.Start()
+		e2.Start()
+		time.Sleep(300 * time.Millisecond)
+
+		Expect(atomic.LoadInt32(&elected)).To(Equal(int32(1)))
+		Expect(e1.IsLeader()).ToNot(Equal(e2.IsLeader()))
+
+		Expect(e1.Stop()).To(Succeed())
+		Expect(e2.Stop()).To(Succeed())
+	})
+
+	It("should hand over leadership when the leader stops", func() {
+		var demoted int32
+		e1, err := NewElection(ElectionConfig{
+			Locker:        locker,
+			Resource:      "test-leader",
+			Lease:         time.Second,
+			RetryInterval: 50 * time.Millisecond,
+			OnDemoted: func() {
+				atomic.AddInt32(&demoted, 1)
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		e1.Start()
+		Eventually(e1.IsLeader).Should(BeTrue())
+
+		e2, err := NewElection(ElectionConfig{
+			Locker:        locker,
+			Resource:      "test-leader",
+			Lease:         time.Second,
+			RetryInterval: 50 * time.Millisecond,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		e2.Start()
+
+		Expect(e1.Stop()).To(Succeed())
+		Expect(atomic.LoadInt32(&demoted)).To(Equal(int32(1)))
+		Eventually(e2.IsLeader).Should(BeTrue())
+
+		Expect(e2.Stop()).To(Succeed())
+	})
+})
@@ -0,0 +1,357 @@
+// Package lock provides distributed locks and leader-election backed by
+// a MongoDB collection.
+//
+// Each lock is a document keyed by its resource-name. A unique index on the
+// resource ensures only a single owner can hold the lock, and a TTL-index
+// removes the documents whose leases have long expired.
+//
+// The lease-expiry is set and checked using the clocks of lock-clients, so
+// these should be kept in sync. The acquiredAt time is set by the server.
+package lock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo/updateopt"
+	"github.com/pkg/errors"
+)
+
+// DefaultCollection is the collection used for storing locks when
+// LockerConfig.Collection is not specified.
+const DefaultCollection = "locks"
+
+// ErrLockHeld is returned when the lock is currently held by another owner.
+var ErrLockHeld = errors.New("Lock is held by another owner")
+
+// ErrLockNotHeld is returned when renewing or releasing a lock which
+// is not (or no longer) held by the owner.
+var ErrLockNotHeld = errors.New("Lock is not held by the owner")
+
+// lockDoc is the document stored for every lock.
+type lockDoc struct {
+	ID         objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Resource   string            `bson:"resource" json:"resource"`
+	Owner      string            `bson:"owner" json:"owner"`
+	AcquiredAt time.Time         `bson:"acquiredAt" json:"acquiredAt"`
+	ExpiresAt  time.Time         `bson:"expiresAt" json:"expiresAt"`
+}
+
+// LockerConfig defines the configuration for a Locker.
+type LockerConfig struct {
+	Connection *mongo.ConnectionConfig
+	Database   string
+	// Collection to store locks in. Defaults to DefaultCollection.
+	Collection string
+	// ExpireAfterSeconds is the time after lease-expiry when the lock-documents
+	// are removed by MongoDB. Defaults to 3600.
+	// This is only for housekeeping, expired locks can be re-acquired regardless.
+	ExpireAfterSeconds int32
+}
+
+// Locker acquires and manages distributed locks.
+type Locker struct {
+	collection *mongo.Collection
+}
+
+// NewLocker creates a new Locker, and ensures the lock-collection
+// along with its indexes.
+func NewLocker(config LockerConfig) (*Locker, error) {
+	if config.Connection == nil {
+		return nil, errors.New("LockerConfig.Connection cannot be nil")
+	}
+	if config.Database == "" {
+		return nil, errors.New("LockerConfig.Database cannot be blank")
+	}
+	if config.Collection == "" {
+		config.Collection = DefaultCollection
+	}
+	if config.ExpireAfterSeconds == 0 {
+		config.ExpireAfterSeconds = 3600
+	}
+
+	c, err := mongo.EnsureCollection(&mongo.Collection{
+		Connection:   config.Connection,
+		Database:     config.Database,
+		Name:         config.Collection,
+		SchemaStruct: &lockDoc{},
+		Indexes: []mongo.IndexConfig{
+			mongo.IndexConfig{
+				ColumnConfig: []mongo.IndexColumnConfig{
+					mongo.IndexColumnConfig{
+						Name: "resource",
+					},
+				},
+				IsUnique: true,
+				Name:     "lock_resource_index",
+			},
+			mongo.IndexConfig{
+				ColumnConfig: []mongo.IndexColumnConfig{
+					mongo.IndexColumnConfig{
+						Name: "expiresAt",
+					},
+				},
+				ExpireAfterSeconds: config.ExpireAfterSeconds,
+				Name:               "lock_expiry_index",
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Ensuring Lock-Collection")
+	}
+
+	return &Locker{
+		collection: c,
+	}, nil
+}
+
+// NewOwnerID generates an owner-ID unique to this process, prefixed
+// by hostname for easier identification of lock-holders.
+func NewOwnerID() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), objectid.New().Hex())
+}
+
+// Acquire tries to acquire the lock on specified resource for the
+// provided lease-duration. The lock is granted if no other owner holds it,
+// or if the current holder's lease has expired. Acquiring a lock already
+// held by the same owner extends its lease.
+// ErrLockHeld is returned if another owner holds the lock.
+func (l *Locker) Acquire(
+	resource string,
+	owner string,
+	lease time.Duration,
+) (*Lock, error) {
+	if resource == "" || owner == "" {
+		return nil, errors.New("Acquire - Resource and Owner cannot be blank")
+	}
+	if lease <= 0 {
+		return nil, errors.New("Acquire - Lease must be a positive duration")
+	}
+
+	// The times are set as BSON-dates, since the time.Time values
+	// in maps are not encoded as dates
+	now := time.Now()
+	filter := bson.NewDocument(
+		bson.EC.String("resource", resource),
+		bson.EC.ArrayFromElements(
+			"$or",
+			bson.VC.DocumentFromElements(
+				bson.EC.String("owner", owner),
+			),
+			bson.VC.DocumentFromElements(
+				bson.EC.SubDocumentFromElements(
+					"expiresAt",
+					bson.EC.Time("$lte", now),
+				),
+			),
+		),
+	)
+	update := bson.NewDocument(
+		bson.EC.SubDocumentFromElements(
+			"$set",
+			bson.EC.String("owner", owner),
+			bson.EC.Time("expiresAt", now.Add(lease)),
+		),
+		bson.EC.SubDocumentFromElements(
+			"$currentDate",
+			bson.EC.Boolean("acquiredAt", true),
+		),
+	)
+
+	ctx, cancel := l.timeoutContext()
+	defer cancel()
+
+	// If the lock is held by someone else, the filter does not match,
+	// and the upsert then fails on unique resource-index.
+	_, err := l.collection.Collection().UpdateOne(
+		ctx, filter, update, updateopt.Upsert(true),
+	)
+	if err != nil {
+		if _, isDuplicate := mongo.IsDuplicateKey(err); isDuplicate {
+			return nil, ErrLockHeld
+		}
+		return nil, errors.Wrap(err, "Acquire - Error Acquiring Lock")
+	}
+
+	return &Lock{
+		Resource: resource,
+		Owner:    owner,
+		Lease:    lease,
+		locker:   l,
+	}, nil
+}
+
+// renew extends the lease for the lock if its still held by owner.
+func (l *Locker) renew(resource, owner string, lease time.Duration) error {
+	now := time.Now()
+	filter := bson.NewDocument(
+		bson.EC.String("resource", resource),
+		bson.EC.String("owner", owner),
+		bson.EC.SubDocumentFromElements(
+			"expiresAt",
+			bson.EC.Time("$gt", now),
+		),
+	)
+	update := bson.NewDocument(
+		bson.EC.SubDocumentFromElements(
+			"$set",
+			bson.EC.Time("expiresAt", now.Add(lease)),
+		),
+	)
+
+	ctx, cancel := l.timeoutContext()
+	defer cancel()
+	result, err := l.collection.Collection().UpdateOne(ctx, filter, update)
+	if err != nil {
+		return errors.Wrap(err, "Renew - Error Renewing Lock")
+	}
+	if result.MatchedCount == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// release removes the lock if its held by owner.
+func (l *Locker) release(resource, owner string) error {
+	result, err := l.collection.DeleteMany(map[string]interface{}{
+		"resource": resource,
+		"owner":    owner,
+	})
+	if err != nil {
+		return errors.Wrap(err, "Release - Error Releasing Lock")
+	}
+	if result.DeletedCount == 0 {
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Holder returns the current owner of lock on resource.
+// A blank string is returned if the lock is not held by anyone.
+func (l *Locker) Holder(resource string) (string, error) {
+	filter := bson.NewDocument(
+		bson.EC.String("resource", resource),
+		bson.EC.SubDocumentFromElements(
+			"expiresAt",
+			bson.EC.Time("$gt", time.Now()),
+		),
+	)
+
+	ctx, cancel := l.timeoutContext()
+	defer cancel()
+	holder := &lockDoc{}
+	err := l.collection.Collection().FindOne(ctx, filter).Decode(holder)
+	if err != nil {
+		if mongo.IsNotFound(err) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "Holder - Error Finding Lock")
+	}
+	return holder.Owner, nil
+}
+
+func (l *Locker) timeoutContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(
+		context.Background(),
+		time.Duration(l.collection.Connection.Timeout)*time.Millisecond,
+	)
+}
+
+// Lock represents an acquired lock.
+type Lock struct {
+	Resource string
+	Owner    string
+	Lease    time.Duration
+
+	locker    *Locker
+	mutex     sync.Mutex
+	stopRenew chan struct{}
+	renewDone chan struct{}
+}
+
+// Renew extends the lock's lease by its lease-duration.
+// ErrLockNotHeld is returned if the lock was lost (such as
+// when the lease expired and another owner acquired it).
+func (l *Lock) Renew() error {
+	return l.locker.renew(l.Resource, l.Owner, l.Lease)
+}
+
+// Release releases the lock, and stops any background-renewal.
+// ErrLockNotHeld is returned if the lock is no longer held by the owner.
+func (l *Lock) Release() error {
+	l.StopRenewal()
+	return l.locker.release(l.Resource, l.Owner)
+}
+
+// StartRenewal starts renewing the lock in background at the specified
+// interval, which should be sufficiently shorter than the lease.
+// The returned channel receives an error and is closed if a renewal fails,
+// after which the lock must be considered lost. The channel is closed
+// without errors when the renewal is stopped using StopRenewal or Release.
+// This is a no-op returning nil if the renewal is already running.
+func (l *Lock) StartRenewal(interval time.Duration) <-chan error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.stopRenew != nil {
+		return nil
+	}
+	if interval <= 0 {
+		interval = l.Lease / 3
+	}
+
+	errChan := make(chan error, 1)
+	stopRenew := make(chan struct{})
+	renewDone := make(chan struct{})
+	l.stopRenew = stopRenew
+	l.renewDone = renewDone
+
+	go func() {
+		defer close(renewDone)
+		defer close(errChan)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopRenew:
+				return
+			case <-ticker.C:
+				err := l.Renew()
+				if err != nil {
+					errChan <- err
+					return
+				}
+			}
+		}
+	}()
+	return errChan
+}
+
+// StopRenewal stops the background-renewal of lock.
+// This is a no-op if the renewal is not running.
+func (l *Lock) StopRenewal() {
+	l.mutex.Lock()
+	stopRenew := l.stopRenew
+	renewDone := l.renewDone
+	l.stopRenew = nil
+	l.renewDone = nil
+	l.mutex.Unlock()
+
+	if stopRenew == nil {
+		return
+	}
+	close(stopRenew)
+	<-renewDone
+}
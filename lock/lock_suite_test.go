@@ -0,0 +1,24 @@
+package lock
+
+import (
+	"log"
+	"testing"
+
+	"github.com/joho/godotenv"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+func TestLock(t *testing.T) {
+	err := godotenv.Load("../test.env")
+	if err != nil {
+		err = errors.Wrap(err,
+			".env file not found, env-vars will be read as set in environment",
+		)
+		log.Println(err)
+	}
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Lock Suite")
+}
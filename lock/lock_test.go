@@ -0,0 +1,182 @@
+package lock
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// newTestLocker creates a Locker on a freshly dropped test-database.
+func newTestLocker() *Locker {
+	hosts := os.Getenv("MONGO_TEST_HOSTS")
+	username := os.Getenv("MONGO_TEST_USERNAME")
+	password := os.Getenv("MONGO_TEST_PASSWORD")
+	resourceTimeoutStr := os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS")
+	testDatabase := os.Getenv("MONGO_TEST_DATABASE")
+
+	resourceTimeout, err := strconv.Atoi(resourceTimeoutStr)
+	if err != nil {
+		err = errors.Wrap(
+			err,
+			"error getting RESOURCE_TIMEOUT from env, will use 3000",
+		)
+		log.Println(err)
+		resourceTimeout = 3000
+	}
+
+	client, err := mongo.NewClient(mongo.ClientConfig{
+		Hosts:               *commonutil.ParseHosts(hosts),
+		Username:            username,
+		Password:            password,
+		TimeoutMilliseconds: uint32(resourceTimeout),
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	dbCtx, dbCancel := context.WithTimeout(
+		context.Background(),
+		time.Duration(resourceTimeout)*time.Millisecond,
+	)
+	err = client.Database(testDatabase).Drop(dbCtx)
+	dbCancel()
+	Expect(err).ToNot(HaveOccurred())
+
+	locker, err := NewLocker(LockerConfig{
+		Connection: &mongo.ConnectionConfig{
+			Client:  client,
+			Timeout: uint32(resourceTimeout),
+		},
+		Database: testDatabase,
+	})
+	Expect(err).ToNot(HaveOccurred())
+	return locker
+}
+
+var _ = Describe("Locker", func() {
+	var locker *Locker
+
+	BeforeEach(func() {
+		locker = newTestLocker()
+	})
+
+	AfterEach(func() {
+		err := locker.collection.Connection.Client.Disconnect()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("NewLocker", func() {
+		It("should return error if Connection is nil", func() {
+			_, err := NewLocker(LockerConfig{
+				Database: "test",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if Database is blank", func() {
+			_, err := NewLocker(LockerConfig{
+				Connection: locker.collection.Connection,
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Acquire", func() {
+		It("should acquire the lock if its not held", func() {
+			lock, err := locker.Acquire("test-resource", "owner1", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lock.Owner).To(Equal("owner1"))
+
+			holder, err := locker.Holder("test-resource")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(holder).To(Equal("owner1"))
+		})
+
+		It("should return ErrLockHeld if the lock is held by another owner", func() {
+			_, err := locker.Acquire("test-resource", "owner1", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = locker.Acquire("test-resource", "owner2", time.Minute)
+			Expect(err).To(Equal(ErrLockHeld))
+		})
+
+		It("should allow the same owner to re-acquire the lock", func() {
+			_, err := locker.Acquire("test-resource", "owner1", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = locker.Acquire("test-resource", "owner1", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should acquire the lock if the previous lease has expired", func() {
+			_, err := locker.Acquire("test-resource", "owner1", 50*time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+			time.Sleep(100 * time.Millisecond)
+
+			lock, err := locker.Acquire("test-resource", "owner2", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(lock.Owner).To(Equal("owner2"))
+		})
+
+		It("should return error if lease is not positive", func() {
+			_, err := locker.Acquire("test-resource", "owner1", 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Lock", func() {
+		It("should renew the lease while the lock is held", func() {
+			lock, err := locker.Acquire("test-resource", "owner1", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = lock.Renew()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should return ErrLockNotHeld when renewing a lost lock", func() {
+			lock, err := locker.Acquire("test-resource", "owner1", 50*time.Millisecond)
+			Expect(err).ToNot(HaveOccurred())
+			time.Sleep(100 * time.Millisecond)
+
+			_, err = locker.Acquire("test-resource", "owner2", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = lock.Renew()
+			Expect(err).To(Equal(ErrLockNotHeld))
+		})
+
+		It("should release the lock", func() {
+			lock, err := locker.Acquire("test-resource", "owner1", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = lock.Release()
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = locker.Acquire("test-resource", "owner2", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should keep the lock alive with background-renewal", func() {
+			lease := 300 * time.Millisecond
+			lock, err := locker.Acquire("test-resource", "owner1", lease)
+			Expect(err).ToNot(HaveOccurred())
+
+			errChan := lock.StartRenewal(100 * time.Millisecond)
+			time.Sleep(2 * lease)
+
+			_, err = locker.Acquire("test-resource", "owner2", time.Minute)
+			Expect(err).To(Equal(ErrLockHeld))
+
+			err = lock.Release()
+			Expect(err).ToNot(HaveOccurred())
+			// Channel is closed without errors after renewal stops
+			Expect(<-errChan).To(BeNil())
+		})
+	})
+})
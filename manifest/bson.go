@@ -0,0 +1,109 @@
+package manifest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+)
+
+// renderDocument renders the BSON-document as JSON for comparison and
+// display. The numbers are rendered alike regardless of their BSON-types,
+// so an int32 and a double of same value are considered equal.
+func renderDocument(doc *bson.Document) string {
+	if doc == nil {
+		return ""
+	}
+	rendered, err := json.Marshal(plainValue(bson.VC.Document(doc)))
+	if err != nil {
+		return doc.String()
+	}
+	return string(rendered)
+}
+
+// orderedDocument is a document that retains the order of its keys
+// when marshalled to JSON.
+type orderedDocument []orderedField
+
+type orderedField struct {
+	key   string
+	value interface{}
+}
+
+func (d orderedDocument) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("{")
+	for i, field := range d {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		key, err := json.Marshal(field.key)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(field.value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteString(":")
+		buf.Write(value)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// plainValue converts the BSON-value to a value that can be marshalled
+// to JSON.
+func plainValue(v *bson.Value) interface{} {
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		doc := orderedDocument{}
+		iter := v.MutableDocument().Iterator()
+		for iter.Next() {
+			elem := iter.Element()
+			doc = append(doc, orderedField{
+				key:   elem.Key(),
+				value: plainValue(elem.Value()),
+			})
+		}
+		return doc
+
+	case bson.TypeArray:
+		arr := v.MutableArray()
+		values := []interface{}{}
+		for i := 0; i < arr.Len(); i++ {
+			item, err := arr.Lookup(uint(i))
+			if err != nil {
+				continue
+			}
+			values = append(values, plainValue(item))
+		}
+		return values
+
+	case bson.TypeInt32:
+		return float64(v.Int32())
+	case bson.TypeInt64:
+		return float64(v.Int64())
+	case bson.TypeDouble:
+		return v.Double()
+	case bson.TypeString:
+		return v.StringValue()
+	case bson.TypeBoolean:
+		return v.Boolean()
+	case bson.TypeNull:
+		return nil
+	case bson.TypeObjectID:
+		return v.ObjectID().Hex()
+	case bson.TypeDateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano)
+	case bson.TypeDecimal128:
+		return v.Decimal128().String()
+	case bson.TypeRegex:
+		pattern, options := v.Regex()
+		return "/" + pattern + "/" + options
+	}
+	return fmt.Sprintf("%v", v.Interface())
+}
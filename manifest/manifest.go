@@ -0,0 +1,260 @@
+// Package manifest declares the databases, collections, indexes, and
+// validators in YAML or JSON files, and applies them to a MongoDB server.
+//
+// A Plan lists the Changes required for the server to match a Manifest,
+// which are then applied using Apply. The collections and indexes are
+// created the same way as mongo.EnsureCollection creates them.
+package manifest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/TerrexTech/go-mongoutils/config"
+	"github.com/TerrexTech/go-mongoutils/extjson"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Manifest declares the databases and their collections, such as:
+//
+//	databases:
+//	  - name: test
+//	    collections:
+//	      - name: test_coll
+//	        validator:
+//	          $jsonSchema:
+//	            required: ["word"]
+//	        indexes:
+//	          - name: test_index
+//	            unique: true
+//	            columns:
+//	              - name: word
+//	                descending: true
+type Manifest struct {
+	Databases []Database `yaml:"databases"`
+}
+
+// Database declares a database and its collections.
+type Database struct {
+	Name        string       `yaml:"name"`
+	Collections []Collection `yaml:"collections"`
+}
+
+// Collection declares a collection along with its options and indexes.
+type Collection struct {
+	Name    string            `yaml:"name"`
+	Options CollectionOptions `yaml:"options"`
+	// Validator is the query-document, such as a "$jsonSchema", that the
+	// documents must match. The server's validator is left unchanged if
+	// this is not set.
+	Validator yaml.MapSlice `yaml:"validator"`
+	// ValidationLevel is "off", "strict", or "moderate".
+	ValidationLevel string `yaml:"validationLevel"`
+	// ValidationAction is "error" or "warn".
+	ValidationAction string                 `yaml:"validationAction"`
+	Indexes          []config.IndexSettings `yaml:"indexes"`
+}
+
+// CollectionOptions declares the options set when creating a collection.
+// These cannot be changed once the collection is created.
+type CollectionOptions struct {
+	Capped       bool  `yaml:"capped"`
+	SizeBytes    int64 `yaml:"size"`
+	MaxDocuments int64 `yaml:"max"`
+}
+
+// Load reads the Manifest from YAML (".yaml", ".yml") or JSON (".json")
+// files. The databases in later files are merged into earlier ones, with
+// the collections of same name being replaced. A *config.ValidationError
+// is returned listing all the problems found.
+func Load(paths ...string) (*Manifest, error) {
+	problems := []string{}
+	manifest := &Manifest{}
+
+	for _, path := range paths {
+		fileManifest, err := readFile(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", path, err))
+			continue
+		}
+		manifest.merge(fileManifest)
+	}
+
+	problems = append(problems, manifest.validate()...)
+	if len(problems) > 0 {
+		return nil, &config.ValidationError{
+			Problems: problems,
+		}
+	}
+	return manifest, nil
+}
+
+// readFile decodes the manifest-file. JSON is decoded as YAML, since YAML
+// is a superset of JSON.
+func readFile(path string) (*Manifest, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+	default:
+		return nil, fmt.Errorf("unknown manifest-format: %s", filepath.Ext(path))
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := &Manifest{}
+	err = yaml.UnmarshalStrict(data, manifest)
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// merge adds the databases and collections from other Manifest.
+func (m *Manifest) merge(other *Manifest) {
+	for _, otherDB := range other.Databases {
+		db := m.Database(otherDB.Name)
+		if db == nil {
+			m.Databases = append(m.Databases, otherDB)
+			continue
+		}
+		for _, otherColl := range otherDB.Collections {
+			coll := db.Collection(otherColl.Name)
+			if coll == nil {
+				db.Collections = append(db.Collections, otherColl)
+			} else {
+				*coll = otherColl
+			}
+		}
+	}
+}
+
+// Database returns the named Database, or nil if it is not declared.
+func (m *Manifest) Database(name string) *Database {
+	for i, db := range m.Databases {
+		if db.Name == name {
+			return &m.Databases[i]
+		}
+	}
+	return nil
+}
+
+// Collection returns the named Collection, or nil if it is not declared.
+func (d *Database) Collection(name string) *Collection {
+	for i, coll := range d.Collections {
+		if coll.Name == name {
+			return &d.Collections[i]
+		}
+	}
+	return nil
+}
+
+func (m *Manifest) validate() []string {
+	problems := []string{}
+	for i, db := range m.Databases {
+		dbPath := fmt.Sprintf("databases[%d]", i)
+		if db.Name == "" {
+			problems = append(problems, dbPath+".name is required")
+		}
+		for j, coll := range db.Collections {
+			collPath := fmt.Sprintf("%s.collections[%d]", dbPath, j)
+			problems = append(problems, coll.validate(collPath)...)
+		}
+	}
+	return problems
+}
+
+func (c *Collection) validate(path string) []string {
+	problems := []string{}
+	if c.Name == "" {
+		problems = append(problems, path+".name is required")
+	}
+	if c.Options.Capped && c.Options.SizeBytes <= 0 {
+		problems = append(problems, path+".options.size is required for capped")
+	}
+	if !c.Options.Capped && (c.Options.SizeBytes > 0 || c.Options.MaxDocuments > 0) {
+		problems = append(problems, path+".options.size and max require capped")
+	}
+
+	switch c.ValidationLevel {
+	case "", "off", "strict", "moderate":
+	default:
+		problems = append(problems, fmt.Sprintf(
+			"%s.validationLevel: %s is not supported", path, c.ValidationLevel,
+		))
+	}
+	switch c.ValidationAction {
+	case "", "error", "warn":
+	default:
+		problems = append(problems, fmt.Sprintf(
+			"%s.validationAction: %s is not supported", path, c.ValidationAction,
+		))
+	}
+	if c.Validator != nil {
+		_, err := c.validatorDocument()
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s.validator: %s", path, err))
+		}
+	}
+
+	names := map[string]bool{}
+	for k, index := range c.Indexes {
+		indexPath := fmt.Sprintf("%s.indexes[%d]", path, k)
+		problems = append(problems, index.Validate(indexPath)...)
+
+		name := indexName(index)
+		if names[name] {
+			problems = append(
+				problems,
+				fmt.Sprintf("%s: duplicate index-name: %s", indexPath, name),
+			)
+		}
+		names[name] = true
+	}
+	return problems
+}
+
+// validatorDocument converts the Validator to a BSON-document.
+func (c *Collection) validatorDocument() (*bson.Document, error) {
+	return extjson.ToDocument(c.Validator)
+}
+
+// mongoOptions returns the options for mongo.CreateCollection.
+func (c *Collection) mongoOptions() (*mongo.CollectionOptions, error) {
+	options := &mongo.CollectionOptions{
+		Capped:           c.Options.Capped,
+		SizeBytes:        c.Options.SizeBytes,
+		MaxDocuments:     c.Options.MaxDocuments,
+		ValidationLevel:  c.ValidationLevel,
+		ValidationAction: c.ValidationAction,
+	}
+	if c.Validator != nil {
+		validator, err := c.validatorDocument()
+		if err != nil {
+			return nil, err
+		}
+		options.Validator = validator
+	}
+	return options, nil
+}
+
+// indexName returns the index's name, or the name generated by
+// server if the index is not named, such as "word_-1_hits_1".
+func indexName(index config.IndexSettings) string {
+	if index.Name != "" {
+		return index.Name
+	}
+	parts := []string{}
+	for _, column := range index.Columns {
+		order := "1"
+		if column.Descending {
+			order = "-1"
+		}
+		parts = append(parts, column.Name, order)
+	}
+	return strings.Join(parts, "_")
+}
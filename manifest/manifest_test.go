@@ -0,0 +1,313 @@
+package manifest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/config"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Manifest", func() {
+	var dir string
+
+	// writeFile writes the content into a file in the temp-dir
+	// and returns the file's path.
+	writeFile := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0600)
+		Expect(err).ToNot(HaveOccurred())
+		return path
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "mongoutils-manifest")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(dir)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("Load", func() {
+		It("should load and merge YAML and JSON files", func() {
+			yamlFile := writeFile("manifest.yaml", `
+databases:
+  - name: test
+    collections:
+      - name: words
+        validator:
+          $jsonSchema:
+            required: ["word"]
+        indexes:
+          - columns:
+              - name: word
+                descending: true
+      - name: logs
+        options:
+          capped: true
+          size: 4096
+`)
+			jsonFile := writeFile("manifest.json", `{
+  "databases": [{
+    "name": "test",
+    "collections": [{"name": "logs", "validationAction": "warn"}]
+  }]
+}`)
+			manifest, err := Load(yamlFile, jsonFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(manifest.Databases).To(HaveLen(1))
+
+			db := manifest.Database("test")
+			Expect(db.Collections).To(HaveLen(2))
+			words := db.Collection("words")
+			Expect(indexName(words.Indexes[0])).To(Equal("word_-1"))
+
+			validator, err := words.validatorDocument()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(renderDocument(validator)).To(Equal(
+				`{"$jsonSchema":{"required":["word"]}}`,
+			))
+
+			// The JSON-file replaces the collection from YAML-file
+			logs := db.Collection("logs")
+			Expect(logs.Options.Capped).To(BeFalse())
+			Expect(logs.ValidationAction).To(Equal("warn"))
+		})
+
+		It("should list every problem in ValidationError", func() {
+			file := writeFile("manifest.yaml", `
+databases:
+  - collections:
+      - options:
+          capped: true
+        validationLevel: loose
+        indexes:
+          - name: test_index
+          - name: test_index
+            columns:
+              - name: word
+`)
+			_, err := Load(file, filepath.Join(dir, "manifest.toml"))
+			Expect(err).To(HaveOccurred())
+			validationErr, ok := err.(*config.ValidationError)
+			Expect(ok).To(BeTrue())
+
+			problems := validationErr.Problems
+			Expect(problems[0]).To(ContainSubstring("manifest.toml"))
+			Expect(problems[1:]).To(Equal([]string{
+				"databases[0].name is required",
+				"databases[0].collections[0].name is required",
+				"databases[0].collections[0].options.size is required for capped",
+				"databases[0].collections[0].validationLevel: loose is not supported",
+				"databases[0].collections[0].indexes[0].columns is required",
+				"databases[0].collections[0].indexes[1]: " +
+					"duplicate index-name: test_index",
+			}))
+		})
+	})
+
+	Describe("renderDocument", func() {
+		It("should render numbers alike regardless of their types", func() {
+			int32Doc := bson.NewDocument(bson.EC.Int32("minimum", 1))
+			doubleDoc := bson.NewDocument(bson.EC.Double("minimum", 1))
+			Expect(renderDocument(int32Doc)).To(Equal(renderDocument(doubleDoc)))
+		})
+
+		It("should retain the order of keys", func() {
+			doc := bson.NewDocument(
+				bson.EC.Int32("word", -1),
+				bson.EC.Int32("hits", 1),
+			)
+			Expect(renderDocument(doc)).To(Equal(`{"word":-1,"hits":1}`))
+		})
+	})
+
+	Describe("diffCollection", func() {
+		coll := &Collection{
+			Name: "words",
+			Indexes: []config.IndexSettings{
+				{
+					Name:   "word_index",
+					Unique: true,
+					Columns: []config.IndexColumnSettings{
+						{Name: "word"},
+					},
+				},
+				{
+					Columns: []config.IndexColumnSettings{
+						{Name: "hits", Descending: true},
+					},
+				},
+			},
+		}
+
+		It("should create missing collection and its indexes", func() {
+			changes, err := diffCollection("test", coll, &collectionState{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(3))
+			Expect(changes[0].Action).To(Equal(ActionCreateCollection))
+			Expect(changes[1].Action).To(Equal(ActionCreateIndex))
+			Expect(changes[1].Index).To(Equal("word_index"))
+			Expect(changes[2].Action).To(Equal(ActionCreateIndex))
+			Expect(changes[2].Index).To(Equal("hits_-1"))
+		})
+
+		It("should recreate changed indexes and drop undeclared ones", func() {
+			state := &collectionState{
+				exists: true,
+				indexes: []indexState{
+					{name: "_id_", keys: `{"_id":1}`},
+					{name: "word_index", keys: `{"word":1}`},
+					{name: "hits_-1", keys: `{"hits":-1}`},
+					{name: "old_index", keys: `{"old":1}`},
+				},
+			}
+			changes, err := diffCollection("test", coll, state)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(2))
+
+			Expect(changes[0].Action).To(Equal(ActionRecreateIndex))
+			Expect(changes[0].Index).To(Equal("word_index"))
+			Expect(changes[0].Destructive).To(BeTrue())
+			Expect(changes[0].Expected).To(ContainSubstring("unique: true"))
+			Expect(changes[0].Actual).To(ContainSubstring("unique: false"))
+
+			Expect(changes[1].Action).To(Equal(ActionDropIndex))
+			Expect(changes[1].Index).To(Equal("old_index"))
+			Expect(changes[1].String()).To(Equal("drop-index test.words old_index"))
+		})
+
+		It("should report conflicting options of existing collection", func() {
+			capped := &Collection{
+				Name: "logs",
+				Options: CollectionOptions{
+					Capped:    true,
+					SizeBytes: 4096,
+				},
+			}
+			changes, err := diffCollection("test", capped, &collectionState{
+				exists: true,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Action).To(Equal(ActionConflict))
+		})
+	})
+
+	Describe("Apply", func() {
+		var (
+			client   *mongo.Client
+			database string
+			ctx      context.Context
+			cancel   context.CancelFunc
+		)
+
+		BeforeEach(func() {
+			settings, err := config.Load(config.Options{
+				EnvPrefix: "MONGO_TEST",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			client, err = mongo.NewClient(settings.Client.ClientConfig())
+			Expect(err).ToNot(HaveOccurred())
+
+			database = os.Getenv("MONGO_TEST_DATABASE")
+			ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+			err = client.Database(database).Drop(ctx)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			err := client.Database(database).Drop(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			cancel()
+			err = client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should apply the manifest until server matches it", func() {
+			file := writeFile("manifest.yaml", `
+databases:
+  - name: `+database+`
+    collections:
+      - name: words
+        validator:
+          $jsonSchema:
+            required: ["word"]
+            properties:
+              hits:
+                minimum: 0
+        validationAction: error
+        indexes:
+          - name: word_index
+            unique: true
+            columns:
+              - name: word
+`)
+			manifest, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+
+			plan, err := NewPlan(ctx, client, manifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.Changes).To(HaveLen(2))
+
+			result, err := Apply(ctx, client, plan, ApplyOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Applied).To(HaveLen(2))
+			Expect(result.Skipped).To(BeEmpty())
+
+			plan, err = NewPlan(ctx, client, manifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.Changes).To(BeEmpty())
+
+			// The validator is enforced
+			_, err = client.Database(database).Collection("words").InsertOne(
+				ctx,
+				bson.NewDocument(bson.EC.Int32("hits", 1)),
+			)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should skip destructive changes unless allowed", func() {
+			coll := client.Database(database).Collection("words")
+			err := mongo.CreateIndex(ctx, coll, mongo.IndexConfig{
+				Name: "old_index",
+				ColumnConfig: []mongo.IndexColumnConfig{
+					{Name: "old"},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			file := writeFile("manifest.yaml", `
+databases:
+  - name: `+database+`
+    collections:
+      - name: words
+`)
+			manifest, err := Load(file)
+			Expect(err).ToNot(HaveOccurred())
+			plan, err := NewPlan(ctx, client, manifest)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(plan.Changes).To(HaveLen(1))
+			Expect(plan.Changes[0].Action).To(Equal(ActionDropIndex))
+
+			result, err := Apply(ctx, client, plan, ApplyOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Skipped).To(HaveLen(1))
+
+			result, err = Apply(ctx, client, plan, ApplyOptions{
+				AllowDestructive: true,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Applied).To(HaveLen(1))
+		})
+	})
+})
@@ -0,0 +1,300 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/TerrexTech/go-mongoutils/config"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// Action is the kind of Change required to match the Manifest.
+type Action string
+
+// The Actions in a Plan.
+const (
+	ActionCreateCollection Action = "create-collection"
+	ActionUpdateValidator  Action = "update-validator"
+	ActionCreateIndex      Action = "create-index"
+	// ActionRecreateIndex drops and recreates an index that differs from
+	// its declaration, since the indexes cannot be modified.
+	ActionRecreateIndex Action = "recreate-index"
+	// ActionDropIndex drops an index that is not declared in Manifest.
+	ActionDropIndex Action = "drop-index"
+	// ActionConflict is a difference that cannot be applied, such as
+	// the options of an existing collection.
+	ActionConflict Action = "conflict"
+)
+
+// Change is a difference between the Manifest and the server, along with
+// the Action to resolve it.
+type Change struct {
+	Action     Action
+	Database   string
+	Collection string
+	// Index is the index-name for index-Actions.
+	Index string
+	// Expected is the state declared in Manifest, and Actual is the
+	// server's state.
+	Expected string
+	Actual   string
+	// Destructive Changes drop the indexes, and are only applied
+	// with ApplyOptions.AllowDestructive.
+	Destructive bool
+
+	collection *Collection
+	index      *config.IndexSettings
+}
+
+// String describes the Change, such as: "create-index test.test_coll test_index".
+func (c Change) String() string {
+	str := fmt.Sprintf("%s %s.%s", c.Action, c.Database, c.Collection)
+	if c.Index != "" {
+		str += " " + c.Index
+	}
+	return str
+}
+
+// Plan lists the Changes required for the server to match the Manifest.
+type Plan struct {
+	Changes []Change
+}
+
+// NewPlan compares the Manifest against the server, and returns the Plan
+// for resolving the differences.
+func NewPlan(
+	ctx context.Context,
+	client *mongo.Client,
+	manifest *Manifest,
+) (*Plan, error) {
+	plan := &Plan{}
+	for _, db := range manifest.Databases {
+		for i := range db.Collections {
+			coll := &db.Collections[i]
+			state, err := readCollectionState(ctx, client.Database(db.Name), coll.Name)
+			if err != nil {
+				return nil, errors.Wrapf(
+					err,
+					"Error Reading Collection: %s.%s", db.Name, coll.Name,
+				)
+			}
+			changes, err := diffCollection(db.Name, coll, state)
+			if err != nil {
+				return nil, err
+			}
+			plan.Changes = append(plan.Changes, changes...)
+		}
+	}
+	return plan, nil
+}
+
+// diffCollection returns the Changes required for the collection's state
+// to match its declaration.
+func diffCollection(
+	database string,
+	coll *Collection,
+	state *collectionState,
+) ([]Change, error) {
+	newChange := func(action Action) Change {
+		return Change{
+			Action:     action,
+			Database:   database,
+			Collection: coll.Name,
+			collection: coll,
+		}
+	}
+	changes := []Change{}
+
+	validator, err := coll.validatorDocument()
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid Validator: %s.%s", database, coll.Name)
+	}
+
+	if !state.exists {
+		changes = append(changes, newChange(ActionCreateCollection))
+	} else {
+		expected := fmt.Sprintf(
+			"capped: %t, size: %d, max: %d",
+			coll.Options.Capped, coll.Options.SizeBytes, coll.Options.MaxDocuments,
+		)
+		actual := fmt.Sprintf(
+			"capped: %t, size: %d, max: %d",
+			state.capped, state.sizeBytes, state.maxDocuments,
+		)
+		if expected != actual {
+			change := newChange(ActionConflict)
+			change.Expected = expected
+			change.Actual = actual
+			changes = append(changes, change)
+		}
+
+		// The validation is only compared if declared
+		isValidatorChanged := (coll.Validator != nil &&
+			renderDocument(validator) != renderDocument(state.validator)) ||
+			(coll.ValidationLevel != "" &&
+				coll.ValidationLevel != state.validationLevel) ||
+			(coll.ValidationAction != "" &&
+				coll.ValidationAction != state.validationAction)
+		if isValidatorChanged {
+			change := newChange(ActionUpdateValidator)
+			change.Expected = fmt.Sprintf(
+				"validator: %s, level: %s, action: %s",
+				renderDocument(validator), coll.ValidationLevel, coll.ValidationAction,
+			)
+			change.Actual = fmt.Sprintf(
+				"validator: %s, level: %s, action: %s",
+				renderDocument(state.validator),
+				state.validationLevel,
+				state.validationAction,
+			)
+			changes = append(changes, change)
+		}
+	}
+
+	declared := map[string]bool{}
+	for i := range coll.Indexes {
+		index := &coll.Indexes[i]
+		name := indexName(*index)
+		declared[name] = true
+
+		expected := describeIndex(indexState{
+			keys:               indexKeys(*index),
+			unique:             index.Unique,
+			expireAfterSeconds: index.ExpireAfterSeconds,
+		})
+		actual := ""
+		for _, existing := range state.indexes {
+			if existing.name == name {
+				actual = describeIndex(existing)
+			}
+		}
+		if expected == actual {
+			continue
+		}
+
+		change := newChange(ActionCreateIndex)
+		if actual != "" {
+			change = newChange(ActionRecreateIndex)
+			change.Destructive = true
+		}
+		change.Index = name
+		change.Expected = expected
+		change.Actual = actual
+		change.index = index
+		changes = append(changes, change)
+	}
+
+	for _, existing := range state.indexes {
+		// The default index on _id cannot be dropped
+		if declared[existing.name] || existing.name == "_id_" {
+			continue
+		}
+		change := newChange(ActionDropIndex)
+		change.Index = existing.name
+		change.Actual = describeIndex(existing)
+		change.Destructive = true
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func describeIndex(index indexState) string {
+	return fmt.Sprintf(
+		"keys: %s, unique: %t, expireAfterSeconds: %d",
+		index.keys, index.unique, index.expireAfterSeconds,
+	)
+}
+
+// ApplyOptions defines the options for Apply.
+type ApplyOptions struct {
+	// AllowDestructive applies the Changes that drop indexes.
+	AllowDestructive bool
+	// OnChange is called before applying each Change, such as for logging.
+	OnChange func(change Change)
+}
+
+// ApplyResult lists the Changes applied and skipped by Apply.
+// The conflicts, and the destructive Changes unless allowed, are skipped.
+type ApplyResult struct {
+	Applied []Change
+	Skipped []Change
+}
+
+// Apply applies the Plan's Changes in order, and stops at first error.
+func Apply(
+	ctx context.Context,
+	client *mongo.Client,
+	plan *Plan,
+	opts ApplyOptions,
+) (*ApplyResult, error) {
+	result := &ApplyResult{}
+	for _, change := range plan.Changes {
+		if change.Action == ActionConflict ||
+			(change.Destructive && !opts.AllowDestructive) {
+			result.Skipped = append(result.Skipped, change)
+			continue
+		}
+		if opts.OnChange != nil {
+			opts.OnChange(change)
+		}
+		err := applyChange(ctx, client, change)
+		if err != nil {
+			return result, errors.Wrapf(err, "Error Applying Change: %s", change)
+		}
+		result.Applied = append(result.Applied, change)
+	}
+	return result, nil
+}
+
+func applyChange(ctx context.Context, client *mongo.Client, change Change) error {
+	database := client.Database(change.Database)
+	collection := database.Collection(change.Collection)
+
+	switch change.Action {
+	case ActionCreateCollection:
+		options, err := change.collection.mongoOptions()
+		if err != nil {
+			return err
+		}
+		return mongo.CreateCollection(ctx, database, change.Collection, options)
+
+	case ActionUpdateValidator:
+		command := bson.NewDocument(bson.EC.String("collMod", change.Collection))
+		if change.collection.Validator != nil {
+			validator, err := change.collection.validatorDocument()
+			if err != nil {
+				return err
+			}
+			command.Append(bson.EC.SubDocument("validator", validator))
+		}
+		if change.collection.ValidationLevel != "" {
+			command.Append(
+				bson.EC.String("validationLevel", change.collection.ValidationLevel),
+			)
+		}
+		if change.collection.ValidationAction != "" {
+			command.Append(
+				bson.EC.String("validationAction", change.collection.ValidationAction),
+			)
+		}
+		_, err := database.RunCommand(ctx, command)
+		return err
+
+	case ActionCreateIndex:
+		return mongo.CreateIndex(ctx, collection, change.index.IndexConfig())
+
+	case ActionRecreateIndex:
+		_, err := collection.Indexes().DropOne(ctx, change.Index)
+		if err != nil {
+			return err
+		}
+		return mongo.CreateIndex(ctx, collection, change.index.IndexConfig())
+
+	case ActionDropIndex:
+		_, err := collection.Indexes().DropOne(ctx, change.Index)
+		return err
+	}
+	return fmt.Errorf("Action: %s cannot be applied", change.Action)
+}
@@ -0,0 +1,167 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/TerrexTech/go-mongoutils/config"
+	"github.com/mongodb/mongo-go-driver/bson"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+)
+
+// collectionState is the collection's current state on server.
+type collectionState struct {
+	exists           bool
+	capped           bool
+	sizeBytes        int64
+	maxDocuments     int64
+	validator        *bson.Document
+	validationLevel  string
+	validationAction string
+	indexes          []indexState
+}
+
+// indexState is an index's current state on server.
+type indexState struct {
+	name string
+	// keys are rendered as: {"word":-1}
+	keys               string
+	unique             bool
+	expireAfterSeconds int32
+}
+
+// readCollectionState reads the collection's options and indexes.
+func readCollectionState(
+	ctx context.Context,
+	database *mgo.Database,
+	name string,
+) (*collectionState, error) {
+	state := &collectionState{}
+
+	collections, err := runCursorCommand(ctx, database, bson.NewDocument(
+		bson.EC.Int32("listCollections", 1),
+		bson.EC.SubDocumentFromElements("filter", bson.EC.String("name", name)),
+	))
+	if err != nil {
+		return nil, err
+	}
+	if len(collections) == 0 {
+		return state, nil
+	}
+	state.exists = true
+
+	options := collections[0].Lookup("options")
+	if options != nil && options.Type() == bson.TypeEmbeddedDocument {
+		optionsDoc := options.MutableDocument()
+		state.capped = boolValue(optionsDoc, "capped")
+		state.sizeBytes = intValue(optionsDoc, "size")
+		state.maxDocuments = intValue(optionsDoc, "max")
+		state.validationLevel = stringValue(optionsDoc, "validationLevel")
+		state.validationAction = stringValue(optionsDoc, "validationAction")
+
+		validator := optionsDoc.Lookup("validator")
+		if validator != nil && validator.Type() == bson.TypeEmbeddedDocument {
+			state.validator = validator.MutableDocument()
+		}
+	}
+
+	indexes, err := runCursorCommand(ctx, database, bson.NewDocument(
+		bson.EC.String("listIndexes", name),
+	))
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		keys := index.Lookup("key")
+		if keys == nil || keys.Type() != bson.TypeEmbeddedDocument {
+			continue
+		}
+		state.indexes = append(state.indexes, indexState{
+			name:               stringValue(index, "name"),
+			keys:               renderDocument(keys.MutableDocument()),
+			unique:             boolValue(index, "unique"),
+			expireAfterSeconds: int32(intValue(index, "expireAfterSeconds")),
+		})
+	}
+	return state, nil
+}
+
+// runCursorCommand runs a command returning a cursor, and returns the
+// documents from cursor's first batch. This is sufficient for listing the
+// collections and indexes, since they fit in the first batch.
+func runCursorCommand(
+	ctx context.Context,
+	database *mgo.Database,
+	command *bson.Document,
+) ([]*bson.Document, error) {
+	reader, err := database.RunCommand(ctx, command)
+	if err != nil {
+		return nil, err
+	}
+	result, err := bson.ReadDocument(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := result.Lookup("cursor")
+	if cursor == nil || cursor.Type() != bson.TypeEmbeddedDocument {
+		return nil, fmt.Errorf("invalid cursor-result: %s", result)
+	}
+	batch := cursor.MutableDocument().Lookup("firstBatch")
+	if batch == nil || batch.Type() != bson.TypeArray {
+		return nil, fmt.Errorf("invalid cursor-result: %s", result)
+	}
+	docs := []*bson.Document{}
+	arr := batch.MutableArray()
+	for i := 0; i < arr.Len(); i++ {
+		v, err := arr.Lookup(uint(i))
+		if err == nil && v.Type() == bson.TypeEmbeddedDocument {
+			docs = append(docs, v.MutableDocument())
+		}
+	}
+	return docs, nil
+}
+
+// indexKeys renders the index-columns as the keys returned by server.
+func indexKeys(index config.IndexSettings) string {
+	keys := bson.NewDocument()
+	for _, column := range index.Columns {
+		var order int32 = 1
+		if column.Descending {
+			order = -1
+		}
+		keys.Append(bson.EC.Int32(column.Name, order))
+	}
+	return renderDocument(keys)
+}
+
+func stringValue(doc *bson.Document, key string) string {
+	v := doc.Lookup(key)
+	if v == nil || v.Type() != bson.TypeString {
+		return ""
+	}
+	return v.StringValue()
+}
+
+func boolValue(doc *bson.Document, key string) bool {
+	v := doc.Lookup(key)
+	return v != nil && v.Type() == bson.TypeBoolean && v.Boolean()
+}
+
+// intValue returns the numeric value of key, since the server can return
+// the numbers as int32, int64, or double.
+func intValue(doc *bson.Document, key string) int64 {
+	v := doc.Lookup(key)
+	if v == nil {
+		return 0
+	}
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32())
+	case bson.TypeInt64:
+		return v.Int64()
+	case bson.TypeDouble:
+		return int64(v.Double())
+	}
+	return 0
+}
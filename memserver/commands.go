@@ -0,0 +1,1063 @@
+package memserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/memstore"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// The server error-codes returned by the commands.
+const (
+	codeBadValue             int32 = 2
+	codeFailedToParse        int32 = 9
+	codeNamespaceNotFound    int32 = 26
+	codeIndexNotFound        int32 = 27
+	codeCursorNotFound       int32 = 43
+	codeNamespaceExists      int32 = 48
+	codeCommandNotFound      int32 = 59
+	codeIndexOptionsConflict int32 = 85
+	codeDuplicateKey         int32 = 11000
+)
+
+var codeNames = map[int32]string{
+	codeBadValue:             "BadValue",
+	codeFailedToParse:        "FailedToParse",
+	codeNamespaceNotFound:    "NamespaceNotFound",
+	codeIndexNotFound:        "IndexNotFound",
+	codeCursorNotFound:       "CursorNotFound",
+	codeNamespaceExists:      "NamespaceExists",
+	codeCommandNotFound:      "CommandNotFound",
+	codeIndexOptionsConflict: "IndexOptionsConflict",
+	codeDuplicateKey:         "DuplicateKey",
+}
+
+// The limits reported by the hello-command.
+const (
+	maxBSONObjectSize = 16 * 1024 * 1024
+	maxWriteBatchSize = 100000
+	// maxWireVersion is of MongoDB 3.6, which is the oldest version
+	// supporting OP_MSG.
+	maxWireVersion = 6
+	// defaultBatchSize is the number of documents in the first batch of
+	// find-command, unless specified.
+	defaultBatchSize = 101
+)
+
+// commandError is a command failure, returned to the client with the
+// error-code.
+type commandError struct {
+	code    int32
+	message string
+}
+
+func newCommandError(code int32, message string) *commandError {
+	return &commandError{
+		code:    code,
+		message: message,
+	}
+}
+
+func (e *commandError) Error() string {
+	return e.message
+}
+
+// toCommandError returns the error as commandError, classifying the
+// memstore-errors by their messages.
+func toCommandError(err error) *commandError {
+	if cmdErr, ok := errors.Cause(err).(*commandError); ok {
+		return cmdErr
+	}
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "E11000"):
+		// The server's message, without the memstore's context
+		return newCommandError(codeDuplicateKey, errors.Cause(err).Error())
+	case strings.Contains(message, "exists with different options"):
+		return newCommandError(codeIndexOptionsConflict, message)
+	}
+	return newCommandError(codeBadValue, message)
+}
+
+// errorReply returns the reply-document for a failed command.
+func errorReply(err error) *bson.Document {
+	cmdErr := toCommandError(err)
+	return bson.NewDocument(
+		bson.EC.Double("ok", 0),
+		bson.EC.String("errmsg", cmdErr.message),
+		bson.EC.Int32("code", cmdErr.code),
+		bson.EC.String("codeName", codeNames[cmdErr.code]),
+	)
+}
+
+// writeError returns the document describing the failed write at index,
+// for the "writeErrors" of write-commands.
+func writeError(index int, err error) *bson.Value {
+	cmdErr := toCommandError(err)
+	return bson.VC.DocumentFromElements(
+		bson.EC.Int32("index", int32(index)),
+		bson.EC.Int32("code", cmdErr.code),
+		bson.EC.String("errmsg", cmdErr.message),
+	)
+}
+
+// commandHandler runs the command on database, and returns the reply,
+// without the "ok"-field.
+type commandHandler func(
+	s *Server,
+	database string,
+	command *bson.Document,
+) (*bson.Document, error)
+
+// commands are the supported commands by name.
+var commands = map[string]commandHandler{
+	"hello":           (*Server).hello,
+	"isMaster":        (*Server).hello,
+	"ismaster":        (*Server).hello,
+	"ping":            (*Server).empty,
+	"endSessions":     (*Server).empty,
+	"buildInfo":       (*Server).buildInfo,
+	"buildinfo":       (*Server).buildInfo,
+	"listDatabases":   (*Server).listDatabases,
+	"dropDatabase":    (*Server).dropDatabase,
+	"create":          (*Server).create,
+	"drop":            (*Server).drop,
+	"listCollections": (*Server).listCollections,
+	"createIndexes":   (*Server).createIndexes,
+	"listIndexes":     (*Server).listIndexes,
+	"dropIndexes":     (*Server).dropIndexes,
+	"deleteIndexes":   (*Server).dropIndexes,
+	"insert":          (*Server).insert,
+	"update":          (*Server).update,
+	"delete":          (*Server).delete,
+	"find":            (*Server).find,
+	"getMore":         (*Server).getMore,
+	"killCursors":     (*Server).killCursors,
+	"aggregate":       (*Server).aggregate,
+	"count":           (*Server).count,
+	"findAndModify":   (*Server).findAndModify,
+	"findandmodify":   (*Server).findAndModify,
+}
+
+func (s *Server) hello(string, *bson.Document) (*bson.Document, error) {
+	return bson.NewDocument(
+		bson.EC.Boolean("ismaster", true),
+		bson.EC.Boolean("isWritablePrimary", true),
+		bson.EC.Int32("maxBsonObjectSize", maxBSONObjectSize),
+		bson.EC.Int32("maxMessageSizeBytes", maxMessageSize),
+		bson.EC.Int32("maxWriteBatchSize", maxWriteBatchSize),
+		bson.EC.Time("localTime", time.Now()),
+		bson.EC.Int32("minWireVersion", 0),
+		bson.EC.Int32("maxWireVersion", maxWireVersion),
+	), nil
+}
+
+func (s *Server) empty(string, *bson.Document) (*bson.Document, error) {
+	return bson.NewDocument(), nil
+}
+
+func (s *Server) buildInfo(string, *bson.Document) (*bson.Document, error) {
+	return bson.NewDocument(
+		bson.EC.String("version", "3.6.0"),
+		bson.EC.ArrayFromElements(
+			"versionArray",
+			bson.VC.Int32(3), bson.VC.Int32(6), bson.VC.Int32(0), bson.VC.Int32(0),
+		),
+		bson.EC.Int32("maxBsonObjectSize", maxBSONObjectSize),
+	), nil
+}
+
+func (s *Server) listDatabases(
+	_ string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	filter, err := documentArg(command, "filter")
+	if err != nil {
+		return nil, err
+	}
+	nameOnly := boolArg(command, "nameOnly")
+
+	databases := bson.NewArray()
+	for _, name := range s.store.Databases() {
+		info := bson.NewDocument(bson.EC.String("name", name))
+		if !nameOnly {
+			info.Append(
+				bson.EC.Int64("sizeOnDisk", 0),
+				bson.EC.Boolean("empty", false),
+			)
+		}
+		isMatch, err := matchFilter(info, filter)
+		if err != nil {
+			return nil, err
+		}
+		if isMatch {
+			databases.Append(bson.VC.Document(info))
+		}
+	}
+
+	reply := bson.NewDocument(bson.EC.Array("databases", databases))
+	if !nameOnly {
+		reply.Append(bson.EC.Int64("totalSize", 0))
+	}
+	return reply, nil
+}
+
+func (s *Server) dropDatabase(
+	database string,
+	_ *bson.Document,
+) (*bson.Document, error) {
+	s.store.DropDatabase(database)
+	return bson.NewDocument(bson.EC.String("dropped", database)), nil
+}
+
+// create creates the collection. The collection-options are accepted, but
+// not enforced.
+func (s *Server) create(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	if s.collectionExists(database, name) {
+		return nil, newCommandError(
+			codeNamespaceExists,
+			fmt.Sprintf("a collection '%s.%s' already exists", database, name),
+		)
+	}
+	s.store.CreateCollection(database, name)
+	return bson.NewDocument(), nil
+}
+
+func (s *Server) drop(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	indexes := s.store.Indexes(database, name)
+	if !s.store.DropCollection(database, name) {
+		return nil, newCommandError(codeNamespaceNotFound, "ns not found")
+	}
+	return bson.NewDocument(
+		bson.EC.String("ns", database+"."+name),
+		bson.EC.Int32("nIndexesWas", int32(len(indexes))),
+	), nil
+}
+
+func (s *Server) listCollections(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	filter, err := documentArg(command, "filter")
+	if err != nil {
+		return nil, err
+	}
+	batchSize, err := cursorBatchSize(command)
+	if err != nil {
+		return nil, err
+	}
+	nameOnly := boolArg(command, "nameOnly")
+
+	collections := []*bson.Document{}
+	for _, name := range s.store.Collections(database) {
+		info := bson.NewDocument(
+			bson.EC.String("name", name),
+			bson.EC.String("type", "collection"),
+		)
+		if !nameOnly {
+			info.Append(bson.EC.SubDocument("options", bson.NewDocument()))
+		}
+		isMatch, err := matchFilter(info, filter)
+		if err != nil {
+			return nil, err
+		}
+		if isMatch {
+			collections = append(collections, info)
+		}
+	}
+
+	ns := database + ".$cmd.listCollections"
+	return s.cursorReply(ns, collections, batchSize, false), nil
+}
+
+func (s *Server) createIndexes(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	specs, err := arrayArg(command, "indexes")
+	if err != nil {
+		return nil, err
+	}
+
+	createdCollection := !s.collectionExists(database, name)
+	numIndexesBefore := len(s.store.Indexes(database, name))
+	if createdCollection {
+		numIndexesBefore = 0
+	}
+	for _, spec := range specs {
+		if spec.Type() != bson.TypeEmbeddedDocument {
+			return nil, newCommandError(
+				codeFailedToParse, "index-spec must be a document",
+			)
+		}
+		index, err := parseIndex(spec.MutableDocument())
+		if err != nil {
+			return nil, err
+		}
+		err = s.store.CreateIndex(database, name, index)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return bson.NewDocument(
+		bson.EC.Boolean("createdCollectionAutomatically", createdCollection),
+		bson.EC.Int32("numIndexesBefore", int32(numIndexesBefore)),
+		bson.EC.Int32(
+			"numIndexesAfter", int32(len(s.store.Indexes(database, name))),
+		),
+	), nil
+}
+
+// parseIndex parses the index-specification from createIndexes-command.
+func parseIndex(spec *bson.Document) (memstore.Index, error) {
+	keys, err := documentArg(spec, "key")
+	if err != nil {
+		return memstore.Index{}, err
+	}
+	if keys == nil || keys.Len() == 0 {
+		return memstore.Index{}, newCommandError(
+			codeFailedToParse, "index-spec requires the key",
+		)
+	}
+	name := ""
+	if v := spec.Lookup("name"); v != nil && v.Type() == bson.TypeString {
+		name = v.StringValue()
+	}
+	expireAfterSeconds, err := intArg(spec, "expireAfterSeconds")
+	if err != nil {
+		return memstore.Index{}, err
+	}
+	return memstore.Index{
+		Name:               name,
+		Keys:               keys,
+		Unique:             boolArg(spec, "unique"),
+		ExpireAfterSeconds: int32(expireAfterSeconds),
+	}, nil
+}
+
+func (s *Server) listIndexes(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	batchSize, err := cursorBatchSize(command)
+	if err != nil {
+		return nil, err
+	}
+	if !s.collectionExists(database, name) {
+		return nil, newCommandError(codeNamespaceNotFound, "ns does not exist")
+	}
+
+	ns := database + "." + name
+	indexes := []*bson.Document{}
+	for _, index := range s.store.Indexes(database, name) {
+		info := bson.NewDocument(
+			bson.EC.Int32("v", 2),
+			bson.EC.SubDocument("key", index.Keys),
+			bson.EC.String("name", index.Name),
+			bson.EC.String("ns", ns),
+		)
+		if index.Unique && index.Name != memstore.IDIndexName {
+			info.Append(bson.EC.Boolean("unique", true))
+		}
+		if index.ExpireAfterSeconds > 0 {
+			info.Append(bson.EC.Int32("expireAfterSeconds", index.ExpireAfterSeconds))
+		}
+		indexes = append(indexes, info)
+	}
+	cursorNS := database + ".$cmd.listIndexes." + name
+	return s.cursorReply(cursorNS, indexes, batchSize, false), nil
+}
+
+// dropIndexes drops the index specified by name, or by keys, or all indexes
+// except the _id-index for "*".
+func (s *Server) dropIndexes(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	if !s.collectionExists(database, name) {
+		return nil, newCommandError(codeNamespaceNotFound, "ns not found")
+	}
+	indexes := s.store.Indexes(database, name)
+
+	spec := command.Lookup("index")
+	if spec == nil {
+		return nil, newCommandError(codeFailedToParse, "index is required")
+	}
+	dropped := 0
+	for _, index := range indexes {
+		isMatch := false
+		switch spec.Type() {
+		case bson.TypeString:
+			isMatch = spec.StringValue() == "*" || spec.StringValue() == index.Name
+		case bson.TypeEmbeddedDocument:
+			isMatch = sameKeys(index.Keys, spec.MutableDocument())
+		}
+		if isMatch && s.store.DropIndex(database, name, index.Name) {
+			dropped++
+		}
+	}
+	if dropped == 0 && !(spec.Type() == bson.TypeString && spec.StringValue() == "*") {
+		specText := spec.Type().String()
+		switch spec.Type() {
+		case bson.TypeString:
+			specText = spec.StringValue()
+		case bson.TypeEmbeddedDocument:
+			specText = spec.MutableDocument().String()
+		}
+		return nil, newCommandError(
+			codeIndexNotFound, fmt.Sprintf("index not found with name [%s]", specText),
+		)
+	}
+	return bson.NewDocument(
+		bson.EC.Int32("nIndexesWas", int32(len(indexes))),
+	), nil
+}
+
+func (s *Server) insert(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	docs, err := arrayArg(command, "documents")
+	if err != nil {
+		return nil, err
+	}
+	ordered := isOrdered(command)
+
+	inserted := 0
+	writeErrors := bson.NewArray()
+	for i, v := range docs {
+		if v.Type() != bson.TypeEmbeddedDocument {
+			err = newCommandError(codeBadValue, "document to insert must be a document")
+		} else {
+			_, err = s.store.Insert(database, name, v.MutableDocument())
+		}
+		if err != nil {
+			writeErrors.Append(writeError(i, err))
+			if ordered {
+				break
+			}
+			continue
+		}
+		inserted++
+	}
+
+	reply := bson.NewDocument(bson.EC.Int32("n", int32(inserted)))
+	if writeErrors.Len() > 0 {
+		reply.Append(bson.EC.Array("writeErrors", writeErrors))
+	}
+	return reply, nil
+}
+
+func (s *Server) update(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	updates, err := arrayArg(command, "updates")
+	if err != nil {
+		return nil, err
+	}
+	ordered := isOrdered(command)
+
+	var n, modified int64
+	upserted := bson.NewArray()
+	writeErrors := bson.NewArray()
+	for i, v := range updates {
+		result, err := s.updateOne(database, name, v)
+		if err != nil {
+			writeErrors.Append(writeError(i, err))
+			if ordered {
+				break
+			}
+			continue
+		}
+		n += result.Matched
+		modified += result.Modified
+		if result.UpsertedID != nil {
+			n++
+			upserted.Append(bson.VC.DocumentFromElements(
+				bson.EC.Int32("index", int32(i)),
+				bson.EC.Interface("_id", result.UpsertedID.Interface()),
+			))
+		}
+	}
+
+	reply := bson.NewDocument(
+		bson.EC.Int32("n", int32(n)),
+		bson.EC.Int32("nModified", int32(modified)),
+	)
+	if upserted.Len() > 0 {
+		reply.Append(bson.EC.Array("upserted", upserted))
+	}
+	if writeErrors.Len() > 0 {
+		reply.Append(bson.EC.Array("writeErrors", writeErrors))
+	}
+	return reply, nil
+}
+
+// updateOne runs a statement from the "updates" of update-command.
+func (s *Server) updateOne(
+	database string,
+	name string,
+	statement *bson.Value,
+) (*memstore.UpdateResult, error) {
+	if statement.Type() != bson.TypeEmbeddedDocument {
+		return nil, newCommandError(
+			codeFailedToParse, "update-statement must be a document",
+		)
+	}
+	doc := statement.MutableDocument()
+	filter, err := documentArg(doc, "q")
+	if err != nil {
+		return nil, err
+	}
+	update, err := documentArg(doc, "u")
+	if err != nil {
+		return nil, newCommandError(codeBadValue, "pipeline-updates are not supported")
+	}
+	if update == nil {
+		return nil, newCommandError(codeFailedToParse, "update-statement requires u")
+	}
+	return s.store.Update(
+		database, name, filter, update, boolArg(doc, "multi"), boolArg(doc, "upsert"),
+	)
+}
+
+func (s *Server) delete(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	deletes, err := arrayArg(command, "deletes")
+	if err != nil {
+		return nil, err
+	}
+	ordered := isOrdered(command)
+
+	var n int64
+	writeErrors := bson.NewArray()
+	for i, v := range deletes {
+		deleted, err := s.deleteOne(database, name, v)
+		if err != nil {
+			writeErrors.Append(writeError(i, err))
+			if ordered {
+				break
+			}
+			continue
+		}
+		n += deleted
+	}
+
+	reply := bson.NewDocument(bson.EC.Int32("n", int32(n)))
+	if writeErrors.Len() > 0 {
+		reply.Append(bson.EC.Array("writeErrors", writeErrors))
+	}
+	return reply, nil
+}
+
+// deleteOne runs a statement from the "deletes" of delete-command.
+func (s *Server) deleteOne(
+	database string,
+	name string,
+	statement *bson.Value,
+) (int64, error) {
+	if statement.Type() != bson.TypeEmbeddedDocument {
+		return 0, newCommandError(
+			codeFailedToParse, "delete-statement must be a document",
+		)
+	}
+	doc := statement.MutableDocument()
+	filter, err := documentArg(doc, "q")
+	if err != nil {
+		return 0, err
+	}
+	limit, err := intArg(doc, "limit")
+	if err != nil {
+		return 0, err
+	}
+	return s.store.Delete(database, name, filter, int(limit))
+}
+
+func (s *Server) find(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	opts := memstore.FindOptions{}
+	filter, err := documentArg(command, "filter")
+	if err == nil {
+		opts.Sort, err = documentArg(command, "sort")
+	}
+	if err == nil {
+		opts.Projection, err = documentArg(command, "projection")
+	}
+	if err == nil {
+		opts.Skip, err = intArg(command, "skip")
+	}
+	if err == nil {
+		opts.Limit, err = intArg(command, "limit")
+	}
+	batchSize := int64(defaultBatchSize)
+	if err == nil && command.Lookup("batchSize") != nil {
+		batchSize, err = intArg(command, "batchSize")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	singleBatch := boolArg(command, "singleBatch")
+	// A negative limit returns a single batch of at most -limit documents
+	if opts.Limit < 0 {
+		opts.Limit = -opts.Limit
+		singleBatch = true
+	}
+	if singleBatch && opts.Limit > 0 {
+		batchSize = opts.Limit
+	}
+
+	docs, err := s.store.Find(database, name, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return s.cursorReply(database+"."+name, docs, batchSize, singleBatch), nil
+}
+
+func (s *Server) getMore(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	id, err := intArg(command, "getMore")
+	if err != nil {
+		return nil, err
+	}
+	batchSize, err := intArg(command, "batchSize")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, exists := s.cursors[id]
+	if !exists {
+		return nil, newCommandError(
+			codeCursorNotFound, fmt.Sprintf("cursor id %d not found", id),
+		)
+	}
+	batch := c.docs
+	if batchSize > 0 && batchSize < int64(len(batch)) {
+		batch = batch[:batchSize]
+	}
+	c.docs = c.docs[len(batch):]
+	if len(c.docs) == 0 {
+		delete(s.cursors, id)
+		id = 0
+	}
+	return bson.NewDocument(bson.EC.SubDocumentFromElements(
+		"cursor",
+		bson.EC.Int64("id", id),
+		bson.EC.String("ns", c.ns),
+		bson.EC.Array("nextBatch", documentArray(batch)),
+	)), nil
+}
+
+func (s *Server) killCursors(
+	_ string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	ids, err := arrayArg(command, "cursors")
+	if err != nil {
+		return nil, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	killed := bson.NewArray()
+	notFound := bson.NewArray()
+	for _, v := range ids {
+		id, ok := intValue(v)
+		if _, exists := s.cursors[id]; ok && exists {
+			delete(s.cursors, id)
+			killed.Append(bson.VC.Int64(id))
+		} else {
+			notFound.Append(v)
+		}
+	}
+	return bson.NewDocument(
+		bson.EC.Array("cursorsKilled", killed),
+		bson.EC.Array("cursorsNotFound", notFound),
+		bson.EC.Array("cursorsAlive", bson.NewArray()),
+		bson.EC.Array("cursorsUnknown", bson.NewArray()),
+	), nil
+}
+
+// aggregate runs the pipeline, with the stages supported by memstore.
+func (s *Server) aggregate(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	stages, err := arrayArg(command, "pipeline")
+	if err != nil {
+		return nil, err
+	}
+	batchSize, err := cursorBatchSize(command)
+	if err != nil {
+		return nil, err
+	}
+
+	pipeline := []*bson.Document{}
+	for _, stage := range stages {
+		if stage.Type() != bson.TypeEmbeddedDocument {
+			return nil, newCommandError(
+				codeFailedToParse, "pipeline-stage must be a document",
+			)
+		}
+		pipeline = append(pipeline, stage.MutableDocument())
+	}
+	docs, err := s.store.Aggregate(database, name, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return s.cursorReply(database+"."+name, docs, batchSize, false), nil
+}
+
+func (s *Server) count(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	opts := memstore.FindOptions{}
+	filter, err := documentArg(command, "query")
+	if err == nil {
+		opts.Skip, err = intArg(command, "skip")
+	}
+	if err == nil {
+		opts.Limit, err = intArg(command, "limit")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Limit < 0 {
+		opts.Limit = -opts.Limit
+	}
+
+	docs, err := s.store.Find(database, name, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return bson.NewDocument(bson.EC.Int32("n", int32(len(docs)))), nil
+}
+
+func (s *Server) findAndModify(
+	database string,
+	command *bson.Document,
+) (*bson.Document, error) {
+	name, err := collectionName(command)
+	if err != nil {
+		return nil, err
+	}
+	opts := memstore.FindAndModifyOptions{
+		Remove:    boolArg(command, "remove"),
+		Upsert:    boolArg(command, "upsert"),
+		ReturnNew: boolArg(command, "new"),
+	}
+	filter, err := documentArg(command, "query")
+	if err == nil {
+		opts.Sort, err = documentArg(command, "sort")
+	}
+	if err == nil {
+		opts.Update, err = documentArg(command, "update")
+	}
+	if err == nil {
+		opts.Projection, err = documentArg(command, "fields")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !opts.Remove && opts.Update == nil {
+		return nil, newCommandError(
+			codeFailedToParse, "Either an update or remove=true must be specified",
+		)
+	}
+
+	doc, result, err := s.store.FindAndModify(database, name, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lastErrorObject := bson.NewDocument(
+		bson.EC.Int32("n", int32(result.Matched)),
+		bson.EC.Boolean("updatedExisting", result.Matched > 0 && !opts.Remove),
+	)
+	if result.UpsertedID != nil {
+		lastErrorObject.Set(bson.EC.Int32("n", 1))
+		lastErrorObject.Append(
+			bson.EC.Interface("upserted", result.UpsertedID.Interface()),
+		)
+	}
+	value := bson.EC.Null("value")
+	if doc != nil {
+		value = bson.EC.SubDocument("value", doc)
+	}
+	return bson.NewDocument(
+		bson.EC.SubDocument("lastErrorObject", lastErrorObject),
+		value,
+	), nil
+}
+
+// cursor holds the documents of a cursor, which are not yet returned.
+type cursor struct {
+	ns   string
+	docs []*bson.Document
+}
+
+// cursorReply returns the reply with first batch of docs. A cursor is
+// created for the remaining documents, unless singleBatch is true. A
+// batchSize of 0 returns all documents.
+func (s *Server) cursorReply(
+	ns string,
+	docs []*bson.Document,
+	batchSize int64,
+	singleBatch bool,
+) *bson.Document {
+	batch := docs
+	if batchSize > 0 && batchSize < int64(len(batch)) {
+		batch = batch[:batchSize]
+	}
+
+	id := int64(0)
+	if remaining := docs[len(batch):]; len(remaining) > 0 && !singleBatch {
+		s.mutex.Lock()
+		s.lastCursorID++
+		id = s.lastCursorID
+		s.cursors[id] = &cursor{
+			ns:   ns,
+			docs: remaining,
+		}
+		s.mutex.Unlock()
+	}
+
+	return bson.NewDocument(bson.EC.SubDocumentFromElements(
+		"cursor",
+		bson.EC.Int64("id", id),
+		bson.EC.String("ns", ns),
+		bson.EC.Array("firstBatch", documentArray(batch)),
+	))
+}
+
+func documentArray(docs []*bson.Document) *bson.Array {
+	arr := bson.NewArray()
+	for _, doc := range docs {
+		arr.Append(bson.VC.Document(doc))
+	}
+	return arr
+}
+
+func (s *Server) collectionExists(database string, name string) bool {
+	for _, existing := range s.store.Collections(database) {
+		if existing == name {
+			return true
+		}
+	}
+	return false
+}
+
+// collectionName returns the collection-name, which is the value of
+// command's first element.
+func collectionName(command *bson.Document) (string, error) {
+	elem := command.ElementAt(0)
+	if elem.Value().Type() != bson.TypeString || elem.Value().StringValue() == "" {
+		return "", newCommandError(
+			codeFailedToParse,
+			fmt.Sprintf("collection-name for %s must be a string", elem.Key()),
+		)
+	}
+	return elem.Value().StringValue(), nil
+}
+
+// documentArg returns the document-field with key, or nil if the field
+// is missing or null.
+func documentArg(doc *bson.Document, key string) (*bson.Document, error) {
+	v := doc.Lookup(key)
+	if v == nil || v.Type() == bson.TypeNull {
+		return nil, nil
+	}
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, newCommandError(
+			codeFailedToParse, fmt.Sprintf("%s must be a document", key),
+		)
+	}
+	return v.MutableDocument(), nil
+}
+
+// arrayArg returns the values of array-field with key, which is required.
+func arrayArg(doc *bson.Document, key string) ([]*bson.Value, error) {
+	v := doc.Lookup(key)
+	if v == nil || v.Type() != bson.TypeArray {
+		return nil, newCommandError(
+			codeFailedToParse, fmt.Sprintf("%s must be an array", key),
+		)
+	}
+	arr := v.MutableArray()
+	values := make([]*bson.Value, 0, arr.Len())
+	for i := 0; i < arr.Len(); i++ {
+		value, err := arr.Lookup(uint(i))
+		if err != nil {
+			return nil, newCommandError(codeFailedToParse, err.Error())
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// intArg returns the numeric field with key, or 0 if it is missing.
+func intArg(doc *bson.Document, key string) (int64, error) {
+	v := doc.Lookup(key)
+	if v == nil || v.Type() == bson.TypeNull {
+		return 0, nil
+	}
+	n, ok := intValue(v)
+	if !ok {
+		return 0, newCommandError(
+			codeFailedToParse, fmt.Sprintf("%s must be a number", key),
+		)
+	}
+	return n, nil
+}
+
+func intValue(v *bson.Value) (int64, bool) {
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), true
+	case bson.TypeInt64:
+		return v.Int64(), true
+	case bson.TypeDouble:
+		return int64(v.Double()), true
+	}
+	return 0, false
+}
+
+// boolArg returns the boolean field with key, accepting the numbers as
+// the server does, such as {upsert: 1}.
+func boolArg(doc *bson.Document, key string) bool {
+	v := doc.Lookup(key)
+	if v == nil {
+		return false
+	}
+	if v.Type() == bson.TypeBoolean {
+		return v.Boolean()
+	}
+	n, ok := intValue(v)
+	return ok && n != 0
+}
+
+// isOrdered returns the "ordered" field of write-commands, which defaults
+// to true.
+func isOrdered(command *bson.Document) bool {
+	if command.Lookup("ordered") == nil {
+		return true
+	}
+	return boolArg(command, "ordered")
+}
+
+// cursorBatchSize returns the batchSize from the "cursor" field of
+// commands, such as aggregate.
+func cursorBatchSize(command *bson.Document) (int64, error) {
+	options, err := documentArg(command, "cursor")
+	if err != nil || options == nil {
+		return 0, err
+	}
+	return intArg(options, "batchSize")
+}
+
+func matchFilter(doc *bson.Document, filter *bson.Document) (bool, error) {
+	if filter == nil {
+		return true, nil
+	}
+	isMatch, err := memstore.Match(doc, filter)
+	if err != nil {
+		return false, newCommandError(codeBadValue, err.Error())
+	}
+	return isMatch, nil
+}
+
+// sameKeys checks if the index-keys are same, comparing the numbers
+// by value, so {email: 1} matches {email: NumberLong(1)}.
+func sameKeys(a *bson.Document, b *bson.Document) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	for i := 0; i < a.Len(); i++ {
+		elemA := a.ElementAt(uint(i))
+		elemB := b.ElementAt(uint(i))
+		if elemA.Key() != elemB.Key() {
+			return false
+		}
+		numA, okA := intValue(elemA.Value())
+		numB, okB := intValue(elemB.Value())
+		if okA && okB {
+			if numA != numB {
+				return false
+			}
+			continue
+		}
+		// The other index-keys are strings, such as "text" or "2dsphere"
+		strA, okA := elemA.Value().StringValueOK()
+		strB, okB := elemB.Value().StringValueOK()
+		if !okA || !okB || strA != strB {
+			return false
+		}
+	}
+	return true
+}
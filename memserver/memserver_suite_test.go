@@ -0,0 +1,13 @@
+package memserver
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMemserver(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Memserver Suite")
+}
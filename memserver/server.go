@@ -0,0 +1,189 @@
+// Package memserver provides a local server speaking enough of the MongoDB
+// wire-protocol for the driver, with the data held in a memstore.Store.
+// This allows the tests to run Client, EnsureCollection, and the Collection
+// operations end-to-end through the real driver, without a MongoDB server:
+//
+//	server, err := memserver.New(memserver.Config{})
+//	defer server.Close()
+//
+//	client, err := mongo.NewClient(mongo.ClientConfig{
+//		Hosts: []string{server.Addr()},
+//	})
+//
+// The server accepts the commands as OP_MSG, and as OP_QUERY used by drivers
+// for the handshake (and by older drivers for all commands). It implements
+// hello (and isMaster), ping, buildInfo, insert, find, getMore, killCursors,
+// update, delete, findAndModify, count, aggregate (with the stages supported
+// by memstore), create, drop, dropDatabase, listDatabases, listCollections,
+// createIndexes, listIndexes, and dropIndexes. The other commands fail with
+// CommandNotFound.
+//
+// This is intended for tests only: there is no authentication or TLS, the
+// collection-options such as validators are accepted but not enforced, and
+// the TTL-indexes do not expire documents.
+package memserver
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/TerrexTech/go-mongoutils/memstore"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// Config defines the configuration for a Server.
+type Config struct {
+	// Address to listen on. Defaults to "127.0.0.1:0", which listens on
+	// a free port, see Server.Addr.
+	Address string
+	// Store holding the data. Defaults to a new empty Store.
+	Store *memstore.Store
+}
+
+// Server serves the wire-protocol requests using a memstore.Store.
+type Server struct {
+	store    *memstore.Store
+	listener net.Listener
+	// lastRequestID is the ID of last reply sent
+	lastRequestID int32
+
+	mutex   sync.Mutex
+	conns   map[net.Conn]bool
+	cursors map[int64]*cursor
+	// lastCursorID is the ID of last created cursor
+	lastCursorID int64
+	closed       bool
+	wg           sync.WaitGroup
+}
+
+// New starts a Server listening on the configured Address.
+// The Server must be stopped using Close.
+func New(config Config) (*Server, error) {
+	if config.Address == "" {
+		config.Address = "127.0.0.1:0"
+	}
+	if config.Store == nil {
+		config.Store = memstore.NewStore()
+	}
+	listener, err := net.Listen("tcp", config.Address)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Starting Listener")
+	}
+
+	s := &Server{
+		store:    config.Store,
+		listener: listener,
+		conns:    map[net.Conn]bool{},
+		cursors:  map[int64]*cursor{},
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the address the Server is listening on, such as
+// "127.0.0.1:50123", for using in ClientConfig.Hosts.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Store returns the Store holding the Server's data.
+func (s *Server) Store() *memstore.Store {
+	return s.store
+}
+
+// Close stops the Server, and closes the open connections.
+func (s *Server) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mutex.Unlock()
+
+	s.wg.Wait()
+	if err != nil {
+		return errors.Wrap(err, "Error Closing Listener")
+	}
+	return nil
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			// The listener is closed
+			return
+		}
+
+		s.mutex.Lock()
+		if s.closed {
+			s.mutex.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.wg.Add(1)
+		s.mutex.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+// serve handles the requests on connection until it is closed, or
+// an invalid message is received.
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mutex.Lock()
+		delete(s.conns, conn)
+		s.mutex.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		req, err := readRequest(conn)
+		if err != nil {
+			return
+		}
+		reply := s.runCommand(req.database, req.command)
+		if req.moreToCome {
+			continue
+		}
+		requestID := atomic.AddInt32(&s.lastRequestID, 1)
+		err = writeReply(conn, req, requestID, reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// runCommand runs the command, and returns the reply-document, with
+// {ok: 0} and the error-details if the command failed.
+func (s *Server) runCommand(database string, command *bson.Document) *bson.Document {
+	if command == nil || command.Len() == 0 {
+		return errorReply(newCommandError(codeBadValue, "empty command"))
+	}
+	name := command.ElementAt(0).Key()
+	handler, exists := commands[name]
+	if !exists {
+		return errorReply(newCommandError(
+			codeCommandNotFound, "no such command: '"+name+"'",
+		))
+	}
+
+	reply, err := handler(s, database, command)
+	if err != nil {
+		return errorReply(err)
+	}
+	reply.Append(bson.EC.Double("ok", 1))
+	return reply
+}
@@ -0,0 +1,449 @@
+package memserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/lock"
+	"github.com/TerrexTech/go-mongoutils/memstore"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/TerrexTech/go-mongoutils/sequence"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/mongoopt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	type user struct {
+		ID    objectid.ObjectID `bson:"_id,omitempty"`
+		Name  string            `bson:"name,omitempty"`
+		Email string            `bson:"email,omitempty"`
+		Age   int64             `bson:"age,omitempty"`
+	}
+
+	var (
+		server *Server
+		client *mongo.Client
+		users  *mongo.Collection
+	)
+
+	BeforeEach(func() {
+		var err error
+		server, err = New(Config{})
+		Expect(err).ToNot(HaveOccurred())
+
+		client, err = mongo.NewClient(mongo.ClientConfig{
+			Hosts:               []string{server.Addr()},
+			TimeoutMilliseconds: 3000,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		users, err = mongo.EnsureCollection(&mongo.Collection{
+			Connection: &mongo.ConnectionConfig{
+				Client:  client,
+				Timeout: 3000,
+			},
+			Database:     "test",
+			Name:         "users",
+			SchemaStruct: &user{},
+			Indexes: []mongo.IndexConfig{
+				{
+					ColumnConfig: []mongo.IndexColumnConfig{{Name: "email"}},
+					IsUnique:     true,
+					Name:         "email_index",
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = users.InsertMany([]interface{}{
+			&user{Name: "alice", Email: "alice@example.com", Age: 30},
+			&user{Name: "bob", Email: "bob@example.com", Age: 25},
+			&user{Name: "carol", Email: "carol@example.com", Age: 35},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := client.Disconnect()
+		Expect(err).ToNot(HaveOccurred())
+		err = server.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should create the collection and its indexes in Store", func() {
+		Expect(server.Store().Collections("test")).To(Equal([]string{"users"}))
+
+		names := []string{}
+		for _, index := range server.Store().Indexes("test", "users") {
+			names = append(names, index.Name)
+		}
+		Expect(names).To(Equal([]string{memstore.IDIndexName, "email_index"}))
+
+		// Ensuring the existing collection succeeds
+		_, err := mongo.EnsureCollection(&mongo.Collection{
+			Connection:   users.Connection,
+			Database:     "test",
+			Name:         "users",
+			SchemaStruct: &user{},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should find the documents, across several batches", func() {
+		found, err := users.Find(
+			map[string]interface{}{"age": map[string]interface{}{"$gte": 25}},
+			findopt.Sort(map[string]interface{}{"age": 1}),
+			findopt.BatchSize(2),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(HaveLen(3))
+		Expect(found[0].(*user).Name).To(Equal("bob"))
+		Expect(found[2].(*user).Name).To(Equal("carol"))
+
+		one, err := users.FindOne(&user{Email: "alice@example.com"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(one.(*user).Name).To(Equal("alice"))
+	})
+
+	It("should return not-found error if FindOne matches no document", func() {
+		_, err := users.FindOne(&user{Name: "dave"})
+		Expect(mongo.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should return duplicate-key error for the unique indexes", func() {
+		_, err := users.InsertOne(&user{Name: "alice2", Email: "alice@example.com"})
+		dupErr, isDuplicate := mongo.IsDuplicateKey(err)
+		Expect(isDuplicate).To(BeTrue())
+		Expect(dupErr.Index).To(Equal("email_index"))
+	})
+
+	It("should insert the documents with explicit _id", func() {
+		id := objectid.New()
+		_, err := users.InsertOne(&user{ID: id, Name: "dave", Email: "dave@example.com"})
+		Expect(err).ToNot(HaveOccurred())
+
+		one, err := users.FindOne(&user{ID: id})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(one.(*user).Name).To(Equal("dave"))
+
+		_, err = users.InsertOne(&user{ID: id, Name: "erin", Email: "erin@example.com"})
+		dupErr, isDuplicate := mongo.IsDuplicateKey(err)
+		Expect(isDuplicate).To(BeTrue())
+		Expect(dupErr.Index).To(Equal(memstore.IDIndexName))
+	})
+
+	It("should replace the documents", func() {
+		bob, err := users.FindOne(&user{Name: "bob"})
+		Expect(err).ToNot(HaveOccurred())
+
+		replaced, err := users.ReplaceOne(
+			&user{Name: "bob"},
+			&user{Name: "robert", Email: "bob@example.com", Age: 26},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(replaced.MatchedCount).To(Equal(int64(1)))
+		Expect(replaced.ModifiedCount).To(Equal(int64(1)))
+
+		one, err := users.FindOne(&user{Email: "bob@example.com"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(one.(*user).ID).To(Equal(bob.(*user).ID))
+		Expect(one.(*user).Name).To(Equal("robert"))
+		Expect(one.(*user).Age).To(Equal(int64(26)))
+
+		_, err = users.FindOne(&user{Name: "bob"})
+		Expect(mongo.IsNotFound(err)).To(BeTrue())
+	})
+
+	It("should find and modify the documents", func() {
+		updated := &user{}
+		err := users.Collection().FindOneAndUpdate(
+			context.Background(),
+			map[string]interface{}{"name": "alice"},
+			map[string]interface{}{
+				"$inc": map[string]interface{}{"age": 1},
+			},
+			findopt.ReturnDocument(mongoopt.After),
+		).Decode(updated)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.Name).To(Equal("alice"))
+		Expect(updated.Age).To(Equal(int64(31)))
+
+		// The sequences are upserted, and incremented using findAndModify
+		generator, err := sequence.NewGenerator(sequence.Config{
+			Connection: users.Connection,
+			Database:   "test",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		for i := int64(1); i <= 3; i++ {
+			value, err := generator.Next("orders")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(value.Number).To(Equal(i))
+		}
+	})
+
+	It("should upsert the locks, and reject the held ones", func() {
+		locker, err := lock.NewLocker(lock.LockerConfig{
+			Connection: users.Connection,
+			Database:   "test",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		held, err := locker.Acquire("reports", "owner-1", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = locker.Acquire("reports", "owner-2", time.Minute)
+		Expect(err).To(Equal(lock.ErrLockHeld))
+
+		err = held.Release()
+		Expect(err).ToNot(HaveOccurred())
+		_, err = locker.Acquire("reports", "owner-2", time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should record the audited writes", func() {
+		auditor, err := mongo.NewAuditor(mongo.AuditConfig{
+			Connection: users.Connection,
+			Database:   "test",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		audited, err := mongo.EnsureCollection(&mongo.Collection{
+			Connection:   users.Connection,
+			Database:     "test",
+			Name:         "audited_users",
+			SchemaStruct: &user{},
+			Auditor:      auditor,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = audited.InsertOne(&user{
+			Name:  "dave",
+			Email: "dave@example.com",
+			Age:   40,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		updated, err := audited.UpdateMany(
+			map[string]interface{}{"name": "dave"},
+			map[string]interface{}{"age": 41},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.ModifiedCount).To(Equal(int64(1)))
+		_, err = audited.ReplaceOne(
+			&user{Name: "dave"},
+			&user{Name: "dave", Email: "david@example.com", Age: 41},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		deleted, err := audited.DeleteMany(&user{Name: "dave"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted.DeletedCount).To(Equal(int64(1)))
+
+		// The entries are read as documents, since the driver does not decode
+		// the timestamps into time.Time.
+		cur, err := auditor.Collection().Collection().Find(
+			context.Background(), bson.NewDocument(),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		entries := []*bson.Document{}
+		for cur.Next(context.Background()) {
+			entry := bson.NewDocument()
+			err = cur.Decode(entry)
+			Expect(err).ToNot(HaveOccurred())
+			entries = append(entries, entry)
+		}
+		Expect(cur.Err()).ToNot(HaveOccurred())
+		operations := []string{}
+		for _, entry := range entries {
+			operations = append(operations, entry.Lookup("operation").StringValue())
+			Expect(entry.Lookup("timestamp").Type()).To(Equal(bson.TypeDateTime))
+		}
+		Expect(operations).To(Equal([]string{
+			mongo.OpInsertOne, mongo.OpUpdateMany, mongo.OpReplaceOne, mongo.OpDeleteMany,
+		}))
+
+		update := entries[1]
+		changes := update.Lookup("changes").MutableArray()
+		Expect(changes.Len()).To(Equal(1))
+		change, err := changes.Lookup(0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(change.MutableDocument().Lookup("field").StringValue()).To(Equal("age"))
+		before := update.Lookup("before", "email")
+		Expect(before.StringValue()).To(Equal("dave@example.com"))
+		after := update.Lookup("after", "age")
+		Expect(after.Interface()).To(BeNumerically("==", 41))
+	})
+
+	It("should scope the operations to tenants by field", func() {
+		type member struct {
+			ID       objectid.ObjectID `bson:"_id,omitempty"`
+			Name     string            `bson:"name,omitempty"`
+			Age      int64             `bson:"age,omitempty"`
+			TenantID string            `bson:"tenantId,omitempty"`
+		}
+		factory, err := mongo.NewTenantFactory(mongo.TenantConfig{
+			Mode: mongo.TenantPerField,
+			Collection: &mongo.Collection{
+				Connection:   users.Connection,
+				Database:     "test",
+				Name:         "members",
+				SchemaStruct: &member{},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		members := map[string]*mongo.Collection{}
+		for _, tenant := range []string{"tenant1", "tenant2"} {
+			members[tenant], err = factory.Collection(
+				mongo.WithTenant(context.Background(), tenant),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = members[tenant].InsertOne(&member{Name: "dave", Age: 40})
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		updated, err := members["tenant1"].UpdateMany(
+			map[string]interface{}{"name": "dave"},
+			map[string]interface{}{"age": 41},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.MatchedCount).To(Equal(int64(1)))
+
+		found, err := members["tenant2"].Find(&member{Name: "dave"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(HaveLen(1))
+		Expect(found[0].(*member).TenantID).To(Equal("tenant2"))
+		Expect(found[0].(*member).Age).To(Equal(int64(40)))
+
+		deleted, err := members["tenant2"].DeleteMany(&member{Name: "dave"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted.DeletedCount).To(Equal(int64(1)))
+
+		one, err := members["tenant1"].FindOne(&member{Name: "dave"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(one.(*member).Age).To(Equal(int64(41)))
+	})
+
+	It("should report the upgrade write-backs lost to concurrent writes", func() {
+		type person struct {
+			ID            objectid.ObjectID `bson:"_id,omitempty"`
+			FullName      string            `bson:"fullName,omitempty"`
+			Version       int64             `bson:"version" mongoutils:"version"`
+			SchemaVersion int64             `bson:"schemaVersion" mongoutils:"schemaVersion"`
+		}
+		var people *mongo.Collection
+		interfere := false
+		upgrades := &mongo.SchemaUpgrades{WriteBack: true}
+		upgrades.Register(1, func(doc *bson.Document) error {
+			name := doc.Lookup("name")
+			doc.Set(bson.EC.String("fullName", name.StringValue()))
+			doc.Delete("name")
+			if interfere {
+				_, err := people.Collection().UpdateOne(
+					context.Background(),
+					bson.NewDocument(doc.LookupElement("_id")),
+					bson.NewDocument(bson.EC.SubDocumentFromElements(
+						"$set", bson.EC.Int64("version", 1),
+					)),
+				)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			return nil
+		})
+		writeBackErrs := []error{}
+		upgrades.OnWriteBackError = func(err error) {
+			writeBackErrs = append(writeBackErrs, err)
+		}
+		people, err := mongo.EnsureCollection(&mongo.Collection{
+			Connection:        users.Connection,
+			Database:          "test",
+			Name:              "people",
+			SchemaStruct:      &person{},
+			SchemaUpgrades:    upgrades,
+			OptimisticLocking: true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		for _, name := range []string{"alice", "bob"} {
+			_, err = people.Collection().InsertOne(
+				context.Background(),
+				bson.NewDocument(bson.EC.String("name", name)),
+			)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		found, err := people.FindOne(map[string]interface{}{"name": "alice"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found.(*person).SchemaVersion).To(Equal(int64(1)))
+		Expect(writeBackErrs).To(BeEmpty())
+
+		interfere = true
+		found, err = people.FindOne(map[string]interface{}{"name": "bob"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found.(*person).FullName).To(Equal("bob"))
+		Expect(writeBackErrs).To(Equal([]error{mongo.ErrUpgradeConflict}))
+
+		raw := bson.NewDocument()
+		err = people.Collection().FindOne(
+			context.Background(),
+			bson.NewDocument(bson.EC.String("fullName", "alice")),
+		).Decode(raw)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(raw.Lookup("name")).To(BeNil())
+		Expect(raw.Lookup("schemaVersion").Int64()).To(Equal(int64(1)))
+
+		raw = bson.NewDocument()
+		err = people.Collection().FindOne(
+			context.Background(),
+			bson.NewDocument(bson.EC.String("name", "bob")),
+		).Decode(raw)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(raw.Lookup("schemaVersion")).To(BeNil())
+	})
+
+	It("should update, and delete the documents", func() {
+		updated, err := users.UpdateMany(
+			map[string]interface{}{"age": map[string]interface{}{"$lt": 35}},
+			map[string]interface{}{"age": 40},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(updated.MatchedCount).To(Equal(int64(2)))
+		Expect(updated.ModifiedCount).To(Equal(int64(2)))
+
+		deleted, err := users.DeleteMany(map[string]interface{}{"age": 40})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deleted.DeletedCount).To(Equal(int64(2)))
+
+		found, err := users.Find(map[string]interface{}{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(HaveLen(1))
+		Expect(found[0].(*user).Name).To(Equal("carol"))
+	})
+
+	It("should aggregate the documents", func() {
+		results, err := users.Aggregate([]interface{}{
+			map[string]interface{}{
+				"$match": map[string]interface{}{
+					"age": map[string]interface{}{"$gt": 25},
+				},
+			},
+			map[string]interface{}{
+				"$group": map[string]interface{}{
+					"_id":   nil,
+					"count": map[string]interface{}{"$sum": 1},
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(1))
+		count := results[0].(map[string]interface{})["count"]
+		Expect(count).To(BeNumerically("==", 2))
+	})
+
+	It("should return CommandNotFound for unsupported commands", func() {
+		_, err := client.DriverClient().Database("admin").RunCommand(
+			context.Background(),
+			bson.NewDocument(bson.EC.Int32("replSetGetStatus", 1)),
+		)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("no such command"))
+	})
+})
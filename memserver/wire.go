@@ -0,0 +1,269 @@
+package memserver
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// The op-codes of wire-messages.
+// See: https://docs.mongodb.com/manual/reference/mongodb-wire-protocol/
+const (
+	opReply int32 = 1
+	opQuery int32 = 2004
+	opMsg   int32 = 2013
+)
+
+// The flag-bits of OP_MSG.
+const (
+	msgChecksumPresent uint32 = 1 << 0
+	msgMoreToCome      uint32 = 1 << 1
+)
+
+// headerLength is the length of the header of every wire-message.
+const headerLength = 16
+
+// maxMessageSize is the maximum length of wire-messages, as reported by
+// the hello-command.
+const maxMessageSize = 48000000
+
+// header is the header of a wire-message.
+type header struct {
+	length     int32
+	requestID  int32
+	responseTo int32
+	opCode     int32
+}
+
+// request is a command received as OP_QUERY or OP_MSG.
+type request struct {
+	header   header
+	database string
+	command  *bson.Document
+	// moreToCome is set for OP_MSGs which expect no reply
+	moreToCome bool
+}
+
+// readRequest reads the next wire-message, and parses the command from it.
+func readRequest(r io.Reader) (*request, error) {
+	headerBytes := make([]byte, headerLength)
+	_, err := io.ReadFull(r, headerBytes)
+	if err != nil {
+		return nil, err
+	}
+	h := header{
+		length:     readInt32(headerBytes, 0),
+		requestID:  readInt32(headerBytes, 4),
+		responseTo: readInt32(headerBytes, 8),
+		opCode:     readInt32(headerBytes, 12),
+	}
+	if h.length < headerLength || h.length > maxMessageSize {
+		return nil, fmt.Errorf("invalid message-length: %d", h.length)
+	}
+	body := make([]byte, h.length-headerLength)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &request{header: h}
+	switch h.opCode {
+	case opQuery:
+		err = req.parseQuery(body)
+	case opMsg:
+		err = req.parseMsg(body)
+	default:
+		err = fmt.Errorf("unsupported op-code: %d", h.opCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// parseQuery parses the command from OP_QUERY on the "<database>.$cmd"
+// collection, as used by drivers for the handshake, and for all commands
+// with older servers.
+func (req *request) parseQuery(body []byte) error {
+	// flags
+	pos := 4
+	fullName, pos, err := readCString(body, pos)
+	if err != nil {
+		return err
+	}
+	if !strings.HasSuffix(fullName, ".$cmd") {
+		return fmt.Errorf("legacy queries are not supported: %s", fullName)
+	}
+	req.database = strings.TrimSuffix(fullName, ".$cmd")
+	// numberToSkip and numberToReturn
+	pos += 8
+
+	command, _, err := readDocument(body, pos)
+	if err != nil {
+		return err
+	}
+	// The commands with read-preference are wrapped as {$query: <command>}
+	if wrapped := command.Lookup("$query"); wrapped != nil &&
+		wrapped.Type() == bson.TypeEmbeddedDocument {
+		command = wrapped.MutableDocument()
+	}
+	req.command = command
+	return nil
+}
+
+// parseMsg parses the command from OP_MSG. The document-sequences, such as
+// the "documents" of insert-command, are added to the command as arrays.
+func (req *request) parseMsg(body []byte) error {
+	if len(body) < 4 {
+		return errors.New("OP_MSG is too short")
+	}
+	flags := binary.LittleEndian.Uint32(body)
+	req.moreToCome = flags&msgMoreToCome != 0
+	end := len(body)
+	if flags&msgChecksumPresent != 0 {
+		end -= 4
+	}
+
+	sequences := []*bson.Element{}
+	pos := 4
+	for pos < end {
+		kind := body[pos]
+		pos++
+		switch kind {
+		case 0:
+			doc, next, err := readDocument(body[:end], pos)
+			if err != nil {
+				return err
+			}
+			req.command = doc
+			pos = next
+
+		case 1:
+			if pos+4 > end {
+				return errors.New("OP_MSG document-sequence is truncated")
+			}
+			size := int(readInt32(body, pos))
+			seqEnd := pos + size
+			if size < 4 || seqEnd > end {
+				return fmt.Errorf("invalid document-sequence size: %d", size)
+			}
+			identifier, next, err := readCString(body[:seqEnd], pos+4)
+			if err != nil {
+				return err
+			}
+			docs := bson.NewArray()
+			for next < seqEnd {
+				var doc *bson.Document
+				doc, next, err = readDocument(body[:seqEnd], next)
+				if err != nil {
+					return err
+				}
+				docs.Append(bson.VC.Document(doc))
+			}
+			sequences = append(sequences, bson.EC.Array(identifier, docs))
+			pos = seqEnd
+
+		default:
+			return fmt.Errorf("unsupported OP_MSG section-kind: %d", kind)
+		}
+	}
+
+	if req.command == nil {
+		return errors.New("OP_MSG has no body-section")
+	}
+	for _, seq := range sequences {
+		req.command.Set(seq)
+	}
+	db := req.command.Lookup("$db")
+	if db == nil || db.Type() != bson.TypeString {
+		return errors.New("OP_MSG command has no $db")
+	}
+	req.database = db.StringValue()
+	return nil
+}
+
+// writeReply writes the reply in the format of request: as OP_REPLY for
+// OP_QUERY, and as OP_MSG for OP_MSG.
+func writeReply(
+	w io.Writer,
+	req *request,
+	requestID int32,
+	reply *bson.Document,
+) error {
+	doc, err := reply.MarshalBSON()
+	if err != nil {
+		return err
+	}
+
+	body := &bytes.Buffer{}
+	opCode := opMsg
+	if req.header.opCode == opQuery {
+		opCode = opReply
+		// responseFlags, cursorID, startingFrom, numberReturned
+		writeInt32(body, 0)
+		writeInt32(body, 0)
+		writeInt32(body, 0)
+		writeInt32(body, 0)
+		writeInt32(body, 1)
+	} else {
+		// flagBits, and the body-section's kind
+		writeInt32(body, 0)
+		body.WriteByte(0)
+	}
+	body.Write(doc)
+
+	message := &bytes.Buffer{}
+	writeInt32(message, int32(headerLength+body.Len()))
+	writeInt32(message, requestID)
+	writeInt32(message, req.header.requestID)
+	writeInt32(message, opCode)
+	message.Write(body.Bytes())
+
+	_, err = w.Write(message.Bytes())
+	return err
+}
+
+func readInt32(b []byte, pos int) int32 {
+	return int32(binary.LittleEndian.Uint32(b[pos:]))
+}
+
+func writeInt32(buf *bytes.Buffer, v int32) {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, uint32(v))
+	buf.Write(b)
+}
+
+// readCString reads the null-terminated string at pos, and returns it
+// along with the position after it.
+func readCString(b []byte, pos int) (string, int, error) {
+	if pos > len(b) {
+		return "", 0, errors.New("message is truncated")
+	}
+	end := bytes.IndexByte(b[pos:], 0)
+	if end < 0 {
+		return "", 0, errors.New("unterminated string in message")
+	}
+	return string(b[pos : pos+end]), pos + end + 1, nil
+}
+
+// readDocument reads the BSON-document at pos, and returns it along with
+// the position after it.
+func readDocument(b []byte, pos int) (*bson.Document, int, error) {
+	if pos+4 > len(b) {
+		return nil, 0, errors.New("message is truncated")
+	}
+	size := int(readInt32(b, pos))
+	if size < 5 || pos+size > len(b) {
+		return nil, 0, fmt.Errorf("invalid document-size: %d", size)
+	}
+	doc, err := bson.ReadDocument(b[pos : pos+size])
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "invalid document")
+	}
+	return doc, pos + size, nil
+}
@@ -0,0 +1,534 @@
+package memstore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// sortDocuments sorts the documents as per the sort-document, such as
+// {hits: -1, name: 1}. The sort is stable, so the documents with equal keys
+// remain in order of insertion.
+func sortDocuments(docs []*bson.Document, spec *bson.Document) error {
+	type sortKey struct {
+		path       []string
+		descending bool
+	}
+	keys := []sortKey{}
+	iter := spec.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		if !isNumber(elem.Value()) {
+			return fmt.Errorf("sort-order of %s must be 1 or -1", elem.Key())
+		}
+		keys = append(keys, sortKey{
+			path:       splitPath(elem.Key()),
+			descending: floatValue(elem.Value()) < 0,
+		})
+	}
+	if iter.Err() != nil {
+		return iter.Err()
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, key := range keys {
+			cmp := compareValues(sortValue(docs[i], key.path), sortValue(docs[j], key.path))
+			if key.descending {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+// sortValue returns the value to sort a document by, which is the first
+// value at path, or nil if the field is missing.
+func sortValue(doc *bson.Document, path []string) *bson.Value {
+	values := lookupValues(doc, path)
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// project applies a projection such as {name: 1} or {secret: 0}. The _id is
+// included unless excluded explicitly.
+func project(doc *bson.Document, projection *bson.Document) (*bson.Document, error) {
+	include := []string{}
+	exclude := []string{}
+	excludeID := false
+	iter := projection.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		if !isTruthy(elem.Value()) {
+			if elem.Key() == "_id" {
+				excludeID = true
+			} else {
+				exclude = append(exclude, elem.Key())
+			}
+			continue
+		}
+		if elem.Value().Type() == bson.TypeEmbeddedDocument ||
+			elem.Value().Type() == bson.TypeString {
+			return nil, fmt.Errorf("unsupported projection of %s", elem.Key())
+		}
+		if elem.Key() != "_id" {
+			include = append(include, elem.Key())
+		}
+	}
+	if iter.Err() != nil {
+		return nil, iter.Err()
+	}
+	if len(include) > 0 && len(exclude) > 0 {
+		return nil, errors.New("projection cannot mix inclusion and exclusion")
+	}
+
+	if len(include) == 0 {
+		projected, err := copyDocument(doc)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range exclude {
+			deleteValue(projected, splitPath(key))
+		}
+		if excludeID {
+			projected.Delete("_id")
+		}
+		return projected, nil
+	}
+
+	if !excludeID {
+		include = append([]string{"_id"}, include...)
+	}
+	projected := bson.NewDocument()
+	for _, key := range include {
+		path := splitPath(key)
+		v := lookupValue(doc, path)
+		if v == nil {
+			continue
+		}
+		err := setValue(projected, path, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return projected, nil
+}
+
+// aggregate runs the pipeline-stages on documents. The stages $match,
+// $sort, $skip, $limit, $project, $group, $unwind, and $count are supported.
+// See: https://docs.mongodb.com/manual/reference/operator/aggregation-pipeline/
+func aggregate(
+	docs []*bson.Document,
+	pipeline []*bson.Document,
+) ([]*bson.Document, error) {
+	for i, stage := range pipeline {
+		if stage.Len() != 1 {
+			return nil, fmt.Errorf("stage %d must have exactly one field", i)
+		}
+		name := stage.ElementAt(0).Key()
+		arg := stage.ElementAt(0).Value()
+
+		var err error
+		docs, err = runStage(docs, name, arg)
+		if err != nil {
+			return nil, errors.Wrapf(err, "stage %d: %s", i, name)
+		}
+	}
+	return docs, nil
+}
+
+func runStage(
+	docs []*bson.Document,
+	name string,
+	arg *bson.Value,
+) ([]*bson.Document, error) {
+	switch name {
+	case "$match":
+		if arg.Type() != bson.TypeEmbeddedDocument {
+			return nil, errors.New("requires a document")
+		}
+		matched := []*bson.Document{}
+		for _, doc := range docs {
+			isMatch, err := matches(doc, arg.MutableDocument())
+			if err != nil {
+				return nil, err
+			}
+			if isMatch {
+				matched = append(matched, doc)
+			}
+		}
+		return matched, nil
+	case "$sort":
+		if arg.Type() != bson.TypeEmbeddedDocument {
+			return nil, errors.New("requires a document")
+		}
+		return docs, sortDocuments(docs, arg.MutableDocument())
+	case "$skip", "$limit":
+		if !isNumber(arg) || floatValue(arg) < 0 {
+			return nil, errors.New("requires a non-negative number")
+		}
+		n := int(floatValue(arg))
+		if n > len(docs) {
+			n = len(docs)
+		}
+		if name == "$skip" {
+			return docs[n:], nil
+		}
+		return docs[:n], nil
+	case "$project":
+		if arg.Type() != bson.TypeEmbeddedDocument {
+			return nil, errors.New("requires a document")
+		}
+		projected := make([]*bson.Document, len(docs))
+		for i, doc := range docs {
+			var err error
+			projected[i], err = projectStage(doc, arg.MutableDocument())
+			if err != nil {
+				return nil, err
+			}
+		}
+		return projected, nil
+	case "$group":
+		if arg.Type() != bson.TypeEmbeddedDocument {
+			return nil, errors.New("requires a document")
+		}
+		return group(docs, arg.MutableDocument())
+	case "$unwind":
+		return unwind(docs, arg)
+	case "$count":
+		if arg.Type() != bson.TypeString || arg.StringValue() == "" {
+			return nil, errors.New("requires a field-name")
+		}
+		if len(docs) == 0 {
+			return docs, nil
+		}
+		return []*bson.Document{
+			bson.NewDocument(bson.EC.Int32(arg.StringValue(), int32(len(docs)))),
+		}, nil
+	}
+	return nil, errors.New("unsupported stage")
+}
+
+// projectStage applies a $project, which also allows the field-paths such
+// as {city: "$address.city"} to compute the fields.
+func projectStage(doc *bson.Document, spec *bson.Document) (*bson.Document, error) {
+	projection := bson.NewDocument()
+	computed := map[string]*bson.Value{}
+	computedKeys := []string{}
+	isInclusion := false
+	iter := spec.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		switch elem.Value().Type() {
+		case bson.TypeString, bson.TypeEmbeddedDocument:
+			v, err := evaluate(doc, elem.Value())
+			if err != nil {
+				return nil, err
+			}
+			computed[elem.Key()] = v
+			computedKeys = append(computedKeys, elem.Key())
+			isInclusion = true
+		default:
+			if elem.Key() != "_id" && isTruthy(elem.Value()) {
+				isInclusion = true
+			}
+			projection.Append(bson.EC.Boolean(elem.Key(), isTruthy(elem.Value())))
+		}
+	}
+	if iter.Err() != nil {
+		return nil, iter.Err()
+	}
+	if !isInclusion {
+		return project(doc, projection)
+	}
+
+	projected := bson.NewDocument()
+	includeID := projection.Lookup("_id") == nil || isTruthy(projection.Lookup("_id"))
+	if id := doc.Lookup("_id"); includeID && id != nil {
+		err := setValue(projected, []string{"_id"}, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	projIter := projection.Iterator()
+	for projIter.Next() {
+		elem := projIter.Element()
+		if elem.Key() == "_id" {
+			continue
+		}
+		if !isTruthy(elem.Value()) {
+			return nil, errors.New("projection cannot mix inclusion and exclusion")
+		}
+		path := splitPath(elem.Key())
+		if v := lookupValue(doc, path); v != nil {
+			err := setValue(projected, path, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, key := range computedKeys {
+		if computed[key] == nil {
+			continue
+		}
+		err := setValue(projected, splitPath(key), computed[key])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return projected, nil
+}
+
+// evaluate evaluates an aggregation-expression, which is a field-path such
+// as "$address.city", a literal value, or a document of expressions.
+// It returns nil for the missing fields.
+func evaluate(doc *bson.Document, expr *bson.Value) (*bson.Value, error) {
+	switch expr.Type() {
+	case bson.TypeString:
+		str := expr.StringValue()
+		if strings.HasPrefix(str, "$$") {
+			return nil, fmt.Errorf("unsupported variable: %s", str)
+		}
+		if strings.HasPrefix(str, "$") {
+			return lookupValue(doc, splitPath(str[1:])), nil
+		}
+	case bson.TypeEmbeddedDocument:
+		exprDoc := expr.MutableDocument()
+		if isOperatorDocument(expr) {
+			return nil, fmt.Errorf(
+				"unsupported expression-operator: %s", exprDoc.ElementAt(0).Key(),
+			)
+		}
+		evaluated := bson.NewDocument()
+		iter := exprDoc.Iterator()
+		for iter.Next() {
+			v, err := evaluate(doc, iter.Element().Value())
+			if err != nil {
+				return nil, err
+			}
+			if v == nil {
+				continue
+			}
+			elem, err := element(iter.Element().Key(), v)
+			if err != nil {
+				return nil, err
+			}
+			evaluated.Append(elem)
+		}
+		return bson.VC.Document(evaluated), iter.Err()
+	}
+	return expr, nil
+}
+
+// accumulator accumulates the values of a $group-field.
+type accumulator struct {
+	op     string
+	values []*bson.Value
+}
+
+// result returns the accumulated value.
+func (a *accumulator) result() (*bson.Value, error) {
+	switch a.op {
+	case "$sum", "$avg":
+		sum := bson.VC.Int32(0)
+		count := 0
+		for _, v := range a.values {
+			if !isNumber(v) {
+				continue
+			}
+			var err error
+			sum, err = addNumbers(sum, v)
+			if err != nil {
+				return nil, err
+			}
+			count++
+		}
+		if a.op == "$sum" {
+			return sum, nil
+		}
+		if count == 0 {
+			return bson.VC.Null(), nil
+		}
+		return bson.VC.Double(floatValue(sum) / float64(count)), nil
+	case "$min", "$max":
+		var result *bson.Value
+		for _, v := range a.values {
+			if v.Type() == bson.TypeNull || v.Type() == bson.TypeUndefined {
+				continue
+			}
+			cmp := compareValues(v, result)
+			if result == nil || (a.op == "$min" && cmp < 0) || (a.op == "$max" && cmp > 0) {
+				result = v
+			}
+		}
+		if result == nil {
+			return bson.VC.Null(), nil
+		}
+		return result, nil
+	case "$first", "$last":
+		if len(a.values) == 0 {
+			return bson.VC.Null(), nil
+		}
+		if a.op == "$first" {
+			return a.values[0], nil
+		}
+		return a.values[len(a.values)-1], nil
+	case "$push", "$addToSet":
+		values := []*bson.Value{}
+		for _, v := range a.values {
+			if a.op == "$addToSet" {
+				exists := false
+				for _, existing := range values {
+					if compareValues(existing, v) == 0 {
+						exists = true
+						break
+					}
+				}
+				if exists {
+					continue
+				}
+			}
+			values = append(values, v)
+		}
+		return bson.VC.Array(bson.NewArray(values...)), nil
+	}
+	return nil, fmt.Errorf("unsupported accumulator: %s", a.op)
+}
+
+// group groups the documents by the _id-expression, and computes the
+// fields using accumulators such as {total: {$sum: "$hits"}}.
+func group(docs []*bson.Document, spec *bson.Document) ([]*bson.Document, error) {
+	idExpr := spec.Lookup("_id")
+	if idExpr == nil {
+		return nil, errors.New("_id is required")
+	}
+
+	type groupFields struct {
+		id           *bson.Value
+		accumulators []*accumulator
+	}
+	groups := []*groupFields{}
+	for _, doc := range docs {
+		id, err := evaluate(doc, idExpr)
+		if err != nil {
+			return nil, err
+		}
+		if id == nil {
+			id = bson.VC.Null()
+		}
+
+		var g *groupFields
+		for _, existing := range groups {
+			if compareValues(existing.id, id) == 0 {
+				g = existing
+				break
+			}
+		}
+		if g == nil {
+			g = &groupFields{id: id}
+			groups = append(groups, g)
+		}
+
+		iter := spec.Iterator()
+		for i := 0; iter.Next(); {
+			elem := iter.Element()
+			if elem.Key() == "_id" {
+				continue
+			}
+			if !isOperatorDocument(elem.Value()) || elem.Value().MutableDocument().Len() != 1 {
+				return nil, fmt.Errorf("%s must be an accumulator", elem.Key())
+			}
+			accElem := elem.Value().MutableDocument().ElementAt(0)
+			if len(g.accumulators) <= i {
+				g.accumulators = append(g.accumulators, &accumulator{op: accElem.Key()})
+			}
+			v, err := evaluate(doc, accElem.Value())
+			if err != nil {
+				return nil, err
+			}
+			if v != nil {
+				acc := g.accumulators[i]
+				acc.values = append(acc.values, v)
+			}
+			i++
+		}
+		if iter.Err() != nil {
+			return nil, iter.Err()
+		}
+	}
+
+	grouped := []*bson.Document{}
+	for _, g := range groups {
+		idElem, err := element("_id", g.id)
+		if err != nil {
+			return nil, err
+		}
+		doc := bson.NewDocument(idElem)
+
+		i := 0
+		iter := spec.Iterator()
+		for iter.Next() {
+			key := iter.Element().Key()
+			if key == "_id" {
+				continue
+			}
+			result, err := g.accumulators[i].result()
+			if err != nil {
+				return nil, err
+			}
+			elem, err := element(key, result)
+			if err != nil {
+				return nil, err
+			}
+			doc.Append(elem)
+			i++
+		}
+		grouped = append(grouped, doc)
+	}
+	return grouped, nil
+}
+
+// unwind outputs a document for each element of an array-field. The
+// documents with missing, null, or empty arrays are skipped.
+func unwind(docs []*bson.Document, arg *bson.Value) ([]*bson.Document, error) {
+	if arg.Type() == bson.TypeEmbeddedDocument {
+		arg = arg.MutableDocument().Lookup("path")
+	}
+	if arg == nil || arg.Type() != bson.TypeString ||
+		!strings.HasPrefix(arg.StringValue(), "$") {
+		return nil, errors.New("requires a field-path such as \"$tags\"")
+	}
+	path := splitPath(arg.StringValue()[1:])
+
+	unwound := []*bson.Document{}
+	for _, doc := range docs {
+		v := lookupValue(doc, path)
+		if v == nil || v.Type() == bson.TypeNull {
+			continue
+		}
+		elems := []*bson.Value{v}
+		if v.Type() == bson.TypeArray {
+			elems = arrayValues(v.MutableArray())
+		}
+		for _, elem := range elems {
+			unwoundDoc, err := copyDocument(doc)
+			if err != nil {
+				return nil, err
+			}
+			err = setValue(unwoundDoc, path, elem)
+			if err != nil {
+				return nil, err
+			}
+			unwound = append(unwound, unwoundDoc)
+		}
+	}
+	return unwound, nil
+}
@@ -0,0 +1,467 @@
+package memstore
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/replaceopt"
+	"github.com/mongodb/mongo-go-driver/mongo/updateopt"
+	"github.com/pkg/errors"
+)
+
+// Collection is an in-memory implementation of mongo.CollectionAPI, which
+// stores the documents in a Store. The data is verified against the
+// SchemaStruct, and the results are decoded into it, same as mongo.Collection.
+//
+// The schema-tags (such as "createdAt" and "encrypt"), Middlewares, Auditor,
+// and SchemaUpgrades of mongo.Collection are not applied. The supported
+// options are findopt.Sort, findopt.Skip, findopt.Limit, findopt.Projection,
+// and upsert.
+type Collection struct {
+	store        *Store
+	database     string
+	name         string
+	schemaStruct interface{}
+}
+
+var _ mongo.CollectionAPI = &Collection{}
+
+// NewCollection creates the in-memory Collection for the config, along with
+// its Indexes. Only the Database, Name, SchemaStruct, and Indexes of config
+// are used, so the same config can be used with mongo.EnsureCollection.
+func NewCollection(store *Store, config *mongo.Collection) (*Collection, error) {
+	if config.Name == "" {
+		return nil, errors.New("NewCollection - Collection-name is required")
+	}
+	schemaType := reflect.TypeOf(config.SchemaStruct)
+	if schemaType == nil ||
+		schemaType.Kind() != reflect.Ptr ||
+		schemaType.Elem().Kind() != reflect.Struct {
+		return nil, errors.New(
+			"NewCollection - SchemaStruct must be a pointer to a struct",
+		)
+	}
+
+	store.CreateCollection(config.Database, config.Name)
+	for _, indexConfig := range config.Indexes {
+		keys := bson.NewDocument()
+		for _, column := range indexConfig.ColumnConfig {
+			order := int32(1)
+			if column.IsDescOrder {
+				order = -1
+			}
+			keys.Append(bson.EC.Int32(column.Name, order))
+		}
+		err := store.CreateIndex(config.Database, config.Name, Index{
+			Name:               indexConfig.Name,
+			Keys:               keys,
+			Unique:             indexConfig.IsUnique,
+			ExpireAfterSeconds: indexConfig.ExpireAfterSeconds,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "NewCollection - Error Creating Index")
+		}
+	}
+
+	return &Collection{
+		store:        store,
+		database:     config.Database,
+		name:         config.Name,
+		schemaStruct: config.SchemaStruct,
+	}, nil
+}
+
+// verifyDataSchema checks if the data is a map, or same type as SchemaStruct.
+func (c *Collection) verifyDataSchema(data interface{}) error {
+	dataType := reflect.TypeOf(data)
+	if dataType == nil {
+		return errors.New("data cannot be nil")
+	}
+	if dataType.Kind() == reflect.Ptr {
+		dataType = dataType.Elem()
+	}
+	if dataType.Kind() == reflect.Map {
+		return nil
+	}
+	expectedType := reflect.TypeOf(c.schemaStruct)
+	if dataType != expectedType.Elem() {
+		return &mongo.SchemaMismatchError{
+			Expected: expectedType.String(),
+			Actual:   reflect.PtrTo(dataType).String(),
+		}
+	}
+	return nil
+}
+
+// toDocument converts the data to BSON. The zero-ObjectID is removed from
+// _id, so a new one is generated, same as mongo.Collection.
+func toDocument(data interface{}) (*bson.Document, error) {
+	if doc, ok := data.(*bson.Document); ok {
+		return doc, nil
+	}
+	doc, err := bson.NewDocumentEncoder().EncodeDocument(data)
+	if err != nil {
+		return nil, err
+	}
+	id := doc.Lookup("_id")
+	isZeroID := id != nil && id.Type() == bson.TypeObjectID &&
+		id.ObjectID() == objectid.ObjectID{}
+	if isZeroID {
+		doc.Delete("_id")
+	}
+	return doc, nil
+}
+
+// decode decodes the document into a new instance of SchemaStruct.
+func (c *Collection) decode(doc *bson.Document) (interface{}, error) {
+	docBytes, err := doc.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+	item := reflect.New(reflect.TypeOf(c.schemaStruct).Elem()).Interface()
+	err = bson.Unmarshal(docBytes, item)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func isKind(data interface{}, kinds ...reflect.Kind) bool {
+	dataType := reflect.TypeOf(data)
+	if dataType == nil {
+		return false
+	}
+	if dataType.Kind() == reflect.Ptr {
+		dataType = dataType.Elem()
+	}
+	for _, kind := range kinds {
+		if dataType.Kind() == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// findOptions converts the driver's find-options.
+func findOptions(opts []interface{}) (FindOptions, error) {
+	findOpts := FindOptions{}
+	for _, opt := range opts {
+		var err error
+		switch o := opt.(type) {
+		case findopt.OptSort:
+			findOpts.Sort, err = toDocument(o.Sort)
+		case findopt.OptProjection:
+			findOpts.Projection, err = toDocument(o.Projection)
+		case findopt.OptSkip:
+			findOpts.Skip = int64(o)
+		case findopt.OptLimit:
+			findOpts.Limit = int64(o)
+		default:
+			return findOpts, fmt.Errorf("unsupported option: %T", opt)
+		}
+		if err != nil {
+			return findOpts, err
+		}
+	}
+	return findOpts, nil
+}
+
+// Find finds the documents matching the filter.
+func (c *Collection) Find(
+	filter interface{},
+	opts ...findopt.Find,
+) ([]interface{}, error) {
+	genericOpts := make([]interface{}, len(opts))
+	for i, opt := range opts {
+		genericOpts[i] = opt
+	}
+	return c.find("Find", filter, genericOpts)
+}
+
+func (c *Collection) find(
+	opName string,
+	filter interface{},
+	opts []interface{},
+) ([]interface{}, error) {
+	err := c.verifyDataSchema(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, opName+" - Schema Verification Error")
+	}
+	filterDoc, err := toDocument(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, opName+" - BSON Convert Error")
+	}
+	findOpts, err := findOptions(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, opName+" - Options Error")
+	}
+	if opName == "FindOne" {
+		findOpts.Limit = 1
+	}
+
+	docs, err := c.store.Find(c.database, c.name, filterDoc, findOpts)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]interface{}, 0)
+	for _, doc := range docs {
+		item, err := c.decode(doc)
+		if err != nil {
+			return nil, errors.Wrap(err, opName+" - Decode Error")
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// FindOne returns single result that matches the provided filter.
+// The mgo.ErrNoDocuments is returned if no documents match, so
+// mongo.IsNotFound can be used to check the error.
+func (c *Collection) FindOne(
+	filter interface{},
+	opts ...findopt.One,
+) (interface{}, error) {
+	genericOpts := make([]interface{}, len(opts))
+	for i, opt := range opts {
+		genericOpts[i] = opt
+	}
+	items, err := c.find("FindOne", filter, genericOpts)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, errors.Wrap(mgo.ErrNoDocuments, "FindOne Decoding Error")
+	}
+	return items[0], nil
+}
+
+// InsertOne inserts the provided data into Collection.
+func (c *Collection) InsertOne(data interface{}) (*mgo.InsertOneResult, error) {
+	err := c.verifyDataSchema(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "InsertOne - Schema Verification Error")
+	}
+	doc, err := toDocument(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "InsertOne - BSON Convert Error")
+	}
+	ids, err := c.store.Insert(c.database, c.name, doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "InsertOne Error")
+	}
+	return &mgo.InsertOneResult{InsertedID: ids[0].Interface()}, nil
+}
+
+// InsertMany inserts the provided data into Collection, in order.
+func (c *Collection) InsertMany(
+	data []interface{},
+) (*[]mgo.InsertOneResult, error) {
+	insertResults := []mgo.InsertOneResult{}
+	for i, d := range data {
+		result, err := c.InsertOne(d)
+		if err != nil {
+			return nil, errors.Wrapf(
+				err,
+				"InsertMany - Error Inserting Data at Index: %d", i,
+			)
+		}
+		insertResults = append(insertResults, *result)
+	}
+	return &insertResults, nil
+}
+
+// UpdateMany updates multiple documents in the collection. The update-data
+// is applied as $set, same as mongo.Collection.
+func (c *Collection) UpdateMany(
+	filter interface{},
+	update interface{},
+	opts ...updateopt.Update,
+) (*mgo.UpdateResult, error) {
+	upsert := false
+	for _, opt := range opts {
+		o, ok := opt.(updateopt.OptUpsert)
+		if !ok {
+			return nil, fmt.Errorf("UpdateMany - Unsupported Option: %T", opt)
+		}
+		upsert = bool(o)
+	}
+	if !isKind(filter, reflect.Map, reflect.Struct) {
+		return nil, errors.New(
+			"UpdateMany - Filter-argument must be a Map or Struct " +
+				"(pointer or non-pointer)",
+		)
+	}
+	if !isKind(update, reflect.Map) {
+		return nil, errors.New(
+			"UpdateMany - Update-argument must be a Map (pointer or non-pointer)",
+		)
+	}
+
+	filterDoc, err := toDocument(filter)
+	if err != nil {
+		return nil, errors.Wrap(
+			err,
+			"UpdateMany - BSON Convert Error for filter-argument",
+		)
+	}
+	setDoc, err := toDocument(update)
+	if err != nil {
+		return nil, errors.Wrap(
+			err,
+			"UpdateMany - BSON Convert Error for update-argument",
+		)
+	}
+	updateDoc := bson.NewDocument(bson.EC.SubDocument("$set", setDoc))
+	result, err := c.store.Update(c.database, c.name, filterDoc, updateDoc, true, upsert)
+	return updateResult(result), err
+}
+
+// ReplaceOne replaces a single document matching the filter.
+func (c *Collection) ReplaceOne(
+	filter interface{},
+	replacement interface{},
+	opts ...replaceopt.Replace,
+) (*mgo.UpdateResult, error) {
+	upsert := false
+	for _, opt := range opts {
+		o, ok := opt.(replaceopt.OptUpsert)
+		if !ok {
+			return nil, fmt.Errorf("ReplaceOne - Unsupported Option: %T", opt)
+		}
+		upsert = bool(o)
+	}
+	if !isKind(filter, reflect.Map, reflect.Struct) {
+		return nil, errors.New(
+			"ReplaceOne - Filter-argument must be a Map or Struct " +
+				"(pointer or non-pointer)",
+		)
+	}
+	err := c.verifyDataSchema(replacement)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReplaceOne - Schema Verification Error")
+	}
+
+	filterDoc, err := toDocument(filter)
+	if err != nil {
+		return nil, errors.Wrap(
+			err,
+			"ReplaceOne - BSON Convert Error for filter-argument",
+		)
+	}
+	replacementDoc, err := toDocument(replacement)
+	if err != nil {
+		return nil, errors.Wrap(
+			err,
+			"ReplaceOne - BSON Convert Error for replacement-argument",
+		)
+	}
+	// The _id of existing document cannot be changed
+	replacementDoc.Delete("_id")
+
+	result, err := c.store.Update(
+		c.database, c.name, filterDoc, replacementDoc, false, upsert,
+	)
+	return updateResult(result), err
+}
+
+func updateResult(result *UpdateResult) *mgo.UpdateResult {
+	if result == nil {
+		return nil
+	}
+	r := &mgo.UpdateResult{
+		MatchedCount:  result.Matched,
+		ModifiedCount: result.Modified,
+	}
+	if result.UpsertedID != nil {
+		r.UpsertedID = result.UpsertedID.Interface()
+	}
+	return r
+}
+
+// DeleteMany deletes multiple documents from the collection.
+func (c *Collection) DeleteMany(filter interface{}) (*mgo.DeleteResult, error) {
+	err := c.verifyDataSchema(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "DeleteMany - Schema Verification Error")
+	}
+	doc, err := toDocument(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "DeleteMany - BSON Convert Error")
+	}
+	deleted, err := c.store.Delete(c.database, c.name, doc, 0)
+	if err != nil {
+		return nil, errors.Wrap(err, "Deletion Error")
+	}
+	return &mgo.DeleteResult{DeletedCount: deleted}, nil
+}
+
+// Aggregate runs an aggregation-pipeline, which can be a *bson.Array,
+// []*bson.Document, or a slice of maps or documents. The results are
+// returned as map[string]interface{}, same as mongo.Collection.
+func (c *Collection) Aggregate(pipeline interface{}) ([]interface{}, error) {
+	stages, err := pipelineStages(pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "Aggregate - Pipeline Error")
+	}
+	docs, err := c.store.Aggregate(c.database, c.name, stages)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]interface{}, 0)
+	for _, doc := range docs {
+		docBytes, err := doc.MarshalBSON()
+		if err != nil {
+			return nil, errors.Wrap(err, "Aggregate - Decode Error")
+		}
+		item := map[string]interface{}{}
+		err = bson.Unmarshal(docBytes, item)
+		if err != nil {
+			return nil, errors.Wrap(err, "Aggregate - Decode Error")
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// pipelineStages converts the pipeline into stage-documents.
+func pipelineStages(pipeline interface{}) ([]*bson.Document, error) {
+	stages := []*bson.Document{}
+	switch p := pipeline.(type) {
+	case *bson.Array:
+		for _, v := range arrayValues(p) {
+			if v.Type() != bson.TypeEmbeddedDocument {
+				return nil, errors.New("stages must be documents")
+			}
+			stages = append(stages, v.MutableDocument())
+		}
+	case []*bson.Document:
+		stages = p
+	case []interface{}:
+		for _, stage := range p {
+			doc, err := toDocument(stage)
+			if err != nil {
+				return nil, err
+			}
+			stages = append(stages, doc)
+		}
+	default:
+		if !isKind(pipeline, reflect.Slice, reflect.Array) {
+			return nil, fmt.Errorf("unsupported pipeline-type: %T", pipeline)
+		}
+		value := reflect.Indirect(reflect.ValueOf(pipeline))
+		for i := 0; i < value.Len(); i++ {
+			doc, err := toDocument(value.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			stages = append(stages, doc)
+		}
+	}
+	return stages, nil
+}
@@ -0,0 +1,151 @@
+package memstore
+
+import (
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/updateopt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Collection", func() {
+	type item struct {
+		ID    objectid.ObjectID `bson:"_id,omitempty"`
+		Word  string            `bson:"word,omitempty"`
+		Email string            `bson:"email,omitempty"`
+		Hits  int               `bson:"hits,omitempty"`
+	}
+
+	var c *Collection
+
+	BeforeEach(func() {
+		var err error
+		c, err = NewCollection(NewStore(), &mongo.Collection{
+			Database:     "test",
+			Name:         "items",
+			SchemaStruct: &item{},
+			Indexes: []mongo.IndexConfig{
+				{
+					ColumnConfig: []mongo.IndexColumnConfig{{Name: "email"}},
+					IsUnique:     true,
+					Name:         "email_index",
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = c.InsertMany([]interface{}{
+			&item{Word: "some-word", Email: "a@example.com", Hits: 5},
+			&item{Word: "some-word", Email: "b@example.com", Hits: 10},
+			&item{Word: "other-word", Email: "c@example.com", Hits: 8},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should return error if SchemaStruct is not a pointer to struct", func() {
+		_, err := NewCollection(NewStore(), &mongo.Collection{
+			Name:         "items",
+			SchemaStruct: item{},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should insert the data, and generate the ObjectIDs", func() {
+		result, err := c.InsertOne(&item{Word: "new-word", Email: "d@example.com"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.InsertedID).To(BeAssignableToTypeOf(objectid.ObjectID{}))
+
+		found, err := c.FindOne(map[string]interface{}{"_id": result.InsertedID})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found.(*item).Word).To(Equal("new-word"))
+	})
+
+	It("should find the documents with sort and limit", func() {
+		found, err := c.Find(
+			&item{Word: "some-word"},
+			findopt.Sort(map[string]interface{}{"hits": -1}),
+			findopt.Limit(1),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(HaveLen(1))
+		Expect(found[0].(*item).Hits).To(Equal(10))
+	})
+
+	It("should return the not-found and schema-mismatch errors", func() {
+		_, err := c.FindOne(&item{Word: "missing"})
+		Expect(mongo.IsNotFound(err)).To(BeTrue())
+
+		type other struct {
+			Word string
+		}
+		_, err = c.Find(&other{Word: "some-word"})
+		Expect(mongo.IsSchemaMismatch(err)).To(BeTrue())
+	})
+
+	It("should return duplicate-key errors for unique indexes", func() {
+		_, err := c.InsertOne(&item{Word: "dup", Email: "a@example.com"})
+		dupErr, isDuplicate := mongo.IsDuplicateKey(err)
+		Expect(isDuplicate).To(BeTrue())
+		Expect(dupErr.Index).To(Equal("email_index"))
+	})
+
+	It("should update and replace the documents", func() {
+		result, err := c.UpdateMany(
+			&item{Word: "some-word"},
+			map[string]interface{}{"hits": 5},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.MatchedCount).To(Equal(int64(2)))
+		Expect(result.ModifiedCount).To(Equal(int64(1)))
+
+		result, err = c.UpdateMany(
+			&item{Word: "new-word"},
+			map[string]interface{}{"hits": 1},
+			updateopt.Upsert(true),
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.UpsertedID).To(BeAssignableToTypeOf(objectid.ObjectID{}))
+
+		result, err = c.ReplaceOne(
+			&item{Word: "other-word"},
+			&item{Word: "replaced-word", Email: "c@example.com"},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.ModifiedCount).To(Equal(int64(1)))
+
+		found, err := c.Find(map[string]interface{}{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(HaveLen(4))
+		Expect(found[2].(*item).Word).To(Equal("replaced-word"))
+		Expect(found[2].(*item).Hits).To(BeZero())
+	})
+
+	It("should delete the documents", func() {
+		result, err := c.DeleteMany(&item{Word: "some-word"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.DeletedCount).To(Equal(int64(2)))
+	})
+
+	It("should aggregate the documents", func() {
+		pipeline := bson.NewArray(
+			bson.VC.DocumentFromElements(
+				bson.EC.SubDocumentFromElements(
+					"$match",
+					bson.EC.SubDocumentFromElements(
+						"hits",
+						bson.EC.Int32("$gt", 5),
+					),
+				),
+			),
+		)
+		results, err := c.Aggregate(pipeline)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(2))
+
+		result, ok := results[0].(map[string]interface{})
+		Expect(ok).To(BeTrue())
+		Expect(result["email"]).To(Equal("b@example.com"))
+	})
+})
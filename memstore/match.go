@@ -0,0 +1,399 @@
+package memstore
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// Match checks if the document matches the query-filter, as evaluated by
+// the Store. This returns an error for the unsupported query-operators.
+func Match(doc *bson.Document, filter *bson.Document) (bool, error) {
+	return matches(doc, filter)
+}
+
+// matches checks if the document matches the query-filter.
+// See: https://docs.mongodb.com/manual/reference/operator/query/
+func matches(doc *bson.Document, filter *bson.Document) (bool, error) {
+	iter := filter.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		key := elem.Key()
+
+		var isMatch bool
+		var err error
+		switch key {
+		case "$and", "$or", "$nor":
+			isMatch, err = matchLogical(doc, key, elem.Value())
+		case "$comment":
+			isMatch = true
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false, fmt.Errorf("unsupported query-operator: %s", key)
+			}
+			isMatch, err = matchField(lookupValues(doc, splitPath(key)), elem.Value())
+		}
+		if err != nil || !isMatch {
+			return false, err
+		}
+	}
+	return true, iter.Err()
+}
+
+func matchLogical(doc *bson.Document, op string, v *bson.Value) (bool, error) {
+	if v.Type() != bson.TypeArray || v.MutableArray().Len() == 0 {
+		return false, fmt.Errorf("%s must be a non-empty array", op)
+	}
+	for _, clause := range arrayValues(v.MutableArray()) {
+		if clause.Type() != bson.TypeEmbeddedDocument {
+			return false, fmt.Errorf("%s must be an array of documents", op)
+		}
+		isMatch, err := matches(doc, clause.MutableDocument())
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case op == "$and" && !isMatch:
+			return false, nil
+		case op == "$or" && isMatch:
+			return true, nil
+		case op == "$nor" && isMatch:
+			return false, nil
+		}
+	}
+	return op != "$or", nil
+}
+
+// isOperatorDocument checks if the value is a document of operators,
+// such as {$gt: 4, $lt: 9}.
+func isOperatorDocument(v *bson.Value) bool {
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return false
+	}
+	doc := v.MutableDocument()
+	return doc.Len() > 0 && strings.HasPrefix(doc.ElementAt(0).Key(), "$")
+}
+
+// matchField checks if the values at a field's path match the condition,
+// which is either a value to compare with, or a document of operators.
+func matchField(values []*bson.Value, cond *bson.Value) (bool, error) {
+	if !isOperatorDocument(cond) {
+		return matchEquals(values, cond)
+	}
+
+	ops := cond.MutableDocument()
+	iter := ops.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		isMatch, err := matchOperator(values, elem.Key(), elem.Value(), ops)
+		if err != nil || !isMatch {
+			return false, err
+		}
+	}
+	return true, iter.Err()
+}
+
+// expand returns the values along with the elements of array-values,
+// since the conditions match a field if these match any of its elements.
+func expand(values []*bson.Value) []*bson.Value {
+	expanded := []*bson.Value{}
+	for _, v := range values {
+		expanded = append(expanded, v)
+		if v.Type() == bson.TypeArray {
+			expanded = append(expanded, arrayValues(v.MutableArray())...)
+		}
+	}
+	return expanded
+}
+
+// matchEquals checks if any value equals the condition. The null matches
+// the missing fields, and a regex matches the strings.
+func matchEquals(values []*bson.Value, cond *bson.Value) (bool, error) {
+	if cond.Type() == bson.TypeRegex {
+		pattern, options := cond.Regex()
+		return matchRegex(values, pattern, options)
+	}
+	if cond.Type() == bson.TypeNull && len(values) == 0 {
+		return true, nil
+	}
+	for _, v := range expand(values) {
+		if compareValues(v, cond) == 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchRegex(values []*bson.Value, pattern string, options string) (bool, error) {
+	flags := ""
+	for _, option := range options {
+		switch option {
+		case 'i', 'm', 's':
+			flags += string(option)
+		default:
+			return false, fmt.Errorf("unsupported regex-option: %c", option)
+		}
+	}
+	if flags != "" {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, errors.Wrap(err, "invalid regex")
+	}
+
+	for _, v := range expand(values) {
+		if v.Type() == bson.TypeString && re.MatchString(v.StringValue()) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// matchOperator evaluates a single query-operator. The ops are the
+// operators of field, which are required for the $options of $regex.
+func matchOperator(
+	values []*bson.Value,
+	op string,
+	arg *bson.Value,
+	ops *bson.Document,
+) (bool, error) {
+	switch op {
+	case "$eq":
+		return matchEquals(values, arg)
+	case "$ne":
+		isMatch, err := matchEquals(values, arg)
+		return !isMatch, err
+	case "$gt", "$gte", "$lt", "$lte":
+		return matchComparison(values, op, arg), nil
+	case "$in", "$nin":
+		if arg.Type() != bson.TypeArray {
+			return false, fmt.Errorf("%s requires an array", op)
+		}
+		isMatch := false
+		for _, v := range arrayValues(arg.MutableArray()) {
+			equals, err := matchEquals(values, v)
+			if err != nil {
+				return false, err
+			}
+			if equals {
+				isMatch = true
+				break
+			}
+		}
+		return isMatch == (op == "$in"), nil
+	case "$exists":
+		return (len(values) > 0) == isTruthy(arg), nil
+	case "$type":
+		return matchType(values, arg)
+	case "$regex":
+		options := ""
+		if v := ops.Lookup("$options"); v != nil && v.Type() == bson.TypeString {
+			options = v.StringValue()
+		}
+		switch arg.Type() {
+		case bson.TypeString:
+			return matchRegex(values, arg.StringValue(), options)
+		case bson.TypeRegex:
+			pattern, regexOptions := arg.Regex()
+			return matchRegex(values, pattern, regexOptions+options)
+		}
+		return false, errors.New("$regex requires a string or regex")
+	case "$options":
+		// Applied with $regex
+		return true, nil
+	case "$size":
+		if !isNumber(arg) {
+			return false, errors.New("$size requires a number")
+		}
+		for _, v := range values {
+			if v.Type() == bson.TypeArray &&
+				float64(v.MutableArray().Len()) == floatValue(arg) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "$all":
+		return matchAll(values, arg)
+	case "$elemMatch":
+		return matchElement(values, arg)
+	case "$not":
+		var isMatch bool
+		var err error
+		switch {
+		case arg.Type() == bson.TypeRegex:
+			isMatch, err = matchEquals(values, arg)
+		case isOperatorDocument(arg):
+			isMatch, err = matchField(values, arg)
+		default:
+			return false, errors.New("$not requires a regex or document of operators")
+		}
+		return !isMatch, err
+	case "$mod":
+		return matchMod(values, arg)
+	}
+	return false, fmt.Errorf("unsupported query-operator: %s", op)
+}
+
+// matchComparison compares the values with argument, which only matches
+// the values of same type-order, so {$gt: 4} does not match the strings.
+func matchComparison(values []*bson.Value, op string, arg *bson.Value) bool {
+	for _, v := range expand(values) {
+		if typeOrder(v.Type()) != typeOrder(arg.Type()) {
+			continue
+		}
+		cmp := compareValues(v, arg)
+		isMatch := (op == "$gt" && cmp > 0) ||
+			(op == "$gte" && cmp >= 0) ||
+			(op == "$lt" && cmp < 0) ||
+			(op == "$lte" && cmp <= 0)
+		if isMatch {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAll(values []*bson.Value, arg *bson.Value) (bool, error) {
+	if arg.Type() != bson.TypeArray {
+		return false, errors.New("$all requires an array")
+	}
+	all := arrayValues(arg.MutableArray())
+	if len(all) == 0 {
+		return false, nil
+	}
+	for _, v := range all {
+		var isMatch bool
+		var err error
+		if isOperatorDocument(v) && v.MutableDocument().Lookup("$elemMatch") != nil {
+			isMatch, err = matchField(values, v)
+		} else {
+			isMatch, err = matchEquals(values, v)
+		}
+		if err != nil || !isMatch {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchElement checks if any element of array-values matches the
+// condition, which is either a document of operators for the element,
+// or a query-filter for the element-documents.
+func matchElement(values []*bson.Value, cond *bson.Value) (bool, error) {
+	if cond.Type() != bson.TypeEmbeddedDocument {
+		return false, errors.New("$elemMatch requires a document")
+	}
+	filter := cond.MutableDocument()
+	isLogical := filter.Len() > 0 && filter.ElementAt(0).Key()[0] == '$' &&
+		(filter.Lookup("$and") != nil ||
+			filter.Lookup("$or") != nil ||
+			filter.Lookup("$nor") != nil)
+
+	for _, v := range values {
+		if v.Type() != bson.TypeArray {
+			continue
+		}
+		for _, elem := range arrayValues(v.MutableArray()) {
+			var isMatch bool
+			var err error
+			switch {
+			case isOperatorDocument(cond) && !isLogical:
+				isMatch, err = matchField([]*bson.Value{elem}, cond)
+			case elem.Type() == bson.TypeEmbeddedDocument:
+				isMatch, err = matches(elem.MutableDocument(), filter)
+			}
+			if err != nil {
+				return false, err
+			}
+			if isMatch {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func matchMod(values []*bson.Value, arg *bson.Value) (bool, error) {
+	var args []*bson.Value
+	if arg.Type() == bson.TypeArray {
+		args = arrayValues(arg.MutableArray())
+	}
+	if len(args) != 2 || !isNumber(args[0]) || !isNumber(args[1]) {
+		return false, errors.New("$mod requires an array of divisor and remainder")
+	}
+	divisor, remainder := math.Trunc(floatValue(args[0])), math.Trunc(floatValue(args[1]))
+	if divisor == 0 {
+		return false, errors.New("$mod divisor cannot be 0")
+	}
+
+	for _, v := range expand(values) {
+		if isNumber(v) && math.Mod(math.Trunc(floatValue(v)), divisor) == remainder {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// typeAliases are the type-names accepted by $type, along with the
+// BSON type-numbers.
+var typeAliases = map[string]bson.Type{
+	"double":    bson.TypeDouble,
+	"string":    bson.TypeString,
+	"object":    bson.TypeEmbeddedDocument,
+	"array":     bson.TypeArray,
+	"binData":   bson.TypeBinary,
+	"undefined": bson.TypeUndefined,
+	"objectId":  bson.TypeObjectID,
+	"bool":      bson.TypeBoolean,
+	"date":      bson.TypeDateTime,
+	"null":      bson.TypeNull,
+	"regex":     bson.TypeRegex,
+	"int":       bson.TypeInt32,
+	"timestamp": bson.TypeTimestamp,
+	"long":      bson.TypeInt64,
+	"decimal":   bson.TypeDecimal128,
+	"minKey":    bson.TypeMinKey,
+	"maxKey":    bson.TypeMaxKey,
+}
+
+func matchType(values []*bson.Value, arg *bson.Value) (bool, error) {
+	types := []*bson.Value{arg}
+	if arg.Type() == bson.TypeArray {
+		types = arrayValues(arg.MutableArray())
+	}
+
+	for _, t := range types {
+		var isMatch func(v *bson.Value) bool
+		switch {
+		case t.Type() == bson.TypeString && t.StringValue() == "number":
+			isMatch = isNumber
+		case t.Type() == bson.TypeString:
+			bsonType, ok := typeAliases[t.StringValue()]
+			if !ok {
+				return false, fmt.Errorf("unknown $type: %s", t.StringValue())
+			}
+			isMatch = func(v *bson.Value) bool {
+				return v.Type() == bsonType
+			}
+		case isNumber(t):
+			bsonType := bson.Type(floatValue(t))
+			isMatch = func(v *bson.Value) bool {
+				return v.Type() == bsonType
+			}
+		default:
+			return false, errors.New("$type requires a type-name or number")
+		}
+
+		for _, v := range expand(values) {
+			if isMatch(v) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,13 @@
+package memstore
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMemstore(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Memstore Suite")
+}
@@ -0,0 +1,763 @@
+// Package memstore provides an in-memory implementation of the Collection
+// operations, so the code using Collections can be unit-tested without a
+// MongoDB server.
+//
+// The Store evaluates the common query-operators, update-operators, sorting,
+// projections, limits, unique indexes, and the simple aggregation-stages
+// ($match, $sort, $skip, $limit, $project, $group, $unwind, and $count).
+// The unsupported operators return errors, rather than being ignored, so
+// the tests do not pass by accident.
+package memstore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/TerrexTech/go-mongoutils/extjson"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/pkg/errors"
+)
+
+// IDIndexName is the name of the unique index on _id, which every
+// collection has.
+const IDIndexName = "_id_"
+
+// Index defines an index on a collection. Only the unique indexes affect
+// the documents, the rest are retained so these can be listed.
+type Index struct {
+	Name string
+	// Keys is the index-specification, such as {email: 1}.
+	Keys   *bson.Document
+	Unique bool
+	// ExpireAfterSeconds is retained for TTL-indexes, but the documents
+	// are not expired.
+	ExpireAfterSeconds int32
+}
+
+// FindOptions defines the options for Store.Find.
+type FindOptions struct {
+	// Sort is the sort-document, such as {hits: -1}.
+	Sort *bson.Document
+	// Projection is an inclusion or exclusion projection,
+	// such as {name: 1}.
+	Projection *bson.Document
+	Skip       int64
+	// Limit is the maximum number of documents returned, 0 is no limit.
+	Limit int64
+}
+
+// FindAndModifyOptions defines the options for Store.FindAndModify.
+type FindAndModifyOptions struct {
+	// Sort determines the document modified if several match the filter.
+	Sort *bson.Document
+	// Update is the document of update-operators, or a replacement-document.
+	// This is ignored if Remove is true.
+	Update *bson.Document
+	Remove bool
+	Upsert bool
+	// ReturnNew returns the updated or upserted document, rather than the
+	// original document.
+	ReturnNew  bool
+	Projection *bson.Document
+}
+
+// UpdateResult is the result of Store.Update.
+type UpdateResult struct {
+	Matched  int64
+	Modified int64
+	// UpsertedID is the _id of upserted document, if any.
+	UpsertedID *bson.Value
+}
+
+// Store holds the databases and their collections in memory.
+// It is safe for concurrent use.
+type Store struct {
+	mutex sync.RWMutex
+	// collections by namespace, such as "database.collection"
+	collections map[string]*collection
+}
+
+type collection struct {
+	docs    []*bson.Document
+	indexes []Index
+}
+
+// NewStore creates an empty Store.
+func NewStore() *Store {
+	return &Store{
+		collections: map[string]*collection{},
+	}
+}
+
+func namespace(database string, name string) string {
+	return database + "." + name
+}
+
+// collection returns the collection with namespace, creating it if
+// required. The mutex must be locked for writing.
+func (s *Store) collection(ns string) *collection {
+	c, exists := s.collections[ns]
+	if !exists {
+		c = &collection{
+			indexes: []Index{{
+				Name:   IDIndexName,
+				Keys:   bson.NewDocument(bson.EC.Int32("_id", 1)),
+				Unique: true,
+			}},
+		}
+		s.collections[ns] = c
+	}
+	return c
+}
+
+// Databases returns the names of databases with at least one collection.
+func (s *Store) Databases() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	databases := map[string]bool{}
+	for ns := range s.collections {
+		databases[ns[:strings.Index(ns, ".")]] = true
+	}
+	names := []string{}
+	for name := range databases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Collections returns the names of collections in database.
+func (s *Store) Collections(database string) []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := []string{}
+	for ns := range s.collections {
+		if strings.HasPrefix(ns, database+".") {
+			names = append(names, ns[len(database)+1:])
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CreateCollection creates the collection if it does not exist.
+func (s *Store) CreateCollection(database string, name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.collection(namespace(database, name))
+}
+
+// DropCollection deletes the collection along with its indexes, and returns
+// false if the collection did not exist.
+func (s *Store) DropCollection(database string, name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ns := namespace(database, name)
+	_, exists := s.collections[ns]
+	delete(s.collections, ns)
+	return exists
+}
+
+// DropDatabase deletes all collections in database.
+func (s *Store) DropDatabase(database string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for ns := range s.collections {
+		if strings.HasPrefix(ns, database+".") {
+			delete(s.collections, ns)
+		}
+	}
+}
+
+// CreateIndex creates the index, or does nothing if an index with same
+// name and keys exists. The unique indexes cannot be created if the
+// existing documents have duplicate keys.
+func (s *Store) CreateIndex(database string, name string, index Index) error {
+	if index.Keys == nil || index.Keys.Len() == 0 {
+		return errors.New("CreateIndex - Index-keys are required")
+	}
+	if index.Name == "" {
+		index.Name = indexName(index.Keys)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ns := namespace(database, name)
+	c := s.collection(ns)
+	for _, existing := range c.indexes {
+		if existing.Name != index.Name {
+			continue
+		}
+		if compareDocuments(existing.Keys, index.Keys) != 0 ||
+			existing.Unique != index.Unique ||
+			existing.ExpireAfterSeconds != index.ExpireAfterSeconds {
+			return fmt.Errorf(
+				"CreateIndex - Index with name: %s exists with different options",
+				index.Name,
+			)
+		}
+		return nil
+	}
+
+	if index.Unique {
+		for i, doc := range c.docs {
+			err := checkIndex(ns, index, c.docs[:i], doc)
+			if err != nil {
+				return errors.Wrap(err, "CreateIndex Error")
+			}
+		}
+	}
+	c.indexes = append(c.indexes, index)
+	return nil
+}
+
+// DropIndex deletes the index with name, and returns false if there is no
+// such index.
+func (s *Store) DropIndex(database string, name string, indexName string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, exists := s.collections[namespace(database, name)]
+	if !exists || indexName == IDIndexName {
+		return false
+	}
+	for i, index := range c.indexes {
+		if index.Name == indexName {
+			c.indexes = append(c.indexes[:i], c.indexes[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Indexes returns the indexes of collection.
+func (s *Store) Indexes(database string, name string) []Index {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	c, exists := s.collections[namespace(database, name)]
+	if !exists {
+		return nil
+	}
+	return append([]Index{}, c.indexes...)
+}
+
+// indexName returns the default index-name, such as "email_1_age_-1".
+func indexName(keys *bson.Document) string {
+	parts := []string{}
+	iter := keys.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		value := fmt.Sprintf("%v", elem.Value().Interface())
+		parts = append(parts, elem.Key(), value)
+	}
+	return strings.Join(parts, "_")
+}
+
+// checkIndex returns a duplicate-key error if the document has same keys
+// as any of the other documents for a unique index. The error-message is
+// same as the server's, so mongo.IsDuplicateKey recognizes it.
+func checkIndex(
+	ns string,
+	index Index,
+	others []*bson.Document,
+	doc *bson.Document,
+) error {
+	if !index.Unique {
+		return nil
+	}
+	key := indexKey(index, doc)
+	for _, other := range others {
+		if other == doc || compareArrays(indexKey(index, other), key) != 0 {
+			continue
+		}
+
+		formatted := []string{}
+		for _, v := range arrayValues(key) {
+			value, err := extjson.MarshalValue(v, false)
+			if err != nil {
+				return err
+			}
+			formatted = append(formatted, ": "+string(value))
+		}
+		return fmt.Errorf(
+			"E11000 duplicate key error collection: %s index: %s dup key: { %s }",
+			ns, index.Name, strings.Join(formatted, ", "),
+		)
+	}
+	return nil
+}
+
+// indexKey returns the values of document's index-keys, with null for
+// the missing fields.
+func indexKey(index Index, doc *bson.Document) *bson.Array {
+	key := bson.NewArray()
+	iter := index.Keys.Iterator()
+	for iter.Next() {
+		v := lookupValue(doc, splitPath(iter.Element().Key()))
+		if v == nil {
+			v = bson.VC.Null()
+		}
+		key.Append(v)
+	}
+	return key
+}
+
+// checkIndexes checks the document against all unique indexes.
+func (c *collection) checkIndexes(ns string, doc *bson.Document) error {
+	for _, index := range c.indexes {
+		err := checkIndex(ns, index, c.docs, doc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Insert inserts copies of the documents, in order, and returns their _ids.
+// The documents without _id are assigned a generated ObjectID. If a document
+// cannot be inserted, the _ids of documents inserted before it are returned
+// along with the error.
+func (s *Store) Insert(
+	database string,
+	name string,
+	docs ...*bson.Document,
+) ([]*bson.Value, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ns := namespace(database, name)
+	c := s.collection(ns)
+	ids := []*bson.Value{}
+	for i, doc := range docs {
+		inserted, err := copyDocument(doc)
+		if err != nil {
+			return ids, errors.Wrapf(err, "Insert - Error Copying Document: %d", i)
+		}
+		if inserted.Lookup("_id") == nil {
+			inserted.Prepend(bson.EC.ObjectID("_id", objectid.New()))
+		}
+		err = c.checkIndexes(ns, inserted)
+		if err != nil {
+			return ids, err
+		}
+		c.docs = append(c.docs, inserted)
+		ids = append(ids, inserted.Lookup("_id"))
+	}
+	return ids, nil
+}
+
+// Find returns copies of the documents matching filter. A nil filter
+// matches all documents.
+func (s *Store) Find(
+	database string,
+	name string,
+	filter *bson.Document,
+	opts FindOptions,
+) ([]*bson.Document, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	c, exists := s.collections[namespace(database, name)]
+	if !exists {
+		return []*bson.Document{}, nil
+	}
+	found, err := c.find(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "Find Error")
+	}
+
+	if opts.Sort != nil {
+		err = sortDocuments(found, opts.Sort)
+		if err != nil {
+			return nil, errors.Wrap(err, "Find - Sort Error")
+		}
+	}
+	if opts.Skip > 0 {
+		if opts.Skip > int64(len(found)) {
+			opts.Skip = int64(len(found))
+		}
+		found = found[opts.Skip:]
+	}
+	if opts.Limit > 0 && opts.Limit < int64(len(found)) {
+		found = found[:opts.Limit]
+	}
+
+	results := make([]*bson.Document, len(found))
+	for i, doc := range found {
+		if opts.Projection != nil {
+			results[i], err = project(doc, opts.Projection)
+		} else {
+			results[i], err = copyDocument(doc)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Find - Projection Error")
+		}
+	}
+	return results, nil
+}
+
+// find returns the stored documents matching filter.
+func (c *collection) find(filter *bson.Document) ([]*bson.Document, error) {
+	found := []*bson.Document{}
+	for _, doc := range c.docs {
+		if filter != nil {
+			isMatch, err := matches(doc, filter)
+			if err != nil {
+				return nil, err
+			}
+			if !isMatch {
+				continue
+			}
+		}
+		found = append(found, doc)
+	}
+	return found, nil
+}
+
+// Update applies the update to the first document matching filter, or
+// to all such documents if multi is true. The update is either a document
+// of update-operators, or a replacement-document. With upsert, a document
+// is inserted if none match the filter.
+func (s *Store) Update(
+	database string,
+	name string,
+	filter *bson.Document,
+	update *bson.Document,
+	multi bool,
+	upsert bool,
+) (*UpdateResult, error) {
+	isReplace, err := isReplacement(update)
+	if err != nil {
+		return nil, errors.Wrap(err, "Update Error")
+	}
+	if isReplace && multi {
+		return nil, errors.New("Update - Replacement cannot be applied to multiple documents")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ns := namespace(database, name)
+	c := s.collection(ns)
+	matched, err := c.find(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "Update Error")
+	}
+	if !multi && len(matched) > 1 {
+		matched = matched[:1]
+	}
+
+	result := &UpdateResult{}
+	if len(matched) == 0 {
+		if !upsert {
+			return result, nil
+		}
+		doc, err := c.upsert(ns, filter, update, isReplace)
+		if err != nil {
+			return nil, errors.Wrap(err, "Update - Upsert Error")
+		}
+		result.UpsertedID = doc.Lookup("_id")
+		return result, nil
+	}
+
+	for _, doc := range matched {
+		result.Matched++
+		updated, err := updatedDocument(doc, update, isReplace)
+		if err != nil {
+			return result, errors.Wrap(err, "Update Error")
+		}
+		if compareDocuments(doc, updated) == 0 {
+			continue
+		}
+		err = c.replace(ns, doc, updated)
+		if err != nil {
+			return result, err
+		}
+		result.Modified++
+	}
+	return result, nil
+}
+
+// FindAndModify updates, replaces, or removes the first document matching
+// filter in the Sort-order, and returns the copy of original document, or of
+// the updated document with ReturnNew. The returned document is nil if none
+// matched, and none was upserted (or ReturnNew is false). The UpdateResult
+// counts the removed document as Matched.
+func (s *Store) FindAndModify(
+	database string,
+	name string,
+	filter *bson.Document,
+	opts FindAndModifyOptions,
+) (*bson.Document, *UpdateResult, error) {
+	isReplace := false
+	if !opts.Remove {
+		if opts.Update == nil {
+			return nil, nil, errors.New("FindAndModify - Update or Remove is required")
+		}
+		var err error
+		isReplace, err = isReplacement(opts.Update)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "FindAndModify Error")
+		}
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ns := namespace(database, name)
+	c := s.collection(ns)
+	matched, err := c.find(filter)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "FindAndModify Error")
+	}
+	if opts.Sort != nil {
+		err = sortDocuments(matched, opts.Sort)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "FindAndModify - Sort Error")
+		}
+	}
+
+	result := &UpdateResult{}
+	var original, returned *bson.Document
+	switch {
+	case len(matched) == 0 && (opts.Remove || !opts.Upsert):
+		return nil, result, nil
+
+	case len(matched) == 0:
+		upserted, err := c.upsert(ns, filter, opts.Update, isReplace)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "FindAndModify - Upsert Error")
+		}
+		result.UpsertedID = upserted.Lookup("_id")
+		returned = upserted
+
+	case opts.Remove:
+		original = matched[0]
+		for i, doc := range c.docs {
+			if doc == original {
+				c.docs = append(c.docs[:i], c.docs[i+1:]...)
+				break
+			}
+		}
+		result.Matched = 1
+
+	default:
+		original = matched[0]
+		result.Matched = 1
+		updated, err := updatedDocument(original, opts.Update, isReplace)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "FindAndModify Error")
+		}
+		returned = original
+		if compareDocuments(original, updated) != 0 {
+			err = c.replace(ns, original, updated)
+			if err != nil {
+				return nil, nil, err
+			}
+			result.Modified = 1
+			returned = updated
+		}
+	}
+
+	if !opts.ReturnNew {
+		returned = original
+	}
+	if returned == nil {
+		return nil, result, nil
+	}
+	if opts.Projection != nil {
+		returned, err = project(returned, opts.Projection)
+	} else {
+		returned, err = copyDocument(returned)
+	}
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "FindAndModify - Projection Error")
+	}
+	return returned, result, nil
+}
+
+// updatedDocument returns the updated copy of document, so the stored
+// document is only changed if the update succeeds.
+func updatedDocument(
+	doc *bson.Document,
+	update *bson.Document,
+	isReplace bool,
+) (*bson.Document, error) {
+	id := doc.Lookup("_id")
+	var updated *bson.Document
+	var err error
+	if isReplace {
+		updated, err = copyDocument(update)
+		if err != nil {
+			return nil, err
+		}
+		if updated.Lookup("_id") == nil {
+			idElem, err := element("_id", id)
+			if err != nil {
+				return nil, err
+			}
+			updated.Prepend(idElem)
+		}
+	} else {
+		updated, err = copyDocument(doc)
+		if err != nil {
+			return nil, err
+		}
+		err = applyUpdate(updated, update, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if compareValues(updated.Lookup("_id"), id) != 0 {
+		return nil, errors.New("the _id field cannot be modified")
+	}
+	return updated, nil
+}
+
+// replace replaces the stored document with updated, if it satisfies the
+// unique indexes.
+func (c *collection) replace(
+	ns string,
+	doc *bson.Document,
+	updated *bson.Document,
+) error {
+	for i, stored := range c.docs {
+		if stored != doc {
+			continue
+		}
+		others := append(append([]*bson.Document{}, c.docs[:i]...), c.docs[i+1:]...)
+		for _, index := range c.indexes {
+			err := checkIndex(ns, index, others, updated)
+			if err != nil {
+				return err
+			}
+		}
+		c.docs[i] = updated
+		return nil
+	}
+	return nil
+}
+
+// upsert inserts the document for an update that matched no documents.
+func (c *collection) upsert(
+	ns string,
+	filter *bson.Document,
+	update *bson.Document,
+	isReplace bool,
+) (*bson.Document, error) {
+	doc := bson.NewDocument()
+	if filter != nil {
+		var err error
+		doc, err = upsertDocument(filter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if isReplace {
+		replacement, err := copyDocument(update)
+		if err != nil {
+			return nil, err
+		}
+		if id := doc.Lookup("_id"); id != nil && replacement.Lookup("_id") == nil {
+			idElem, err := element("_id", id)
+			if err != nil {
+				return nil, err
+			}
+			replacement.Prepend(idElem)
+		}
+		doc = replacement
+	} else {
+		err := applyUpdate(doc, update, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if doc.Lookup("_id") == nil {
+		doc.Prepend(bson.EC.ObjectID("_id", objectid.New()))
+	}
+	err := c.checkIndexes(ns, doc)
+	if err != nil {
+		return nil, err
+	}
+	c.docs = append(c.docs, doc)
+	return doc, nil
+}
+
+// Delete deletes the documents matching filter, and returns the number of
+// documents deleted. A limit of 0 deletes all matching documents.
+func (s *Store) Delete(
+	database string,
+	name string,
+	filter *bson.Document,
+	limit int,
+) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	c, exists := s.collections[namespace(database, name)]
+	if !exists {
+		return 0, nil
+	}
+
+	retained := []*bson.Document{}
+	deleted := int64(0)
+	for _, doc := range c.docs {
+		if limit > 0 && deleted >= int64(limit) {
+			retained = append(retained, doc)
+			continue
+		}
+		isMatch := true
+		if filter != nil {
+			var err error
+			isMatch, err = matches(doc, filter)
+			if err != nil {
+				return 0, errors.Wrap(err, "Delete Error")
+			}
+		}
+		if isMatch {
+			deleted++
+		} else {
+			retained = append(retained, doc)
+		}
+	}
+	c.docs = retained
+	return deleted, nil
+}
+
+// Aggregate runs the aggregation-pipeline on the collection's documents.
+func (s *Store) Aggregate(
+	database string,
+	name string,
+	pipeline []*bson.Document,
+) ([]*bson.Document, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	docs := []*bson.Document{}
+	if c, exists := s.collections[namespace(database, name)]; exists {
+		for _, doc := range c.docs {
+			copied, err := copyDocument(doc)
+			if err != nil {
+				return nil, errors.Wrap(err, "Aggregate Error")
+			}
+			docs = append(docs, copied)
+		}
+	}
+	results, err := aggregate(docs, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "Aggregate Error")
+	}
+	return results, nil
+}
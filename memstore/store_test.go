@@ -0,0 +1,318 @@
+package memstore
+
+import (
+	"github.com/TerrexTech/go-mongoutils/extjson"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Store", func() {
+	var store *Store
+
+	// parse parses the Extended JSON, or YAML flow-style, document.
+	parse := func(doc string) *bson.Document {
+		parsed, err := extjson.ParseDocument([]byte(doc))
+		Expect(err).ToNot(HaveOccurred())
+		return parsed
+	}
+
+	// names returns the "name" of each document.
+	names := func(docs []*bson.Document) []string {
+		names := []string{}
+		for _, doc := range docs {
+			names = append(names, doc.Lookup("name").StringValue())
+		}
+		return names
+	}
+
+	find := func(filter string) []string {
+		found, err := store.Find("db", "users", parse(filter), FindOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		return names(found)
+	}
+
+	BeforeEach(func() {
+		store = NewStore()
+		_, err := store.Insert(
+			"db", "users",
+			parse(`{name: alice, age: 30, tags: [a, b], address: {city: Oslo}}`),
+			parse(`{name: bob, age: 25, tags: [b], address: {city: Bergen}}`),
+			parse(`{name: carol, age: 35.5, roles: [{name: admin, level: 2}]}`),
+		)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("Find", func() {
+		It("should evaluate the query-operators", func() {
+			Expect(find(`{}`)).To(Equal([]string{"alice", "bob", "carol"}))
+			Expect(find(`{age: {$gt: 25, $lt: 35}}`)).To(Equal([]string{"alice"}))
+			Expect(find(`{age: {$gte: 30}}`)).To(Equal([]string{"alice", "carol"}))
+			Expect(find(`{age: {$in: [25, 35.5]}}`)).To(Equal([]string{"bob", "carol"}))
+			Expect(find(`{age: {$nin: [25]}}`)).To(Equal([]string{"alice", "carol"}))
+			Expect(find(`{age: {$ne: 25}}`)).To(Equal([]string{"alice", "carol"}))
+			Expect(find(`{tags: b}`)).To(Equal([]string{"alice", "bob"}))
+			Expect(find(`{tags: [b]}`)).To(Equal([]string{"bob"}))
+			Expect(find(`{tags: {$all: [a, b]}}`)).To(Equal([]string{"alice"}))
+			Expect(find(`{tags: {$size: 1}}`)).To(Equal([]string{"bob"}))
+			Expect(find(`{tags: {$exists: false}}`)).To(Equal([]string{"carol"}))
+			Expect(find(`{tags: null}`)).To(Equal([]string{"carol"}))
+			Expect(find(`{address.city: Oslo}`)).To(Equal([]string{"alice"}))
+			Expect(find(`{roles.name: admin}`)).To(Equal([]string{"carol"}))
+			Expect(find(`{roles: {$elemMatch: {name: admin, level: {$gte: 2}}}}`)).To(
+				Equal([]string{"carol"}),
+			)
+			Expect(find(`{name: {$regex: "^A", $options: i}}`)).To(Equal([]string{"alice"}))
+			Expect(find(`{age: {$not: {$gt: 26}}}`)).To(Equal([]string{"bob"}))
+			Expect(find(`{age: {$type: double}}`)).To(Equal([]string{"carol"}))
+			Expect(find(`{age: {$mod: [4, 2]}}`)).To(Equal([]string{"alice"}))
+			Expect(find(`{$or: [{name: bob}, {age: 30}]}`)).To(Equal([]string{"alice", "bob"}))
+			Expect(find(`{$nor: [{name: bob}, {age: 30}]}`)).To(Equal([]string{"carol"}))
+		})
+
+		It("should not compare values of different types", func() {
+			Expect(find(`{name: {$gt: 1}}`)).To(BeEmpty())
+		})
+
+		It("should return error for unsupported operators", func() {
+			_, err := store.Find("db", "users", parse(`{$where: "true"}`), FindOptions{})
+			Expect(err).To(HaveOccurred())
+			_, err = store.Find("db", "users", parse(`{age: {$near: 1}}`), FindOptions{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should sort, skip, limit, and project the documents", func() {
+			found, err := store.Find("db", "users", nil, FindOptions{
+				Sort:       parse(`{age: -1}`),
+				Skip:       1,
+				Limit:      1,
+				Projection: parse(`{name: 1, _id: 0}`),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(HaveLen(1))
+			Expect(found[0].Len()).To(Equal(1))
+			Expect(found[0].Lookup("name").StringValue()).To(Equal("alice"))
+		})
+
+		It("should return copies of the stored documents", func() {
+			found, err := store.Find("db", "users", parse(`{name: alice}`), FindOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			found[0].Set(bson.EC.String("name", "changed"))
+			Expect(find(`{name: alice}`)).To(HaveLen(1))
+		})
+	})
+
+	Describe("Update", func() {
+		It("should apply the update-operators", func() {
+			result, err := store.Update(
+				"db", "users",
+				parse(`{name: alice}`),
+				parse(`{
+  $set: {address.zip: "0150"},
+  $inc: {age: 1},
+  $push: {tags: {$each: [c, d]}},
+  $addToSet: {roles: a},
+  $unset: {address.city: ""},
+  $rename: {name: fullName}
+}`),
+				false, false,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*result).To(Equal(UpdateResult{Matched: 1, Modified: 1}))
+
+			found, err := store.Find("db", "users", parse(`{fullName: alice}`), FindOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(HaveLen(1))
+			doc := found[0]
+			Expect(doc.Lookup("age").Int32()).To(Equal(int32(31)))
+			Expect(doc.Lookup("address", "zip").StringValue()).To(Equal("0150"))
+			Expect(doc.Lookup("address", "city")).To(BeNil())
+			Expect(doc.Lookup("tags").MutableArray().Len()).To(Equal(4))
+			Expect(doc.Lookup("roles").MutableArray().Len()).To(Equal(1))
+		})
+
+		It("should update multiple documents, and count the modified ones", func() {
+			result, err := store.Update(
+				"db", "users",
+				parse(`{age: {$lte: 30}}`),
+				parse(`{$set: {age: 30}}`),
+				true, false,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*result).To(Equal(UpdateResult{Matched: 2, Modified: 1}))
+		})
+
+		It("should upsert using the filter's equality-conditions", func() {
+			result, err := store.Update(
+				"db", "users",
+				parse(`{name: dave, age: {$gt: 10}}`),
+				parse(`{$set: {active: true}, $setOnInsert: {age: 40}}`),
+				false, true,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Matched).To(BeZero())
+			Expect(result.UpsertedID).ToNot(BeNil())
+
+			found, err := store.Find("db", "users", parse(`{name: dave}`), FindOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found).To(HaveLen(1))
+			Expect(found[0].Lookup("active").Boolean()).To(BeTrue())
+			Expect(found[0].Lookup("age").Int32()).To(Equal(int32(40)))
+		})
+
+		It("should replace the document, retaining its _id", func() {
+			before, err := store.Find("db", "users", parse(`{name: bob}`), FindOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = store.Update(
+				"db", "users", parse(`{name: bob}`), parse(`{name: robert}`), false, false,
+			)
+			Expect(err).ToNot(HaveOccurred())
+			after, err := store.Find("db", "users", parse(`{name: robert}`), FindOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(after).To(HaveLen(1))
+			Expect(after[0].Lookup("age")).To(BeNil())
+			Expect(after[0].Lookup("_id").Interface()).To(
+				Equal(before[0].Lookup("_id").Interface()),
+			)
+		})
+
+		It("should not modify the _id", func() {
+			_, err := store.Update(
+				"db", "users",
+				parse(`{name: bob}`),
+				parse(`{$set: {_id: 1}}`),
+				false, false,
+			)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Unique indexes", func() {
+		It("should reject the duplicate keys on insert and update", func() {
+			err := store.CreateIndex("db", "users", Index{
+				Keys:   parse(`{name: 1}`),
+				Unique: true,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = store.Insert("db", "users", parse(`{name: alice}`))
+			dupErr, isDuplicate := mongo.IsDuplicateKey(err)
+			Expect(isDuplicate).To(BeTrue())
+			Expect(dupErr.Index).To(Equal("name_1"))
+			Expect(dupErr.Key).To(Equal(`{ : "alice" }`))
+
+			_, err = store.Update(
+				"db", "users", parse(`{name: bob}`), parse(`{$set: {name: alice}}`),
+				false, false,
+			)
+			_, isDuplicate = mongo.IsDuplicateKey(err)
+			Expect(isDuplicate).To(BeTrue())
+			Expect(find(`{name: bob}`)).To(HaveLen(1))
+		})
+
+		It("should not create unique indexes on duplicate keys", func() {
+			// The missing fields are indexed as null
+			err := store.CreateIndex("db", "users", Index{
+				Keys:   parse(`{missing: 1}`),
+				Unique: true,
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("FindAndModify", func() {
+		It("should update the first document in sort-order", func() {
+			doc, result, err := store.FindAndModify(
+				"db", "users", parse(`{age: {$lt: 40}}`), FindAndModifyOptions{
+					Sort:   parse(`{age: 1}`),
+					Update: parse(`{$inc: {age: 1}}`),
+				},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Matched).To(Equal(int64(1)))
+			// The original document is returned, unless ReturnNew is set
+			Expect(doc.Lookup("name").StringValue()).To(Equal("bob"))
+			Expect(doc.Lookup("age").Int32()).To(Equal(int32(25)))
+			Expect(find(`{age: 26}`)).To(Equal([]string{"bob"}))
+		})
+
+		It("should return the upserted document with ReturnNew", func() {
+			doc, result, err := store.FindAndModify(
+				"db", "users", parse(`{name: dave}`), FindAndModifyOptions{
+					Update:    parse(`{$set: {age: 40}}`),
+					Upsert:    true,
+					ReturnNew: true,
+				},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Matched).To(Equal(int64(0)))
+			Expect(result.UpsertedID).ToNot(BeNil())
+			Expect(doc.Lookup("name").StringValue()).To(Equal("dave"))
+		})
+
+		It("should remove the document", func() {
+			doc, result, err := store.FindAndModify(
+				"db", "users", parse(`{name: alice}`), FindAndModifyOptions{
+					Remove:     true,
+					Projection: parse(`{name: 1, _id: 0}`),
+				},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Matched).To(Equal(int64(1)))
+			Expect(doc.Len()).To(Equal(1))
+			Expect(find(`{}`)).To(Equal([]string{"bob", "carol"}))
+		})
+	})
+
+	Describe("Delete", func() {
+		It("should delete the matching documents, up to limit", func() {
+			deleted, err := store.Delete("db", "users", parse(`{age: {$gte: 30}}`), 1)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deleted).To(Equal(int64(1)))
+			Expect(find(`{}`)).To(Equal([]string{"bob", "carol"}))
+		})
+	})
+
+	Describe("Aggregate", func() {
+		It("should run the pipeline-stages", func() {
+			pipeline := []*bson.Document{
+				parse(`{$unwind: "$tags"}`),
+				parse(`{$group: {
+  _id: "$tags",
+  count: {$sum: 1},
+  avgAge: {$avg: "$age"},
+  names: {$push: "$name"}
+}}`),
+				parse(`{$sort: {_id: 1}}`),
+				parse(`{$project: {tag: "$_id", count: 1, names: 1, _id: 0}}`),
+			}
+			results, err := store.Aggregate("db", "users", pipeline)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(HaveLen(2))
+
+			b := results[1]
+			Expect(b.Lookup("_id")).To(BeNil())
+			Expect(b.Lookup("tag").StringValue()).To(Equal("b"))
+			Expect(b.Lookup("count").Int32()).To(Equal(int32(2)))
+			Expect(b.Lookup("names").MutableArray().Len()).To(Equal(2))
+		})
+
+		It("should count the matching documents", func() {
+			results, err := store.Aggregate("db", "users", []*bson.Document{
+				parse(`{$match: {age: {$gt: 26}}}`),
+				parse(`{$count: total}`),
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results[0].Lookup("total").Int32()).To(Equal(int32(2)))
+		})
+
+		It("should return error for unsupported stages", func() {
+			_, err := store.Aggregate("db", "users", []*bson.Document{
+				parse(`{$lookup: {from: posts}}`),
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,290 @@
+package memstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// isReplacement checks if the update is a replacement-document, rather than
+// a document of update-operators.
+func isReplacement(update *bson.Document) (bool, error) {
+	operators := 0
+	iter := update.Iterator()
+	for iter.Next() {
+		if strings.HasPrefix(iter.Element().Key(), "$") {
+			operators++
+		}
+	}
+	if iter.Err() != nil {
+		return false, iter.Err()
+	}
+	if operators > 0 && operators < update.Len() {
+		return false, errors.New(
+			"update cannot mix update-operators and replacement-fields",
+		)
+	}
+	return operators == 0, nil
+}
+
+// applyUpdate applies the update-operators to the document. The
+// $setOnInsert is only applied if the update results in an insert.
+// See: https://docs.mongodb.com/manual/reference/operator/update/
+func applyUpdate(doc *bson.Document, update *bson.Document, isInsert bool) error {
+	iter := update.Iterator()
+	for iter.Next() {
+		op := iter.Element().Key()
+		fields := iter.Element().Value()
+		if fields.Type() != bson.TypeEmbeddedDocument {
+			return fmt.Errorf("%s requires a document", op)
+		}
+		if op == "$setOnInsert" && !isInsert {
+			continue
+		}
+
+		fieldIter := fields.MutableDocument().Iterator()
+		for fieldIter.Next() {
+			field := fieldIter.Element()
+			err := applyOperator(doc, op, splitPath(field.Key()), field.Value())
+			if err != nil {
+				return errors.Wrapf(err, "%s %s", op, field.Key())
+			}
+		}
+		if fieldIter.Err() != nil {
+			return fieldIter.Err()
+		}
+	}
+	return iter.Err()
+}
+
+func applyOperator(
+	doc *bson.Document,
+	op string,
+	path []string,
+	arg *bson.Value,
+) error {
+	for _, key := range path {
+		if key == "$" || strings.HasPrefix(key, "$[") {
+			return errors.New("positional updates are not supported")
+		}
+	}
+	current := lookupValue(doc, path)
+
+	switch op {
+	case "$set", "$setOnInsert":
+		return setValue(doc, path, arg)
+	case "$unset":
+		deleteValue(doc, path)
+		return nil
+	case "$inc", "$mul":
+		if !isNumber(arg) {
+			return errors.New("argument must be a number")
+		}
+		if current == nil {
+			if op == "$mul" {
+				// Multiplying a missing field sets it to zero of same type
+				zero, err := multiplyNumbers(arg, bson.VC.Int32(0))
+				if err != nil {
+					return err
+				}
+				return setValue(doc, path, zero)
+			}
+			return setValue(doc, path, arg)
+		}
+		var result *bson.Value
+		var err error
+		if op == "$inc" {
+			result, err = addNumbers(current, arg)
+		} else {
+			result, err = multiplyNumbers(current, arg)
+		}
+		if err != nil {
+			return err
+		}
+		return setValue(doc, path, result)
+	case "$min", "$max":
+		cmp := compareValues(arg, current)
+		if current == nil || (op == "$min" && cmp < 0) || (op == "$max" && cmp > 0) {
+			return setValue(doc, path, arg)
+		}
+		return nil
+	case "$rename":
+		if arg.Type() != bson.TypeString {
+			return errors.New("the new name must be a string")
+		}
+		if current == nil {
+			return nil
+		}
+		renamed, err := copyValue(current)
+		if err != nil {
+			return err
+		}
+		deleteValue(doc, path)
+		return setValue(doc, splitPath(arg.StringValue()), renamed)
+	case "$currentDate":
+		now := time.Now()
+		if arg.Type() == bson.TypeEmbeddedDocument {
+			t := arg.MutableDocument().Lookup("$type")
+			if t != nil && t.Type() == bson.TypeString && t.StringValue() == "timestamp" {
+				return setValue(doc, path, bson.VC.Timestamp(uint32(now.Unix()), 1))
+			}
+		}
+		return setValue(doc, path, bson.VC.DateTime(now.UnixNano()/int64(time.Millisecond)))
+	case "$push", "$addToSet":
+		return applyPush(doc, op, path, current, arg)
+	case "$pull":
+		return applyPull(doc, path, current, arg)
+	case "$pop":
+		if current == nil {
+			return nil
+		}
+		if current.Type() != bson.TypeArray {
+			return errors.New("the field must be an array")
+		}
+		elems := arrayValues(current.MutableArray())
+		if len(elems) == 0 {
+			return nil
+		}
+		if floatValue(arg) < 0 {
+			elems = elems[1:]
+		} else {
+			elems = elems[:len(elems)-1]
+		}
+		return setValue(doc, path, bson.VC.Array(bson.NewArray(elems...)))
+	}
+	return fmt.Errorf("unsupported update-operator: %s", op)
+}
+
+// applyPush appends the argument, or the values of its $each, to an array.
+// The $addToSet only appends the values not present in the array.
+func applyPush(
+	doc *bson.Document,
+	op string,
+	path []string,
+	current *bson.Value,
+	arg *bson.Value,
+) error {
+	values := []*bson.Value{arg}
+	if arg.Type() == bson.TypeEmbeddedDocument {
+		if each := arg.MutableDocument().Lookup("$each"); each != nil {
+			if each.Type() != bson.TypeArray {
+				return errors.New("$each requires an array")
+			}
+			if arg.MutableDocument().Len() > 1 {
+				return errors.New("only $each is supported as modifier")
+			}
+			values = arrayValues(each.MutableArray())
+		}
+	}
+
+	elems := []*bson.Value{}
+	if current != nil {
+		if current.Type() != bson.TypeArray {
+			return errors.New("the field must be an array")
+		}
+		elems = arrayValues(current.MutableArray())
+	}
+	for _, v := range values {
+		if op == "$addToSet" {
+			exists := false
+			for _, elem := range elems {
+				if compareValues(elem, v) == 0 {
+					exists = true
+					break
+				}
+			}
+			if exists {
+				continue
+			}
+		}
+		elems = append(elems, v)
+	}
+	return setValue(doc, path, bson.VC.Array(bson.NewArray(elems...)))
+}
+
+// applyPull removes the array-elements that equal the argument, or that
+// match the argument's condition.
+func applyPull(
+	doc *bson.Document,
+	path []string,
+	current *bson.Value,
+	arg *bson.Value,
+) error {
+	if current == nil {
+		return nil
+	}
+	if current.Type() != bson.TypeArray {
+		return errors.New("the field must be an array")
+	}
+
+	elems := []*bson.Value{}
+	for _, elem := range arrayValues(current.MutableArray()) {
+		var isMatch bool
+		var err error
+		switch {
+		case isOperatorDocument(arg):
+			isMatch, err = matchField([]*bson.Value{elem}, arg)
+		case arg.Type() == bson.TypeEmbeddedDocument &&
+			elem.Type() == bson.TypeEmbeddedDocument:
+			isMatch, err = matches(elem.MutableDocument(), arg.MutableDocument())
+		default:
+			isMatch = compareValues(elem, arg) == 0
+		}
+		if err != nil {
+			return err
+		}
+		if !isMatch {
+			elems = append(elems, elem)
+		}
+	}
+	return setValue(doc, path, bson.VC.Array(bson.NewArray(elems...)))
+}
+
+// upsertDocument creates the document to insert for an upsert, from the
+// equality-conditions of filter.
+func upsertDocument(filter *bson.Document) (*bson.Document, error) {
+	doc := bson.NewDocument()
+	iter := filter.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		key := elem.Key()
+		v := elem.Value()
+		if key == "$and" && v.Type() == bson.TypeArray {
+			for _, clause := range arrayValues(v.MutableArray()) {
+				if clause.Type() != bson.TypeEmbeddedDocument {
+					continue
+				}
+				nested, err := upsertDocument(clause.MutableDocument())
+				if err != nil {
+					return nil, err
+				}
+				nestedIter := nested.Iterator()
+				for nestedIter.Next() {
+					nestedElem := nestedIter.Element()
+					err = setValue(doc, []string{nestedElem.Key()}, nestedElem.Value())
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			continue
+		}
+		if strings.HasPrefix(key, "$") {
+			continue
+		}
+		if isOperatorDocument(v) {
+			v = v.MutableDocument().Lookup("$eq")
+			if v == nil {
+				continue
+			}
+		}
+		err := setValue(doc, splitPath(key), v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, iter.Err()
+}
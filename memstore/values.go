@@ -0,0 +1,473 @@
+package memstore
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+)
+
+// typeOrder returns the order of BSON-types when comparing the values of
+// different types, as done by MongoDB. The numbers are compared with each
+// other regardless of their type.
+// See: https://docs.mongodb.com/manual/reference/bson-type-comparison-order/
+func typeOrder(t bson.Type) int {
+	switch t {
+	case bson.TypeMinKey:
+		return 1
+	case bson.TypeNull, bson.TypeUndefined:
+		return 2
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+		return 3
+	case bson.TypeString:
+		return 4
+	case bson.TypeEmbeddedDocument:
+		return 5
+	case bson.TypeArray:
+		return 6
+	case bson.TypeBinary:
+		return 7
+	case bson.TypeObjectID:
+		return 8
+	case bson.TypeBoolean:
+		return 9
+	case bson.TypeDateTime:
+		return 10
+	case bson.TypeTimestamp:
+		return 11
+	case bson.TypeRegex:
+		return 12
+	case bson.TypeMaxKey:
+		return 14
+	}
+	return 13
+}
+
+// compareValues returns -1, 0, or 1 if a is less than, equal to, or
+// greater than b. The nil values are compared as null.
+func compareValues(a *bson.Value, b *bson.Value) int {
+	if a == nil {
+		a = bson.VC.Null()
+	}
+	if b == nil {
+		b = bson.VC.Null()
+	}
+	orderA, orderB := typeOrder(a.Type()), typeOrder(b.Type())
+	if orderA != orderB {
+		return compareInts(int64(orderA), int64(orderB))
+	}
+
+	switch a.Type() {
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble, bson.TypeDecimal128:
+		return compareNumbers(a, b)
+	case bson.TypeString:
+		return strings.Compare(a.StringValue(), b.StringValue())
+	case bson.TypeEmbeddedDocument:
+		return compareDocuments(a.MutableDocument(), b.MutableDocument())
+	case bson.TypeArray:
+		return compareArrays(a.MutableArray(), b.MutableArray())
+	case bson.TypeBinary:
+		subtypeA, dataA := a.Binary()
+		subtypeB, dataB := b.Binary()
+		if len(dataA) != len(dataB) {
+			return compareInts(int64(len(dataA)), int64(len(dataB)))
+		}
+		if subtypeA != subtypeB {
+			return compareInts(int64(subtypeA), int64(subtypeB))
+		}
+		return bytes.Compare(dataA, dataB)
+	case bson.TypeObjectID:
+		oidA, oidB := a.ObjectID(), b.ObjectID()
+		return bytes.Compare(oidA[:], oidB[:])
+	case bson.TypeBoolean:
+		if a.Boolean() == b.Boolean() {
+			return 0
+		}
+		if b.Boolean() {
+			return -1
+		}
+		return 1
+	case bson.TypeDateTime:
+		return compareInts(a.DateTime(), b.DateTime())
+	case bson.TypeTimestamp:
+		tA, iA := a.Timestamp()
+		tB, iB := b.Timestamp()
+		if tA != tB {
+			return compareInts(int64(tA), int64(tB))
+		}
+		return compareInts(int64(iA), int64(iB))
+	case bson.TypeRegex:
+		patternA, optionsA := a.Regex()
+		patternB, optionsB := b.Regex()
+		if patternA != patternB {
+			return strings.Compare(patternA, patternB)
+		}
+		return strings.Compare(optionsA, optionsB)
+	}
+	return 0
+}
+
+func compareInts(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareNumbers compares the integers exactly, and the rest of numbers
+// as doubles.
+func compareNumbers(a *bson.Value, b *bson.Value) int {
+	intA, isIntA := intValue(a)
+	intB, isIntB := intValue(b)
+	if isIntA && isIntB {
+		return compareInts(intA, intB)
+	}
+	floatA, floatB := floatValue(a), floatValue(b)
+	switch {
+	case floatA < floatB:
+		return -1
+	case floatA > floatB:
+		return 1
+	case floatA == floatB:
+		return 0
+	}
+	// NaN is less than all other numbers
+	if math.IsNaN(floatA) && math.IsNaN(floatB) {
+		return 0
+	}
+	if math.IsNaN(floatA) {
+		return -1
+	}
+	return 1
+}
+
+func compareDocuments(a *bson.Document, b *bson.Document) int {
+	for i := 0; i < a.Len() && i < b.Len(); i++ {
+		elemA, elemB := a.ElementAt(uint(i)), b.ElementAt(uint(i))
+		cmp := compareInts(
+			int64(typeOrder(elemA.Value().Type())),
+			int64(typeOrder(elemB.Value().Type())),
+		)
+		if cmp == 0 {
+			cmp = strings.Compare(elemA.Key(), elemB.Key())
+		}
+		if cmp == 0 {
+			cmp = compareValues(elemA.Value(), elemB.Value())
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return compareInts(int64(a.Len()), int64(b.Len()))
+}
+
+func compareArrays(a *bson.Array, b *bson.Array) int {
+	valuesA, valuesB := arrayValues(a), arrayValues(b)
+	for i := 0; i < len(valuesA) && i < len(valuesB); i++ {
+		cmp := compareValues(valuesA[i], valuesB[i])
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return compareInts(int64(len(valuesA)), int64(len(valuesB)))
+}
+
+// arrayValues returns the values of array as slice.
+func arrayValues(arr *bson.Array) []*bson.Value {
+	values := make([]*bson.Value, 0, arr.Len())
+	for i := 0; i < arr.Len(); i++ {
+		v, err := arr.Lookup(uint(i))
+		if err == nil {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func isNumber(v *bson.Value) bool {
+	return v != nil && typeOrder(v.Type()) == typeOrder(bson.TypeInt32)
+}
+
+func intValue(v *bson.Value) (int64, bool) {
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), true
+	case bson.TypeInt64:
+		return v.Int64(), true
+	}
+	return 0, false
+}
+
+func floatValue(v *bson.Value) float64 {
+	switch v.Type() {
+	case bson.TypeInt32:
+		return float64(v.Int32())
+	case bson.TypeInt64:
+		return float64(v.Int64())
+	case bson.TypeDouble:
+		return v.Double()
+	case bson.TypeDecimal128:
+		f, err := strconv.ParseFloat(v.Decimal128().String(), 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	}
+	return math.NaN()
+}
+
+// isTruthy returns the boolean value of a flag such as {$exists: 1}.
+func isTruthy(v *bson.Value) bool {
+	switch v.Type() {
+	case bson.TypeBoolean:
+		return v.Boolean()
+	case bson.TypeNull, bson.TypeUndefined:
+		return false
+	}
+	if isNumber(v) {
+		return floatValue(v) != 0
+	}
+	return true
+}
+
+// addNumbers adds the numbers, retaining the widest type as done by
+// $inc. The int32 results are widened to int64 on overflow.
+func addNumbers(a *bson.Value, b *bson.Value) (*bson.Value, error) {
+	return arithmetic(a, b, func(x, y int64) (int64, bool) {
+		sum := x + y
+		return sum, (sum > x) == (y > 0)
+	}, func(x, y float64) float64 {
+		return x + y
+	})
+}
+
+// multiplyNumbers multiplies the numbers, as done by $mul.
+func multiplyNumbers(a *bson.Value, b *bson.Value) (*bson.Value, error) {
+	return arithmetic(a, b, func(x, y int64) (int64, bool) {
+		product := x * y
+		return product, x == 0 || (product/x == y && !(x == -1 && y == math.MinInt64))
+	}, func(x, y float64) float64 {
+		return x * y
+	})
+}
+
+func arithmetic(
+	a *bson.Value,
+	b *bson.Value,
+	intOp func(x, y int64) (int64, bool),
+	floatOp func(x, y float64) float64,
+) (*bson.Value, error) {
+	if !isNumber(a) || !isNumber(b) {
+		return nil, fmt.Errorf("cannot apply arithmetic to non-numeric values")
+	}
+	if a.Type() == bson.TypeDecimal128 || b.Type() == bson.TypeDecimal128 {
+		return nil, fmt.Errorf("arithmetic on decimals is not supported")
+	}
+
+	intA, isIntA := intValue(a)
+	intB, isIntB := intValue(b)
+	if !isIntA || !isIntB {
+		return bson.VC.Double(floatOp(floatValue(a), floatValue(b))), nil
+	}
+	result, ok := intOp(intA, intB)
+	if !ok {
+		return nil, fmt.Errorf("integer overflow")
+	}
+	isInt32 := a.Type() == bson.TypeInt32 && b.Type() == bson.TypeInt32
+	if isInt32 && int64(int32(result)) == result {
+		return bson.VC.Int32(int32(result)), nil
+	}
+	return bson.VC.Int64(result), nil
+}
+
+// element creates an element with a copy of the value.
+func element(key string, v *bson.Value) (*bson.Element, error) {
+	switch v.Type() {
+	case bson.TypeDouble:
+		return bson.EC.Double(key, v.Double()), nil
+	case bson.TypeString:
+		return bson.EC.String(key, v.StringValue()), nil
+	case bson.TypeEmbeddedDocument:
+		doc, err := copyDocument(v.MutableDocument())
+		if err != nil {
+			return nil, err
+		}
+		return bson.EC.SubDocument(key, doc), nil
+	case bson.TypeArray:
+		arr, err := copyArray(v.MutableArray())
+		if err != nil {
+			return nil, err
+		}
+		return bson.EC.Array(key, arr), nil
+	case bson.TypeBinary:
+		subtype, data := v.Binary()
+		return bson.EC.BinaryWithSubtype(key, data, subtype), nil
+	case bson.TypeUndefined:
+		return bson.EC.Undefined(key), nil
+	case bson.TypeObjectID:
+		return bson.EC.ObjectID(key, v.ObjectID()), nil
+	case bson.TypeBoolean:
+		return bson.EC.Boolean(key, v.Boolean()), nil
+	case bson.TypeDateTime:
+		return bson.EC.DateTime(key, v.DateTime()), nil
+	case bson.TypeNull:
+		return bson.EC.Null(key), nil
+	case bson.TypeRegex:
+		pattern, options := v.Regex()
+		return bson.EC.Regex(key, pattern, options), nil
+	case bson.TypeInt32:
+		return bson.EC.Int32(key, v.Int32()), nil
+	case bson.TypeTimestamp:
+		t, i := v.Timestamp()
+		return bson.EC.Timestamp(key, t, i), nil
+	case bson.TypeInt64:
+		return bson.EC.Int64(key, v.Int64()), nil
+	case bson.TypeDecimal128:
+		return bson.EC.Decimal128(key, v.Decimal128()), nil
+	case bson.TypeMinKey:
+		return bson.EC.MinKey(key), nil
+	case bson.TypeMaxKey:
+		return bson.EC.MaxKey(key), nil
+	}
+	return nil, fmt.Errorf("unsupported BSON-type: %s", v.Type())
+}
+
+// copyValue returns a deep copy of the value.
+func copyValue(v *bson.Value) (*bson.Value, error) {
+	elem, err := element("", v)
+	if err != nil {
+		return nil, err
+	}
+	return elem.Value(), nil
+}
+
+// copyDocument returns a deep copy of the document, so the stored
+// documents cannot be modified by callers.
+func copyDocument(doc *bson.Document) (*bson.Document, error) {
+	docBytes, err := doc.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+	return bson.ReadDocument(docBytes)
+}
+
+func copyArray(arr *bson.Array) (*bson.Array, error) {
+	values := []*bson.Value{}
+	for _, v := range arrayValues(arr) {
+		copied, err := copyValue(v)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, copied)
+	}
+	return bson.NewArray(values...), nil
+}
+
+// splitPath splits a dotted path, such as "address.city".
+func splitPath(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// lookupValues returns the values at path. The arrays along the path are
+// traversed, so "tags.name" returns the names from all documents in the
+// array "tags", and "tags.0" returns the first element.
+func lookupValues(doc *bson.Document, path []string) []*bson.Value {
+	v := doc.Lookup(path[0])
+	if v == nil {
+		return nil
+	}
+	if len(path) == 1 {
+		return []*bson.Value{v}
+	}
+
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		return lookupValues(v.MutableDocument(), path[1:])
+	case bson.TypeArray:
+		elems := arrayValues(v.MutableArray())
+		if index, err := strconv.Atoi(path[1]); err == nil {
+			if index < 0 || index >= len(elems) {
+				return nil
+			}
+			if len(path) == 2 {
+				return []*bson.Value{elems[index]}
+			}
+			if elems[index].Type() != bson.TypeEmbeddedDocument {
+				return nil
+			}
+			return lookupValues(elems[index].MutableDocument(), path[2:])
+		}
+
+		values := []*bson.Value{}
+		for _, elem := range elems {
+			if elem.Type() == bson.TypeEmbeddedDocument {
+				values = append(values, lookupValues(elem.MutableDocument(), path[1:])...)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// lookupValue returns the value at path through the nested documents,
+// or nil if there is no such value.
+func lookupValue(doc *bson.Document, path []string) *bson.Value {
+	for i, key := range path {
+		v := doc.Lookup(key)
+		if v == nil {
+			return nil
+		}
+		if i == len(path)-1 {
+			return v
+		}
+		if v.Type() != bson.TypeEmbeddedDocument {
+			return nil
+		}
+		doc = v.MutableDocument()
+	}
+	return nil
+}
+
+// setValue sets the value at path, creating the nested documents
+// as required.
+func setValue(doc *bson.Document, path []string, v *bson.Value) error {
+	for _, key := range path[:len(path)-1] {
+		nested := doc.Lookup(key)
+		if nested == nil {
+			doc.Append(bson.EC.SubDocument(key, bson.NewDocument()))
+			nested = doc.Lookup(key)
+		}
+		if nested.Type() != bson.TypeEmbeddedDocument {
+			return fmt.Errorf(
+				"cannot set %s: %s is not a document",
+				strings.Join(path, "."), key,
+			)
+		}
+		doc = nested.MutableDocument()
+	}
+	elem, err := element(path[len(path)-1], v)
+	if err != nil {
+		return err
+	}
+	doc.Set(elem)
+	return nil
+}
+
+// deleteValue deletes the value at path, if it exists.
+func deleteValue(doc *bson.Document, path []string) {
+	parent := doc
+	if len(path) > 1 {
+		v := lookupValue(doc, path[:len(path)-1])
+		if v == nil || v.Type() != bson.TypeEmbeddedDocument {
+			return
+		}
+		parent = v.MutableDocument()
+	}
+	parent.Delete(path[len(path)-1])
+}
@@ -0,0 +1,103 @@
+package migrate
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RunCommand runs the command from command-line arguments, so services
+// can expose their Migrations as a CLI, such as:
+//
+//	func main() {
+//		migrator, err := migrate.NewMigrator(config)
+//		...
+//		err = migrator.RunCommand(context.Background(), os.Args[1:], os.Stdout)
+//	}
+//
+// The commands are:
+//
+//	status                      Show the status of all Migrations
+//	up [-steps N] [-dry-run]    Run the pending Migrations
+//	down [-steps N] [-dry-run]  Roll back the latest Migrations, 1 by default
+//	redo [-steps N] [-dry-run]  Roll back and re-run the latest Migrations
+func (m *Migrator) RunCommand(
+	ctx context.Context,
+	args []string,
+	stdout io.Writer,
+) error {
+	if len(args) == 0 {
+		return errors.New("RunCommand - Command is required: status, up, down, or redo")
+	}
+
+	name := args[0]
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stdout)
+	opts := RunOptions{
+		OnRun: func(migration Migration) {
+			fmt.Fprintf(stdout, "%s %s\n", name, migration)
+		},
+	}
+	if name != "status" {
+		flags.IntVar(&opts.Steps, "steps", 0, "Number of Migrations to run")
+		flags.BoolVar(&opts.DryRun, "dry-run", false, "List the Migrations without running")
+	}
+	err := flags.Parse(args[1:])
+	if err != nil {
+		return err
+	}
+
+	var run func(context.Context, RunOptions) ([]Migration, error)
+	switch name {
+	case "status":
+		return m.printStatus(stdout)
+	case "up":
+		run = m.Up
+	case "down":
+		run = m.Down
+	case "redo":
+		run = m.Redo
+	default:
+		return fmt.Errorf("RunCommand - Unknown Command: %s", name)
+	}
+
+	migrations, err := run(ctx, opts)
+	if opts.DryRun {
+		for _, migration := range migrations {
+			fmt.Fprintf(stdout, "%s %s (dry-run)\n", name, migration)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		fmt.Fprintln(stdout, "No Migrations to run.")
+	}
+	return nil
+}
+
+func (m *Migrator) printStatus(stdout io.Writer) error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tSTATUS")
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied " + status.AppliedAt.Format(time.RFC3339)
+		}
+		if status.Unknown {
+			state += " (unknown)"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Description, state)
+	}
+	return w.Flush()
+}
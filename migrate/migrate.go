@@ -0,0 +1,426 @@
+// Package migrate runs versioned schema-migrations, such as backfilling
+// fields, renaming keys, and building indexes.
+//
+// The Migrations are Go functions ordered by their versions. The applied
+// Migrations are recorded in a collection, so each Migration runs once.
+// A distributed lock (see the lock-package) ensures that only a single
+// process, such as one of the service's replicas, runs the Migrations.
+//
+// A Migration is recorded after its function completes, so a Migration
+// that fails midway is run again from start. Hence the Migrations should
+// be idempotent where possible.
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/lock"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/pkg/errors"
+)
+
+// DefaultCollection is the collection used for recording the applied
+// Migrations when Config.Collection is not specified.
+const DefaultCollection = "migrations"
+
+// ErrNoDown is returned when rolling back a Migration without Down-function.
+var ErrNoDown = errors.New("Migration cannot be rolled back without Down")
+
+// Func is a migration-function, which runs on the Migrator's database.
+type Func func(ctx context.Context, db *mgo.Database) error
+
+// Migration is a versioned change to database.
+type Migration struct {
+	// Version orders the Migrations, and must be unique and positive.
+	// Timestamps, such as 20181018120000, avoid conflicts between Migrations
+	// written concurrently.
+	Version     int64
+	Description string
+	Up          Func
+	// Down reverts the changes made by Up. This is optional, but required
+	// for rolling back the Migration.
+	Down Func
+}
+
+// String describes the Migration, such as: "20181018120000 add-index".
+func (m Migration) String() string {
+	return fmt.Sprintf("%d %s", m.Version, m.Description)
+}
+
+// record is the document stored for every applied Migration.
+type record struct {
+	ID          objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Version     int64             `bson:"version" json:"version"`
+	Description string            `bson:"description" json:"description"`
+	AppliedAt   time.Time         `bson:"appliedAt" json:"appliedAt"`
+}
+
+// Config defines the configuration for a Migrator.
+type Config struct {
+	Connection *mongo.ConnectionConfig
+	// Database to run the Migrations on, and to record them in.
+	Database string
+	// Collection to record the applied Migrations in.
+	// Defaults to DefaultCollection.
+	Collection string
+	Migrations []Migration
+
+	// LockLease is the lease for the migration-lock, which is renewed while
+	// the Migrations run. Defaults to 1 minute.
+	LockLease time.Duration
+	// LockWait is the time to wait for another process to finish running
+	// the Migrations. Defaults to 0, which returns lock.ErrLockHeld
+	// immediately if another process holds the lock.
+	LockWait time.Duration
+	// Owner identifies this process as the lock-owner.
+	// Defaults to lock.NewOwnerID.
+	Owner string
+}
+
+// RunOptions defines the options for running Migrations.
+type RunOptions struct {
+	// Steps is the number of Migrations to run. Defaults to all the pending
+	// Migrations for Up, and to 1 for Down.
+	Steps int
+	// DryRun returns the Migrations that would be run, without running them.
+	DryRun bool
+	// OnRun is called before running each Migration, such as for logging.
+	OnRun func(m Migration)
+}
+
+// Status is the status of a Migration.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Unknown indicates an applied Migration that is not in
+	// Config.Migrations, such as when its code was removed.
+	Unknown bool
+}
+
+// Migrator runs the Migrations.
+type Migrator struct {
+	config     Config
+	collection *mongo.Collection
+	locker     *lock.Locker
+}
+
+// NewMigrator creates a new Migrator, and ensures the collection for
+// recording Migrations along with its indexes.
+func NewMigrator(config Config) (*Migrator, error) {
+	if config.Connection == nil {
+		return nil, errors.New("Config.Connection cannot be nil")
+	}
+	if config.Database == "" {
+		return nil, errors.New("Config.Database cannot be blank")
+	}
+	if config.Collection == "" {
+		config.Collection = DefaultCollection
+	}
+	if config.LockLease <= 0 {
+		config.LockLease = time.Minute
+	}
+	if config.Owner == "" {
+		config.Owner = lock.NewOwnerID()
+	}
+
+	migrations := append([]Migration{}, config.Migrations...)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("Migration %s: Version must be positive", m)
+		}
+		if i > 0 && migrations[i-1].Version == m.Version {
+			return nil, fmt.Errorf("Migration %s: Version is duplicated", m)
+		}
+		if m.Up == nil {
+			return nil, fmt.Errorf("Migration %s: Up cannot be nil", m)
+		}
+	}
+	config.Migrations = migrations
+
+	c, err := mongo.EnsureCollection(&mongo.Collection{
+		Connection:   config.Connection,
+		Database:     config.Database,
+		Name:         config.Collection,
+		SchemaStruct: &record{},
+		Indexes: []mongo.IndexConfig{
+			mongo.IndexConfig{
+				ColumnConfig: []mongo.IndexColumnConfig{
+					mongo.IndexColumnConfig{
+						Name: "version",
+					},
+				},
+				IsUnique: true,
+				Name:     "migration_version_index",
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Ensuring Migrations-Collection")
+	}
+
+	locker, err := lock.NewLocker(lock.LockerConfig{
+		Connection: config.Connection,
+		Database:   config.Database,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Creating Locker")
+	}
+
+	return &Migrator{
+		config:     config,
+		collection: c,
+		locker:     locker,
+	}, nil
+}
+
+// Status returns the status of all Migrations ordered by their versions,
+// including the applied Migrations unknown to this Migrator.
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, errors.Wrap(err, "Status - Error Reading Migrations")
+	}
+
+	statuses := []Status{}
+	for _, migration := range m.config.Migrations {
+		status := Status{
+			Migration: migration,
+		}
+		if r, isApplied := applied[migration.Version]; isApplied {
+			status.Applied = true
+			status.AppliedAt = r.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, r := range applied {
+		statuses = append(statuses, Status{
+			Migration: Migration{
+				Version:     r.Version,
+				Description: r.Description,
+			},
+			Applied:   true,
+			AppliedAt: r.AppliedAt,
+			Unknown:   true,
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Version < statuses[j].Version
+	})
+	return statuses, nil
+}
+
+// applied returns the records of applied Migrations by their versions.
+func (m *Migrator) applied() (map[int64]*record, error) {
+	results, err := m.collection.Find(map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	applied := map[int64]*record{}
+	for _, result := range results {
+		r := result.(*record)
+		applied[r.Version] = r
+	}
+	return applied, nil
+}
+
+// Up runs the pending Migrations in order of their versions, and returns
+// the Migrations run (or that would be run with RunOptions.DryRun).
+func (m *Migrator) Up(ctx context.Context, opts RunOptions) ([]Migration, error) {
+	return m.withLock(ctx, opts, func(run runFunc) ([]Migration, error) {
+		applied, err := m.applied()
+		if err != nil {
+			return nil, errors.Wrap(err, "Up - Error Reading Migrations")
+		}
+
+		pending := []Migration{}
+		for _, migration := range m.config.Migrations {
+			if _, isApplied := applied[migration.Version]; !isApplied {
+				pending = append(pending, migration)
+			}
+		}
+		if opts.Steps > 0 && opts.Steps < len(pending) {
+			pending = pending[:opts.Steps]
+		}
+
+		for i, migration := range pending {
+			err := run(migration, true)
+			if err != nil {
+				return pending[:i], err
+			}
+		}
+		return pending, nil
+	})
+}
+
+// Down rolls back the latest applied Migrations in reverse order of their
+// versions, and returns the Migrations rolled back (or that would be rolled
+// back with RunOptions.DryRun).
+func (m *Migrator) Down(ctx context.Context, opts RunOptions) ([]Migration, error) {
+	return m.withLock(ctx, opts, func(run runFunc) ([]Migration, error) {
+		rollback, err := m.rollbackCandidates(opts.Steps)
+		if err != nil {
+			return nil, err
+		}
+		for i, migration := range rollback {
+			err := run(migration, false)
+			if err != nil {
+				return rollback[:i], err
+			}
+		}
+		return rollback, nil
+	})
+}
+
+// Redo rolls back the latest applied Migrations, and then runs them again.
+// This is useful when developing a Migration.
+func (m *Migrator) Redo(ctx context.Context, opts RunOptions) ([]Migration, error) {
+	return m.withLock(ctx, opts, func(run runFunc) ([]Migration, error) {
+		redo, err := m.rollbackCandidates(opts.Steps)
+		if err != nil {
+			return nil, err
+		}
+		for _, migration := range redo {
+			err := run(migration, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for i := len(redo) - 1; i >= 0; i-- {
+			err := run(redo[i], true)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return redo, nil
+	})
+}
+
+// rollbackCandidates returns the latest applied Migrations in reverse
+// order of their versions. ErrNoDown is returned if any of them cannot be
+// rolled back, or is unknown to this Migrator.
+func (m *Migrator) rollbackCandidates(steps int) ([]Migration, error) {
+	if steps <= 0 {
+		steps = 1
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []Migration{}
+	for i := len(statuses) - 1; i >= 0 && len(candidates) < steps; i-- {
+		status := statuses[i]
+		if !status.Applied {
+			continue
+		}
+		if status.Unknown || status.Down == nil {
+			return nil, errors.Wrapf(ErrNoDown, "Migration %s", status.Migration)
+		}
+		candidates = append(candidates, status.Migration)
+	}
+	return candidates, nil
+}
+
+// runFunc runs the Migration up or down.
+type runFunc func(migration Migration, up bool) error
+
+// withLock runs the function while holding the migration-lock. The
+// function receives a runFunc, which aborts if the lock is lost.
+func (m *Migrator) withLock(
+	ctx context.Context,
+	opts RunOptions,
+	fn func(run runFunc) ([]Migration, error),
+) ([]Migration, error) {
+	if opts.DryRun {
+		// The Migrations are only listed in dry-run
+		return fn(func(Migration, bool) error {
+			return nil
+		})
+	}
+
+	lck, err := m.acquireLock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	renewErr := lck.StartRenewal(m.config.LockLease / 3)
+	defer lck.Release()
+
+	return fn(func(migration Migration, up bool) error {
+		select {
+		case err := <-renewErr:
+			if err == nil {
+				err = lock.ErrLockNotHeld
+			}
+			return errors.Wrap(err, "Migration-Lock Lost")
+		default:
+		}
+		if opts.OnRun != nil {
+			opts.OnRun(migration)
+		}
+		if up {
+			return m.runUp(ctx, migration)
+		}
+		return m.runDown(ctx, migration)
+	})
+}
+
+// acquireLock acquires the migration-lock, waiting up to Config.LockWait
+// for other processes to release it.
+func (m *Migrator) acquireLock(ctx context.Context) (*lock.Lock, error) {
+	resource := "migrate:" + m.config.Collection
+	deadline := time.Now().Add(m.config.LockWait)
+	for {
+		lck, err := m.locker.Acquire(resource, m.config.Owner, m.config.LockLease)
+		if err != lock.ErrLockHeld || time.Now().After(deadline) {
+			return lck, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (m *Migrator) runUp(ctx context.Context, migration Migration) error {
+	db := m.config.Connection.Client.Database(m.config.Database)
+	err := migration.Up(ctx, db)
+	if err != nil {
+		return errors.Wrapf(err, "Up - Migration %s Failed", migration)
+	}
+	_, err = m.collection.InsertOne(&record{
+		Version:     migration.Version,
+		Description: migration.Description,
+		AppliedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Up - Error Recording Migration %s", migration)
+	}
+	return nil
+}
+
+func (m *Migrator) runDown(ctx context.Context, migration Migration) error {
+	db := m.config.Connection.Client.Database(m.config.Database)
+	err := migration.Down(ctx, db)
+	if err != nil {
+		return errors.Wrapf(err, "Down - Migration %s Failed", migration)
+	}
+	_, err = m.collection.DeleteMany(map[string]interface{}{
+		"version": migration.Version,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Down - Error Removing Migration %s", migration)
+	}
+	return nil
+}
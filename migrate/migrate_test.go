@@ -0,0 +1,330 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/TerrexTech/go-mongoutils/lock"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// newTestConnection creates a ConnectionConfig on a freshly dropped
+// test-database.
+func newTestConnection() (*mongo.ConnectionConfig, string) {
+	hosts := os.Getenv("MONGO_TEST_HOSTS")
+	username := os.Getenv("MONGO_TEST_USERNAME")
+	password := os.Getenv("MONGO_TEST_PASSWORD")
+	resourceTimeoutStr := os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS")
+	testDatabase := os.Getenv("MONGO_TEST_DATABASE")
+
+	resourceTimeout, err := strconv.Atoi(resourceTimeoutStr)
+	if err != nil {
+		err = errors.Wrap(
+			err,
+			"error getting RESOURCE_TIMEOUT from env, will use 3000",
+		)
+		log.Println(err)
+		resourceTimeout = 3000
+	}
+
+	client, err := mongo.NewClient(mongo.ClientConfig{
+		Hosts:               *commonutil.ParseHosts(hosts),
+		Username:            username,
+		Password:            password,
+		TimeoutMilliseconds: uint32(resourceTimeout),
+	})
+	Expect(err).ToNot(HaveOccurred())
+
+	dbCtx, dbCancel := context.WithTimeout(
+		context.Background(),
+		time.Duration(resourceTimeout)*time.Millisecond,
+	)
+	err = client.Database(testDatabase).Drop(dbCtx)
+	dbCancel()
+	Expect(err).ToNot(HaveOccurred())
+
+	return &mongo.ConnectionConfig{
+		Client:  client,
+		Timeout: uint32(resourceTimeout),
+	}, testDatabase
+}
+
+var _ = Describe("Migrator", func() {
+	var (
+		conn     *mongo.ConnectionConfig
+		database string
+		ctx      context.Context
+		// ran records the migration-functions run, such as "up 1"
+		ran []string
+	)
+
+	// newMigration creates a Migration recording its runs in ran.
+	newMigration := func(version int64, description string) Migration {
+		record := func(direction string) Func {
+			return func(ctx context.Context, db *mgo.Database) error {
+				ran = append(ran, direction+" "+strconv.FormatInt(version, 10))
+				return nil
+			}
+		}
+		return Migration{
+			Version:     version,
+			Description: description,
+			Up:          record("up"),
+			Down:        record("down"),
+		}
+	}
+
+	newMigrator := func(migrations ...Migration) *Migrator {
+		migrator, err := NewMigrator(Config{
+			Connection: conn,
+			Database:   database,
+			Migrations: migrations,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return migrator
+	}
+
+	BeforeEach(func() {
+		conn, database = newTestConnection()
+		ctx = context.Background()
+		ran = []string{}
+	})
+
+	AfterEach(func() {
+		err := conn.Client.Disconnect()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("NewMigrator", func() {
+		It("should return error for invalid Migrations", func() {
+			_, err := NewMigrator(Config{
+				Connection: conn,
+				Database:   database,
+				Migrations: []Migration{
+					newMigration(1, "first"),
+					newMigration(1, "duplicate"),
+				},
+			})
+			Expect(err).To(HaveOccurred())
+
+			_, err = NewMigrator(Config{
+				Connection: conn,
+				Database:   database,
+				Migrations: []Migration{
+					{Version: 1, Description: "without up"},
+				},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Up", func() {
+		It("should run pending Migrations in order of versions", func() {
+			migrator := newMigrator(
+				newMigration(2, "second"),
+				newMigration(1, "first"),
+			)
+			migrations, err := migrator.Up(ctx, RunOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(migrations).To(HaveLen(2))
+			Expect(ran).To(Equal([]string{"up 1", "up 2"}))
+
+			// The applied Migrations are not run again
+			migrator = newMigrator(
+				newMigration(1, "first"),
+				newMigration(2, "second"),
+				newMigration(3, "third"),
+			)
+			_, err = migrator.Up(ctx, RunOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ran).To(Equal([]string{"up 1", "up 2", "up 3"}))
+
+			statuses, err := migrator.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses).To(HaveLen(3))
+			for _, status := range statuses {
+				Expect(status.Applied).To(BeTrue())
+				Expect(status.AppliedAt).ToNot(BeZero())
+			}
+		})
+
+		It("should limit the Migrations to Steps", func() {
+			migrator := newMigrator(
+				newMigration(1, "first"),
+				newMigration(2, "second"),
+			)
+			_, err := migrator.Up(ctx, RunOptions{
+				Steps: 1,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ran).To(Equal([]string{"up 1"}))
+		})
+
+		It("should not run Migrations in dry-run", func() {
+			migrator := newMigrator(newMigration(1, "first"))
+			migrations, err := migrator.Up(ctx, RunOptions{
+				DryRun: true,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(migrations).To(HaveLen(1))
+			Expect(ran).To(BeEmpty())
+
+			statuses, err := migrator.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses[0].Applied).To(BeFalse())
+		})
+
+		It("should stop at the failing Migration", func() {
+			failing := newMigration(2, "failing")
+			failing.Up = func(ctx context.Context, db *mgo.Database) error {
+				return errors.New("some error")
+			}
+			migrator := newMigrator(
+				newMigration(1, "first"),
+				failing,
+				newMigration(3, "third"),
+			)
+			migrations, err := migrator.Up(ctx, RunOptions{})
+			Expect(err).To(HaveOccurred())
+			Expect(migrations).To(HaveLen(1))
+			Expect(ran).To(Equal([]string{"up 1"}))
+
+			statuses, err := migrator.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses[1].Applied).To(BeFalse())
+		})
+
+		It("should run Migrations on the database", func() {
+			migrator := newMigrator(Migration{
+				Version:     1,
+				Description: "backfill",
+				Up: func(ctx context.Context, db *mgo.Database) error {
+					_, err := db.Collection("items").InsertOne(
+						ctx,
+						bson.NewDocument(bson.EC.String("word", "test")),
+					)
+					return err
+				},
+			})
+			_, err := migrator.Up(ctx, RunOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			count, err := conn.Client.Database(database).
+				Collection("items").
+				Count(ctx, bson.NewDocument())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(1)))
+		})
+
+		It("should return error if another process holds the lock", func() {
+			migrator := newMigrator(newMigration(1, "first"))
+			locker, err := lock.NewLocker(lock.LockerConfig{
+				Connection: conn,
+				Database:   database,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = locker.Acquire("migrate:"+DefaultCollection, "other", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = migrator.Up(ctx, RunOptions{})
+			Expect(err).To(Equal(lock.ErrLockHeld))
+			Expect(ran).To(BeEmpty())
+		})
+	})
+
+	Describe("Down", func() {
+		It("should roll back the latest Migrations", func() {
+			migrator := newMigrator(
+				newMigration(1, "first"),
+				newMigration(2, "second"),
+				newMigration(3, "third"),
+			)
+			_, err := migrator.Up(ctx, RunOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			migrations, err := migrator.Down(ctx, RunOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(migrations).To(HaveLen(1))
+			Expect(migrations[0].Version).To(Equal(int64(3)))
+
+			_, err = migrator.Down(ctx, RunOptions{
+				Steps: 2,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ran).To(Equal([]string{
+				"up 1", "up 2", "up 3", "down 3", "down 2", "down 1",
+			}))
+
+			statuses, err := migrator.Status()
+			Expect(err).ToNot(HaveOccurred())
+			for _, status := range statuses {
+				Expect(status.Applied).To(BeFalse())
+			}
+		})
+
+		It("should return error for Migrations without Down", func() {
+			migration := newMigration(1, "first")
+			migration.Down = nil
+			migrator := newMigrator(migration)
+			_, err := migrator.Up(ctx, RunOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			_, err = migrator.Down(ctx, RunOptions{})
+			Expect(errors.Cause(err)).To(Equal(ErrNoDown))
+		})
+
+		It("should report applied Migrations unknown to Migrator", func() {
+			migrator := newMigrator(newMigration(1, "first"))
+			_, err := migrator.Up(ctx, RunOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			migrator = newMigrator()
+			statuses, err := migrator.Status()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(statuses).To(HaveLen(1))
+			Expect(statuses[0].Unknown).To(BeTrue())
+
+			_, err = migrator.Down(ctx, RunOptions{})
+			Expect(errors.Cause(err)).To(Equal(ErrNoDown))
+		})
+	})
+
+	Describe("RunCommand", func() {
+		It("should run the commands", func() {
+			migrator := newMigrator(
+				newMigration(1, "first"),
+				newMigration(2, "second"),
+			)
+			out := &bytes.Buffer{}
+			err := migrator.RunCommand(ctx, []string{"up", "-dry-run"}, out)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(out.String()).To(ContainSubstring("up 1 first (dry-run)"))
+			Expect(ran).To(BeEmpty())
+
+			err = migrator.RunCommand(ctx, []string{"up"}, out)
+			Expect(err).ToNot(HaveOccurred())
+			err = migrator.RunCommand(ctx, []string{"redo"}, out)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ran).To(Equal([]string{"up 1", "up 2", "down 2", "up 2"}))
+
+			out.Reset()
+			err = migrator.RunCommand(ctx, []string{"status"}, out)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(out.String()).To(ContainSubstring("second"))
+			Expect(out.String()).To(ContainSubstring("applied"))
+
+			err = migrator.RunCommand(ctx, []string{"unknown"}, out)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
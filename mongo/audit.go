@@ -0,0 +1,386 @@
+package mongo
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/mongoopt"
+	"github.com/pkg/errors"
+)
+
+// DefaultAuditCollection is the collection used for storing audit-entries
+// when AuditConfig.Collection is not specified.
+const DefaultAuditCollection = "audit_log"
+
+// AuditChange describes the change in a single field of a document.
+type AuditChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before" json:"before"`
+	After  interface{} `bson:"after" json:"after"`
+}
+
+// AuditEntry records a change made to a single document.
+// The values of sensitive fields are masked.
+type AuditEntry struct {
+	ID         objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Operation  string            `bson:"operation" json:"operation"`
+	Database   string            `bson:"database" json:"database"`
+	Collection string            `bson:"collection" json:"collection"`
+	DocumentID interface{}       `bson:"documentId" json:"documentId"`
+	Actor      string            `bson:"actor" json:"actor"`
+	Timestamp  time.Time         `bson:"timestamp" json:"timestamp"`
+	// Before is the document before the change, and is nil for inserts.
+	Before map[string]interface{} `bson:"before" json:"before"`
+	// After is the document after the change, and is nil for deletions.
+	After   map[string]interface{} `bson:"after" json:"after"`
+	Changes []AuditChange          `bson:"changes" json:"changes"`
+}
+
+// AuditConfig defines the configuration for an Auditor.
+type AuditConfig struct {
+	Connection *ConnectionConfig
+	Database   string
+	// Collection to store audit-entries in. Defaults to DefaultAuditCollection.
+	Collection string
+}
+
+// Auditor records the changes made to the documents of Collections that
+// have Collection.Auditor set. Every inserted, updated, replaced, and deleted
+// document is recorded as an AuditEntry, along with the actor from
+// operation-context (see WithActor).
+//
+// To capture the state of documents before and after the change, audited
+// updates, replacements, and deletions are run as find-and-modify operations
+// on individual documents. This makes them slower than their unaudited
+// counterparts, and the update/replace options are not supported. The
+// before-images of updates are read just before the update, so these are
+// best-effort with concurrent writes to the same documents.
+type Auditor struct {
+	collection *Collection
+}
+
+// NewAuditor creates a new Auditor, and ensures the audit-collection
+// along with its indexes.
+func NewAuditor(config AuditConfig) (*Auditor, error) {
+	if config.Connection == nil {
+		return nil, errors.New("AuditConfig.Connection cannot be nil")
+	}
+	if config.Database == "" {
+		return nil, errors.New("AuditConfig.Database cannot be blank")
+	}
+	if config.Collection == "" {
+		config.Collection = DefaultAuditCollection
+	}
+
+	c, err := EnsureCollection(&Collection{
+		Connection:   config.Connection,
+		Database:     config.Database,
+		Name:         config.Collection,
+		SchemaStruct: &AuditEntry{},
+		Indexes: []IndexConfig{
+			IndexConfig{
+				ColumnConfig: []IndexColumnConfig{
+					IndexColumnConfig{
+						Name: "database",
+					},
+					IndexColumnConfig{
+						Name: "collection",
+					},
+					IndexColumnConfig{
+						Name: "documentId",
+					},
+				},
+				Name: "audit_document_index",
+			},
+			IndexConfig{
+				ColumnConfig: []IndexColumnConfig{
+					IndexColumnConfig{
+						Name:        "timestamp",
+						IsDescOrder: true,
+					},
+				},
+				Name: "audit_timestamp_index",
+			},
+			IndexConfig{
+				ColumnConfig: []IndexColumnConfig{
+					IndexColumnConfig{
+						Name: "actor",
+					},
+				},
+				Name: "audit_actor_index",
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Ensuring Audit-Collection")
+	}
+	return &Auditor{
+		collection: c,
+	}, nil
+}
+
+// Collection returns the Collection storing the audit-entries.
+func (a *Auditor) Collection() *Collection {
+	return a.collection
+}
+
+type actorContextKey struct{}
+
+// WithActor returns a copy of context carrying the actor, which is
+// recorded in the audit-entries of operations run with this context.
+// Use Collection.WithContext to run operations with the context.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey{}, actor)
+}
+
+// ActorFromContext returns the actor set using WithActor, or
+// a blank string if no actor is set.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorContextKey{}).(string)
+	return actor
+}
+
+// record inserts the audit-entry for the change from before to after.
+func (a *Auditor) record(
+	op *Operation,
+	documentID interface{},
+	before map[string]interface{},
+	after map[string]interface{},
+) error {
+	// Changes are computed before masking, so a changed sensitive
+	// field is recorded even if its masked values are same.
+	tags := op.Collection.tags
+	changes := diffDocuments(before, after)
+	for i, change := range changes {
+		if tags == nil {
+			break
+		}
+		if strategy, isSensitive := tags.sensitive[change.Field]; isSensitive {
+			changes[i].Before = maskValue(change.Before, strategy)
+			changes[i].After = maskValue(change.After, strategy)
+		}
+	}
+
+	entry := &AuditEntry{
+		Operation:  op.Name,
+		Database:   op.Collection.Database,
+		Collection: op.Collection.Name,
+		DocumentID: documentID,
+		Actor:      ActorFromContext(op.Context),
+		Timestamp:  time.Now(),
+		Before:     tags.redactMap(before),
+		After:      tags.redactMap(after),
+		Changes:    changes,
+	}
+	_, err := a.collection.WithContext(op.Context).InsertOne(entry)
+	if err != nil {
+		return errors.Wrap(err, "Audit Error")
+	}
+	return nil
+}
+
+// diffDocuments returns the changes in top-level fields between two
+// documents, sorted by field-name.
+func diffDocuments(before, after map[string]interface{}) []AuditChange {
+	fields := map[string]bool{}
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+
+	changes := []AuditChange{}
+	for field := range fields {
+		b, a := before[field], after[field]
+		if !reflect.DeepEqual(b, a) {
+			changes = append(changes, AuditChange{
+				Field:  field,
+				Before: b,
+				After:  a,
+			})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+	return changes
+}
+
+// documentToMap converts a BSON-document to a map.
+func documentToMap(doc *bson.Document) (map[string]interface{}, error) {
+	docBytes, err := doc.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	err = bson.Unmarshal(docBytes, m)
+	return m, err
+}
+
+// auditInsert records the audit-entry for an inserted document.
+func (c *Collection) auditInsert(
+	op *Operation,
+	doc *bson.Document,
+	result *mgo.InsertOneResult,
+) error {
+	after, err := documentToMap(doc)
+	if err != nil {
+		return errors.Wrap(err, "Audit Error")
+	}
+	after["_id"] = result.InsertedID
+	return c.Auditor.record(op, result.InsertedID, nil, after)
+}
+
+// auditedDeleteMany deletes the matching documents one at a time, so the
+// deleted documents can be recorded.
+func (c *Collection) auditedDeleteMany(
+	op *Operation,
+	filterDoc *bson.Document,
+) (*mgo.DeleteResult, error) {
+	result := &mgo.DeleteResult{}
+	for {
+		ctx, cancel := newOpTimeoutContext(op, c.Connection.Timeout)
+		before := map[string]interface{}{}
+		err := c.Collection().FindOneAndDelete(ctx, filterDoc).Decode(before)
+		cancel()
+
+		if err == mgo.ErrNoDocuments {
+			return result, nil
+		}
+		if err != nil {
+			return result, errors.Wrap(err, "Deletion Error")
+		}
+		result.DeletedCount++
+
+		err = c.Auditor.record(op, before["_id"], before, nil)
+		if err != nil {
+			return result, err
+		}
+	}
+}
+
+// auditedUpdateMany updates the matching documents one at a time, so the
+// state of each document before and after the update can be recorded.
+// The after-image is returned by the update itself, while the before-image
+// is from reading the matching documents just before, so it is best-effort:
+// a concurrent write in between is included in the before-image.
+func (c *Collection) auditedUpdateMany(
+	op *Operation,
+	filterDoc *bson.Document,
+	updateDoc *bson.Document,
+) (*mgo.UpdateResult, error) {
+	matched, err := c.matchingDocuments(op, filterDoc)
+	if err != nil {
+		return nil, errors.Wrap(err, "UpdateMany Error")
+	}
+
+	result := &mgo.UpdateResult{}
+	for _, before := range matched {
+		id := before["_id"]
+		// The document must still match the filter when its updated
+		idFilter := bson.NewDocument(
+			bson.EC.ArrayFromElements(
+				"$and",
+				bson.VC.Document(filterDoc),
+				bson.VC.DocumentFromElements(bson.EC.Interface("_id", id)),
+			),
+		)
+
+		ctx, cancel := newOpTimeoutContext(op, c.Connection.Timeout)
+		after := map[string]interface{}{}
+		err := c.Collection().FindOneAndUpdate(
+			ctx,
+			idFilter,
+			updateDoc,
+			findopt.ReturnDocument(mongoopt.After),
+		).Decode(after)
+		cancel()
+
+		if err == mgo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return result, errors.Wrap(err, "UpdateMany Error")
+		}
+		result.MatchedCount++
+		if !reflect.DeepEqual(before, after) {
+			result.ModifiedCount++
+		}
+
+		err = c.Auditor.record(op, id, before, after)
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// auditedReplaceOne replaces the matching document while capturing
+// its state before replacement.
+func (c *Collection) auditedReplaceOne(
+	op *Operation,
+	filterDoc *bson.Document,
+	replacementDoc *bson.Document,
+) (*mgo.UpdateResult, error) {
+	ctx, cancel := newOpTimeoutContext(op, c.Connection.Timeout)
+	before := map[string]interface{}{}
+	err := c.Collection().FindOneAndReplace(
+		ctx,
+		filterDoc,
+		replacementDoc,
+		findopt.ReturnDocument(mongoopt.Before),
+	).Decode(before)
+	cancel()
+
+	result := &mgo.UpdateResult{}
+	if err == mgo.ErrNoDocuments {
+		return result, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "ReplaceOne Error")
+	}
+	result.MatchedCount = 1
+	result.ModifiedCount = 1
+
+	after, err := documentToMap(replacementDoc)
+	if err != nil {
+		return result, errors.Wrap(err, "Audit Error")
+	}
+	after["_id"] = before["_id"]
+
+	err = c.Auditor.record(op, before["_id"], before, after)
+	return result, err
+}
+
+// matchingDocuments returns the documents matching the filter as maps.
+func (c *Collection) matchingDocuments(
+	op *Operation,
+	filterDoc *bson.Document,
+) ([]map[string]interface{}, error) {
+	ctx, cancel := newOpTimeoutContext(op, c.Connection.Timeout)
+	defer cancel()
+
+	cur, err := c.Collection().Find(ctx, filterDoc)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	docs := []map[string]interface{}{}
+	for cur.Next(ctx) {
+		doc := map[string]interface{}{}
+		err := cur.Decode(doc)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, cur.Err()
+}
@@ -0,0 +1,226 @@
+package mongo
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo/updateopt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Auditor", func() {
+	type item struct {
+		ID         objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		Word       string            `bson:"word,omitempty" json:"word,omitempty"`
+		Definition string            `bson:"definition,omitempty" json:"definition,omitempty"`
+	}
+
+	var (
+		c       *Collection
+		auditor *Auditor
+	)
+
+	// auditEntries returns the audit-entries for specified operation.
+	auditEntries := func(operation string) []*AuditEntry {
+		results, err := auditor.Collection().Find(map[string]interface{}{
+			"operation": operation,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		entries := []*AuditEntry{}
+		for _, r := range results {
+			entries = append(entries, r.(*AuditEntry))
+		}
+		return entries
+	}
+
+	BeforeEach(func() {
+		hosts := os.Getenv("MONGO_TEST_HOSTS")
+		username := os.Getenv("MONGO_TEST_USERNAME")
+		password := os.Getenv("MONGO_TEST_PASSWORD")
+		resourceTimeoutStr := os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS")
+		testDatabase := os.Getenv("MONGO_TEST_DATABASE")
+
+		resourceTimeoutInt, err := strconv.Atoi(resourceTimeoutStr)
+		if err != nil {
+			err = errors.Wrap(
+				err,
+				"error getting RESOURCE_TIMEOUT from env, will use 3000",
+			)
+			log.Println(err)
+			resourceTimeoutInt = 3000
+		}
+		resourceTimeout := uint32(resourceTimeoutInt)
+
+		client, err := NewClient(ClientConfig{
+			Hosts:               *commonutil.ParseHosts(hosts),
+			Username:            username,
+			Password:            password,
+			TimeoutMilliseconds: resourceTimeout,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		dbCtx, dbCancel := newTimeoutContext(resourceTimeout)
+		err = client.Database(testDatabase).Drop(dbCtx)
+		dbCancel()
+		Expect(err).ToNot(HaveOccurred())
+
+		conn := &ConnectionConfig{
+			Client:  client,
+			Timeout: resourceTimeout,
+		}
+		auditor, err = NewAuditor(AuditConfig{
+			Connection: conn,
+			Database:   testDatabase,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		c, err = EnsureCollection(&Collection{
+			Connection:   conn,
+			Database:     testDatabase,
+			Name:         "test_collection",
+			SchemaStruct: &item{},
+			Auditor:      auditor,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = c.InsertOne(&item{
+			Word:       "some-word",
+			Definition: "some-definition",
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := c.Connection.Client.Disconnect()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should record inserted documents", func() {
+		entries := auditEntries(OpInsertOne)
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Collection).To(Equal("test_collection"))
+		Expect(entries[0].Before).To(BeNil())
+		Expect(entries[0].After["word"]).To(Equal("some-word"))
+	})
+
+	It("should record the actor from context", func() {
+		ctx := WithActor(context.Background(), "test-user")
+		_, err := c.WithContext(ctx).InsertOne(&item{
+			Word: "some-word2",
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		results, err := auditor.Collection().Find(map[string]interface{}{
+			"actor": "test-user",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(1))
+	})
+
+	It("should record the state before and after update along with diff", func() {
+		result, err := c.UpdateMany(
+			&item{
+				Word: "some-word",
+			},
+			map[string]interface{}{
+				"definition": "updated-definition",
+			},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.MatchedCount).To(Equal(int64(1)))
+		Expect(result.ModifiedCount).To(Equal(int64(1)))
+
+		entries := auditEntries(OpUpdateMany)
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Before["definition"]).To(Equal("some-definition"))
+		Expect(entries[0].After["definition"]).To(Equal("updated-definition"))
+		Expect(entries[0].Changes).To(HaveLen(1))
+		Expect(entries[0].Changes[0].Field).To(Equal("definition"))
+	})
+
+	It("should record the state before replacement", func() {
+		_, err := c.ReplaceOne(
+			&item{
+				Word: "some-word",
+			},
+			&item{
+				Word: "replaced-word",
+			},
+		)
+		Expect(err).ToNot(HaveOccurred())
+
+		entries := auditEntries(OpReplaceOne)
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Before["word"]).To(Equal("some-word"))
+		Expect(entries[0].After["word"]).To(Equal("replaced-word"))
+	})
+
+	It("should record the deleted documents", func() {
+		result, err := c.DeleteMany(&item{
+			Word: "some-word",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.DeletedCount).To(Equal(int64(1)))
+
+		entries := auditEntries(OpDeleteMany)
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0].Before["word"]).To(Equal("some-word"))
+		Expect(entries[0].After).To(BeNil())
+	})
+
+	It("should return error if update-options are specified", func() {
+		_, err := c.UpdateMany(
+			&item{
+				Word: "some-word",
+			},
+			map[string]interface{}{
+				"definition": "updated-definition",
+			},
+			updateopt.Upsert(true),
+		)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("diffDocuments", func() {
+		It("should return the changed fields sorted by name", func() {
+			changes := diffDocuments(
+				map[string]interface{}{
+					"a": 1,
+					"b": "same",
+					"c": "removed",
+				},
+				map[string]interface{}{
+					"a": 2,
+					"b": "same",
+					"d": "added",
+				},
+			)
+			Expect(changes).To(Equal([]AuditChange{
+				AuditChange{Field: "a", Before: 1, After: 2},
+				AuditChange{Field: "c", Before: "removed", After: nil},
+				AuditChange{Field: "d", Before: nil, After: "added"},
+			}))
+		})
+	})
+
+	Describe("documentToMap", func() {
+		It("should convert the document to map", func() {
+			m, err := documentToMap(bson.NewDocument(
+				bson.EC.String("word", "some-word"),
+				bson.EC.Int32("hits", 3),
+			))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(HaveLen(2))
+			Expect(m["word"]).To(Equal("some-word"))
+			Expect(m["hits"]).To(BeNumerically("==", 3))
+		})
+	})
+})
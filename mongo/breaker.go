@@ -0,0 +1,300 @@
+package mongo
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// BreakerState is the state of a circuit in CircuitBreaker.
+type BreakerState int
+
+// The states of a circuit.
+const (
+	// BreakerClosed allows all operations.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen rejects all operations with CircuitOpenError.
+	BreakerOpen
+	// BreakerHalfOpen allows a limited number of probe-operations, which
+	// close the circuit if these succeed, or re-open it if any fails.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// CircuitOpenError is returned for operations rejected by an open circuit.
+type CircuitOpenError struct {
+	// Operation is the name of rejected operation, such as OpFind.
+	Operation string
+	// RetryAfter is the duration after which the circuit half-opens.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf(
+		"Circuit open for operation: %s, retry after: %s",
+		e.Operation, e.RetryAfter,
+	)
+}
+
+// IsCircuitOpen checks if the error is caused by an open circuit.
+func IsCircuitOpen(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, isOpen := errors.Cause(err).(*CircuitOpenError)
+	return isOpen
+}
+
+// BreakerConfig defines the configuration for a CircuitBreaker.
+// The zero-value fields use their defaults.
+type BreakerConfig struct {
+	// FailureRatio is the ratio (0 to 1) of failed operations in Window
+	// at which the circuit opens. Defaults to 0.5.
+	FailureRatio float64
+	// MinRequests is the minimum operations in Window before the circuit
+	// can open. Defaults to 10.
+	MinRequests int
+	// Window is the duration over which failures are counted.
+	// Defaults to 10s.
+	Window time.Duration
+	// OpenTimeout is the duration for which the circuit stays open before
+	// half-opening. Defaults to 30s.
+	OpenTimeout time.Duration
+	// HalfOpenProbes is the number of operations allowed when half-open,
+	// all of which must succeed to close the circuit. Defaults to 1.
+	HalfOpenProbes int
+	// IsFailure checks if an error indicates a degraded database, as opposed
+	// to errors such as duplicate-keys. Defaults to IsRetryable.
+	IsFailure func(err error) bool
+	// OnStateChange is called when a circuit changes state, if set. This is
+	// called after the CircuitBreaker is unlocked, so it can call the
+	// CircuitBreaker's methods, but it may be called concurrently.
+	OnStateChange func(operation string, from BreakerState, to BreakerState)
+}
+
+// CircuitBreaker fails the operations fast when these are failing
+// frequently, instead of having every operation wait for timeouts.
+// A separate circuit is kept for every operation-type (such as OpFind),
+// so failing writes don't block the reads.
+type CircuitBreaker struct {
+	config BreakerConfig
+
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+	// transitions are the state-changes to be reported on unlocking
+	transitions []transition
+	// now returns the current time, replaceable for tests
+	now func() time.Time
+}
+
+// circuit holds the state for an operation-type.
+type circuit struct {
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	// probes is the number of probes allowed, and successes the number
+	// succeeded, in half-open state
+	probes    int
+	successes int
+}
+
+// transition is a state-change of the circuit for operation.
+type transition struct {
+	operation string
+	from      BreakerState
+	to        BreakerState
+}
+
+// NewCircuitBreaker creates a new CircuitBreaker.
+func NewCircuitBreaker(config BreakerConfig) (*CircuitBreaker, error) {
+	if config.FailureRatio < 0 || config.FailureRatio > 1 {
+		return nil, errors.New("BreakerConfig.FailureRatio must be between 0 and 1")
+	}
+	if config.FailureRatio == 0 {
+		config.FailureRatio = 0.5
+	}
+	if config.MinRequests == 0 {
+		config.MinRequests = 10
+	}
+	if config.Window == 0 {
+		config.Window = 10 * time.Second
+	}
+	if config.OpenTimeout == 0 {
+		config.OpenTimeout = 30 * time.Second
+	}
+	if config.HalfOpenProbes == 0 {
+		config.HalfOpenProbes = 1
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = IsRetryable
+	}
+
+	return &CircuitBreaker{
+		config:   config,
+		circuits: map[string]*circuit{},
+		now:      time.Now,
+	}, nil
+}
+
+// State returns the state of circuit for the operation-type.
+func (b *CircuitBreaker) State(operation string) BreakerState {
+	b.mutex.Lock()
+	defer b.unlock()
+	return b.circuit(operation).state
+}
+
+// States returns the states of circuits for all operation-types
+// run so far.
+func (b *CircuitBreaker) States() map[string]BreakerState {
+	b.mutex.Lock()
+	defer b.unlock()
+
+	states := map[string]BreakerState{}
+	for operation, c := range b.circuits {
+		b.refresh(operation, c)
+		states[operation] = c.state
+	}
+	return states
+}
+
+// circuit returns the refreshed circuit for operation, creating it if
+// required. The mutex must be held.
+func (b *CircuitBreaker) circuit(operation string) *circuit {
+	c, exists := b.circuits[operation]
+	if !exists {
+		c = &circuit{
+			windowStart: b.now(),
+		}
+		b.circuits[operation] = c
+	}
+	b.refresh(operation, c)
+	return c
+}
+
+// refresh half-opens the circuit if its OpenTimeout has elapsed.
+func (b *CircuitBreaker) refresh(operation string, c *circuit) {
+	if c.state == BreakerOpen && b.now().Sub(c.openedAt) >= b.config.OpenTimeout {
+		c.probes = 0
+		c.successes = 0
+		b.setState(operation, c, BreakerHalfOpen)
+	}
+}
+
+// setState changes the state of circuit, and queues the transition for
+// OnStateChange. The mutex must be held.
+func (b *CircuitBreaker) setState(operation string, c *circuit, state BreakerState) {
+	from := c.state
+	c.state = state
+	if from != state && b.config.OnStateChange != nil {
+		b.transitions = append(b.transitions, transition{
+			operation: operation,
+			from:      from,
+			to:        state,
+		})
+	}
+}
+
+// unlock unlocks the mutex, and then reports the queued transitions,
+// so OnStateChange does not block the operations or deadlock.
+func (b *CircuitBreaker) unlock() {
+	transitions := b.transitions
+	b.transitions = nil
+	b.mutex.Unlock()
+
+	for _, t := range transitions {
+		b.config.OnStateChange(t.operation, t.from, t.to)
+	}
+}
+
+// allow checks if the operation can run, and reserves a probe if the
+// circuit is half-open.
+func (b *CircuitBreaker) allow(operation string) error {
+	b.mutex.Lock()
+	defer b.unlock()
+
+	c := b.circuit(operation)
+	switch c.state {
+	case BreakerOpen:
+		return &CircuitOpenError{
+			Operation:  operation,
+			RetryAfter: c.openedAt.Add(b.config.OpenTimeout).Sub(b.now()),
+		}
+	case BreakerHalfOpen:
+		if c.probes >= b.config.HalfOpenProbes {
+			return &CircuitOpenError{
+				Operation: operation,
+			}
+		}
+		c.probes++
+	}
+	return nil
+}
+
+// record records the result of an allowed operation.
+func (b *CircuitBreaker) record(operation string, err error) {
+	b.mutex.Lock()
+	defer b.unlock()
+
+	c := b.circuit(operation)
+	failed := err != nil && b.config.IsFailure(err)
+	now := b.now()
+
+	switch c.state {
+	case BreakerHalfOpen:
+		if failed {
+			c.openedAt = now
+			b.setState(operation, c, BreakerOpen)
+			return
+		}
+		c.successes++
+		if c.successes >= b.config.HalfOpenProbes {
+			c.windowStart = now
+			c.requests = 0
+			c.failures = 0
+			b.setState(operation, c, BreakerClosed)
+		}
+	case BreakerClosed:
+		if now.Sub(c.windowStart) >= b.config.Window {
+			c.windowStart = now
+			c.requests = 0
+			c.failures = 0
+		}
+		c.requests++
+		if failed {
+			c.failures++
+		}
+		ratio := float64(c.failures) / float64(c.requests)
+		if c.requests >= b.config.MinRequests && ratio >= b.config.FailureRatio {
+			c.openedAt = now
+			b.setState(operation, c, BreakerOpen)
+		}
+	}
+}
+
+// wrap wraps the Handler to run through the circuit for operation.
+func (b *CircuitBreaker) wrap(next Handler) Handler {
+	return func(op *Operation) (interface{}, error) {
+		err := b.allow(op.Name)
+		if err != nil {
+			return nil, err
+		}
+		result, err := next(op)
+		b.record(op.Name, err)
+		return result, err
+	}
+}
@@ -0,0 +1,159 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/core/command"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("CircuitBreaker", func() {
+	var (
+		breaker *CircuitBreaker
+		now     time.Time
+		// failing makes the handler return a transient error
+		failing bool
+		calls   int
+		handler Handler
+		changes []BreakerState
+	)
+
+	run := func(name string) error {
+		_, err := handler(&Operation{
+			Name:    name,
+			Context: context.Background(),
+		})
+		return err
+	}
+
+	BeforeEach(func() {
+		var err error
+		changes = []BreakerState{}
+		breaker, err = NewCircuitBreaker(BreakerConfig{
+			MinRequests: 4,
+			OpenTimeout: time.Minute,
+			OnStateChange: func(op string, from BreakerState, to BreakerState) {
+				changes = append(changes, to)
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		now = time.Now()
+		breaker.now = func() time.Time {
+			return now
+		}
+		failing = false
+		calls = 0
+		handler = breaker.wrap(func(op *Operation) (interface{}, error) {
+			calls++
+			if failing {
+				return nil, command.Error{Code: 6}
+			}
+			return nil, nil
+		})
+	})
+
+	It("should return error for invalid FailureRatio", func() {
+		_, err := NewCircuitBreaker(BreakerConfig{
+			FailureRatio: 1.5,
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should open once failure-ratio is reached after MinRequests", func() {
+		failing = true
+		for i := 0; i < 3; i++ {
+			Expect(IsCircuitOpen(run(OpFind))).To(BeFalse())
+		}
+		Expect(breaker.State(OpFind)).To(Equal(BreakerClosed))
+
+		Expect(IsCircuitOpen(run(OpFind))).To(BeFalse())
+		Expect(breaker.State(OpFind)).To(Equal(BreakerOpen))
+
+		err := run(OpFind)
+		Expect(IsCircuitOpen(errors.Wrap(err, "Find Error"))).To(BeTrue())
+		Expect(err.(*CircuitOpenError).RetryAfter).To(Equal(time.Minute))
+		Expect(calls).To(Equal(4))
+	})
+
+	It("should keep separate circuits for operation-types", func() {
+		failing = true
+		for i := 0; i < 4; i++ {
+			run(OpInsertOne)
+		}
+		Expect(breaker.State(OpInsertOne)).To(Equal(BreakerOpen))
+
+		failing = false
+		Expect(run(OpFind)).ToNot(HaveOccurred())
+		Expect(breaker.States()).To(Equal(map[string]BreakerState{
+			OpInsertOne: BreakerOpen,
+			OpFind:      BreakerClosed,
+		}))
+	})
+
+	It("should not count non-transient errors as failures", func() {
+		handler = breaker.wrap(func(op *Operation) (interface{}, error) {
+			return nil, errors.New("some error")
+		})
+		for i := 0; i < 10; i++ {
+			run(OpFind)
+		}
+		Expect(breaker.State(OpFind)).To(Equal(BreakerClosed))
+	})
+
+	It("should half-open after OpenTimeout and close if probe succeeds", func() {
+		failing = true
+		for i := 0; i < 4; i++ {
+			run(OpFind)
+		}
+		now = now.Add(time.Minute)
+		Expect(breaker.State(OpFind)).To(Equal(BreakerHalfOpen))
+
+		failing = false
+		Expect(run(OpFind)).ToNot(HaveOccurred())
+		Expect(breaker.State(OpFind)).To(Equal(BreakerClosed))
+		Expect(changes).To(Equal([]BreakerState{
+			BreakerOpen, BreakerHalfOpen, BreakerClosed,
+		}))
+	})
+
+	It("should allow OnStateChange to read the states", func() {
+		observed := []BreakerState{}
+		breaker.config.OnStateChange = func(
+			op string, from BreakerState, to BreakerState,
+		) {
+			// This deadlocks if called while the breaker is locked
+			observed = append(observed, breaker.States()[op])
+		}
+		failing = true
+		for i := 0; i < 4; i++ {
+			run(OpFind)
+		}
+		Expect(observed).To(Equal([]BreakerState{BreakerOpen}))
+	})
+
+	It("should re-open if the probe fails", func() {
+		failing = true
+		for i := 0; i < 4; i++ {
+			run(OpFind)
+		}
+		now = now.Add(time.Minute)
+
+		Expect(IsCircuitOpen(run(OpFind))).To(BeFalse())
+		Expect(breaker.State(OpFind)).To(Equal(BreakerOpen))
+		Expect(IsCircuitOpen(run(OpFind))).To(BeTrue())
+	})
+
+	It("should reset the failure-counts after Window", func() {
+		failing = true
+		for i := 0; i < 3; i++ {
+			run(OpFind)
+		}
+		now = now.Add(10 * time.Second)
+		run(OpFind)
+		Expect(breaker.State(OpFind)).To(Equal(BreakerClosed))
+	})
+})
@@ -3,6 +3,7 @@ package mongo
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	mgo "github.com/mongodb/mongo-go-driver/mongo"
@@ -16,24 +17,131 @@ type ClientConfig struct {
 	Password            string
 	NoDefaultConnect    bool
 	TimeoutMilliseconds uint32
+
+	// AuthSource is the database holding the user's credentials.
+	// Defaults to "admin", or to "$external" with AuthX509.
+	AuthSource string
+	// AuthMechanism is one of the Auth* constants, such as AuthSCRAMSHA256.
+	// Defaults to negotiating the mechanism with server.
+	AuthMechanism string
+	// AppName is the application's name, as logged by the server.
+	AppName string
+	// Compressors for the network-traffic, in order of preference,
+	// such as CompressorSnappy.
+	Compressors []string
+	// MaxPoolSize is the maximum connections per host.
+	MaxPoolSize uint16
+	// MinPoolSize is the minimum connections per host. This requires a newer
+	// MongoDB driver, so NewClient currently returns error if this is set.
+	MinPoolSize uint16
+	// ServerSelectionTimeoutMilliseconds is the time to wait for a suitable
+	// server to become available. Defaults to the driver's default of 30s.
+	ServerSelectionTimeoutMilliseconds uint32
+
+	// TLS enables TLS for connections. The other TLS-options require this.
+	TLS bool
+	// TLSCAFile is the file with CA-certificates for verifying the server.
+	TLSCAFile string
+	// TLSCertificateKeyFile is the file with client-certificate and
+	// private-key, for authenticating the client.
+	TLSCertificateKeyFile string
+	// TLSCertificateKeyPassword decrypts the private-key, if encrypted.
+	TLSCertificateKeyPassword string
+	// TLSInsecure disables verifying the server's certificate and hostname.
+	// Never use this in production.
+	TLSInsecure bool
+
+	// RetryPolicy for operations on the Client's Collections.
+	// The operations are not retried if this is nil.
+	RetryPolicy *RetryPolicy
+	// CircuitBreaker for operations on the Client's Collections.
+	// No CircuitBreaker is used if this is nil.
+	CircuitBreaker *BreakerConfig
+	// Reconnect enables checking the connection in background, and
+	// reconnecting when the server becomes unreachable.
+	// The connection is not checked if this is nil.
+	Reconnect *ReconnectConfig
+	// OnStateChange is called on every ConnectionState transition, if set.
+	// See Client.OnStateChange.
+	OnStateChange func(from ConnectionState, to ConnectionState)
+}
+
+// ConnectionState is the state of a Client's connection.
+type ConnectionState int
+
+// The states of a Client's connection.
+const (
+	// StateDisconnected is the state before connecting,
+	// and after disconnecting.
+	StateDisconnected ConnectionState = iota
+	StateConnected
+	// StateReconnecting is the state when the server has become unreachable,
+	// and the Client is reconnecting as per its ReconnectConfig.
+	StateReconnecting
+)
+
+func (s ConnectionState) String() string {
+	switch s {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	}
+	return fmt.Sprintf("ConnectionState(%d)", int(s))
+}
+
+// ReconnectConfig defines how a Client checks its connection and reconnects.
+// The zero-value fields use their defaults.
+type ReconnectConfig struct {
+	// CheckInterval is the interval between pings for checking the
+	// connection. Defaults to 10s.
+	CheckInterval time.Duration
+	// InitialBackoff is the wait before the first reconnection-attempt.
+	// Defaults to 500ms.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum wait between reconnection-attempts, which
+	// doubles after every failed attempt. Defaults to 30s.
+	MaxBackoff time.Duration
 }
 
 // Client represents a MongoDB client.
 // This wraps the Mongo-Go-Driver client.
+// Client is safe for concurrent use, and can be reconnected after
+// being disconnected.
 type Client struct {
-	client    *mgo.Client
-	connected bool
-	timeout   uint32
+	connStr     string
+	timeout     uint32
+	retryPolicy *RetryPolicy
+	breaker     *CircuitBreaker
+	reconnect   *ReconnectConfig
+
+	// lifecycleMutex serializes Connect and Disconnect
+	lifecycleMutex sync.Mutex
+	// mutex guards the fields below
+	mutex       sync.RWMutex
+	middlewares []Middleware
+	client      *mgo.Client
+	// stale is set when the driver-client is disconnected, since it
+	// cannot be reused, and a new one is created on connecting.
+	stale bool
+	// generation is incremented whenever the driver-client is recreated,
+	// so the Collections can refresh their driver-collections.
+	generation uint64
+	state      ConnectionState
+	listeners  []func(from ConnectionState, to ConnectionState)
+	// stopMonitor stops the connection-monitor, if running
+	stopMonitor chan struct{}
+	monitorDone chan struct{}
 }
 
 // NewClient creates new client based on the ClientConfig provided.
 func NewClient(config ClientConfig) (*Client, error) {
-	connStr := fmt.Sprintf(
-		"mongodb://%s:%s@%s",
-		config.Username,
-		config.Password,
-		config.Hosts[0],
-	)
+	connStr, err := connectionString(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid ClientConfig")
+	}
 	mgoClient, err := mgo.NewClient(connStr)
 	if err != nil {
 		err = errors.Wrap(err, "Error Creating MongoDB Client")
@@ -43,35 +151,86 @@ func NewClient(config ClientConfig) (*Client, error) {
 		config.TimeoutMilliseconds = 1000
 	}
 	client := &Client{
-		client:  mgoClient,
-		timeout: config.TimeoutMilliseconds,
+		connStr:     connStr,
+		client:      mgoClient,
+		timeout:     config.TimeoutMilliseconds,
+		retryPolicy: config.RetryPolicy,
+	}
+	if config.CircuitBreaker != nil {
+		client.breaker, err = NewCircuitBreaker(*config.CircuitBreaker)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error Creating CircuitBreaker")
+		}
+	}
+	if config.Reconnect != nil {
+		reconnect := config.Reconnect.withDefaults()
+		client.reconnect = &reconnect
+	}
+	if config.OnStateChange != nil {
+		client.OnStateChange(config.OnStateChange)
 	}
 
 	if config.NoDefaultConnect {
-		client.connected = false
 		return client, err
 	}
 	err = client.Connect()
 	return client, err
 }
 
+func (r ReconnectConfig) withDefaults() ReconnectConfig {
+	if r.CheckInterval == 0 {
+		r.CheckInterval = 10 * time.Second
+	}
+	if r.InitialBackoff == 0 {
+		r.InitialBackoff = 500 * time.Millisecond
+	}
+	if r.MaxBackoff == 0 {
+		r.MaxBackoff = 30 * time.Second
+	}
+	return r
+}
+
 // Connect connects the created client to Database. This is a no-op if
 // the client is already connected.
 // This is also run by default unless "NoDefaultConnect" is specified in ClientConfig.
 func (c *Client) Connect() error {
-	if !c.connected {
-		ctx, cancel := context.WithTimeout(
-			context.Background(),
-			time.Duration(c.timeout)*time.Millisecond,
-		)
-		defer cancel()
-
-		err := c.client.Connect(ctx)
+	c.lifecycleMutex.Lock()
+	defer c.lifecycleMutex.Unlock()
+
+	if c.State() != StateDisconnected {
+		return nil
+	}
+
+	c.mutex.RLock()
+	mgoClient, stale := c.client, c.stale
+	c.mutex.RUnlock()
+	if stale {
+		var err error
+		mgoClient, err = mgo.NewClient(c.connStr)
 		if err != nil {
-			err = errors.Wrap(err, "Error Connecting MongoDB Client to Database")
-			return err
+			return errors.Wrap(err, "Error Creating MongoDB Client")
 		}
-		c.connected = true
+	}
+
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+	err := mgoClient.Connect(ctx)
+	if err != nil {
+		err = errors.Wrap(err, "Error Connecting MongoDB Client to Database")
+		return err
+	}
+
+	c.mutex.Lock()
+	if c.client != mgoClient {
+		c.client = mgoClient
+		c.generation++
+	}
+	c.stale = false
+	c.mutex.Unlock()
+	c.setState(StateConnected)
+
+	if c.reconnect != nil {
+		c.startMonitor()
 	}
 	return nil
 }
@@ -79,29 +238,200 @@ func (c *Client) Connect() error {
 // Disconnect disconnects the created client from Database. This is a no-op if
 // the client is already disconnected.
 func (c *Client) Disconnect() error {
-	if c.connected {
-		ctx, cancel := context.WithTimeout(
-			context.Background(),
-			time.Duration(c.timeout)*time.Millisecond,
-		)
-		defer cancel()
-
-		err := c.client.Disconnect(ctx)
-		if err != nil {
-			err = errors.Wrap(err, "Error Disconnecting MongoDB Client from Database")
-			return err
-		}
-		c.connected = false
+	c.lifecycleMutex.Lock()
+	defer c.lifecycleMutex.Unlock()
+
+	if c.State() == StateDisconnected {
+		return nil
 	}
+	c.stopMonitoring()
+
+	c.mutex.RLock()
+	mgoClient := c.client
+	c.mutex.RUnlock()
+
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+	err := mgoClient.Disconnect(ctx)
+	if err != nil {
+		err = errors.Wrap(err, "Error Disconnecting MongoDB Client from Database")
+		return err
+	}
+
+	c.mutex.Lock()
+	c.stale = true
+	c.mutex.Unlock()
+	c.setState(StateDisconnected)
 	return nil
 }
 
+// State returns the current ConnectionState.
+func (c *Client) State() ConnectionState {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.state
+}
+
+// OnStateChange registers a callback to be called on every ConnectionState
+// transition. The callbacks are called synchronously, and must not call
+// Connect or Disconnect.
+func (c *Client) OnStateChange(
+	callback func(from ConnectionState, to ConnectionState),
+) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.listeners = append(c.listeners, callback)
+}
+
+// setState sets the ConnectionState, and notifies the listeners if the
+// state changed.
+func (c *Client) setState(state ConnectionState) {
+	c.mutex.Lock()
+	from := c.state
+	c.state = state
+	listeners := c.listeners
+	c.mutex.Unlock()
+
+	if from == state {
+		return
+	}
+	for _, listener := range listeners {
+		listener(from, state)
+	}
+}
+
 // Database returns a handle for a given database.
 func (c *Client) Database(dbName string) *mgo.Database {
-	return c.client.Database(dbName)
+	return c.DriverClient().Database(dbName)
+}
+
+// CircuitBreaker returns the Client's CircuitBreaker, such as for checking
+// its state in health-checks. This is nil if no CircuitBreaker is configured.
+func (c *Client) CircuitBreaker() *CircuitBreaker {
+	return c.breaker
 }
 
 // DriverClient returns the wrapped Mongo-Go-Driver client.
+// The driver-client is recreated when reconnecting, so this should not
+// be retained.
 func (c *Client) DriverClient() *mgo.Client {
-	return c.client
+	mgoClient, _ := c.driverClient()
+	return mgoClient
+}
+
+// driverClient returns the driver-client along with its generation.
+func (c *Client) driverClient() (*mgo.Client, uint64) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.client, c.generation
+}
+
+func (c *Client) timeoutContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(
+		context.Background(),
+		time.Duration(c.timeout)*time.Millisecond,
+	)
+}
+
+// startMonitor starts checking the connection in background.
+func (c *Client) startMonitor() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	c.mutex.Lock()
+	c.stopMonitor = stop
+	c.monitorDone = done
+	c.mutex.Unlock()
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(c.reconnect.CheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			ctx, cancel := c.timeoutContext()
+			err := c.Ping(ctx)
+			cancel()
+			if err != nil {
+				c.reconnectLoop(stop)
+			}
+		}
+	}()
+}
+
+// stopMonitoring stops the connection-monitor and waits for it to exit.
+func (c *Client) stopMonitoring() {
+	c.mutex.Lock()
+	stop, done := c.stopMonitor, c.monitorDone
+	c.stopMonitor = nil
+	c.monitorDone = nil
+	c.mutex.Unlock()
+
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
+// reconnectLoop recreates the driver-client with exponential backoff until
+// the server is reachable, or the monitor is stopped.
+func (c *Client) reconnectLoop(stop chan struct{}) {
+	c.setState(StateReconnecting)
+	backoff := c.reconnect.InitialBackoff
+
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if c.tryReconnect() {
+			c.setState(StateConnected)
+			return
+		}
+		backoff *= 2
+		if backoff > c.reconnect.MaxBackoff {
+			backoff = c.reconnect.MaxBackoff
+		}
+	}
+}
+
+// tryReconnect replaces the driver-client with a newly connected one if the
+// server is reachable.
+func (c *Client) tryReconnect() bool {
+	newClient, err := mgo.NewClient(c.connStr)
+	if err != nil {
+		return false
+	}
+	ctx, cancel := c.timeoutContext()
+	defer cancel()
+	err = newClient.Connect(ctx)
+	if err != nil {
+		return false
+	}
+	_, err = runAdminCommand(ctx, newClient, "ping")
+	if err != nil {
+		newClient.Disconnect(ctx)
+		return false
+	}
+
+	c.mutex.Lock()
+	oldClient := c.client
+	c.client = newClient
+	c.generation++
+	c.mutex.Unlock()
+
+	// The operations still using old driver-client will fail
+	oldCtx, oldCancel := c.timeoutContext()
+	oldClient.Disconnect(oldCtx)
+	oldCancel()
+	return true
 }
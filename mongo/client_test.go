@@ -1,10 +1,12 @@
 package mongo
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"sync"
 
 	"github.com/TerrexTech/go-commonutils/commonutil"
 	mgo "github.com/mongodb/mongo-go-driver/mongo"
@@ -91,7 +93,7 @@ var _ = Describe("MongoClient", func() {
 				clientConfig.NoDefaultConnect = true
 				client, err := NewClient(clientConfig)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(client.connected).To(BeFalse())
+				Expect(client.State()).To(Equal(StateDisconnected))
 			},
 		)
 
@@ -100,7 +102,7 @@ var _ = Describe("MongoClient", func() {
 			func() {
 				client, err := NewClient(clientConfig)
 				Expect(err).ToNot(HaveOccurred())
-				Expect(client.connected).To(BeTrue())
+				Expect(client.State()).To(Equal(StateConnected))
 			},
 		)
 
@@ -111,7 +113,7 @@ var _ = Describe("MongoClient", func() {
 
 			err = client.Connect()
 			Expect(err).ToNot(HaveOccurred())
-			Expect(client.connected).To(BeTrue())
+			Expect(client.State()).To(Equal(StateConnected))
 		})
 
 		It("should disconnect from Database when Disconnect is called", func() {
@@ -120,7 +122,7 @@ var _ = Describe("MongoClient", func() {
 
 			err = client.Disconnect()
 			Expect(err).ToNot(HaveOccurred())
-			Expect(client.connected).To(BeFalse())
+			Expect(client.State()).To(Equal(StateDisconnected))
 		})
 
 		It("should return the Database instance when Database is requested", func() {
@@ -147,5 +149,89 @@ var _ = Describe("MongoClient", func() {
 			)
 			Expect(dc.ConnectionString()).To(Equal(expectedConnStr))
 		})
+
+		It("should notify the state-transitions", func() {
+			transitions := []ConnectionState{}
+			clientConfig.OnStateChange = func(from ConnectionState, to ConnectionState) {
+				transitions = append(transitions, to)
+			}
+			client, err := NewClient(clientConfig)
+			Expect(err).ToNot(HaveOccurred())
+			err = client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(transitions).To(Equal([]ConnectionState{
+				StateConnected,
+				StateDisconnected,
+			}))
+		})
+
+		It("should be reusable after Disconnect", func() {
+			client, err := NewClient(clientConfig)
+			Expect(err).ToNot(HaveOccurred())
+			c, err := EnsureCollection(&Collection{
+				Connection: &ConnectionConfig{
+					Client:  client,
+					Timeout: connectionTimeout,
+				},
+				Database:     testDatabase,
+				Name:         "test_collection",
+				SchemaStruct: &struct{ Word string }{},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+			err = client.Connect()
+			Expect(err).ToNot(HaveOccurred())
+			err = client.Ping(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+
+			// The Collection uses the recreated driver-client
+			_, err = c.Find(map[string]interface{}{})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should replace the driver-client on reconnecting", func() {
+			client, err := NewClient(clientConfig)
+			Expect(err).ToNot(HaveOccurred())
+			oldClient, oldGeneration := client.driverClient()
+
+			Expect(client.tryReconnect()).To(BeTrue())
+			newClient, newGeneration := client.driverClient()
+			Expect(newClient).ToNot(BeIdenticalTo(oldClient))
+			Expect(newGeneration).To(Equal(oldGeneration + 1))
+
+			err = client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should be safe for concurrent use", func() {
+			client, err := NewClient(clientConfig)
+			Expect(err).ToNot(HaveOccurred())
+
+			var wg sync.WaitGroup
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					if i%2 == 0 {
+						client.Disconnect()
+					} else {
+						client.Connect()
+					}
+					client.State()
+					client.Database(testDatabase)
+				}(i)
+			}
+			wg.Wait()
+
+			err = client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+		})
 	})
 })
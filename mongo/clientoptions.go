@@ -0,0 +1,193 @@
+package mongo
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The authentication-mechanisms supported in ClientConfig.AuthMechanism.
+const (
+	AuthSCRAMSHA1   = "SCRAM-SHA-1"
+	AuthSCRAMSHA256 = "SCRAM-SHA-256"
+	// AuthX509 authenticates using the TLS client-certificate, so
+	// ClientConfig.TLSCertificateKeyFile is required.
+	AuthX509   = "MONGODB-X509"
+	AuthPLAIN  = "PLAIN"
+	AuthGSSAPI = "GSSAPI"
+)
+
+// The compressors supported in ClientConfig.Compressors.
+const (
+	CompressorSnappy = "snappy"
+	CompressorZlib   = "zlib"
+)
+
+// connectionString builds the connection-string from ClientConfig,
+// with the credentials and options escaped as required.
+func connectionString(config ClientConfig) (string, error) {
+	err := validateClientConfig(config)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{}
+	setUint := func(key string, value uint64) {
+		if value > 0 {
+			query.Set(key, strconv.FormatUint(value, 10))
+		}
+	}
+	setString := func(key string, value string) {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+
+	authSource := config.AuthSource
+	if authSource == "" && config.AuthMechanism == AuthX509 {
+		authSource = "$external"
+	}
+	setString("authSource", authSource)
+	setString("authMechanism", config.AuthMechanism)
+	setString("appName", config.AppName)
+	setString("compressors", strings.Join(config.Compressors, ","))
+
+	// The driver only keeps up to maxPoolSize idle connections in its pool,
+	// while maxConnsPerHost limits the connections opened to each host, so
+	// both are set for MaxPoolSize to bound the connections per host.
+	setUint("maxPoolSize", uint64(config.MaxPoolSize))
+	setUint("maxConnsPerHost", uint64(config.MaxPoolSize))
+	setUint(
+		"serverSelectionTimeoutMS",
+		uint64(config.ServerSelectionTimeoutMilliseconds),
+	)
+
+	if config.TLS {
+		query.Set("ssl", "true")
+		setString("sslCertificateAuthorityFile", config.TLSCAFile)
+		setString("sslClientCertificateKeyFile", config.TLSCertificateKeyFile)
+		setString("sslClientCertificateKeyPassword", config.TLSCertificateKeyPassword)
+		if config.TLSInsecure {
+			query.Set("sslInsecure", "true")
+		}
+	}
+
+	connStr := "mongodb://"
+	if config.Username != "" {
+		connStr += escapeCredential(config.Username)
+		if config.Password != "" {
+			connStr += ":" + escapeCredential(config.Password)
+		}
+		connStr += "@"
+	}
+	connStr += strings.Join(config.Hosts, ",")
+	if len(query) > 0 {
+		connStr += "/?" + query.Encode()
+	}
+	return connStr, nil
+}
+
+// escapeCredential percent-encodes all characters except the unreserved
+// ones, since the characters such as "+" are decoded differently when
+// left unescaped.
+func escapeCredential(credential string) string {
+	escaped := ""
+	for _, b := range []byte(credential) {
+		isUnreserved := (b >= 'a' && b <= 'z') ||
+			(b >= 'A' && b <= 'Z') ||
+			(b >= '0' && b <= '9') ||
+			b == '-' || b == '.' || b == '_' || b == '~'
+		if isUnreserved {
+			escaped += string(b)
+		} else {
+			escaped += fmt.Sprintf("%%%02X", b)
+		}
+	}
+	return escaped
+}
+
+// hostPattern matches the hostnames, IPv4, and IPv6 addresses,
+// with optional ports.
+var hostPattern = regexp.MustCompile(`^([A-Za-z0-9._-]+|\[[0-9A-Fa-f:.]+\])(:[0-9]+)?$`)
+
+// validateClientConfig checks the ClientConfig for invalid
+// combinations of options.
+func validateClientConfig(config ClientConfig) error {
+	if len(config.Hosts) == 0 {
+		return errors.New("ClientConfig.Hosts cannot be empty")
+	}
+	for _, host := range config.Hosts {
+		if !hostPattern.MatchString(host) {
+			return fmt.Errorf("ClientConfig.Hosts contains invalid host: %s", host)
+		}
+	}
+
+	// The minPoolSize option is ignored by the driver in use, so this is
+	// rejected instead of silently having no effect
+	if config.MinPoolSize > 0 {
+		return errors.New(
+			"ClientConfig.MinPoolSize is not supported by the MongoDB driver in use",
+		)
+	}
+
+	switch config.AuthMechanism {
+	case "":
+	case AuthSCRAMSHA1, AuthSCRAMSHA256, AuthPLAIN, AuthGSSAPI:
+		if config.Username == "" {
+			return fmt.Errorf(
+				"ClientConfig.Username is required with AuthMechanism: %s",
+				config.AuthMechanism,
+			)
+		}
+	case AuthX509:
+		if !config.TLS || config.TLSCertificateKeyFile == "" {
+			return errors.New(
+				"ClientConfig.TLS and TLSCertificateKeyFile are required " +
+					"with AuthMechanism: " + AuthX509,
+			)
+		}
+		if config.Password != "" {
+			return errors.New(
+				"ClientConfig.Password cannot be used with AuthMechanism: " + AuthX509,
+			)
+		}
+		if config.AuthSource != "" && config.AuthSource != "$external" {
+			return errors.New(
+				"ClientConfig.AuthSource must be \"$external\" with AuthMechanism: " +
+					AuthX509,
+			)
+		}
+	default:
+		return fmt.Errorf(
+			"ClientConfig.AuthMechanism: %s is not supported", config.AuthMechanism,
+		)
+	}
+
+	if !config.TLS {
+		hasTLSOptions := config.TLSCAFile != "" ||
+			config.TLSCertificateKeyFile != "" ||
+			config.TLSCertificateKeyPassword != "" ||
+			config.TLSInsecure
+		if hasTLSOptions {
+			return errors.New("ClientConfig.TLS must be enabled to use TLS-options")
+		}
+	}
+	if config.TLSCertificateKeyPassword != "" && config.TLSCertificateKeyFile == "" {
+		return errors.New(
+			"ClientConfig.TLSCertificateKeyPassword requires TLSCertificateKeyFile",
+		)
+	}
+
+	for _, compressor := range config.Compressors {
+		if compressor != CompressorSnappy && compressor != CompressorZlib {
+			return fmt.Errorf(
+				"ClientConfig.Compressors: %s is not supported", compressor,
+			)
+		}
+	}
+	return nil
+}
@@ -1,11 +1,15 @@
 package mongo
 
 import (
-	"fmt"
+	"context"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/replaceopt"
+	"github.com/mongodb/mongo-go-driver/mongo/updateopt"
 
 	"github.com/pkg/errors"
 
@@ -17,6 +21,8 @@ import (
 type ConnectionConfig struct {
 	Client  *Client
 	Timeout uint32
+	// RetryPolicy overrides the Client's RetryPolicy, if set.
+	RetryPolicy *RetryPolicy
 }
 
 // IndexColumnConfig defines configuration for
@@ -32,6 +38,28 @@ type IndexConfig struct {
 	ColumnConfig []IndexColumnConfig
 	IsUnique     bool
 	Name         string
+	// ExpireAfterSeconds creates a TTL-index when set to a positive value.
+	// The indexed column must hold a date for the documents to expire.
+	ExpireAfterSeconds int32
+}
+
+// CollectionOptions defines the options for creating a collection.
+// See: https://docs.mongodb.com/manual/reference/method/db.createCollection/
+type CollectionOptions struct {
+	// Capped creates a fixed-size collection. SizeBytes is required for
+	// capped collections.
+	Capped       bool
+	SizeBytes    int64
+	MaxDocuments int64
+	// Validator is the query-document (such as a "$jsonSchema") that the
+	// documents must match when inserted or updated.
+	Validator interface{}
+	// ValidationLevel is "off", "strict", or "moderate".
+	// Defaults to "strict" on server.
+	ValidationLevel string
+	// ValidationAction is "error" or "warn".
+	// Defaults to "error" on server.
+	ValidationAction string
 }
 
 // Collection represents the MongoDB collection.
@@ -40,16 +68,93 @@ type Collection struct {
 	Database   string
 	Name       string
 	// Indexes to be created when creating collection
-	Indexes      []IndexConfig
+	Indexes []IndexConfig
+	// Options for creating the collection. These are only applied if
+	// the collection does not exist.
+	Options      *CollectionOptions
 	SchemaStruct interface{}
-	collection   *mgo.Collection
+	// OptimisticLocking requires the updates and replacements to specify
+	// the expected value of the SchemaStruct field tagged as "version".
+	// ErrVersionConflict is returned if no document matches the expected
+	// version, which indicates a concurrent modification.
+	OptimisticLocking bool
+	// Middlewares to run around every operation, see Collection.Use.
+	Middlewares []Middleware
+	// Auditor records the changes made to documents, if set.
+	Auditor *Auditor
+	// KeyProvider provides the keys for encrypting the SchemaStruct fields
+	// tagged as "encrypt". This is required if there are any such fields.
+	KeyProvider KeyProvider
+	// SchemaUpgrades upgrades the documents with older schema-versions when
+	// read. This requires a SchemaStruct field tagged as "schemaVersion".
+	SchemaUpgrades *SchemaUpgrades
+	// driver holds the driver-collection, and is shared by the
+	// Collection's copies
+	driver *driverCollection
+	// Information from struct-tags on SchemaStruct
+	tags      *schemaTags
+	encryptor *fieldEncryptor
+	upgrades  map[int64]UpgradeFunc
+	// Context set using WithContext
+	ctx context.Context
+}
+
+// CollectionAPI is the interface of Collection's operations. The code that
+// accepts a CollectionAPI rather than a *Collection can be tested using the
+// in-memory implementation from memstore-package.
+type CollectionAPI interface {
+	Aggregate(pipeline interface{}) ([]interface{}, error)
+	DeleteMany(filter interface{}) (*mgo.DeleteResult, error)
+	Find(filter interface{}, opts ...findopt.Find) ([]interface{}, error)
+	FindOne(filter interface{}, opts ...findopt.One) (interface{}, error)
+	InsertMany(data []interface{}) (*[]mgo.InsertOneResult, error)
+	InsertOne(data interface{}) (*mgo.InsertOneResult, error)
+	ReplaceOne(
+		filter interface{},
+		replacement interface{},
+		opts ...replaceopt.Replace,
+	) (*mgo.UpdateResult, error)
+	UpdateMany(
+		filter interface{},
+		update interface{},
+		opts ...updateopt.Update,
+	) (*mgo.UpdateResult, error)
 }
 
+var _ CollectionAPI = &Collection{}
+
 // Collection returns the embedded Mongo-Go-Driver Collection.
 // Use this only when absolutely required,
 // and prefer inbuilt functions over functions from this.
 func (c *Collection) Collection() *mgo.Collection {
-	return c.collection
+	if c.driver == nil {
+		return nil
+	}
+	return c.driver.get(c.Connection.Client, c.Database, c.Name)
+}
+
+// driverCollection caches the driver-collection for a Collection, and
+// recreates it when the Client's driver-client is recreated.
+type driverCollection struct {
+	mutex      sync.Mutex
+	generation uint64
+	collection *mgo.Collection
+}
+
+func (d *driverCollection) get(
+	client *Client,
+	database string,
+	name string,
+) *mgo.Collection {
+	mgoClient, generation := client.driverClient()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.collection == nil || d.generation != generation {
+		d.collection = mgoClient.Database(database).Collection(name)
+		d.generation = generation
+	}
+	return d.collection
 }
 
 // verifyDataSchema checks if the provided data's schema matches the
@@ -73,11 +178,10 @@ func (c *Collection) verifyDataSchema(data interface{}) error {
 	expectedType := reflect.TypeOf(c.SchemaStruct).String()
 
 	if dataType != expectedType {
-		return fmt.Errorf(
-			"Mismatch between provided data-schema and expected schema. " +
-				"Consider changing collection.SchemaStruct if required. " +
-				"A map[string]interface{} (pointer or non-pointer) can also be used as parameter.",
-		)
+		return &SchemaMismatchError{
+			Expected: expectedType,
+			Actual:   dataType,
+		}
 	}
 	return nil
 }
@@ -86,19 +190,37 @@ func (c *Collection) verifyDataSchema(data interface{}) error {
 // The filter-data must match the schema provided at the time of Collection-
 // creation. Update the Collection.SchemaStruct if new schema is required.
 func (c *Collection) DeleteMany(filter interface{}) (*mgo.DeleteResult, error) {
-	err := c.verifyDataSchema(filter)
+	op := &Operation{
+		Name:   OpDeleteMany,
+		Filter: filter,
+	}
+	result, err := c.run(op, c.deleteMany)
+	r, _ := result.(*mgo.DeleteResult)
+	return r, err
+}
+
+func (c *Collection) deleteMany(op *Operation) (interface{}, error) {
+	err := c.verifyDataSchema(op.Filter)
 	if err != nil {
 		return nil, errors.Wrap(err, "DeleteMany - Schema Verification Error")
 	}
-	doc, err := toBSON(filter)
+	doc, err := toBSON(op.Filter)
 	if err != nil {
 		return nil, errors.Wrap(err, "DeleteMany - BSON Convert Error")
 	}
+	err = c.encryptor.encryptFilter(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "DeleteMany - Encryption Error")
+	}
 
-	ctx, cancel := newTimeoutContext(c.Connection.Timeout)
+	if c.Auditor != nil {
+		return c.auditedDeleteMany(op, doc)
+	}
+
+	ctx, cancel := newOpTimeoutContext(op, c.Connection.Timeout)
 	defer cancel()
 
-	result, err := c.collection.DeleteMany(ctx, doc)
+	result, err := c.Collection().DeleteMany(ctx, doc)
 	if err != nil {
 		err = errors.Wrap(err, "Deletion Error")
 	}
@@ -121,17 +243,34 @@ func (c *Collection) Find(
 	filter interface{},
 	opts ...findopt.Find,
 ) ([]interface{}, error) {
-	err := c.verifyDataSchema(filter)
+	op := &Operation{
+		Name:    OpFind,
+		Filter:  filter,
+		Options: opts,
+	}
+	result, err := c.run(op, c.find)
+	r, _ := result.([]interface{})
+	return r, err
+}
+
+func (c *Collection) find(op *Operation) (interface{}, error) {
+	opts, _ := op.Options.([]findopt.Find)
+
+	err := c.verifyDataSchema(op.Filter)
 	if err != nil {
 		return nil, errors.Wrap(err, "Find - Schema Verification Error")
 	}
-	doc, err := toBSON(filter)
+	doc, err := toBSON(op.Filter)
 	if err != nil {
 		return nil, errors.Wrap(err, "Find - BSON Convert Error")
 	}
+	err = c.encryptor.encryptFilter(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "Find - Encryption Error")
+	}
 
-	findCtx, findCancel := newTimeoutContext(c.Connection.Timeout)
-	cur, err := c.collection.Find(findCtx, doc, opts...)
+	findCtx, findCancel := newOpTimeoutContext(op, c.Connection.Timeout)
+	cur, err := c.Collection().Find(findCtx, doc, opts...)
 	if err != nil {
 		findCancel()
 		return nil, errors.Wrap(err, "Find Error")
@@ -139,10 +278,10 @@ func (c *Collection) Find(
 	findCancel()
 
 	items := make([]interface{}, 0)
-	cursorCtx, cursorCancel := newTimeoutContext(c.Connection.Timeout)
+	cursorCtx, cursorCancel := newOpTimeoutContext(op, c.Connection.Timeout)
 	for cur.Next(cursorCtx) {
 		item := copyInterface(c.SchemaStruct)
-		err := cur.Decode(item)
+		err := c.decode(op, cur.Decode, item)
 		if err != nil {
 			cursorCancel()
 			return nil, errors.Wrap(err, "Find - Cursor Decode Error")
@@ -151,7 +290,9 @@ func (c *Collection) Find(
 	}
 	cursorCancel()
 
-	cursorCloseCtx, cursorCloseCancel := newTimeoutContext(c.Connection.Timeout)
+	cursorCloseCtx, cursorCloseCancel := newOpTimeoutContext(
+		op, c.Connection.Timeout,
+	)
 	defer cursorCloseCancel()
 	err = cur.Close(cursorCloseCtx)
 	if err != nil {
@@ -167,19 +308,38 @@ func (c *Collection) FindOne(
 	filter interface{},
 	opts ...findopt.One,
 ) (interface{}, error) {
-	err := c.verifyDataSchema(filter)
+	op := &Operation{
+		Name:    OpFindOne,
+		Filter:  filter,
+		Options: opts,
+	}
+	return c.run(op, c.findOne)
+}
+
+func (c *Collection) findOne(op *Operation) (interface{}, error) {
+	opts, _ := op.Options.([]findopt.One)
+
+	err := c.verifyDataSchema(op.Filter)
 	if err != nil {
 		return nil, errors.Wrap(err, "Find - Schema Verification Error")
 	}
-	doc, err := toBSON(filter)
+	doc, err := toBSON(op.Filter)
 	if err != nil {
 		return nil, errors.Wrap(err, "Find - BSON Convert Error")
 	}
+	err = c.encryptor.encryptFilter(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "Find - Encryption Error")
+	}
 
-	findCtx, findCancel := newTimeoutContext(c.Connection.Timeout)
+	findCtx, findCancel := newOpTimeoutContext(op, c.Connection.Timeout)
 
 	result := copyInterface(c.SchemaStruct)
-	err = c.collection.FindOne(findCtx, doc, opts...).Decode(result)
+	err = c.decode(
+		op,
+		c.Collection().FindOne(findCtx, doc, opts...).Decode,
+		result,
+	)
 	if err != nil {
 		findCancel()
 		return nil, errors.Wrap(err, "FindOne Decoding Error")
@@ -193,6 +353,21 @@ func (c *Collection) FindOne(
 // The data must match the schema provided at the time of Collection-
 // creation. Update the Collection.SchemaStruct if new schema is required.
 func (c *Collection) InsertOne(data interface{}) (*mgo.InsertOneResult, error) {
+	op := &Operation{
+		Name:      OpInsertOne,
+		Documents: []interface{}{data},
+	}
+	result, err := c.run(op, c.insertOne)
+	r, _ := result.(*mgo.InsertOneResult)
+	return r, err
+}
+
+func (c *Collection) insertOne(op *Operation) (interface{}, error) {
+	if len(op.Documents) != 1 {
+		return nil, errors.New("InsertOne - Exactly one document must be provided")
+	}
+	data := op.Documents[0]
+
 	err := c.verifyDataSchema(data)
 	if err != nil {
 		return nil, errors.Wrap(err, "InsertOne - Schema Verification Error")
@@ -201,13 +376,24 @@ func (c *Collection) InsertOne(data interface{}) (*mgo.InsertOneResult, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "InsertOne - BSON Convert Error")
 	}
+	now := time.Now()
+	c.tags.applyInsertTags(doc, now)
+	err = c.encryptor.encryptDocument(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "InsertOne - Encryption Error")
+	}
 
-	ctx, cancel := newTimeoutContext(c.Connection.Timeout)
+	ctx, cancel := newOpTimeoutContext(op, c.Connection.Timeout)
 	defer cancel()
 
-	result, err := c.collection.InsertOne(ctx, doc)
+	result, err := c.Collection().InsertOne(ctx, doc)
 	if err != nil {
 		err = errors.Wrap(err, "InsertOne Error")
+		return result, err
+	}
+	c.tags.setInsertedFields(data, doc, now)
+	if c.Auditor != nil {
+		err = c.auditInsert(op, doc, result)
 	}
 	return result, err
 }
@@ -228,16 +414,33 @@ func (c *Collection) InsertMany(
 		)
 	}
 
+	op := &Operation{
+		Name:      OpInsertMany,
+		Documents: data,
+	}
+	result, err := c.run(op, c.insertMany)
+	r, _ := result.(*[]mgo.InsertOneResult)
+	return r, err
+}
+
+func (c *Collection) insertMany(op *Operation) (interface{}, error) {
 	insertResults := []mgo.InsertOneResult{}
-	for i, d := range data {
-		result, err := c.InsertOne(d)
+	for i, d := range op.Documents {
+		// The Middlewares already ran for InsertMany,
+		// so the documents are inserted directly.
+		result, err := c.insertOne(&Operation{
+			Name:       OpInsertOne,
+			Collection: c,
+			Context:    op.Context,
+			Documents:  []interface{}{d},
+		})
 		if err != nil {
 			return nil, errors.Wrapf(
 				err,
 				"InsertMany - Error Inserting Data at Index: %d", i,
 			)
 		}
-		insertResults = append(insertResults, *result)
+		insertResults = append(insertResults, *result.(*mgo.InsertOneResult))
 	}
 	return &insertResults, nil
 }
@@ -245,58 +448,213 @@ func (c *Collection) InsertMany(
 // UpdateMany updates multiple documents in the collection.
 // A map or a struct can be supplied as filter-data or update-data,
 // both are transformed into BSON using bson#NewDocumentEncoder#EncodeDocument.
+// If the SchemaStruct has fields tagged as "updatedAt" or "version", these are
+// updated automatically. The "createdAt" field is set if the update results
+// in an upsert.
 func (c *Collection) UpdateMany(
 	filter interface{},
 	update interface{},
+	opts ...updateopt.Update,
 ) (*mgo.UpdateResult, error) {
-	isValidFilter := verifyKind(filter, reflect.Map, reflect.Struct)
+	op := &Operation{
+		Name:    OpUpdateMany,
+		Filter:  filter,
+		Update:  update,
+		Options: opts,
+	}
+	result, err := c.run(op, c.updateMany)
+	r, _ := result.(*mgo.UpdateResult)
+	return r, err
+}
+
+func (c *Collection) updateMany(op *Operation) (interface{}, error) {
+	opts, _ := op.Options.([]updateopt.Update)
+
+	isValidFilter := verifyKind(op.Filter, reflect.Map, reflect.Struct)
 	if !isValidFilter {
 		return nil, errors.New(
 			"UpdateMany - Filter-argument must be a Map or Struct " +
 				"(pointer or non-pointer)",
 		)
 	}
-	isValidUpdate := verifyKind(update, reflect.Map)
+	isValidUpdate := verifyKind(op.Update, reflect.Map)
 	if !isValidUpdate {
 		return nil, errors.New(
 			"UpdateMany - Update-argument must be a Map (pointer or non-pointer)",
 		)
 	}
 
-	encodedUpdate := &map[string]interface{}{
-		"$set": update,
-	}
-
-	updateDoc, err := toBSON(encodedUpdate)
+	setDoc, err := toBSON(op.Update)
 	if err != nil {
 		return nil, errors.Wrap(
 			err,
 			"UpdateMany - BSON Convert Error for update-argument",
 		)
 	}
-	filterDoc, err := toBSON(filter)
+	filterDoc, err := toBSON(op.Filter)
 	if err != nil {
 		return nil, errors.Wrap(
 			err,
 			"UpdateMany - BSON Convert Error for filter-argument",
 		)
 	}
+	err = c.encryptor.encryptFilter(filterDoc)
+	if err != nil {
+		return nil, errors.Wrap(err, "UpdateMany - Encryption Error")
+	}
+	err = c.encryptor.encryptDocument(setDoc)
+	if err != nil {
+		return nil, errors.Wrap(err, "UpdateMany - Encryption Error")
+	}
+	if c.OptimisticLocking {
+		err = c.tags.verifyVersionedUpdate(filterDoc, setDoc)
+		if err != nil {
+			return nil, errors.Wrap(err, "UpdateMany - Optimistic Locking Error")
+		}
+	}
+	updateDoc := c.tags.updateDocument(setDoc, time.Now())
+
+	var result *mgo.UpdateResult
+	if c.Auditor != nil {
+		if len(opts) > 0 {
+			return nil, errors.New(
+				"UpdateMany - Options are not supported with Auditor",
+			)
+		}
+		result, err = c.auditedUpdateMany(op, filterDoc, updateDoc)
+	} else {
+		ctx, cancel := newOpTimeoutContext(op, c.Connection.Timeout)
+		result, err = c.Collection().UpdateMany(ctx, filterDoc, updateDoc, opts...)
+		cancel()
+		if err != nil {
+			err = errors.Wrap(err, "UpdateMany Error")
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+	if c.OptimisticLocking && result.MatchedCount == 0 {
+		return result, ErrVersionConflict
+	}
+	return result, nil
+}
+
+// ReplaceOne replaces a single document matching the filter.
+// The replacement must match the schema provided at the time of Collection-
+// creation. Update the Collection.SchemaStruct if new schema is required.
+// If the SchemaStruct has fields tagged as "updatedAt" or "version", these are
+// updated automatically.
+// With OptimisticLocking, the version in replacement is used as the expected
+// version of the document to replace, and ErrVersionConflict is returned if
+// the stored document has a different version.
+func (c *Collection) ReplaceOne(
+	filter interface{},
+	replacement interface{},
+	opts ...replaceopt.Replace,
+) (*mgo.UpdateResult, error) {
+	op := &Operation{
+		Name:    OpReplaceOne,
+		Filter:  filter,
+		Update:  replacement,
+		Options: opts,
+	}
+	result, err := c.run(op, c.replaceOne)
+	r, _ := result.(*mgo.UpdateResult)
+	return r, err
+}
+
+func (c *Collection) replaceOne(op *Operation) (interface{}, error) {
+	opts, _ := op.Options.([]replaceopt.Replace)
+
+	isValidFilter := verifyKind(op.Filter, reflect.Map, reflect.Struct)
+	if !isValidFilter {
+		return nil, errors.New(
+			"ReplaceOne - Filter-argument must be a Map or Struct " +
+				"(pointer or non-pointer)",
+		)
+	}
+	err := c.verifyDataSchema(op.Update)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReplaceOne - Schema Verification Error")
+	}
 
-	ctx, cancel := newTimeoutContext(c.Connection.Timeout)
-	defer cancel()
+	filterDoc, err := toBSON(op.Filter)
+	if err != nil {
+		return nil, errors.Wrap(
+			err,
+			"ReplaceOne - BSON Convert Error for filter-argument",
+		)
+	}
+	replacementDoc, err := toBSON(op.Update)
+	if err != nil {
+		return nil, errors.Wrap(
+			err,
+			"ReplaceOne - BSON Convert Error for replacement-argument",
+		)
+	}
+	// The _id of existing document cannot be changed
+	replacementDoc.Delete("_id")
 
-	result, err := c.collection.UpdateMany(ctx, filterDoc, updateDoc)
+	if c.OptimisticLocking {
+		err = c.tags.applyExpectedVersion(filterDoc, replacementDoc)
+		if err != nil {
+			return nil, errors.Wrap(err, "ReplaceOne - Optimistic Locking Error")
+		}
+	}
+	now := time.Now()
+	c.tags.applyReplaceTags(replacementDoc, now)
+	err = c.encryptor.encryptFilter(filterDoc)
 	if err != nil {
-		err = errors.Wrap(err, "UpdateMany Error")
+		return nil, errors.Wrap(err, "ReplaceOne - Encryption Error")
 	}
-	return result, err
+	err = c.encryptor.encryptDocument(replacementDoc)
+	if err != nil {
+		return nil, errors.Wrap(err, "ReplaceOne - Encryption Error")
+	}
+
+	var result *mgo.UpdateResult
+	if c.Auditor != nil {
+		if len(opts) > 0 {
+			return nil, errors.New(
+				"ReplaceOne - Options are not supported with Auditor",
+			)
+		}
+		result, err = c.auditedReplaceOne(op, filterDoc, replacementDoc)
+	} else {
+		ctx, cancel := newOpTimeoutContext(op, c.Connection.Timeout)
+		result, err = c.Collection().ReplaceOne(
+			ctx, filterDoc, replacementDoc, opts...,
+		)
+		cancel()
+		if err != nil {
+			err = errors.Wrap(err, "ReplaceOne Error")
+		}
+	}
+	if err != nil {
+		return result, err
+	}
+	if c.OptimisticLocking && result.MatchedCount == 0 {
+		return result, ErrVersionConflict
+	}
+	c.tags.setReplacedFields(op.Update, replacementDoc, now)
+	return result, nil
 }
 
 // Aggregate runs an aggregation framework pipeline
 // See https://docs.mongodb.com/manual/aggregation/.
 func (c *Collection) Aggregate(pipeline interface{}) ([]interface{}, error) {
-	aggCtx, aggCancel := newTimeoutContext(c.Connection.Timeout)
-	cur, err := c.collection.Aggregate(aggCtx, pipeline)
+	op := &Operation{
+		Name:     OpAggregate,
+		Pipeline: pipeline,
+	}
+	result, err := c.run(op, c.aggregate)
+	r, _ := result.([]interface{})
+	return r, err
+}
+
+func (c *Collection) aggregate(op *Operation) (interface{}, error) {
+	aggCtx, aggCancel := newOpTimeoutContext(op, c.Connection.Timeout)
+	cur, err := c.Collection().Aggregate(aggCtx, op.Pipeline)
 	aggCancel()
 
 	if err != nil {
@@ -305,10 +663,10 @@ func (c *Collection) Aggregate(pipeline interface{}) ([]interface{}, error) {
 	}
 
 	items := make([]interface{}, 0)
-	curCtx, curCancel := newTimeoutContext(c.Connection.Timeout)
+	curCtx, curCancel := newOpTimeoutContext(op, c.Connection.Timeout)
 	for cur.Next(curCtx) {
 		item := map[string]interface{}{}
-		err := cur.Decode(item)
+		err := c.encryptor.decode(cur.Decode, item)
 		if err != nil {
 			curCancel()
 			return nil, errors.Wrap(err, "Aggregate - Cursor Decode Error")
@@ -317,7 +675,9 @@ func (c *Collection) Aggregate(pipeline interface{}) ([]interface{}, error) {
 	}
 	curCancel()
 
-	cursorCloseCtx, cursorCloseCancel := newTimeoutContext(c.Connection.Timeout)
+	cursorCloseCtx, cursorCloseCancel := newOpTimeoutContext(
+		op, c.Connection.Timeout,
+	)
 	defer cursorCloseCancel()
 	err = cur.Close(cursorCloseCtx)
 	if err != nil {
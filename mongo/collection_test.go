@@ -780,6 +780,179 @@ var _ = Describe("MongoCollection", func() {
 		})
 	})
 
+	Describe("ReplaceOne", func() {
+		It("should replace the document matching the filter", func() {
+			_, err := c.InsertOne(&item{
+				Word:       "some-word",
+				Definition: "some-definition1",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			result, err := c.ReplaceOne(
+				&item{
+					Word: "some-word",
+				},
+				&item{
+					Word:       "some-word",
+					Definition: "replaced-definition",
+					Hits:       3,
+				},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.MatchedCount).To(Equal(int64(1)))
+
+			found, err := c.FindOne(&item{
+				Word: "some-word",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found.(*item).Definition).To(Equal("replaced-definition"))
+			Expect(found.(*item).Hits).To(Equal(3))
+		})
+
+		It("should throw error if replacement-schema and collection-schema mismatch", func() {
+			data := struct {
+				Mismatch string
+			}{
+				Mismatch: "yup",
+			}
+			_, err := c.ReplaceOne(&item{Word: "some-word"}, data)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("OptimisticLocking", func() {
+		type versionedItem struct {
+			ID      objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+			Word    string            `bson:"word,omitempty" json:"word,omitempty"`
+			Hits    int               `bson:"hits,omitempty" json:"hits,omitempty"`
+			Version int64             `bson:"version,omitempty" mongoutils:"version"`
+		}
+
+		var vc *Collection
+
+		BeforeEach(func() {
+			var err error
+			vc, err = EnsureCollection(&Collection{
+				Connection:        c.Connection,
+				Database:          testDatabase,
+				Name:              "test_versioned_collection",
+				SchemaStruct:      &versionedItem{},
+				OptimisticLocking: true,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			data := &versionedItem{
+				Word: "some-word",
+			}
+			_, err = vc.InsertOne(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data.Version).To(Equal(int64(1)))
+		})
+
+		It("should not set the version on struct if insert fails", func() {
+			data := &versionedItem{
+				Word: "other-word",
+			}
+			_, err := vc.InsertOne(data)
+			Expect(err).ToNot(HaveOccurred())
+
+			found, err := vc.FindOne(&versionedItem{Word: "other-word"})
+			Expect(err).ToNot(HaveOccurred())
+
+			duplicate := &versionedItem{
+				ID:   found.(*versionedItem).ID,
+				Word: "duplicate-word",
+			}
+			_, err = vc.InsertOne(duplicate)
+			_, isDuplicate := IsDuplicateKey(err)
+			Expect(isDuplicate).To(BeTrue())
+			Expect(duplicate.Version).To(Equal(int64(0)))
+		})
+
+		It("should return error if SchemaStruct has no version-field", func() {
+			_, err := EnsureCollection(&Collection{
+				Connection:        c.Connection,
+				Database:          testDatabase,
+				Name:              "test_collection",
+				SchemaStruct:      &item{},
+				OptimisticLocking: true,
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should update and increment version if expected version matches", func() {
+			result, err := vc.UpdateMany(
+				&versionedItem{
+					Word:    "some-word",
+					Version: 1,
+				},
+				map[string]interface{}{
+					"hits": 4,
+				},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.ModifiedCount).To(Equal(int64(1)))
+
+			found, err := vc.FindOne(&versionedItem{
+				Word: "some-word",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found.(*versionedItem).Version).To(Equal(int64(2)))
+		})
+
+		It("should return ErrVersionConflict if expected version mismatches", func() {
+			_, err := vc.UpdateMany(
+				&versionedItem{
+					Word:    "some-word",
+					Version: 3,
+				},
+				map[string]interface{}{
+					"hits": 4,
+				},
+			)
+			Expect(err).To(Equal(ErrVersionConflict))
+		})
+
+		It("should return error if filter does not specify the version", func() {
+			_, err := vc.UpdateMany(
+				&versionedItem{
+					Word: "some-word",
+				},
+				map[string]interface{}{
+					"hits": 4,
+				},
+			)
+			Expect(err).To(HaveOccurred())
+			Expect(err).ToNot(Equal(ErrVersionConflict))
+		})
+
+		It("should detect conflicting replacements", func() {
+			found, err := vc.FindOne(&versionedItem{
+				Word: "some-word",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			first := found.(*versionedItem)
+			second := *first
+
+			first.Hits = 5
+			_, err = vc.ReplaceOne(&versionedItem{ID: first.ID}, first)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(first.Version).To(Equal(int64(2)))
+
+			second.Hits = 6
+			_, err = vc.ReplaceOne(&versionedItem{ID: second.ID}, &second)
+			Expect(err).To(Equal(ErrVersionConflict))
+			// The failed replacement does not change the struct
+			Expect(second.Version).To(Equal(int64(1)))
+
+			// Retrying with the current version succeeds
+			second.Version = first.Version
+			_, err = vc.ReplaceOne(&versionedItem{ID: second.ID}, &second)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(second.Version).To(Equal(int64(3)))
+		})
+	})
+
 	Describe("Aggregate", func() {
 		It("should run the specified aggregate pipeline", func() {
 			data1 := item{
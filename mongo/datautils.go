@@ -16,6 +16,15 @@ func newTimeoutContext(timeout uint32) (ctx.Context, ctx.CancelFunc) {
 	)
 }
 
+// newOpTimeoutContext creates a new WithTimeout context with specified
+// timeout, derived from the Operation's context.
+func newOpTimeoutContext(op *Operation, timeout uint32) (ctx.Context, ctx.CancelFunc) {
+	return ctx.WithTimeout(
+		op.Context,
+		time.Duration(timeout)*time.Millisecond,
+	)
+}
+
 // toBSON tries to convert a given interface{} to bson-document.
 // If the interface{} contains the zero-ObjectID:
 //  ObjectID("000000000000000000000000")
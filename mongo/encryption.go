@@ -0,0 +1,352 @@
+package mongo
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// The options in TagName struct-tags for encrypting fields.
+const (
+	// TagEncrypt marks a field to be encrypted before being stored,
+	// and decrypted when read. By default, the encryption is randomized,
+	// so the field cannot be used in filters.
+	TagEncrypt = "encrypt"
+	// TagDeterministic makes the encryption of a TagEncrypt field
+	// deterministic, so the same value always encrypts to the same
+	// ciphertext. This allows equality-filters on the field, at the cost
+	// of revealing which documents have equal values.
+	TagDeterministic = "deterministic"
+)
+
+// encryptedSubtype is the BSON binary-subtype of encrypted values.
+const encryptedSubtype byte = 0x80
+
+const (
+	encryptionFormatVersion byte = 1
+	modeRandomized          byte = 0
+	modeDeterministic       byte = 1
+	nonceSize                    = 12
+)
+
+// KeyProvider provides the keys for field-encryption. The keys must be
+// 32 bytes long (AES-256). The ID of the key used for encrypting a value
+// is stored along with the value, so the keys can be rotated by changing
+// CurrentKeyID while retaining the older keys for decryption.
+// Rotating keys changes the ciphertexts of deterministic fields, so the
+// documents encrypted with older keys will not match equality-filters.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of key to be used for encrypting values.
+	CurrentKeyID() (string, error)
+	// Key returns the key with specified ID.
+	Key(keyID string) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider with a fixed set of keys.
+type StaticKeyProvider struct {
+	CurrentID string
+	Keys      map[string][]byte
+}
+
+// CurrentKeyID returns the StaticKeyProvider.CurrentID.
+func (p *StaticKeyProvider) CurrentKeyID() (string, error) {
+	if p.CurrentID == "" {
+		return "", errors.New("StaticKeyProvider - CurrentID cannot be blank")
+	}
+	return p.CurrentID, nil
+}
+
+// Key returns the key with specified ID.
+func (p *StaticKeyProvider) Key(keyID string) ([]byte, error) {
+	key, exists := p.Keys[keyID]
+	if !exists {
+		return nil, fmt.Errorf("StaticKeyProvider - Key not found: %s", keyID)
+	}
+	return key, nil
+}
+
+// fieldEncryptor encrypts and decrypts the tagged fields of documents.
+type fieldEncryptor struct {
+	provider KeyProvider
+	// fields maps the BSON-key of encrypted fields to whether the
+	// encryption is deterministic.
+	fields map[string]bool
+}
+
+// deriveKeys derives separate keys for encryption and synthetic-nonces
+// from the provided key.
+func deriveKeys(key []byte) (encKey []byte, nonceKey []byte, err error) {
+	if len(key) != 32 {
+		return nil, nil, errors.New("Encryption-key must be 32 bytes long")
+	}
+	derive := func(label string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(label))
+		return mac.Sum(nil)
+	}
+	return derive("mongoutils-encryption"), derive("mongoutils-nonce"), nil
+}
+
+// encryptElement encrypts the element, and returns an element with same key
+// holding the ciphertext as BSON binary.
+func (e *fieldEncryptor) encryptElement(
+	elem *bson.Element,
+	deterministic bool,
+) (*bson.Element, error) {
+	plaintext, err := bson.NewDocument(elem).MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := e.provider.CurrentKeyID()
+	if err != nil {
+		return nil, err
+	}
+	key, err := e.provider.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	encKey, nonceKey, err := deriveKeys(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyID) > 255 {
+		return nil, errors.New("Key-ID must not be longer than 255 bytes")
+	}
+
+	mode := modeRandomized
+	nonce := make([]byte, nonceSize)
+	if deterministic {
+		mode = modeDeterministic
+		// Synthetic nonce, so same plaintexts produce same ciphertexts
+		mac := hmac.New(sha256.New, nonceKey)
+		mac.Write([]byte(elem.Key()))
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else {
+		_, err = io.ReadFull(rand.Reader, nonce)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	gcm, err := newGCM(encKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := []byte{encryptionFormatVersion, mode, byte(len(keyID))}
+	header = append(header, keyID...)
+	header = append(header, nonce...)
+	// The field-key is authenticated so ciphertexts cannot be
+	// swapped between fields.
+	ciphertext := gcm.Seal(header, nonce, plaintext, []byte(elem.Key()))
+
+	return bson.EC.BinaryWithSubtype(elem.Key(), ciphertext, encryptedSubtype), nil
+}
+
+// decryptElement decrypts the element encrypted using encryptElement.
+func (e *fieldEncryptor) decryptElement(elem *bson.Element) (*bson.Element, error) {
+	subtype, data := elem.Value().Binary()
+	if subtype != encryptedSubtype {
+		return nil, errors.New("Value is not encrypted")
+	}
+	if len(data) < 3 || data[0] != encryptionFormatVersion {
+		return nil, errors.New("Unsupported encryption-format")
+	}
+	keyIDLen := int(data[2])
+	if len(data) < 3+keyIDLen+nonceSize {
+		return nil, errors.New("Encrypted value is truncated")
+	}
+	keyID := string(data[3 : 3+keyIDLen])
+	nonce := data[3+keyIDLen : 3+keyIDLen+nonceSize]
+	ciphertext := data[3+keyIDLen+nonceSize:]
+
+	key, err := e.provider.Key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	encKey, _, err := deriveKeys(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(encKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(elem.Key()))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Authenticating Encrypted Value")
+	}
+
+	doc, err := bson.ReadDocument(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return doc.ElementAt(0), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptDocument encrypts the tagged fields in document.
+func (e *fieldEncryptor) encryptDocument(doc *bson.Document) error {
+	if e == nil {
+		return nil
+	}
+	for key, deterministic := range e.fields {
+		elem := doc.LookupElement(key)
+		if elem == nil || elem.Value().Type() == bson.TypeNull {
+			continue
+		}
+		encrypted, err := e.encryptElement(elem, deterministic)
+		if err != nil {
+			return errors.Wrapf(err, "Error Encrypting Field: %s", key)
+		}
+		doc.Set(encrypted)
+	}
+	return nil
+}
+
+// decryptDocument decrypts the tagged fields in document.
+func (e *fieldEncryptor) decryptDocument(doc *bson.Document) error {
+	if e == nil {
+		return nil
+	}
+	for key := range e.fields {
+		elem := doc.LookupElement(key)
+		if elem == nil || elem.Value().Type() != bson.TypeBinary {
+			continue
+		}
+		// Values stored before enabling encryption are left as is
+		if subtype, _ := elem.Value().Binary(); subtype != encryptedSubtype {
+			continue
+		}
+		decrypted, err := e.decryptElement(elem)
+		if err != nil {
+			return errors.Wrapf(err, "Error Decrypting Field: %s", key)
+		}
+		doc.Set(decrypted)
+	}
+	return nil
+}
+
+// encryptFilter encrypts the values of deterministic fields in filter, so
+// these can be matched against stored ciphertexts. Only the equality
+// comparisons are supported, as direct values or using $eq, $ne, $in, $nin.
+func (e *fieldEncryptor) encryptFilter(filter *bson.Document) error {
+	if e == nil {
+		return nil
+	}
+	for key, deterministic := range e.fields {
+		elem := filter.LookupElement(key)
+		if elem == nil {
+			continue
+		}
+		if !deterministic {
+			return fmt.Errorf(
+				"Field: %s with randomized encryption cannot be used in filters", key,
+			)
+		}
+
+		if elem.Value().Type() != bson.TypeEmbeddedDocument {
+			encrypted, err := e.encryptElement(elem, true)
+			if err != nil {
+				return errors.Wrapf(err, "Error Encrypting Filter-Field: %s", key)
+			}
+			filter.Set(encrypted)
+			continue
+		}
+
+		err := e.encryptOperators(key, elem.Value().MutableDocument())
+		if err != nil {
+			return errors.Wrapf(err, "Error Encrypting Filter-Field: %s", key)
+		}
+	}
+	return nil
+}
+
+// encryptOperators encrypts the operands of equality-operators on the field.
+func (e *fieldEncryptor) encryptOperators(key string, ops *bson.Document) error {
+	opElems := []*bson.Element{}
+	iter := ops.Iterator()
+	for iter.Next() {
+		opElems = append(opElems, iter.Element())
+	}
+	if iter.Err() != nil {
+		return iter.Err()
+	}
+
+	for _, opElem := range opElems {
+		switch opElem.Key() {
+		case "$eq", "$ne":
+			encrypted, err := e.encryptElement(
+				bson.EC.Interface(key, opElem.Value().Interface()), true,
+			)
+			if err != nil {
+				return err
+			}
+			subtype, data := encrypted.Value().Binary()
+			ops.Set(bson.EC.BinaryWithSubtype(opElem.Key(), data, subtype))
+		case "$in", "$nin":
+			values := opElem.Value().MutableArray()
+			encryptedValues := bson.NewArray()
+			for i := 0; i < values.Len(); i++ {
+				v, err := values.Lookup(uint(i))
+				if err != nil {
+					return err
+				}
+				encrypted, err := e.encryptElement(
+					bson.EC.Interface(key, v.Interface()), true,
+				)
+				if err != nil {
+					return err
+				}
+				encryptedValues.Append(encrypted.Value())
+			}
+			ops.Set(bson.EC.Array(opElem.Key(), encryptedValues))
+		default:
+			return fmt.Errorf(
+				"Operator: %s is not supported on encrypted fields", opElem.Key(),
+			)
+		}
+	}
+	return nil
+}
+
+// decode decodes a result using the provided decoder (such as Cursor.Decode)
+// into v, decrypting the encrypted fields if any.
+func (e *fieldEncryptor) decode(
+	decoder func(interface{}) error,
+	v interface{},
+) error {
+	if e == nil {
+		return decoder(v)
+	}
+	doc := bson.NewDocument()
+	err := decoder(doc)
+	if err != nil {
+		return err
+	}
+	err = e.decryptDocument(doc)
+	if err != nil {
+		return err
+	}
+	docBytes, err := doc.MarshalBSON()
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(docBytes, v)
+}
@@ -0,0 +1,249 @@
+package mongo
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Encryption", func() {
+	type user struct {
+		ID    objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		Name  string            `bson:"name,omitempty" json:"name,omitempty"`
+		Email string            `bson:"email,omitempty" mongoutils:"encrypt,deterministic"`
+		Phone string            `bson:"phone,omitempty" mongoutils:"encrypt"`
+	}
+
+	var provider *StaticKeyProvider
+
+	BeforeEach(func() {
+		provider = &StaticKeyProvider{
+			CurrentID: "key1",
+			Keys: map[string][]byte{
+				"key1": bytes.Repeat([]byte{1}, 32),
+				"key2": bytes.Repeat([]byte{2}, 32),
+			},
+		}
+	})
+
+	Describe("fieldEncryptor", func() {
+		var encryptor *fieldEncryptor
+
+		BeforeEach(func() {
+			tags, err := parseSchemaTags(&user{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags.encrypted).To(Equal(map[string]bool{
+				"email": true,
+				"phone": false,
+			}))
+			encryptor = &fieldEncryptor{
+				provider: provider,
+				fields:   tags.encrypted,
+			}
+		})
+
+		It("should encrypt and decrypt the tagged fields", func() {
+			doc, err := toBSON(&user{
+				Name:  "some-name",
+				Email: "user@example.com",
+				Phone: "555-0100",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = encryptor.encryptDocument(doc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(doc.Lookup("name").StringValue()).To(Equal("some-name"))
+			Expect(doc.Lookup("email").Type()).To(Equal(bson.TypeBinary))
+			Expect(doc.Lookup("phone").Type()).To(Equal(bson.TypeBinary))
+
+			err = encryptor.decryptDocument(doc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(doc.Lookup("email").StringValue()).To(Equal("user@example.com"))
+			Expect(doc.Lookup("phone").StringValue()).To(Equal("555-0100"))
+		})
+
+		It("should produce same ciphertexts only for deterministic fields", func() {
+			encrypt := func() *bson.Document {
+				doc, err := toBSON(&user{
+					Email: "user@example.com",
+					Phone: "555-0100",
+				})
+				Expect(err).ToNot(HaveOccurred())
+				err = encryptor.encryptDocument(doc)
+				Expect(err).ToNot(HaveOccurred())
+				return doc
+			}
+			doc1 := encrypt()
+			doc2 := encrypt()
+
+			_, email1 := doc1.Lookup("email").Binary()
+			_, email2 := doc2.Lookup("email").Binary()
+			Expect(email1).To(Equal(email2))
+
+			_, phone1 := doc1.Lookup("phone").Binary()
+			_, phone2 := doc2.Lookup("phone").Binary()
+			Expect(phone1).ToNot(Equal(phone2))
+		})
+
+		It("should decrypt values encrypted with a rotated key", func() {
+			doc, err := toBSON(&user{
+				Phone: "555-0100",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = encryptor.encryptDocument(doc)
+			Expect(err).ToNot(HaveOccurred())
+
+			provider.CurrentID = "key2"
+			err = encryptor.decryptDocument(doc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(doc.Lookup("phone").StringValue()).To(Equal("555-0100"))
+		})
+
+		It("should return error if the ciphertext is tampered with", func() {
+			doc, err := toBSON(&user{
+				Phone: "555-0100",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = encryptor.encryptDocument(doc)
+			Expect(err).ToNot(HaveOccurred())
+
+			subtype, data := doc.Lookup("phone").Binary()
+			data[len(data)-1] ^= 0xff
+			doc.Set(bson.EC.BinaryWithSubtype("phone", data, subtype))
+
+			err = encryptor.decryptDocument(doc)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should encrypt equality-filters on deterministic fields", func() {
+			doc, err := toBSON(&user{
+				Email: "user@example.com",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = encryptor.encryptDocument(doc)
+			Expect(err).ToNot(HaveOccurred())
+			_, stored := doc.Lookup("email").Binary()
+
+			filter, err := toBSON(map[string]interface{}{
+				"email": map[string]interface{}{
+					"$in": []interface{}{"user@example.com"},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = encryptor.encryptFilter(filter)
+			Expect(err).ToNot(HaveOccurred())
+
+			in := filter.Lookup("email").MutableDocument().Lookup("$in").MutableArray()
+			v, err := in.Lookup(0)
+			Expect(err).ToNot(HaveOccurred())
+			_, queried := v.Binary()
+			Expect(queried).To(Equal(stored))
+		})
+
+		It("should return error when filtering on randomized fields", func() {
+			filter, err := toBSON(&user{
+				Phone: "555-0100",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			err = encryptor.encryptFilter(filter)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("Collection", func() {
+		var c *Collection
+
+		BeforeEach(func() {
+			hosts := os.Getenv("MONGO_TEST_HOSTS")
+			username := os.Getenv("MONGO_TEST_USERNAME")
+			password := os.Getenv("MONGO_TEST_PASSWORD")
+			resourceTimeoutStr := os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS")
+			testDatabase := os.Getenv("MONGO_TEST_DATABASE")
+
+			resourceTimeoutInt, err := strconv.Atoi(resourceTimeoutStr)
+			if err != nil {
+				err = errors.Wrap(
+					err,
+					"error getting RESOURCE_TIMEOUT from env, will use 3000",
+				)
+				log.Println(err)
+				resourceTimeoutInt = 3000
+			}
+			resourceTimeout := uint32(resourceTimeoutInt)
+
+			client, err := NewClient(ClientConfig{
+				Hosts:               *commonutil.ParseHosts(hosts),
+				Username:            username,
+				Password:            password,
+				TimeoutMilliseconds: resourceTimeout,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			dbCtx, dbCancel := newTimeoutContext(resourceTimeout)
+			err = client.Database(testDatabase).Drop(dbCtx)
+			dbCancel()
+			Expect(err).ToNot(HaveOccurred())
+
+			c, err = EnsureCollection(&Collection{
+				Connection: &ConnectionConfig{
+					Client:  client,
+					Timeout: resourceTimeout,
+				},
+				Database:     testDatabase,
+				Name:         "test_collection",
+				SchemaStruct: &user{},
+				KeyProvider:  provider,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			err := c.Connection.Client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should return error if KeyProvider is not specified", func() {
+			_, err := EnsureCollection(&Collection{
+				Connection:   c.Connection,
+				Database:     c.Database,
+				Name:         "test_collection",
+				SchemaStruct: &user{},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should store encrypted values and find by deterministic field", func() {
+			_, err := c.InsertOne(&user{
+				Name:  "some-name",
+				Email: "user@example.com",
+				Phone: "555-0100",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			// Raw document holds ciphertexts
+			ctx, cancel := newTimeoutContext(c.Connection.Timeout)
+			raw := bson.NewDocument()
+			err = c.Collection().FindOne(ctx, bson.NewDocument()).Decode(raw)
+			cancel()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(raw.Lookup("email").Type()).To(Equal(bson.TypeBinary))
+
+			result, err := c.FindOne(&user{
+				Email: "user@example.com",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			found := result.(*user)
+			Expect(found.Name).To(Equal("some-name"))
+			Expect(found.Email).To(Equal("user@example.com"))
+			Expect(found.Phone).To(Equal("555-0100"))
+		})
+	})
+})
@@ -0,0 +1,259 @@
+package mongo
+
+import (
+	"context"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/mongodb/mongo-go-driver/core/command"
+	"github.com/mongodb/mongo-go-driver/core/connection"
+	"github.com/mongodb/mongo-go-driver/core/topology"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/pkg/errors"
+)
+
+// The MongoDB server error-codes used for classifying errors.
+const (
+	codeHostUnreachable           = 6
+	codeHostNotFound              = 7
+	codeNamespaceExists           = 48
+	codeMaxTimeMSExpired          = 50
+	codeWriteConcernFailed        = 64
+	codeUnknownReplWriteConcern   = 79
+	codeNetworkTimeout            = 89
+	codeUnsatisfiableWriteConcern = 100
+	codeExceededTimeLimit         = 262
+	codeSocketException           = 9001
+	codeDuplicateKey              = 11000
+	codeDuplicateKeyOnUpdate      = 11001
+	codeDuplicateKeyOnIndex       = 12582
+)
+
+// dupKeyPattern extracts the index and key from a duplicate-key error-message,
+// such as: E11000 duplicate key error collection: db.users index: email_1
+// dup key: { : "user@example.com" }
+// The key is then read up to its balanced closing-brace, see bracedPrefix.
+var dupKeyPattern = regexp.MustCompile(`index: (\S+)(?: dup key: (\{))?`)
+
+// DuplicateKeyError provides the details of a write rejected for
+// violating a unique index.
+type DuplicateKeyError struct {
+	// Index is the name of violated index, such as "email_1".
+	Index string
+	// Key is the duplicate key as reported by server, such as
+	// `{ : "user@example.com" }`. The values of sensitive fields are masked.
+	Key string
+	err error
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the original error.
+func (e *DuplicateKeyError) Cause() error {
+	return e.err
+}
+
+// SchemaMismatchError is returned when the data provided to a Collection
+// operation does not match its SchemaStruct.
+type SchemaMismatchError struct {
+	// Expected is the type of Collection.SchemaStruct.
+	Expected string
+	// Actual is the type of provided data.
+	Actual string
+}
+
+func (e *SchemaMismatchError) Error() string {
+	return "Mismatch between provided data-schema and expected schema. " +
+		"Consider changing collection.SchemaStruct if required. " +
+		"A map[string]interface{} (pointer or non-pointer) can also be used as parameter."
+}
+
+// IsDuplicateKey checks if the error is caused by violating a unique index,
+// and returns the violated index and key if so.
+func IsDuplicateKey(err error) (*DuplicateKeyError, bool) {
+	if err == nil {
+		return nil, false
+	}
+	cause := errors.Cause(err)
+	isDuplicate := hasErrorCode(
+		cause, codeDuplicateKey, codeDuplicateKeyOnUpdate, codeDuplicateKeyOnIndex,
+	) || strings.Contains(cause.Error(), "E11000")
+	if !isDuplicate {
+		return nil, false
+	}
+
+	// The message is matched on top-level error so the masked key is used
+	// if the error was redacted.
+	dupErr := &DuplicateKeyError{
+		err: err,
+	}
+	message := err.Error()
+	match := dupKeyPattern.FindStringSubmatchIndex(message)
+	if match != nil {
+		dupErr.Index = message[match[2]:match[3]]
+		if match[4] >= 0 {
+			dupErr.Key = bracedPrefix(message[match[4]:])
+		}
+	}
+	return dupErr, true
+}
+
+// bracedPrefix returns the prefix of str starting with "{" up to its
+// balanced closing-brace, ignoring the braces in quoted strings. The whole
+// str is returned if the braces are unbalanced.
+func bracedPrefix(str string) string {
+	depth := 0
+	inQuotes := false
+	for i := 0; i < len(str); i++ {
+		switch {
+		case inQuotes && str[i] == '\\':
+			i++
+		case str[i] == '"':
+			inQuotes = !inQuotes
+		case inQuotes:
+		case str[i] == '{':
+			depth++
+		case str[i] == '}':
+			depth--
+			if depth == 0 {
+				return str[:i+1]
+			}
+		}
+	}
+	return str
+}
+
+// IsNotFound checks if the error is caused by FindOne finding no documents.
+func IsNotFound(err error) bool {
+	return err != nil && errors.Cause(err) == mgo.ErrNoDocuments
+}
+
+// IsSchemaMismatch checks if the error is caused by data not matching
+// the Collection's SchemaStruct.
+func IsSchemaMismatch(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, isMismatch := errors.Cause(err).(*SchemaMismatchError)
+	return isMismatch
+}
+
+// IsTimeout checks if the error is caused by an operation, connection,
+// or server-selection timing out.
+func IsTimeout(err error) bool {
+	if err == nil {
+		return false
+	}
+	cause := unwrapConnectionError(errors.Cause(err))
+	if cause == context.DeadlineExceeded || cause == topology.ErrServerSelectionTimeout {
+		return true
+	}
+	if netErr, ok := cause.(net.Error); ok && netErr.Timeout() {
+		return true
+	}
+	return hasErrorCode(
+		cause, codeMaxTimeMSExpired, codeNetworkTimeout, codeExceededTimeLimit,
+	)
+}
+
+// IsNetworkError checks if the error is caused by a network failure while
+// communicating with the server.
+func IsNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	cause := errors.Cause(err)
+	switch cause.(type) {
+	case connection.Error, *connection.Error:
+		return true
+	}
+	if cause == io.EOF || cause == io.ErrUnexpectedEOF {
+		return true
+	}
+	// context.DeadlineExceeded also implements net.Error
+	if _, ok := cause.(net.Error); ok && cause != context.DeadlineExceeded {
+		return true
+	}
+	if labeled, ok := cause.(interface{ HasErrorLabel(string) bool }); ok {
+		if labeled.HasErrorLabel("NetworkError") {
+			return true
+		}
+	}
+	return hasErrorCode(
+		cause,
+		codeHostUnreachable, codeHostNotFound, codeNetworkTimeout, codeSocketException,
+	)
+}
+
+// IsWriteConcernError checks if the error is caused by the write-concern
+// not being satisfied.
+func IsWriteConcernError(err error) bool {
+	if err == nil {
+		return false
+	}
+	cause := errors.Cause(err)
+	switch cause.(type) {
+	case mgo.WriteConcernError, *mgo.WriteConcernError:
+		return true
+	}
+	return hasErrorCode(
+		cause,
+		codeWriteConcernFailed, codeUnknownReplWriteConcern, codeUnsatisfiableWriteConcern,
+	)
+}
+
+// unwrapConnectionError returns the error wrapped by a connection-error.
+func unwrapConnectionError(err error) error {
+	switch e := err.(type) {
+	case connection.Error:
+		if e.Wrapped != nil {
+			return e.Wrapped
+		}
+	case *connection.Error:
+		if e.Wrapped != nil {
+			return e.Wrapped
+		}
+	}
+	return err
+}
+
+// hasErrorCode checks if the server-error has any of the codes.
+func hasErrorCode(err error, codes ...int) bool {
+	for _, errCode := range errorCodes(err) {
+		for _, code := range codes {
+			if errCode == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// errorCodes returns the codes from a server-error.
+func errorCodes(err error) []int {
+	switch e := err.(type) {
+	case command.Error:
+		return []int{int(e.Code)}
+	case *command.Error:
+		return []int{int(e.Code)}
+	case mgo.WriteError:
+		return []int{e.Code}
+	case *mgo.WriteError:
+		return []int{e.Code}
+	case mgo.WriteErrors:
+		codes := []int{}
+		for _, we := range e {
+			codes = append(codes, we.Code)
+		}
+		return codes
+	case mgo.WriteConcernError:
+		return []int{e.Code}
+	case *mgo.WriteConcernError:
+		return []int{e.Code}
+	}
+	return nil
+}
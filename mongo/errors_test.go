@@ -0,0 +1,129 @@
+package mongo
+
+import (
+	"context"
+
+	"github.com/mongodb/mongo-go-driver/core/command"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Errors", func() {
+	dupKeyMessage := `E11000 duplicate key error collection: test_db.users ` +
+		`index: email_1 dup key: { : "user@example.com" }`
+
+	Describe("IsDuplicateKey", func() {
+		It("should return the index and key from wrapped write-errors", func() {
+			err := errors.Wrap(
+				mgo.WriteErrors{
+					mgo.WriteError{
+						Code:    11000,
+						Message: dupKeyMessage,
+					},
+				},
+				"InsertOne - Insert Error",
+			)
+			dupErr, isDuplicate := IsDuplicateKey(err)
+			Expect(isDuplicate).To(BeTrue())
+			Expect(dupErr.Index).To(Equal("email_1"))
+			Expect(dupErr.Key).To(Equal(`{ : "user@example.com" }`))
+			Expect(errors.Cause(dupErr)).To(Equal(errors.Cause(err)))
+		})
+
+		It("should return the keys with nested documents", func() {
+			dupErr, isDuplicate := IsDuplicateKey(errors.Wrap(
+				command.Error{
+					Code: 11000,
+					Message: "E11000 duplicate key error collection: db.users " +
+						`index: address_1 dup key: { : { city: "a}b" } }`,
+				},
+				"ReplaceOne Error",
+			))
+			Expect(isDuplicate).To(BeTrue())
+			Expect(dupErr.Index).To(Equal("address_1"))
+			Expect(dupErr.Key).To(Equal(`{ : { city: "a}b" } }`))
+		})
+
+		It("should classify command-errors by code", func() {
+			_, isDuplicate := IsDuplicateKey(command.Error{
+				Code:    11000,
+				Message: dupKeyMessage,
+			})
+			Expect(isDuplicate).To(BeTrue())
+		})
+
+		It("should use the masked key from redacted errors", func() {
+			err := &redactedError{
+				message: `E11000 duplicate key error index: email_1 dup key: { : "****" }`,
+				cause: mgo.WriteErrors{
+					mgo.WriteError{
+						Code:    11000,
+						Message: dupKeyMessage,
+					},
+				},
+			}
+			dupErr, isDuplicate := IsDuplicateKey(err)
+			Expect(isDuplicate).To(BeTrue())
+			Expect(dupErr.Key).To(Equal(`{ : "****" }`))
+		})
+
+		It("should return false for other errors", func() {
+			_, isDuplicate := IsDuplicateKey(errors.New("some error"))
+			Expect(isDuplicate).To(BeFalse())
+			_, isDuplicate = IsDuplicateKey(nil)
+			Expect(isDuplicate).To(BeFalse())
+		})
+	})
+
+	It("should classify FindOne not finding documents", func() {
+		err := errors.Wrap(mgo.ErrNoDocuments, "FindOne Decoding Error")
+		Expect(IsNotFound(err)).To(BeTrue())
+		Expect(IsNotFound(errors.New("some error"))).To(BeFalse())
+	})
+
+	It("should classify schema-mismatches", func() {
+		type item struct {
+			Word string `bson:"word"`
+		}
+		type other struct {
+			Word string `bson:"word"`
+		}
+		c := &Collection{
+			SchemaStruct: &item{},
+		}
+		err := c.verifyDataSchema(&other{})
+		Expect(IsSchemaMismatch(
+			errors.Wrap(err, "Find - Schema Verification Error"),
+		)).To(BeTrue())
+		Expect(IsSchemaMismatch(c.verifyDataSchema(&item{}))).To(BeFalse())
+	})
+
+	It("should classify timeouts", func() {
+		Expect(IsTimeout(
+			errors.Wrap(context.DeadlineExceeded, "Find - Error"),
+		)).To(BeTrue())
+		Expect(IsTimeout(command.Error{Code: 50})).To(BeTrue())
+		Expect(IsTimeout(errors.New("some error"))).To(BeFalse())
+	})
+
+	It("should classify network-errors", func() {
+		Expect(IsNetworkError(command.Error{
+			Labels: []string{"NetworkError"},
+		})).To(BeTrue())
+		Expect(IsNetworkError(command.Error{Code: 6})).To(BeTrue())
+		Expect(IsNetworkError(command.Error{Code: 11000})).To(BeFalse())
+		Expect(IsNetworkError(
+			errors.Wrap(context.DeadlineExceeded, "Find - Error"),
+		)).To(BeFalse())
+	})
+
+	It("should classify write-concern errors", func() {
+		Expect(IsWriteConcernError(
+			errors.Wrap(mgo.WriteConcernError{Code: 64}, "InsertOne - Insert Error"),
+		)).To(BeTrue())
+		Expect(IsWriteConcernError(command.Error{Code: 100})).To(BeTrue())
+		Expect(IsWriteConcernError(errors.New("some error"))).To(BeFalse())
+	})
+})
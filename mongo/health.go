@@ -0,0 +1,261 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/pkg/errors"
+)
+
+// The topology-types reported by Client.Health.
+const (
+	TopologyStandalone = "Standalone"
+	TopologyReplicaSet = "ReplicaSet"
+	TopologySharded    = "Sharded"
+)
+
+// Probe is the type of health-probe served by Client.HealthHandler.
+type Probe int
+
+// The supported Probes.
+const (
+	// ProbeLiveness succeeds unless the Client is explicitly disconnected,
+	// without checking the server. This prevents restarting
+	// the process when the database is temporarily unreachable.
+	ProbeLiveness Probe = iota
+	// ProbeReadiness succeeds when the server is reachable and, for
+	// replica-sets, has a primary.
+	ProbeReadiness
+)
+
+// HealthReport describes the health of the server as seen by a Client.
+type HealthReport struct {
+	Reachable bool
+	// Topology is one of TopologyStandalone, TopologyReplicaSet,
+	// or TopologySharded.
+	Topology   string
+	ReplicaSet string
+	// Primary is the address of replica-set primary.
+	Primary       string
+	ServerVersion string
+	// Latency is the round-trip time of a ping.
+	Latency time.Duration
+	// ReplicationLag is the lag of the most lagging secondary behind the
+	// primary. This is only available if the user is authorized to run
+	// replSetGetStatus.
+	ReplicationLag time.Duration
+	// CircuitStates are the states of Client's CircuitBreaker circuits,
+	// if a CircuitBreaker is configured.
+	CircuitStates map[string]BreakerState
+	// Error describes why the server is unreachable, if it is.
+	Error     string
+	CheckedAt time.Time
+}
+
+// Ready checks if the server can serve operations.
+func (r *HealthReport) Ready() bool {
+	if !r.Reachable {
+		return false
+	}
+	return r.Topology != TopologyReplicaSet || r.Primary != ""
+}
+
+// MarshalJSON renders the HealthReport with durations in milliseconds,
+// and circuit-states as strings.
+func (r *HealthReport) MarshalJSON() ([]byte, error) {
+	circuits := map[string]string{}
+	for op, state := range r.CircuitStates {
+		circuits[op] = state.String()
+	}
+	return json.Marshal(map[string]interface{}{
+		"reachable":        r.Reachable,
+		"ready":            r.Ready(),
+		"topology":         r.Topology,
+		"replicaSet":       r.ReplicaSet,
+		"primary":          r.Primary,
+		"serverVersion":    r.ServerVersion,
+		"latencyMs":        durationMillis(r.Latency),
+		"replicationLagMs": durationMillis(r.ReplicationLag),
+		"circuits":         circuits,
+		"error":            r.Error,
+		"checkedAt":        r.CheckedAt,
+	})
+}
+
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Ping checks if the server is reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := runAdminCommand(ctx, c.DriverClient(), "ping")
+	if err != nil {
+		return errors.Wrap(err, "Ping Error")
+	}
+	return nil
+}
+
+// Health checks the server and reports its state. Each check is limited
+// by the Client's timeout. The server is reported unreachable if the ping
+// fails, while the failures of other checks leave their fields unset.
+func (c *Client) Health() *HealthReport {
+	report := &HealthReport{
+		CheckedAt: time.Now(),
+	}
+	if c.breaker != nil {
+		report.CircuitStates = c.breaker.States()
+	}
+
+	ctx, cancel := c.timeoutContext()
+	start := time.Now()
+	err := c.Ping(ctx)
+	report.Latency = time.Since(start)
+	cancel()
+	if err != nil {
+		report.Error = err.Error()
+		return report
+	}
+	report.Reachable = true
+
+	ctx, cancel = c.timeoutContext()
+	isMaster, err := runAdminCommand(ctx, c.DriverClient(), "isMaster")
+	cancel()
+	if err == nil {
+		report.applyIsMaster(isMaster)
+	}
+
+	ctx, cancel = c.timeoutContext()
+	buildInfo, err := runAdminCommand(ctx, c.DriverClient(), "buildInfo")
+	cancel()
+	if err == nil {
+		report.ServerVersion = stringValue(buildInfo, "version")
+	}
+
+	if report.Topology == TopologyReplicaSet {
+		ctx, cancel = c.timeoutContext()
+		status, err := runAdminCommand(ctx, c.DriverClient(), "replSetGetStatus")
+		cancel()
+		if err == nil {
+			report.applyReplSetStatus(status)
+		}
+	}
+	return report
+}
+
+// HealthHandler returns an http.Handler serving the specified Probe.
+// The response-status is 200 if the probe succeeds, and 503 otherwise.
+// Readiness-probes respond with the HealthReport as JSON.
+func (c *Client) HealthHandler(probe Probe) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var (
+			ok   bool
+			body interface{}
+		)
+		if probe == ProbeLiveness {
+			ok = c.State() != StateDisconnected
+			status := "ok"
+			if !ok {
+				status = "disconnected"
+			}
+			body = map[string]string{
+				"status": status,
+			}
+		} else {
+			report := c.Health()
+			ok = report.Ready()
+			body = report
+		}
+
+		if ok {
+			w.WriteHeader(http.StatusOK)
+		} else {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(body)
+	})
+}
+
+// runAdminCommand runs the command (such as "ping") on admin-database.
+func runAdminCommand(
+	ctx context.Context,
+	mgoClient *mgo.Client,
+	command string,
+) (*bson.Document, error) {
+	reader, err := mgoClient.Database("admin").RunCommand(
+		ctx,
+		bson.NewDocument(bson.EC.Int32(command, 1)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return bson.ReadDocument(reader)
+}
+
+// applyIsMaster sets the topology from the result of isMaster command.
+func (r *HealthReport) applyIsMaster(isMaster *bson.Document) {
+	switch {
+	case stringValue(isMaster, "msg") == "isdbgrid":
+		r.Topology = TopologySharded
+	case stringValue(isMaster, "setName") != "":
+		r.Topology = TopologyReplicaSet
+		r.ReplicaSet = stringValue(isMaster, "setName")
+		r.Primary = stringValue(isMaster, "primary")
+	default:
+		r.Topology = TopologyStandalone
+	}
+}
+
+// applyReplSetStatus sets the replication-lag from the result of
+// replSetGetStatus command.
+func (r *HealthReport) applyReplSetStatus(status *bson.Document) {
+	members := status.Lookup("members")
+	if members == nil || members.Type() != bson.TypeArray {
+		return
+	}
+
+	var primaryOptime time.Time
+	secondaryOptimes := []time.Time{}
+	memberArray := members.MutableArray()
+	for i := 0; i < memberArray.Len(); i++ {
+		v, err := memberArray.Lookup(uint(i))
+		if err != nil || v.Type() != bson.TypeEmbeddedDocument {
+			continue
+		}
+		member := v.MutableDocument()
+		optime := member.Lookup("optimeDate")
+		if optime == nil || optime.Type() != bson.TypeDateTime {
+			continue
+		}
+		switch stringValue(member, "stateStr") {
+		case "PRIMARY":
+			primaryOptime = optime.Time()
+		case "SECONDARY":
+			secondaryOptimes = append(secondaryOptimes, optime.Time())
+		}
+	}
+	if primaryOptime.IsZero() {
+		return
+	}
+
+	for _, optime := range secondaryOptimes {
+		if lag := primaryOptime.Sub(optime); lag > r.ReplicationLag {
+			r.ReplicationLag = lag
+		}
+	}
+}
+
+// stringValue returns the string-value of key in document, or a blank
+// string if the key is absent or not a string.
+func stringValue(doc *bson.Document, key string) string {
+	v := doc.Lookup(key)
+	if v == nil || v.Type() != bson.TypeString {
+		return ""
+	}
+	return v.StringValue()
+}
@@ -0,0 +1,157 @@
+package mongo
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/mongodb/mongo-go-driver/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Health", func() {
+	Describe("HealthReport", func() {
+		It("should parse replica-set topology from isMaster", func() {
+			report := &HealthReport{
+				Reachable: true,
+			}
+			report.applyIsMaster(bson.NewDocument(
+				bson.EC.Boolean("ismaster", false),
+				bson.EC.String("setName", "rs0"),
+				bson.EC.String("primary", "mongo1:27017"),
+			))
+			Expect(report.Topology).To(Equal(TopologyReplicaSet))
+			Expect(report.ReplicaSet).To(Equal("rs0"))
+			Expect(report.Primary).To(Equal("mongo1:27017"))
+			Expect(report.Ready()).To(BeTrue())
+		})
+
+		It("should not be ready if replica-set has no primary", func() {
+			report := &HealthReport{
+				Reachable: true,
+			}
+			report.applyIsMaster(bson.NewDocument(
+				bson.EC.String("setName", "rs0"),
+			))
+			Expect(report.Ready()).To(BeFalse())
+		})
+
+		It("should parse sharded topology from isMaster", func() {
+			report := &HealthReport{}
+			report.applyIsMaster(bson.NewDocument(
+				bson.EC.String("msg", "isdbgrid"),
+			))
+			Expect(report.Topology).To(Equal(TopologySharded))
+		})
+
+		It("should report lag of the most lagging secondary", func() {
+			now := time.Now()
+			member := func(state string, optime time.Time) *bson.Value {
+				return bson.VC.DocumentFromElements(
+					bson.EC.String("stateStr", state),
+					bson.EC.Time("optimeDate", optime),
+				)
+			}
+			report := &HealthReport{}
+			report.applyReplSetStatus(bson.NewDocument(
+				bson.EC.ArrayFromElements(
+					"members",
+					member("PRIMARY", now),
+					member("SECONDARY", now.Add(-2*time.Second)),
+					member("SECONDARY", now.Add(-5*time.Second)),
+					member("ARBITER", now.Add(-time.Hour)),
+				),
+			))
+			Expect(report.ReplicationLag).To(Equal(5 * time.Second))
+		})
+	})
+
+	Describe("Client", func() {
+		var client *Client
+
+		BeforeEach(func() {
+			hosts := os.Getenv("MONGO_TEST_HOSTS")
+			username := os.Getenv("MONGO_TEST_USERNAME")
+			password := os.Getenv("MONGO_TEST_PASSWORD")
+			resourceTimeoutStr := os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS")
+
+			resourceTimeoutInt, err := strconv.Atoi(resourceTimeoutStr)
+			if err != nil {
+				err = errors.Wrap(
+					err,
+					"error getting RESOURCE_TIMEOUT from env, will use 3000",
+				)
+				log.Println(err)
+				resourceTimeoutInt = 3000
+			}
+
+			client, err = NewClient(ClientConfig{
+				Hosts:               *commonutil.ParseHosts(hosts),
+				Username:            username,
+				Password:            password,
+				TimeoutMilliseconds: uint32(resourceTimeoutInt),
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			err := client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should ping the server", func() {
+			err := client.Ping(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should report the server as reachable", func() {
+			report := client.Health()
+			Expect(report.Reachable).To(BeTrue())
+			Expect(report.Error).To(BeEmpty())
+			Expect(report.Topology).ToNot(BeEmpty())
+			Expect(report.ServerVersion).ToNot(BeEmpty())
+			Expect(report.Latency).To(BeNumerically(">", 0))
+		})
+
+		It("should serve the readiness-probe", func() {
+			recorder := httptest.NewRecorder()
+			client.HealthHandler(ProbeReadiness).ServeHTTP(
+				recorder,
+				httptest.NewRequest(http.MethodGet, "/ready", nil),
+			)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			body := map[string]interface{}{}
+			err := json.Unmarshal(recorder.Body.Bytes(), &body)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(body["reachable"]).To(BeTrue())
+		})
+
+		It("should fail the liveness-probe once disconnected", func() {
+			handler := client.HealthHandler(ProbeLiveness)
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(
+				recorder,
+				httptest.NewRequest(http.MethodGet, "/live", nil),
+			)
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+
+			err := client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+			recorder = httptest.NewRecorder()
+			handler.ServeHTTP(
+				recorder,
+				httptest.NewRequest(http.MethodGet, "/live", nil),
+			)
+			Expect(recorder.Code).To(Equal(http.StatusServiceUnavailable))
+		})
+	})
+})
@@ -0,0 +1,147 @@
+package mongo
+
+import (
+	"context"
+)
+
+// The names of operations passed to Middlewares.
+const (
+	OpAggregate  = "Aggregate"
+	OpDeleteMany = "DeleteMany"
+	OpFind       = "Find"
+	OpFindOne    = "FindOne"
+	OpInsertMany = "InsertMany"
+	OpInsertOne  = "InsertOne"
+	OpReplaceOne = "ReplaceOne"
+	OpUpdateMany = "UpdateMany"
+)
+
+// Operation describes a Collection operation. The fields applicable to the
+// operation are set, and the rest are left nil. Middlewares can modify
+// these before passing the Operation on to next Handler.
+type Operation struct {
+	// Name of the operation, such as OpInsertOne.
+	Name       string
+	Collection *Collection
+	// Context is the context the operation was called with, see
+	// Collection.WithContext. This is never nil.
+	Context context.Context
+
+	Filter interface{}
+	// Update is the update-data for UpdateMany, or the
+	// replacement-document for ReplaceOne.
+	Update interface{}
+	// Documents to be inserted.
+	Documents []interface{}
+	Pipeline  interface{}
+	// Options is the slice of options provided to operation, such as
+	// []findopt.Find for OpFind.
+	Options interface{}
+}
+
+// Handler executes an Operation, and returns the result of operation
+// as would be returned by the corresponding Collection method. For example,
+// the result of OpFind is []interface{}, and of OpInsertOne is
+// *mgo.InsertOneResult.
+type Handler func(op *Operation) (interface{}, error)
+
+// Middleware wraps a Handler to provide behavior around operations.
+// A Middleware can modify the Operation before calling next, inspect or
+// modify the result and error returned by next, or short-circuit the
+// operation by returning without calling next.
+type Middleware func(next Handler) Handler
+
+// Use registers Middlewares to be run around every operation on this
+// Client's Collections. The Client's Middlewares run before the
+// Collection's Middlewares, in order of registration. The Middlewares
+// registered while operations are running apply to the later operations.
+func (c *Client) Use(middlewares ...Middleware) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.middlewares = append(c.middlewares, middlewares...)
+}
+
+// Use registers Middlewares to be run around every operation on this
+// Collection, in order of registration. The copies of Collection, such as
+// from WithContext, are not affected.
+func (c *Collection) Use(middlewares ...Middleware) {
+	// The slice is copied since it may be shared with the copies
+	registered := make([]Middleware, 0, len(c.Middlewares)+len(middlewares))
+	registered = append(registered, c.Middlewares...)
+	c.Middlewares = append(registered, middlewares...)
+}
+
+// IsIntercepted checks if the Collection's operations are changed by
+// Middlewares (of the Collection or its Client), an Auditor, or the
+// field-encryption. These are bypassed when using the driver-collection
+// directly, so the tools doing so should reject such Collections.
+func (c *Collection) IsIntercepted() bool {
+	if len(c.Middlewares) > 0 || c.Auditor != nil {
+		return true
+	}
+	if c.KeyProvider != nil || c.encryptor != nil {
+		return true
+	}
+	if c.Connection != nil && c.Connection.Client != nil {
+		client := c.Connection.Client
+		client.mutex.RLock()
+		defer client.mutex.RUnlock()
+		return len(client.middlewares) > 0
+	}
+	return false
+}
+
+// WithContext returns a shallow copy of Collection which runs its
+// operations with the provided context. The context is available to
+// Middlewares in Operation, and the operation-timeouts are derived from it.
+func (c *Collection) WithContext(ctx context.Context) *Collection {
+	if ctx == nil {
+		panic("nil context")
+	}
+	copied := *c
+	copied.ctx = ctx
+	return &copied
+}
+
+// Context returns the Collection's context. This is context.Background()
+// unless changed using WithContext.
+func (c *Collection) Context() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	return context.Background()
+}
+
+// run executes the Operation through registered Middlewares, with the
+// provided Handler at the end of the chain.
+func (c *Collection) run(op *Operation, handler Handler) (interface{}, error) {
+	op.Collection = c
+	op.Context = c.Context()
+
+	// Retries wrap only the Handler, so Middlewares see a single call
+	// for the operation.
+	if policy := c.retryPolicy(); policy != nil {
+		handler = c.withRetries(policy.withDefaults(), handler)
+	}
+	// The CircuitBreaker counts an operation once regardless of retries
+	if c.Connection != nil && c.Connection.Client != nil {
+		if breaker := c.Connection.Client.breaker; breaker != nil {
+			handler = breaker.wrap(handler)
+		}
+	}
+
+	middlewares := []Middleware{}
+	if c.Connection != nil && c.Connection.Client != nil {
+		client := c.Connection.Client
+		client.mutex.RLock()
+		middlewares = append(middlewares, client.middlewares...)
+		client.mutex.RUnlock()
+	}
+	middlewares = append(middlewares, c.Middlewares...)
+
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	result, err := handler(op)
+	return result, c.redactError(op, err)
+}
@@ -0,0 +1,154 @@
+package mongo
+
+import (
+	"context"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Middleware", func() {
+	type item struct {
+		ID   objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		Word string            `bson:"word,omitempty" json:"word,omitempty"`
+	}
+
+	var (
+		client *Client
+		c      *Collection
+		// captured is the last Operation received by the terminating Middleware
+		captured *Operation
+	)
+
+	// terminate short-circuits all operations, so no database is required.
+	terminate := func(next Handler) Handler {
+		return func(op *Operation) (interface{}, error) {
+			captured = op
+			switch op.Name {
+			case OpInsertOne:
+				return &mgo.InsertOneResult{InsertedID: "test-id"}, nil
+			case OpFind:
+				return []interface{}{&item{Word: "found"}}, nil
+			}
+			return nil, errors.New("unexpected operation")
+		}
+	}
+
+	BeforeEach(func() {
+		captured = nil
+		client = &Client{}
+		c = &Collection{
+			Connection: &ConnectionConfig{
+				Client: client,
+			},
+			SchemaStruct: &item{},
+		}
+	})
+
+	It("should allow the Middleware to short-circuit the operation", func() {
+		c.Use(terminate)
+
+		result, err := c.InsertOne(&item{Word: "some-word"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.InsertedID).To(Equal("test-id"))
+
+		Expect(captured.Name).To(Equal(OpInsertOne))
+		Expect(captured.Collection).To(Equal(c))
+		Expect(captured.Documents).To(HaveLen(1))
+	})
+
+	It("should run Client Middlewares before Collection Middlewares", func() {
+		order := []string{}
+		record := func(name string) Middleware {
+			return func(next Handler) Handler {
+				return func(op *Operation) (interface{}, error) {
+					order = append(order, name)
+					return next(op)
+				}
+			}
+		}
+
+		client.Use(record("client1"), record("client2"))
+		c.Use(record("collection"), terminate)
+
+		_, err := c.Find(&item{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(order).To(Equal([]string{"client1", "client2", "collection"}))
+	})
+
+	It("should allow the Middleware to modify the operation and result", func() {
+		scope := func(next Handler) Handler {
+			return func(op *Operation) (interface{}, error) {
+				op.Filter = map[string]interface{}{
+					"word": "scoped",
+				}
+				result, err := next(op)
+				items := result.([]interface{})
+				return append(items, &item{Word: "appended"}), err
+			}
+		}
+		c.Use(scope, terminate)
+
+		results, err := c.Find(&item{Word: "some-word"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(2))
+		Expect(captured.Filter).To(Equal(map[string]interface{}{
+			"word": "scoped",
+		}))
+	})
+
+	It("should provide the context set using WithContext", func() {
+		type ctxKey string
+		c.Use(terminate)
+
+		ctx := context.WithValue(context.Background(), ctxKey("actor"), "test-user")
+		_, err := c.WithContext(ctx).Find(&item{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(captured.Context.Value(ctxKey("actor"))).To(Equal("test-user"))
+
+		// Original Collection is unaffected
+		_, err = c.Find(&item{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(captured.Context.Value(ctxKey("actor"))).To(BeNil())
+	})
+
+	It("should not share the Middlewares registered on copies", func() {
+		// The pass-through Middleware is shared, and the copy registers its
+		// own Middleware after it, as the original does
+		c.Use(func(next Handler) Handler { return next })
+		copied := c.WithContext(context.Background())
+
+		reject := func(next Handler) Handler {
+			return func(op *Operation) (interface{}, error) {
+				return nil, errors.New("rejected")
+			}
+		}
+		c.Use(reject)
+		copied.Use(terminate)
+
+		_, err := copied.Find(&item{})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.Find(&item{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should allow registering Client Middlewares during operations", func() {
+		c.Use(terminate)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 50; i++ {
+				client.Use(func(next Handler) Handler { return next })
+			}
+		}()
+		for i := 0; i < 50; i++ {
+			_, err := c.Find(&item{})
+			Expect(err).ToNot(HaveOccurred())
+		}
+		<-done
+	})
+})
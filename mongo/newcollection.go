@@ -27,37 +27,133 @@ func EnsureCollection(c *Collection) (*Collection, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "Index-Keys Validation Error")
 	}
+	c.tags, err = parseSchemaTags(c.SchemaStruct)
+	if err != nil {
+		return nil, errors.Wrap(err, "Schema-Tags Parsing Error")
+	}
+	if len(c.tags.encrypted) > 0 {
+		if c.KeyProvider == nil {
+			return nil, errors.New(
+				"KeyProvider is required for SchemaStruct fields tagged as \"encrypt\"",
+			)
+		}
+		c.encryptor = &fieldEncryptor{
+			provider: c.KeyProvider,
+			fields:   c.tags.encrypted,
+		}
+	}
+	if c.OptimisticLocking && c.tags.version == "" {
+		return nil, errors.New(
+			"OptimisticLocking requires a SchemaStruct field tagged as \"version\"",
+		)
+	}
+	if c.SchemaUpgrades != nil {
+		if c.tags.schemaVersion == "" {
+			return nil, errors.New(
+				"SchemaUpgrades requires a SchemaStruct field tagged as \"schemaVersion\"",
+			)
+		}
+		c.upgrades, err = c.SchemaUpgrades.funcs()
+		if err != nil {
+			return nil, errors.Wrap(err, "SchemaUpgrades Validation Error")
+		}
+		c.tags.currentSchemaVersion = c.SchemaUpgrades.CurrentVersion()
+	}
 
-	c.collection = c.Connection.Client.
-		Database(c.Database).
-		Collection(c.Name)
+	c.driver = &driverCollection{}
 
 	ctx, cancel := newTimeoutContext(c.Connection.Timeout)
 	defer cancel()
 
-	if c.Indexes != nil {
-		for _, indexConfig := range c.Indexes {
-			indexOptions := bson.NewDocument(
-				bson.EC.Boolean("unique", indexConfig.IsUnique),
-			)
-			if indexConfig.Name != "" {
-				indexOptions.Append(bson.EC.String("name", indexConfig.Name))
-			}
+	if c.Options != nil {
+		err = CreateCollection(
+			ctx,
+			c.Connection.Client.Database(c.Database),
+			c.Name,
+			c.Options,
+		)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error Creating Collection")
+		}
+	}
 
-			indexes := c.collection.Indexes()
-			err := createIndex(
-				ctx,
-				&indexConfig.ColumnConfig,
-				&indexes,
-				indexOptions,
-			)
+	for _, indexConfig := range c.Indexes {
+		err := CreateIndex(ctx, c.Collection(), indexConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// CreateCollection creates the collection with the specified options, as done
+// by EnsureCollection. This does nothing if the collection already exists,
+// so the options of an existing collection are not changed.
+func CreateCollection(
+	ctx context.Context,
+	database *mgo.Database,
+	name string,
+	options *CollectionOptions,
+) error {
+	command := bson.NewDocument(bson.EC.String("create", name))
+	if options != nil {
+		if options.Capped {
+			command.Append(bson.EC.Boolean("capped", true))
+		}
+		if options.SizeBytes > 0 {
+			command.Append(bson.EC.Int64("size", options.SizeBytes))
+		}
+		if options.MaxDocuments > 0 {
+			command.Append(bson.EC.Int64("max", options.MaxDocuments))
+		}
+		if options.Validator != nil {
+			validator, err := toBSON(options.Validator)
 			if err != nil {
-				cancel()
-				return nil, err
+				return errors.Wrap(err, "Error Encoding Validator")
 			}
+			command.Append(bson.EC.SubDocument("validator", validator))
+		}
+		if options.ValidationLevel != "" {
+			command.Append(bson.EC.String("validationLevel", options.ValidationLevel))
+		}
+		if options.ValidationAction != "" {
+			command.Append(bson.EC.String("validationAction", options.ValidationAction))
 		}
 	}
-	return c, nil
+
+	_, err := database.RunCommand(ctx, command)
+	if err != nil && !hasErrorCode(err, codeNamespaceExists) {
+		return err
+	}
+	return nil
+}
+
+// CreateIndex creates the index on the driver-collection, as done by
+// EnsureCollection. This does nothing if an identical index already exists.
+func CreateIndex(
+	ctx context.Context,
+	collection *mgo.Collection,
+	indexConfig IndexConfig,
+) error {
+	indexOptions := bson.NewDocument(
+		bson.EC.Boolean("unique", indexConfig.IsUnique),
+	)
+	if indexConfig.Name != "" {
+		indexOptions.Append(bson.EC.String("name", indexConfig.Name))
+	}
+	if indexConfig.ExpireAfterSeconds > 0 {
+		indexOptions.Append(
+			bson.EC.Int32("expireAfterSeconds", indexConfig.ExpireAfterSeconds),
+		)
+	}
+
+	indexes := collection.Indexes()
+	return createIndex(
+		ctx,
+		&indexConfig.ColumnConfig,
+		&indexes,
+		indexOptions,
+	)
 }
 
 func verifySchemaStruct(schemaStruct interface{}) error {
@@ -255,7 +255,7 @@ var _ = Describe("Mongo - NewCollection", func() {
 
 			// Get indexes
 			indexCtx, indexCancel := newTimeoutContext(connectionTimeout)
-			cur, err := c.collection.Indexes().List(indexCtx)
+			cur, err := c.Collection().Indexes().List(indexCtx)
 			indexCancel()
 			Expect(err).ToNot(HaveOccurred())
 
@@ -357,7 +357,7 @@ var _ = Describe("Mongo - NewCollection", func() {
 
 				// Get indexes
 				indexCtx, indexCancel := newTimeoutContext(connectionTimeout)
-				cur, err := c.collection.Indexes().List(indexCtx)
+				cur, err := c.Collection().Indexes().List(indexCtx)
 				indexCancel()
 				Expect(err).ToNot(HaveOccurred())
 
@@ -0,0 +1,343 @@
+package mongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// The options in TagName struct-tags for masking sensitive fields.
+const (
+	// TagSensitive marks a field as sensitive, so its values are masked
+	// whenever documents or filters are rendered in errors, audit-entries,
+	// or using Collection.Redact. The fields tagged as TagEncrypt are
+	// always sensitive.
+	TagSensitive = "sensitive"
+	// TagMaskPrefix specifies the masking-strategy for a sensitive field,
+	// such as "mask=partial". Defaults to MaskFull.
+	TagMaskPrefix = "mask="
+)
+
+// The inbuilt masking-strategies.
+const (
+	// MaskFull replaces the value with "****".
+	MaskFull = "full"
+	// MaskPartial only reveals the last 4 characters, such as "****1234".
+	MaskPartial = "partial"
+	// MaskEmail only reveals the first character and the domain,
+	// such as "j***@example.com".
+	MaskEmail = "email"
+	// MaskHash replaces the value with a truncated SHA-256 hash, so equal
+	// values can be correlated without revealing them.
+	MaskHash = "hash"
+)
+
+const maskString = "****"
+
+// MaskFunc masks a sensitive value for display.
+type MaskFunc func(value interface{}) interface{}
+
+var (
+	maskStrategiesMutex sync.RWMutex
+	maskStrategies      = map[string]MaskFunc{
+		MaskFull: func(value interface{}) interface{} {
+			return maskString
+		},
+		MaskPartial: func(value interface{}) interface{} {
+			str := fmt.Sprint(value)
+			if len(str) <= 4 {
+				return maskString
+			}
+			return maskString + str[len(str)-4:]
+		},
+		MaskEmail: func(value interface{}) interface{} {
+			str := fmt.Sprint(value)
+			at := strings.LastIndex(str, "@")
+			if at < 1 {
+				return maskString
+			}
+			return str[:1] + "***" + str[at:]
+		},
+		MaskHash: func(value interface{}) interface{} {
+			sum := sha256.Sum256([]byte(fmt.Sprint(value)))
+			return "sha256:" + hex.EncodeToString(sum[:])[:12]
+		},
+	}
+)
+
+// RegisterMaskStrategy registers a custom masking-strategy, which can then
+// be used in struct-tags as "mask=<name>". Registering an existing name
+// replaces the strategy.
+func RegisterMaskStrategy(name string, mask MaskFunc) {
+	maskStrategiesMutex.Lock()
+	defer maskStrategiesMutex.Unlock()
+	maskStrategies[name] = mask
+}
+
+func maskStrategy(name string) (MaskFunc, bool) {
+	maskStrategiesMutex.RLock()
+	defer maskStrategiesMutex.RUnlock()
+	mask, exists := maskStrategies[name]
+	return mask, exists
+}
+
+// maskValue masks the value using the named strategy. Maps and slices
+// (such as filter-operators) are masked recursively.
+func maskValue(value interface{}, strategy string) interface{} {
+	if value == nil {
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map:
+		masked := map[string]interface{}{}
+		for _, k := range v.MapKeys() {
+			masked[fmt.Sprint(k.Interface())] = maskValue(
+				v.MapIndex(k).Interface(), strategy,
+			)
+		}
+		return masked
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			break
+		}
+		masked := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			masked[i] = maskValue(v.Index(i).Interface(), strategy)
+		}
+		return masked
+	}
+
+	mask, exists := maskStrategy(strategy)
+	if !exists {
+		mask, _ = maskStrategy(MaskFull)
+	}
+	return mask(value)
+}
+
+// redactMap returns a copy of the map with sensitive fields masked.
+func (t *schemaTags) redactMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	redacted := map[string]interface{}{}
+	for k, v := range m {
+		redacted[k] = v
+		if t == nil {
+			continue
+		}
+		if strategy, isSensitive := t.sensitive[k]; isSensitive {
+			redacted[k] = maskValue(v, strategy)
+		}
+	}
+	return redacted
+}
+
+// Redact returns a copy of data (a document, filter, or update as a map or
+// struct) as a map with the sensitive fields masked, so its safe to be
+// logged or displayed.
+func (c *Collection) Redact(data interface{}) (map[string]interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+	doc, err := toBSON(data)
+	if err != nil {
+		return nil, errors.Wrap(err, "Redact - BSON Convert Error")
+	}
+	m, err := documentToMap(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "Redact - BSON Convert Error")
+	}
+	return c.tags.redactMap(m), nil
+}
+
+// String renders the Operation with the sensitive fields masked.
+func (op *Operation) String() string {
+	render := func(data interface{}) string {
+		if op.Collection == nil {
+			return "<unavailable>"
+		}
+		redacted, err := op.Collection.Redact(data)
+		if err != nil {
+			return "<unrenderable>"
+		}
+		return fmt.Sprint(redacted)
+	}
+
+	parts := []string{op.Name}
+	if op.Collection != nil {
+		parts = append(
+			parts,
+			fmt.Sprintf("%s.%s", op.Collection.Database, op.Collection.Name),
+		)
+	}
+	if op.Filter != nil {
+		parts = append(parts, "filter="+render(op.Filter))
+	}
+	if op.Update != nil {
+		parts = append(parts, "update="+render(op.Update))
+	}
+	for i, doc := range op.Documents {
+		parts = append(parts, fmt.Sprintf("documents[%d]=%s", i, render(doc)))
+	}
+	if op.Pipeline != nil {
+		parts = append(parts, "pipeline=<pipeline>")
+	}
+	return strings.Join(parts, " ")
+}
+
+// redactedError is an error with sensitive values masked in its message.
+type redactedError struct {
+	message string
+	cause   error
+}
+
+func (e *redactedError) Error() string {
+	return e.message
+}
+
+// Cause returns the original error, which can contain sensitive values.
+func (e *redactedError) Cause() error {
+	return e.cause
+}
+
+// redactedMessage replaces the message of errors whose sensitive values
+// cannot be determined, so these are never leaked.
+const redactedMessage = "Operation Error (message redacted as it can " +
+	"contain sensitive values)"
+
+// redactError masks any sensitive values from Operation appearing in
+// the error-message (such as duplicate-key errors from server).
+// The error is returned as is if it contains no sensitive values. If the
+// sensitive values cannot be determined, the whole message is redacted.
+func (c *Collection) redactError(op *Operation, err error) error {
+	if err == nil || c.tags == nil || len(c.tags.sensitive) == 0 {
+		return err
+	}
+
+	replacements := map[string]string{}
+	collect := func(data interface{}) error {
+		if data == nil {
+			return nil
+		}
+		doc, convErr := toBSON(data)
+		if convErr != nil {
+			return convErr
+		}
+		m, convErr := documentToMap(doc)
+		if convErr != nil {
+			return convErr
+		}
+		for key, strategy := range c.tags.sensitive {
+			collectSensitiveStrings(m[key], strategy, replacements)
+		}
+		return nil
+	}
+	collectErr := collect(op.Filter)
+	if collectErr == nil {
+		collectErr = collect(op.Update)
+	}
+	for _, doc := range op.Documents {
+		if collectErr == nil {
+			collectErr = collect(doc)
+		}
+	}
+	if collectErr != nil {
+		return &redactedError{
+			message: redactedMessage,
+			cause:   err,
+		}
+	}
+
+	message := err.Error()
+	// Longer values are replaced first, so their substrings
+	// don't break the replacement.
+	values := []string{}
+	for v := range replacements {
+		values = append(values, v)
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	redacted := message
+	for _, v := range values {
+		redacted = replaceWholeValue(redacted, v, replacements[v])
+	}
+
+	if redacted == message {
+		return err
+	}
+	return &redactedError{
+		message: redacted,
+		cause:   err,
+	}
+}
+
+// replaceWholeValue replaces the occurrences of value in message which are
+// not part of a longer word or number, so short values (such as "1") don't
+// replace the unrelated parts (such as in "E11000").
+func replaceWholeValue(message string, value string, replacement string) string {
+	var buf strings.Builder
+	for {
+		i := strings.Index(message, value)
+		if i < 0 {
+			buf.WriteString(message)
+			return buf.String()
+		}
+		end := i + len(value)
+		before, _ := utf8.DecodeLastRuneInString(message[:i])
+		after, _ := utf8.DecodeRuneInString(message[end:])
+		if isWordRune(before) || isWordRune(after) {
+			buf.WriteString(message[:end])
+		} else {
+			buf.WriteString(message[:i])
+			buf.WriteString(replacement)
+		}
+		message = message[end:]
+	}
+}
+
+// isWordRune checks if the rune can be part of a word or number.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// collectSensitiveStrings collects the string-forms of sensitive values
+// mapped to their masked forms.
+func collectSensitiveStrings(
+	value interface{},
+	strategy string,
+	replacements map[string]string,
+) {
+	if value == nil {
+		return
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Map:
+		for _, k := range v.MapKeys() {
+			collectSensitiveStrings(v.MapIndex(k).Interface(), strategy, replacements)
+		}
+		return
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < v.Len(); i++ {
+				collectSensitiveStrings(v.Index(i).Interface(), strategy, replacements)
+			}
+			return
+		}
+	}
+
+	str := fmt.Sprint(value)
+	if str != "" {
+		replacements[str] = fmt.Sprint(maskValue(value, strategy))
+	}
+}
@@ -0,0 +1,156 @@
+package mongo
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Redact", func() {
+	type user struct {
+		Name  string `bson:"name,omitempty"`
+		Email string `bson:"email,omitempty" mongoutils:"sensitive,mask=email"`
+		Card  string `bson:"card,omitempty" mongoutils:"sensitive,mask=partial"`
+		SSN   string `bson:"ssn,omitempty" mongoutils:"sensitive"`
+	}
+
+	var c *Collection
+
+	BeforeEach(func() {
+		tags, err := parseSchemaTags(&user{})
+		Expect(err).ToNot(HaveOccurred())
+		c = &Collection{
+			Database: "test_db",
+			Name:     "users",
+			tags:     tags,
+		}
+	})
+
+	It("should parse sensitive fields with their masking-strategies", func() {
+		Expect(c.tags.sensitive).To(Equal(map[string]string{
+			"email": MaskEmail,
+			"card":  MaskPartial,
+			"ssn":   MaskFull,
+		}))
+	})
+
+	It("should return error for unknown masking-strategies", func() {
+		type test struct {
+			Secret string `bson:"secret" mongoutils:"sensitive,mask=unknown"`
+		}
+		_, err := parseSchemaTags(&test{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should mask sensitive fields in documents", func() {
+		redacted, err := c.Redact(&user{
+			Name:  "some-name",
+			Email: "user@example.com",
+			Card:  "4111111111111111",
+			SSN:   "123-45-6789",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(redacted).To(Equal(map[string]interface{}{
+			"name":  "some-name",
+			"email": "u***@example.com",
+			"card":  "****1111",
+			"ssn":   "****",
+		}))
+	})
+
+	It("should mask operands of filter-operators", func() {
+		redacted, err := c.Redact(map[string]interface{}{
+			"ssn": map[string]interface{}{
+				"$in": []interface{}{"123-45-6789", "987-65-4321"},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(redacted["ssn"]).To(Equal(map[string]interface{}{
+			"$in": []interface{}{"****", "****"},
+		}))
+	})
+
+	It("should use registered masking-strategies", func() {
+		RegisterMaskStrategy("redacted", func(value interface{}) interface{} {
+			return "[REDACTED]"
+		})
+		type test struct {
+			Secret string `bson:"secret" mongoutils:"sensitive,mask=redacted"`
+		}
+		tags, err := parseSchemaTags(&test{})
+		Expect(err).ToNot(HaveOccurred())
+		redacted := tags.redactMap(map[string]interface{}{
+			"secret": "some-secret",
+		})
+		Expect(redacted["secret"]).To(Equal("[REDACTED]"))
+	})
+
+	It("should mask sensitive fields when rendering operations", func() {
+		str := (&Operation{
+			Name:       OpFind,
+			Collection: c,
+			Filter: &user{
+				Name: "some-name",
+				SSN:  "123-45-6789",
+			},
+		}).String()
+		Expect(str).To(ContainSubstring("test_db.users"))
+		Expect(str).To(ContainSubstring("some-name"))
+		Expect(str).ToNot(ContainSubstring("123-45-6789"))
+	})
+
+	It("should mask sensitive values in errors returned by operations", func() {
+		c.Use(func(next Handler) Handler {
+			return func(op *Operation) (interface{}, error) {
+				return nil, errors.Wrap(
+					errors.New(`E11000 dup key: { : "123-45-6789" }`),
+					"InsertOne - Insert Error",
+				)
+			}
+		})
+		_, err := c.InsertOne(&user{
+			SSN: "123-45-6789",
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).ToNot(ContainSubstring("123-45-6789"))
+		Expect(err.Error()).To(ContainSubstring(`E11000 dup key: { : "****" }`))
+	})
+
+	It("should only mask the whole sensitive values in errors", func() {
+		c.Use(func(next Handler) Handler {
+			return func(op *Operation) (interface{}, error) {
+				return nil, errors.New(`E11000 index: ssn_1 dup key: { : "1" }`)
+			}
+		})
+		_, err := c.InsertOne(&user{
+			SSN: "1",
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(Equal(`E11000 index: ssn_1 dup key: { : "****" }`))
+	})
+
+	It("should redact the whole error if the sensitive values are unknown", func() {
+		c.Use(func(next Handler) Handler {
+			return func(op *Operation) (interface{}, error) {
+				return nil, errors.New(`E11000 dup key: { : "123-45-6789" }`)
+			}
+		})
+		_, err := c.InsertOne("not-a-document")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).ToNot(ContainSubstring("123-45-6789"))
+		Expect(errors.Cause(err).Error()).To(ContainSubstring("123-45-6789"))
+	})
+
+	It("should return errors without sensitive values as is", func() {
+		testErr := errors.New("some error")
+		c.Use(func(next Handler) Handler {
+			return func(op *Operation) (interface{}, error) {
+				return nil, testErr
+			}
+		})
+		_, err := c.InsertOne(&user{
+			SSN: "123-45-6789",
+		})
+		Expect(err).To(Equal(testErr))
+	})
+})
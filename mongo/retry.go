@@ -0,0 +1,203 @@
+package mongo
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/pkg/errors"
+)
+
+// The MongoDB server error-codes for transient replica-set state changes,
+// such as a primary step-down.
+const (
+	codeShutdownInProgress              = 91
+	codePrimarySteppedDown              = 189
+	codeNotMaster                       = 10107
+	codeInterruptedAtShutdown           = 11600
+	codeInterruptedDueToReplStateChange = 11602
+	codeNotMasterNoSlaveOk              = 13435
+	codeNotMasterOrSecondary            = 13436
+)
+
+// RetryPolicy defines how the Collection operations failing with transient
+// errors are retried. The zero-value fields use their defaults.
+//
+// The operations which might be applied twice if retried after an
+// unacknowledged success are not retried, unless RetryNonIdempotent is set.
+// These are the inserts having any document without an _id, and the
+// UpdateMany and ReplaceOne operations if the SchemaStruct has a field
+// tagged as "version". The inserts having an _id are safe to retry, but
+// return a duplicate-key error on the "_id_" index if an earlier attempt
+// was applied.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum attempts for an operation, including the
+	// first attempt. Defaults to 3.
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. Defaults to 100ms.
+	InitialBackoff time.Duration
+	// MaxBackoff is the maximum wait between retries. Defaults to 5s.
+	MaxBackoff time.Duration
+	// Multiplier is the factor by which the backoff increases after every
+	// retry. Defaults to 2.
+	Multiplier float64
+	// Jitter is the fraction (0 to 1) of backoff that is randomized, so the
+	// clients failing together don't retry together. Defaults to 0.2.
+	Jitter float64
+	// Retryable checks if an error is transient. Defaults to IsRetryable.
+	Retryable func(err error) bool
+	// RetryNonIdempotent also retries the non-idempotent operations.
+	RetryNonIdempotent bool
+	// OnRetry is called before every retry, such as for logging, if set.
+	OnRetry func(op *Operation, attempt int, err error)
+}
+
+// IsRetryable checks if the error is transient, such as a network-error,
+// timeout, or a primary stepping down.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if IsNetworkError(err) || IsTimeout(err) {
+		return true
+	}
+	cause := errors.Cause(err)
+	if labeled, ok := cause.(interface{ HasErrorLabel(string) bool }); ok {
+		if labeled.HasErrorLabel("TransientTransactionError") {
+			return true
+		}
+	}
+	return hasErrorCode(
+		cause,
+		codeShutdownInProgress,
+		codePrimarySteppedDown,
+		codeNotMaster,
+		codeInterruptedAtShutdown,
+		codeInterruptedDueToReplStateChange,
+		codeNotMasterNoSlaveOk,
+		codeNotMasterOrSecondary,
+	)
+}
+
+// withDefaults returns a copy of RetryPolicy with defaults set.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.InitialBackoff == 0 {
+		p.InitialBackoff = 100 * time.Millisecond
+	}
+	if p.MaxBackoff == 0 {
+		p.MaxBackoff = 5 * time.Second
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = 2
+	}
+	if p.Jitter == 0 {
+		p.Jitter = 0.2
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsRetryable
+	}
+	return p
+}
+
+// backoff returns the wait before the specified retry (starting at 1).
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry-1))
+	if backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	jitter := math.Min(math.Max(p.Jitter, 0), 1)
+	backoff -= backoff * jitter * rand.Float64()
+	return time.Duration(backoff)
+}
+
+// retryPolicy returns the RetryPolicy from ConnectionConfig, or from
+// the Client if not set on ConnectionConfig.
+func (c *Collection) retryPolicy() *RetryPolicy {
+	if c.Connection == nil {
+		return nil
+	}
+	if c.Connection.RetryPolicy != nil {
+		return c.Connection.RetryPolicy
+	}
+	if c.Connection.Client != nil {
+		return c.Connection.Client.retryPolicy
+	}
+	return nil
+}
+
+// withRetries wraps the Handler to retry the transient failures as per the
+// RetryPolicy. Operations are not retried once their context is done.
+func (c *Collection) withRetries(policy RetryPolicy, next Handler) Handler {
+	return func(op *Operation) (interface{}, error) {
+		canRetry := policy.RetryNonIdempotent || c.isIdempotent(op)
+
+		for attempt := 1; ; attempt++ {
+			result, err := next(op)
+			if err == nil || !canRetry || attempt >= policy.MaxAttempts {
+				return result, err
+			}
+			if !policy.Retryable(err) || op.Context.Err() != nil {
+				return result, err
+			}
+
+			if policy.OnRetry != nil {
+				policy.OnRetry(op, attempt, err)
+			}
+			timer := time.NewTimer(policy.backoff(attempt))
+			select {
+			case <-timer.C:
+			case <-op.Context.Done():
+				timer.Stop()
+				return result, err
+			}
+		}
+	}
+}
+
+// isIdempotent checks if the Operation can be safely retried
+// after an unacknowledged success.
+func (c *Collection) isIdempotent(op *Operation) bool {
+	switch op.Name {
+	case OpInsertOne, OpInsertMany, OpUpdateMany, OpReplaceOne, OpDeleteMany:
+		// The audited writes are several writes, along with the audit-entries,
+		// which would be partly repeated
+		if c.Auditor != nil {
+			return false
+		}
+	}
+
+	switch op.Name {
+	case OpInsertOne, OpInsertMany:
+		for _, doc := range op.Documents {
+			if !hasID(doc) {
+				return false
+			}
+		}
+		return true
+	case OpUpdateMany, OpReplaceOne:
+		// Versions are incremented on every update
+		return c.tags == nil || c.tags.version == ""
+	}
+	return true
+}
+
+// hasID checks if the document has a non-zero _id.
+func hasID(data interface{}) bool {
+	doc, err := toBSON(data)
+	if err != nil {
+		return false
+	}
+	id := doc.Lookup("_id")
+	if id == nil || id.Type() == bson.TypeNull {
+		return false
+	}
+	if id.Type() == bson.TypeObjectID {
+		return id.ObjectID() != objectid.ObjectID{}
+	}
+	return true
+}
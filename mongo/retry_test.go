@@ -0,0 +1,173 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/core/command"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("RetryPolicy", func() {
+	type item struct {
+		ID   objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		Word string            `bson:"word,omitempty" json:"word,omitempty"`
+	}
+
+	var (
+		c        *Collection
+		attempts int
+		// failures is the number of attempts that fail before succeeding
+		failures int
+		failErr  error
+		handler  Handler
+	)
+
+	BeforeEach(func() {
+		c = &Collection{
+			SchemaStruct: &item{},
+		}
+		attempts = 0
+		failures = 2
+		failErr = errors.Wrap(command.Error{Code: 10107}, "InsertOne Error")
+		handler = func(op *Operation) (interface{}, error) {
+			attempts++
+			if attempts <= failures {
+				return nil, failErr
+			}
+			return "result", nil
+		}
+	})
+
+	policy := RetryPolicy{
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	}.withDefaults()
+
+	It("should retry idempotent operations failing with transient errors", func() {
+		result, err := c.withRetries(policy, handler)(&Operation{
+			Name:    OpFind,
+			Context: context.Background(),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal("result"))
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("should return the last error after MaxAttempts", func() {
+		failures = 5
+		_, err := c.withRetries(policy, handler)(&Operation{
+			Name:    OpFind,
+			Context: context.Background(),
+		})
+		Expect(err).To(Equal(failErr))
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("should not retry non-transient errors", func() {
+		failErr = errors.New("some error")
+		_, err := c.withRetries(policy, handler)(&Operation{
+			Name:    OpFind,
+			Context: context.Background(),
+		})
+		Expect(err).To(Equal(failErr))
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("should not retry inserts of documents without an _id", func() {
+		_, err := c.withRetries(policy, handler)(&Operation{
+			Name:      OpInsertOne,
+			Context:   context.Background(),
+			Documents: []interface{}{&item{Word: "some-word"}},
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("should retry inserts of documents having an _id", func() {
+		_, err := c.withRetries(policy, handler)(&Operation{
+			Name:    OpInsertOne,
+			Context: context.Background(),
+			Documents: []interface{}{&item{
+				ID:   objectid.New(),
+				Word: "some-word",
+			}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("should retry non-idempotent operations if enabled", func() {
+		nonIdempotent := policy
+		nonIdempotent.RetryNonIdempotent = true
+		_, err := c.withRetries(nonIdempotent, handler)(&Operation{
+			Name:      OpInsertOne,
+			Context:   context.Background(),
+			Documents: []interface{}{&item{Word: "some-word"}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("should not retry versioned updates", func() {
+		type versioned struct {
+			Version int64 `bson:"version" mongoutils:"version"`
+		}
+		tags, err := parseSchemaTags(&versioned{})
+		Expect(err).ToNot(HaveOccurred())
+		c.tags = tags
+
+		_, err = c.withRetries(policy, handler)(&Operation{
+			Name:    OpUpdateMany,
+			Context: context.Background(),
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("should not retry audited writes", func() {
+		c.Auditor = &Auditor{}
+
+		_, err := c.withRetries(policy, handler)(&Operation{
+			Name:    OpDeleteMany,
+			Context: context.Background(),
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(attempts).To(Equal(1))
+
+		// The reads are not audited
+		attempts = 0
+		_, err = c.withRetries(policy, handler)(&Operation{
+			Name:    OpFind,
+			Context: context.Background(),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(attempts).To(Equal(3))
+	})
+
+	It("should stop retrying once the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := c.withRetries(policy, handler)(&Operation{
+			Name:    OpFind,
+			Context: ctx,
+		})
+		Expect(err).To(HaveOccurred())
+		Expect(attempts).To(Equal(1))
+	})
+
+	It("should increase the backoff up to MaxBackoff", func() {
+		p := RetryPolicy{
+			InitialBackoff: 100 * time.Millisecond,
+			MaxBackoff:     300 * time.Millisecond,
+			Jitter:         0.5,
+		}.withDefaults()
+		ms := time.Millisecond
+		Expect(p.backoff(1)).To(BeNumerically("~", 75*ms, 25*ms))
+		Expect(p.backoff(2)).To(BeNumerically("~", 150*ms, 50*ms))
+		Expect(p.backoff(5)).To(BeNumerically("~", 225*ms, 75*ms))
+	})
+})
@@ -0,0 +1,419 @@
+package mongo
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// TagName is the struct-tag key used on SchemaStruct fields for enabling
+// the features provided by this library. For example:
+//  type item struct {
+//    CreatedAt time.Time `bson:"createdAt" mongoutils:"createdAt"`
+//    UpdatedAt time.Time `bson:"updatedAt" mongoutils:"updatedAt"`
+//    Version   int64     `bson:"version" mongoutils:"version"`
+//  }
+const TagName = "mongoutils"
+
+// The options supported in TagName struct-tags.
+const (
+	// TagCreatedAt marks a time.Time field to be set to current time
+	// when the document is inserted.
+	TagCreatedAt = "createdAt"
+	// TagUpdatedAt marks a time.Time field to be set to current time
+	// when the document is inserted or updated.
+	TagUpdatedAt = "updatedAt"
+	// TagVersion marks an integer field to be set to 1 when the document
+	// is inserted, and incremented every time the document is updated.
+	TagVersion = "version"
+)
+
+// schemaTags holds the information parsed from TagName struct-tags
+// on SchemaStruct. The fields are the BSON-keys of tagged fields.
+type schemaTags struct {
+	createdAt string
+	updatedAt string
+	version   string
+	// schemaVersion is the key of TagSchemaVersion field, and
+	// currentSchemaVersion is set from Collection.SchemaUpgrades
+	schemaVersion        string
+	currentSchemaVersion int64
+	// encrypted maps the keys of encrypted fields to whether
+	// the encryption is deterministic
+	encrypted map[string]bool
+	// sensitive maps the keys of sensitive fields to their masking-strategy
+	sensitive map[string]string
+}
+
+// bsonKey returns the BSON-key for a struct-field, which is the name from
+// its bson-tag, or the lowercased field-name if no name is specified.
+func bsonKey(field reflect.StructField) string {
+	tagName := strings.Split(field.Tag.Get("bson"), ",")[0]
+	if tagName == "" {
+		return strings.ToLower(field.Name)
+	}
+	return tagName
+}
+
+// tagOptions returns the comma-separated options in TagName struct-tag.
+func tagOptions(field reflect.StructField) []string {
+	tag := field.Tag.Get(TagName)
+	if tag == "" {
+		return nil
+	}
+	options := strings.Split(tag, ",")
+	for i, o := range options {
+		options[i] = strings.TrimSpace(o)
+	}
+	return options
+}
+
+// parseSchemaTags parses the TagName struct-tags on SchemaStruct.
+func parseSchemaTags(schemaStruct interface{}) (*schemaTags, error) {
+	tags := &schemaTags{
+		encrypted: map[string]bool{},
+		sensitive: map[string]string{},
+	}
+	schemaType := reflect.TypeOf(schemaStruct).Elem()
+	timeType := reflect.TypeOf(time.Time{})
+
+	for i := 0; i < schemaType.NumField(); i++ {
+		field := schemaType.Field(i)
+		key := bsonKey(field)
+
+		options := tagOptions(field)
+		for _, option := range options {
+			switch option {
+			case TagCreatedAt, TagUpdatedAt:
+				if field.Type != timeType {
+					return nil, fmt.Errorf(
+						"Field %s tagged as %s must be of type time.Time",
+						field.Name, option,
+					)
+				}
+				if option == TagCreatedAt {
+					tags.createdAt = key
+				} else {
+					tags.updatedAt = key
+				}
+			case TagVersion:
+				if !isIntegerKind(field.Type.Kind()) {
+					return nil, fmt.Errorf(
+						"Field %s tagged as %s must be an integer",
+						field.Name, option,
+					)
+				}
+				tags.version = key
+			case TagSchemaVersion:
+				if !isIntegerKind(field.Type.Kind()) {
+					return nil, fmt.Errorf(
+						"Field %s tagged as %s must be an integer",
+						field.Name, option,
+					)
+				}
+				tags.schemaVersion = key
+			case TagEncrypt:
+				tags.encrypted[key] = hasOption(options, TagDeterministic)
+				tags.sensitive[key] = maskOption(options)
+			case TagSensitive:
+				tags.sensitive[key] = maskOption(options)
+			}
+		}
+
+		strategy := tags.sensitive[key]
+		if _, exists := maskStrategy(strategy); strategy != "" && !exists {
+			return nil, fmt.Errorf(
+				"Field %s has unknown masking-strategy: %s", field.Name, strategy,
+			)
+		}
+	}
+	return tags, nil
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// maskOption returns the masking-strategy from TagMaskPrefix option,
+// defaulting to MaskFull.
+func maskOption(options []string) string {
+	for _, o := range options {
+		if strings.HasPrefix(o, TagMaskPrefix) {
+			return strings.TrimPrefix(o, TagMaskPrefix)
+		}
+	}
+	return MaskFull
+}
+
+func isIntegerKind(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// isZeroValue checks if the BSON-value is absent, null, or zero.
+func isZeroValue(v *bson.Value) bool {
+	if v == nil {
+		return true
+	}
+	switch v.Type() {
+	case bson.TypeNull:
+		return true
+	case bson.TypeDateTime:
+		return v.Time().IsZero() || v.Time().Year() <= 1
+	case bson.TypeInt32:
+		return v.Int32() == 0
+	case bson.TypeInt64:
+		return v.Int64() == 0
+	}
+	return false
+}
+
+// applyInsertTags sets the createdAt, updatedAt, version, and schemaVersion
+// fields on a document to be inserted. An already set createdAt, version, or
+// schemaVersion is retained. The struct-fields are set separately using
+// setInsertedFields once the insert succeeds, so the insert can be retried
+// with the same struct.
+func (t *schemaTags) applyInsertTags(doc *bson.Document, now time.Time) {
+	if t == nil {
+		return
+	}
+	if t.createdAt != "" && isZeroValue(doc.Lookup(t.createdAt)) {
+		doc.Set(bson.EC.Time(t.createdAt, now))
+	}
+	if t.updatedAt != "" {
+		doc.Set(bson.EC.Time(t.updatedAt, now))
+	}
+	if t.version != "" && isZeroValue(doc.Lookup(t.version)) {
+		doc.Set(bson.EC.Int64(t.version, 1))
+	}
+	if t.schemaVersion != "" && isZeroValue(doc.Lookup(t.schemaVersion)) {
+		doc.Set(bson.EC.Int64(t.schemaVersion, t.currentSchemaVersion))
+	}
+}
+
+// setInsertedFields sets the tagged fields of data, if it is a pointer
+// to struct, to the values set on doc by applyInsertTags. An already set
+// createdAt is retained.
+func (t *schemaTags) setInsertedFields(
+	data interface{},
+	doc *bson.Document,
+	now time.Time,
+) {
+	if t == nil {
+		return
+	}
+	if t.createdAt != "" && isZeroStructField(data, t.createdAt) {
+		setStructField(data, t.createdAt, now)
+	}
+	if t.updatedAt != "" {
+		setStructField(data, t.updatedAt, now)
+	}
+	if t.version != "" {
+		if version, ok := versionValue(doc.Lookup(t.version)); ok {
+			setStructField(data, t.version, version)
+		}
+	}
+	if t.schemaVersion != "" {
+		if version, ok := versionValue(doc.Lookup(t.schemaVersion)); ok {
+			setStructField(data, t.schemaVersion, version)
+		}
+	}
+}
+
+// updateDocument builds the update-document from the provided $set document,
+// adding the updatedAt, and version increments. The createdAt and
+// schemaVersion are set on insert when the update results in an upsert.
+func (t *schemaTags) updateDocument(
+	setDoc *bson.Document,
+	now time.Time,
+) *bson.Document {
+	updateDoc := bson.NewDocument()
+	if t == nil {
+		return updateDoc.Append(bson.EC.SubDocument("$set", setDoc))
+	}
+
+	if t.updatedAt != "" {
+		setDoc.Set(bson.EC.Time(t.updatedAt, now))
+	}
+	updateDoc.Append(bson.EC.SubDocument("$set", setDoc))
+
+	// Keys present in $set cannot be modified by other operators
+	if t.version != "" && setDoc.Lookup(t.version) == nil {
+		updateDoc.Append(bson.EC.SubDocumentFromElements(
+			"$inc",
+			bson.EC.Int64(t.version, 1),
+		))
+	}
+	setOnInsertDoc := bson.NewDocument()
+	if t.createdAt != "" && setDoc.Lookup(t.createdAt) == nil {
+		setOnInsertDoc.Append(bson.EC.Time(t.createdAt, now))
+	}
+	if t.schemaVersion != "" && setDoc.Lookup(t.schemaVersion) == nil {
+		setOnInsertDoc.Append(
+			bson.EC.Int64(t.schemaVersion, t.currentSchemaVersion),
+		)
+	}
+	if setOnInsertDoc.Len() > 0 {
+		updateDoc.Append(bson.EC.SubDocument("$setOnInsert", setOnInsertDoc))
+	}
+	return updateDoc
+}
+
+// setStructField sets the field with specified BSON-key on data,
+// if data is a pointer to struct. This is a no-op otherwise.
+func setStructField(data interface{}, key string, value interface{}) {
+	field, ok := structField(data, key)
+	if !ok || !field.CanSet() {
+		return
+	}
+	newValue := reflect.ValueOf(value)
+	if newValue.Type().ConvertibleTo(field.Type()) {
+		field.Set(newValue.Convert(field.Type()))
+	}
+}
+
+// isZeroStructField checks if the field with specified BSON-key on data
+// has its zero-value. This is false if data is not a pointer to struct.
+func isZeroStructField(data interface{}, key string) bool {
+	field, ok := structField(data, key)
+	if !ok || !field.CanInterface() {
+		return false
+	}
+	zero := reflect.Zero(field.Type()).Interface()
+	return reflect.DeepEqual(field.Interface(), zero)
+}
+
+// structField returns the field with specified BSON-key on data,
+// if data is a pointer to struct.
+func structField(data interface{}, key string) (reflect.Value, bool) {
+	dataValue := reflect.ValueOf(data)
+	if dataValue.Kind() != reflect.Ptr || dataValue.IsNil() {
+		return reflect.Value{}, false
+	}
+	dataValue = dataValue.Elem()
+	if dataValue.Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+
+	dataType := dataValue.Type()
+	for i := 0; i < dataType.NumField(); i++ {
+		if bsonKey(dataType.Field(i)) == key {
+			return dataValue.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// ErrVersionConflict is returned by updates and replacements on Collections
+// with OptimisticLocking when no document matches the expected version.
+// This indicates that the document was modified concurrently (or does not
+// exist), and the operation can be retried after reading the document again.
+var ErrVersionConflict = errors.New(
+	"Version Conflict: document was modified concurrently or does not exist",
+)
+
+// versionValue returns the integer-value of a version-field.
+func versionValue(v *bson.Value) (int64, bool) {
+	if v == nil {
+		return 0, false
+	}
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32()), true
+	case bson.TypeInt64:
+		return v.Int64(), true
+	}
+	return 0, false
+}
+
+// verifyVersionedUpdate ensures that the filter specifies the expected
+// version, and that the update does not modify the version by itself.
+func (t *schemaTags) verifyVersionedUpdate(
+	filterDoc *bson.Document,
+	setDoc *bson.Document,
+) error {
+	version, ok := versionValue(filterDoc.Lookup(t.version))
+	if !ok || version == 0 {
+		return fmt.Errorf(
+			"Filter must specify the expected version in field: %s", t.version,
+		)
+	}
+	if setDoc.Lookup(t.version) != nil {
+		return fmt.Errorf(
+			"Update cannot modify the version-field: %s", t.version,
+		)
+	}
+	return nil
+}
+
+// applyExpectedVersion adds the version from replacement into the filter,
+// so the replacement only succeeds if the stored version is unchanged.
+func (t *schemaTags) applyExpectedVersion(
+	filterDoc *bson.Document,
+	replacementDoc *bson.Document,
+) error {
+	version, ok := versionValue(replacementDoc.Lookup(t.version))
+	if !ok || version == 0 {
+		return fmt.Errorf(
+			"Replacement must specify the expected version in field: %s",
+			t.version,
+		)
+	}
+	filterDoc.Set(bson.EC.Int64(t.version, version))
+	return nil
+}
+
+// applyReplaceTags sets the updatedAt and schemaVersion, and increments the
+// version on a replacement-document. The struct-fields are set separately
+// using setReplacedFields once the replacement succeeds, so the replacement
+// can be retried with the same struct.
+func (t *schemaTags) applyReplaceTags(doc *bson.Document, now time.Time) {
+	if t == nil {
+		return
+	}
+	if t.updatedAt != "" {
+		doc.Set(bson.EC.Time(t.updatedAt, now))
+	}
+	if t.version != "" {
+		version, _ := versionValue(doc.Lookup(t.version))
+		doc.Set(bson.EC.Int64(t.version, version+1))
+	}
+	if t.schemaVersion != "" {
+		doc.Set(bson.EC.Int64(t.schemaVersion, t.currentSchemaVersion))
+	}
+}
+
+// setReplacedFields sets the tagged fields of replacement, if it is a pointer
+// to struct, to the values set on doc by applyReplaceTags.
+func (t *schemaTags) setReplacedFields(
+	replacement interface{},
+	doc *bson.Document,
+	now time.Time,
+) {
+	if t == nil {
+		return
+	}
+	if t.updatedAt != "" {
+		setStructField(replacement, t.updatedAt, now)
+	}
+	if t.version != "" {
+		version, _ := versionValue(doc.Lookup(t.version))
+		setStructField(replacement, t.version, version)
+	}
+	if t.schemaVersion != "" {
+		setStructField(replacement, t.schemaVersion, t.currentSchemaVersion)
+	}
+}
@@ -0,0 +1,138 @@
+package mongo
+
+import (
+	"time"
+
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("SchemaTags", func() {
+	type item struct {
+		ID        objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		Word      string            `bson:"word" json:"word"`
+		CreatedAt time.Time         `bson:"createdAt" mongoutils:"createdAt"`
+		UpdatedAt time.Time         `bson:"updatedAt" mongoutils:"updatedAt"`
+		Version   int               `bson:"version" mongoutils:"version"`
+	}
+
+	Describe("parseSchemaTags", func() {
+		It("should parse the BSON-keys of tagged fields", func() {
+			tags, err := parseSchemaTags(&item{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags.createdAt).To(Equal("createdAt"))
+			Expect(tags.updatedAt).To(Equal("updatedAt"))
+			Expect(tags.version).To(Equal("version"))
+		})
+
+		It("should use lowercased field-name if bson-tag has no name", func() {
+			type test struct {
+				Created time.Time `mongoutils:"createdAt"`
+			}
+			tags, err := parseSchemaTags(&test{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags.createdAt).To(Equal("created"))
+		})
+
+		It("should return error if timestamp-field is not time.Time", func() {
+			type test struct {
+				CreatedAt string `bson:"createdAt" mongoutils:"createdAt"`
+			}
+			_, err := parseSchemaTags(&test{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if version-field is not an integer", func() {
+			type test struct {
+				Version string `bson:"version" mongoutils:"version"`
+			}
+			_, err := parseSchemaTags(&test{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("applyInsertTags", func() {
+		It("should set timestamps and version on document", func() {
+			tags, err := parseSchemaTags(&item{})
+			Expect(err).ToNot(HaveOccurred())
+
+			data := &item{
+				Word: "some-word",
+			}
+			doc, err := toBSON(data)
+			Expect(err).ToNot(HaveOccurred())
+
+			now := time.Now().UTC().Truncate(time.Millisecond)
+			tags.applyInsertTags(doc, now)
+
+			Expect(doc.Lookup("createdAt").Time().Equal(now)).To(BeTrue())
+			Expect(doc.Lookup("updatedAt").Time().Equal(now)).To(BeTrue())
+			Expect(doc.Lookup("version").Int64()).To(Equal(int64(1)))
+
+			// The struct is set only by setInsertedFields
+			Expect(data.CreatedAt.IsZero()).To(BeTrue())
+			Expect(data.Version).To(Equal(0))
+			tags.setInsertedFields(data, doc, now)
+			Expect(data.CreatedAt).To(Equal(now))
+			Expect(data.UpdatedAt).To(Equal(now))
+			Expect(data.Version).To(Equal(1))
+		})
+
+		It("should retain createdAt if already set", func() {
+			tags, err := parseSchemaTags(&item{})
+			Expect(err).ToNot(HaveOccurred())
+
+			createdAt := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+			data := &item{
+				Word:      "some-word",
+				CreatedAt: createdAt,
+			}
+			doc, err := toBSON(data)
+			Expect(err).ToNot(HaveOccurred())
+
+			now := time.Now()
+			tags.applyInsertTags(doc, now)
+			tags.setInsertedFields(data, doc, now)
+			Expect(doc.Lookup("createdAt").Time().Equal(createdAt)).To(BeTrue())
+			Expect(data.CreatedAt).To(Equal(createdAt))
+		})
+	})
+
+	Describe("updateDocument", func() {
+		It("should add updatedAt, version-increment and createdAt-on-insert", func() {
+			tags, err := parseSchemaTags(&item{})
+			Expect(err).ToNot(HaveOccurred())
+
+			setDoc, err := toBSON(map[string]interface{}{
+				"word": "updated-word",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			now := time.Now().UTC().Truncate(time.Millisecond)
+			updateDoc := tags.updateDocument(setDoc, now)
+
+			set := updateDoc.Lookup("$set").MutableDocument()
+			Expect(set.Lookup("word").StringValue()).To(Equal("updated-word"))
+			Expect(set.Lookup("updatedAt").Time().Equal(now)).To(BeTrue())
+
+			inc := updateDoc.Lookup("$inc").MutableDocument()
+			Expect(inc.Lookup("version").Int64()).To(Equal(int64(1)))
+
+			setOnInsert := updateDoc.Lookup("$setOnInsert").MutableDocument()
+			Expect(setOnInsert.Lookup("createdAt").Time().Equal(now)).To(BeTrue())
+		})
+
+		It("should only add $set if there are no tags", func() {
+			var tags *schemaTags
+			setDoc, err := toBSON(map[string]interface{}{
+				"word": "updated-word",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			updateDoc := tags.updateDocument(setDoc, time.Now())
+			Expect(updateDoc.Len()).To(Equal(1))
+			Expect(updateDoc.Lookup("$set")).ToNot(BeNil())
+		})
+	})
+})
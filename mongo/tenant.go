@@ -0,0 +1,323 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// TenantMode defines how the tenants' data is separated.
+type TenantMode int
+
+// The supported TenantModes.
+const (
+	// TenantPerDatabase stores each tenant's data in a separate database,
+	// named as: <Database>_<tenantID>.
+	TenantPerDatabase TenantMode = iota
+	// TenantPerCollection stores each tenant's data in a separate collection,
+	// named as: <Name>_<tenantID>.
+	TenantPerCollection
+	// TenantPerField stores all tenants' data in the same collection, with
+	// the tenant-ID added to every document in TenantConfig.TenantField.
+	TenantPerField
+)
+
+// DefaultTenantField is the field holding the tenant-ID with TenantPerField
+// mode when TenantConfig.TenantField is not specified.
+const DefaultTenantField = "tenantId"
+
+// ErrNoTenant is returned when the tenant cannot be resolved from context.
+var ErrNoTenant = errors.New("Tenant not found in context")
+
+type tenantContextKey struct{}
+
+// WithTenant returns a copy of context carrying the tenant-ID.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant-ID set using WithTenant.
+// ErrNoTenant is returned if no tenant-ID is set.
+func TenantFromContext(ctx context.Context) (string, error) {
+	tenantID, _ := ctx.Value(tenantContextKey{}).(string)
+	if tenantID == "" {
+		return "", ErrNoTenant
+	}
+	return tenantID, nil
+}
+
+// TenantConfig defines the configuration for a TenantFactory.
+type TenantConfig struct {
+	Mode TenantMode
+	// Collection is the template for tenants' Collections. Its Database
+	// and Name are used as prefixes as per the Mode.
+	Collection *Collection
+	// TenantField is the field holding tenant-ID with TenantPerField mode.
+	// Defaults to DefaultTenantField.
+	TenantField string
+	// Resolver resolves the tenant-ID from context.
+	// Defaults to TenantFromContext.
+	Resolver func(ctx context.Context) (string, error)
+}
+
+// TenantFactory provides the Collections for tenants resolved from context.
+// The tenants' Collections are ensured once (along with their indexes),
+// and then cached for subsequent use.
+type TenantFactory struct {
+	config TenantConfig
+
+	mutex       sync.Mutex
+	collections map[string]*tenantCollection
+	// shared is the Collection used by all tenants in TenantPerField mode
+	shared *Collection
+}
+
+// tenantCollection is a tenant's Collection, which is ready once the
+// Collection is ensured, or ensuring it failed.
+type tenantCollection struct {
+	ready      chan struct{}
+	collection *Collection
+	err        error
+}
+
+// NewTenantFactory creates a new TenantFactory. With TenantPerField mode,
+// the shared Collection is ensured immediately.
+func NewTenantFactory(config TenantConfig) (*TenantFactory, error) {
+	if config.Collection == nil {
+		return nil, errors.New("TenantConfig.Collection cannot be nil")
+	}
+	if config.TenantField == "" {
+		config.TenantField = DefaultTenantField
+	}
+	if config.Resolver == nil {
+		config.Resolver = TenantFromContext
+	}
+
+	factory := &TenantFactory{
+		config:      config,
+		collections: map[string]*tenantCollection{},
+	}
+	if config.Mode != TenantPerField {
+		return factory, nil
+	}
+
+	shared := *config.Collection
+	shared.Middlewares = append(
+		[]Middleware{factory.tenantFieldMiddleware},
+		config.Collection.Middlewares...,
+	)
+	c, err := EnsureCollection(&shared)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Ensuring Tenant-Collection")
+	}
+	factory.shared = c
+	return factory, nil
+}
+
+// Collection returns the Collection for the tenant resolved from context.
+// The returned Collection runs its operations with the provided context.
+func (f *TenantFactory) Collection(ctx context.Context) (*Collection, error) {
+	tenantID, err := f.config.Resolver(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Resolving Tenant")
+	}
+	if f.config.Mode == TenantPerField {
+		return f.shared.WithContext(ctx), nil
+	}
+
+	err = verifyTenantID(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The Collection is ensured without holding the mutex, so the other
+	// tenants are not blocked meanwhile
+	f.mutex.Lock()
+	tc, exists := f.collections[tenantID]
+	if !exists {
+		tc = &tenantCollection{
+			ready: make(chan struct{}),
+		}
+		f.collections[tenantID] = tc
+	}
+	f.mutex.Unlock()
+
+	if !exists {
+		tc.collection, tc.err = f.ensureCollection(tenantID)
+		if tc.err != nil {
+			// The failures are not cached, so the next call retries
+			f.mutex.Lock()
+			delete(f.collections, tenantID)
+			f.mutex.Unlock()
+		}
+		close(tc.ready)
+	}
+
+	select {
+	case <-tc.ready:
+	case <-ctx.Done():
+		return nil, errors.Wrapf(
+			ctx.Err(), "Error Ensuring Collection for Tenant: %s", tenantID,
+		)
+	}
+	if tc.err != nil {
+		return nil, errors.Wrapf(
+			tc.err, "Error Ensuring Collection for Tenant: %s", tenantID,
+		)
+	}
+	return tc.collection.WithContext(ctx), nil
+}
+
+// ensureCollection ensures the Collection for tenant, with the Database or
+// Name suffixed with the tenant-ID as per the Mode.
+func (f *TenantFactory) ensureCollection(tenantID string) (*Collection, error) {
+	tenantColl := *f.config.Collection
+	if f.config.Mode == TenantPerDatabase {
+		tenantColl.Database = tenantColl.Database + "_" + tenantID
+	} else {
+		tenantColl.Name = tenantColl.Name + "_" + tenantID
+	}
+	return EnsureCollection(&tenantColl)
+}
+
+// verifyTenantID ensures that the tenant-ID can be used in
+// database and collection names.
+func verifyTenantID(tenantID string) error {
+	if strings.ContainsAny(tenantID, "/\\. \"$*<>:|?") {
+		return fmt.Errorf(
+			"Tenant-ID: %s contains characters invalid for database-names",
+			tenantID,
+		)
+	}
+	return nil
+}
+
+// tenantFieldMiddleware scopes the operations to the tenant from operation's
+// context by injecting the tenant-ID into filters, documents, and updates.
+func (f *TenantFactory) tenantFieldMiddleware(next Handler) Handler {
+	return func(op *Operation) (interface{}, error) {
+		tenantID, err := f.config.Resolver(op.Context)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error Resolving Tenant")
+		}
+		field := f.config.TenantField
+
+		if op.Filter != nil {
+			op.Filter, err = withField(op.Filter, field, tenantID)
+			if err != nil {
+				return nil, errors.Wrap(err, "Error Scoping Filter to Tenant")
+			}
+		}
+		if op.Documents != nil {
+			// Copied so the caller's slice is not modified
+			docs := make([]interface{}, len(op.Documents))
+			for i, doc := range op.Documents {
+				docs[i], err = withDocumentField(doc, field, tenantID)
+				if err != nil {
+					return nil, errors.Wrap(err, "Error Scoping Document to Tenant")
+				}
+			}
+			op.Documents = docs
+		}
+		if op.Update != nil {
+			if op.Name == OpReplaceOne {
+				op.Update, err = withDocumentField(op.Update, field, tenantID)
+			} else {
+				op.Update, err = withField(op.Update, field, tenantID)
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "Error Scoping Update to Tenant")
+			}
+		}
+		if op.Pipeline != nil {
+			op.Pipeline, err = withMatchStage(op.Pipeline, field, tenantID)
+			if err != nil {
+				return nil, errors.Wrap(err, "Error Scoping Pipeline to Tenant")
+			}
+		}
+		return next(op)
+	}
+}
+
+// withField returns a map-copy of data (map or struct) with the field set.
+func withField(
+	data interface{},
+	field string,
+	value interface{},
+) (map[string]interface{}, error) {
+	doc, err := toBSON(data)
+	if err != nil {
+		return nil, err
+	}
+	m, err := documentToMap(doc)
+	if err != nil {
+		return nil, err
+	}
+	m[field] = value
+	return m, nil
+}
+
+// withDocumentField sets the field on document. A pointer to struct having
+// the field is modified in place (so the caller sees the change), and any
+// other data is converted to a map with the field set.
+func withDocumentField(
+	data interface{},
+	field string,
+	value string,
+) (interface{}, error) {
+	dataValue := reflect.ValueOf(data)
+	isStructPtr := dataValue.Kind() == reflect.Ptr &&
+		!dataValue.IsNil() &&
+		dataValue.Elem().Kind() == reflect.Struct
+
+	if isStructPtr {
+		dataType := dataValue.Elem().Type()
+		for i := 0; i < dataType.NumField(); i++ {
+			f := dataType.Field(i)
+			if bsonKey(f) == field && f.Type.Kind() == reflect.String {
+				dataValue.Elem().Field(i).SetString(value)
+				return data, nil
+			}
+		}
+	}
+	return withField(data, field, value)
+}
+
+// withMatchStage prepends a $match stage on field to the pipeline.
+func withMatchStage(
+	pipeline interface{},
+	field string,
+	value string,
+) (interface{}, error) {
+	match := bson.NewDocument(
+		bson.EC.SubDocumentFromElements(
+			"$match",
+			bson.EC.String(field, value),
+		),
+	)
+
+	switch p := pipeline.(type) {
+	case *bson.Array:
+		scoped := bson.NewArray(bson.VC.Document(match))
+		for i := 0; i < p.Len(); i++ {
+			stage, err := p.Lookup(uint(i))
+			if err != nil {
+				return nil, err
+			}
+			scoped.Append(stage)
+		}
+		return scoped, nil
+	case []interface{}:
+		return append([]interface{}{match}, p...), nil
+	case []*bson.Document:
+		return append([]*bson.Document{match}, p...), nil
+	}
+	return nil, fmt.Errorf(
+		"Unsupported pipeline-type: %s", reflect.TypeOf(pipeline),
+	)
+}
@@ -0,0 +1,273 @@
+package mongo
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("TenantFactory", func() {
+	type item struct {
+		ID       objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		TenantID string            `bson:"tenantId,omitempty" json:"tenantId,omitempty"`
+		Word     string            `bson:"word,omitempty" json:"word,omitempty"`
+	}
+
+	Describe("tenantFieldMiddleware", func() {
+		var (
+			factory  *TenantFactory
+			captured *Operation
+			handler  Handler
+		)
+
+		BeforeEach(func() {
+			factory = &TenantFactory{
+				config: TenantConfig{
+					Mode:        TenantPerField,
+					TenantField: DefaultTenantField,
+					Resolver:    TenantFromContext,
+				},
+			}
+			handler = factory.tenantFieldMiddleware(
+				func(op *Operation) (interface{}, error) {
+					captured = op
+					return nil, nil
+				},
+			)
+		})
+
+		It("should return ErrNoTenant if context has no tenant", func() {
+			_, err := handler(&Operation{
+				Name:    OpFind,
+				Context: context.Background(),
+				Filter:  &item{},
+			})
+			Expect(errors.Cause(err)).To(Equal(ErrNoTenant))
+		})
+
+		It("should inject the tenant-ID into filter and update", func() {
+			ctx := WithTenant(context.Background(), "tenant1")
+			_, err := handler(&Operation{
+				Name:    OpUpdateMany,
+				Context: ctx,
+				Filter: &item{
+					Word:     "some-word",
+					TenantID: "tenant2",
+				},
+				Update: map[string]interface{}{
+					"word": "updated-word",
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			filter := captured.Filter.(map[string]interface{})
+			Expect(filter["tenantId"]).To(Equal("tenant1"))
+			Expect(filter["word"]).To(Equal("some-word"))
+
+			update := captured.Update.(map[string]interface{})
+			Expect(update["tenantId"]).To(Equal("tenant1"))
+		})
+
+		It("should set the tenant-ID on inserted documents", func() {
+			ctx := WithTenant(context.Background(), "tenant1")
+			data := &item{
+				Word: "some-word",
+			}
+			docs := []interface{}{
+				data,
+				map[string]interface{}{
+					"word": "some-word2",
+				},
+			}
+			_, err := handler(&Operation{
+				Name:      OpInsertMany,
+				Context:   ctx,
+				Documents: docs,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(data.TenantID).To(Equal("tenant1"))
+			mapDoc := captured.Documents[1].(map[string]interface{})
+			Expect(mapDoc["tenantId"]).To(Equal("tenant1"))
+			// Caller's slice is not modified
+			Expect(docs[1]).ToNot(HaveKey("tenantId"))
+		})
+
+		It("should prepend a $match stage to the pipeline", func() {
+			ctx := WithTenant(context.Background(), "tenant1")
+			_, err := handler(&Operation{
+				Name:     OpAggregate,
+				Context:  ctx,
+				Pipeline: []interface{}{},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			pipeline := captured.Pipeline.([]interface{})
+			Expect(pipeline).To(HaveLen(1))
+			match := pipeline[0].(*bson.Document).
+				Lookup("$match").
+				MutableDocument()
+			Expect(match.Lookup("tenantId").StringValue()).To(Equal("tenant1"))
+		})
+	})
+
+	Describe("Collection", func() {
+		var (
+			conn         *ConnectionConfig
+			testDatabase string
+		)
+
+		BeforeEach(func() {
+			hosts := os.Getenv("MONGO_TEST_HOSTS")
+			username := os.Getenv("MONGO_TEST_USERNAME")
+			password := os.Getenv("MONGO_TEST_PASSWORD")
+			resourceTimeoutStr := os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS")
+			testDatabase = os.Getenv("MONGO_TEST_DATABASE")
+
+			resourceTimeoutInt, err := strconv.Atoi(resourceTimeoutStr)
+			if err != nil {
+				err = errors.Wrap(
+					err,
+					"error getting RESOURCE_TIMEOUT from env, will use 3000",
+				)
+				log.Println(err)
+				resourceTimeoutInt = 3000
+			}
+			resourceTimeout := uint32(resourceTimeoutInt)
+
+			client, err := NewClient(ClientConfig{
+				Hosts:               *commonutil.ParseHosts(hosts),
+				Username:            username,
+				Password:            password,
+				TimeoutMilliseconds: resourceTimeout,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, db := range []string{testDatabase, testDatabase + "_tenant1"} {
+				dbCtx, dbCancel := newTimeoutContext(resourceTimeout)
+				err = client.Database(db).Drop(dbCtx)
+				dbCancel()
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			conn = &ConnectionConfig{
+				Client:  client,
+				Timeout: resourceTimeout,
+			}
+		})
+
+		AfterEach(func() {
+			err := conn.Client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should route to and cache the per-tenant database", func() {
+			factory, err := NewTenantFactory(TenantConfig{
+				Mode: TenantPerDatabase,
+				Collection: &Collection{
+					Connection:   conn,
+					Database:     testDatabase,
+					Name:         "test_collection",
+					SchemaStruct: &item{},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			ctx := WithTenant(context.Background(), "tenant1")
+			c, err := factory.Collection(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Database).To(Equal(testDatabase + "_tenant1"))
+
+			cached, err := factory.Collection(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cached.Collection()).To(BeIdenticalTo(c.Collection()))
+		})
+
+		It("should ensure the Collection once for concurrent calls", func() {
+			factory, err := NewTenantFactory(TenantConfig{
+				Mode: TenantPerCollection,
+				Collection: &Collection{
+					Connection:   conn,
+					Database:     testDatabase,
+					Name:         "test_collection",
+					SchemaStruct: &item{},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			ctx := WithTenant(context.Background(), "tenant1")
+			collections := make([]*Collection, 5)
+			wg := sync.WaitGroup{}
+			for i := range collections {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					c, err := factory.Collection(ctx)
+					Expect(err).ToNot(HaveOccurred())
+					collections[i] = c
+				}(i)
+			}
+			wg.Wait()
+
+			for _, c := range collections[1:] {
+				Expect(c.Collection()).To(BeIdenticalTo(collections[0].Collection()))
+			}
+		})
+
+		It("should return error for tenant-IDs invalid in database-names", func() {
+			factory, err := NewTenantFactory(TenantConfig{
+				Mode: TenantPerDatabase,
+				Collection: &Collection{
+					Connection:   conn,
+					Database:     testDatabase,
+					Name:         "test_collection",
+					SchemaStruct: &item{},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			ctx := WithTenant(context.Background(), "tenant.1")
+			_, err = factory.Collection(ctx)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should only return the tenant's documents in field-mode", func() {
+			factory, err := NewTenantFactory(TenantConfig{
+				Mode: TenantPerField,
+				Collection: &Collection{
+					Connection:   conn,
+					Database:     testDatabase,
+					Name:         "test_collection",
+					SchemaStruct: &item{},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, tenant := range []string{"tenant1", "tenant2"} {
+				c, err := factory.Collection(
+					WithTenant(context.Background(), tenant),
+				)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = c.InsertOne(&item{Word: "some-word"})
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			c, err := factory.Collection(WithTenant(context.Background(), "tenant1"))
+			Expect(err).ToNot(HaveOccurred())
+			results, err := c.Find(&item{Word: "some-word"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].(*item).TenantID).To(Equal("tenant1"))
+		})
+	})
+})
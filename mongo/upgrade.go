@@ -0,0 +1,308 @@
+package mongo
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/pkg/errors"
+)
+
+// TagSchemaVersion marks an integer field holding the schema-version of
+// document. This is set to the current version of Collection.SchemaUpgrades
+// when the document is inserted or replaced. The documents without this
+// field are considered to be at schema-version 0.
+const TagSchemaVersion = "schemaVersion"
+
+// ErrUpgradeConflict is passed to SchemaUpgrades.OnWriteBackError when the
+// upgraded document is not written back, because it was upgraded, modified
+// or deleted concurrently.
+var ErrUpgradeConflict = errors.New(
+	"Upgrade Conflict: document was modified concurrently or does not exist",
+)
+
+// UpgradeFunc upgrades a document from the previous schema-version to the
+// version it is registered for, by modifying the document in place.
+// The encrypted fields are decrypted before the document is upgraded.
+type UpgradeFunc func(doc *bson.Document) error
+
+// SchemaUpgrades is the registry of UpgradeFuncs for lazily upgrading the
+// documents with older schema-versions when these are read using Find or
+// FindOne, so the results always match the current SchemaStruct.
+// For example:
+//  upgrades := &SchemaUpgrades{WriteBack: true}
+//  // Version 1 renamed "name" to "fullName"
+//  upgrades.Register(1, func(doc *bson.Document) error {
+//    if name := doc.Lookup("name"); name != nil {
+//      doc.Set(bson.EC.Interface("fullName", name.Interface()))
+//      doc.Delete("name")
+//    }
+//    return nil
+//  })
+// The UpgradeFuncs must be registered before EnsureCollection.
+// The projections used with Find and FindOne must include the schema-version
+// field, and the fields used by UpgradeFuncs (and the version-field, with
+// OptimisticLocking and WriteBack).
+type SchemaUpgrades struct {
+	// WriteBack writes the upgraded fields back to the collection, so the
+	// documents are only upgraded once. The write-back is skipped with
+	// ErrUpgradeConflict if the stored schema-version (or the version, with
+	// OptimisticLocking) was changed concurrently.
+	WriteBack bool
+	// OnWriteBackError is called if writing back an upgraded document fails,
+	// such as for logging. The read still succeeds with upgraded document.
+	OnWriteBackError func(err error)
+
+	upgrades []schemaUpgrade
+}
+
+type schemaUpgrade struct {
+	version int64
+	upgrade UpgradeFunc
+}
+
+// Register registers the UpgradeFunc for upgrading the documents to the
+// specified schema-version from the previous version. The versions must
+// start from 1, and be registered without any gaps.
+func (s *SchemaUpgrades) Register(version int64, upgrade UpgradeFunc) {
+	s.upgrades = append(s.upgrades, schemaUpgrade{
+		version: version,
+		upgrade: upgrade,
+	})
+}
+
+// CurrentVersion returns the latest registered schema-version.
+func (s *SchemaUpgrades) CurrentVersion() int64 {
+	current := int64(0)
+	for _, u := range s.upgrades {
+		if u.version > current {
+			current = u.version
+		}
+	}
+	return current
+}
+
+// funcs validates the registered UpgradeFuncs, and returns these by version.
+func (s *SchemaUpgrades) funcs() (map[int64]UpgradeFunc, error) {
+	funcs := map[int64]UpgradeFunc{}
+	for _, u := range s.upgrades {
+		if u.version < 1 {
+			return nil, fmt.Errorf("Schema-version must be positive: %d", u.version)
+		}
+		if u.upgrade == nil {
+			return nil, fmt.Errorf("UpgradeFunc is nil for schema-version: %d", u.version)
+		}
+		if _, exists := funcs[u.version]; exists {
+			return nil, fmt.Errorf("Schema-version registered twice: %d", u.version)
+		}
+		funcs[u.version] = u.upgrade
+	}
+
+	versions := make([]int, 0, len(funcs))
+	for version := range funcs {
+		versions = append(versions, int(version))
+	}
+	sort.Ints(versions)
+	for i, version := range versions {
+		if version != i+1 {
+			return nil, fmt.Errorf("UpgradeFunc missing for schema-version: %d", i+1)
+		}
+	}
+	return funcs, nil
+}
+
+// decode decodes a result using the provided decoder into v, as done by
+// fieldEncryptor.decode, upgrading the document if its schema-version is
+// older than the current one.
+func (c *Collection) decode(
+	op *Operation,
+	decoder func(interface{}) error,
+	v interface{},
+) error {
+	if c.upgrades == nil {
+		return c.encryptor.decode(decoder, v)
+	}
+	doc := bson.NewDocument()
+	err := decoder(doc)
+	if err != nil {
+		return err
+	}
+	err = c.encryptor.decryptDocument(doc)
+	if err != nil {
+		return err
+	}
+
+	var original *bson.Document
+	if c.SchemaUpgrades.WriteBack {
+		original, err = copyDocument(doc)
+		if err != nil {
+			return err
+		}
+	}
+	storedVersion, err := c.upgradeDocument(doc)
+	if err != nil {
+		return err
+	}
+	if original != nil && storedVersion < c.tags.currentSchemaVersion {
+		err = c.writeBackUpgrade(op, original, doc, storedVersion)
+		if err != nil && c.SchemaUpgrades.OnWriteBackError != nil {
+			c.SchemaUpgrades.OnWriteBackError(err)
+		}
+	}
+
+	docBytes, err := doc.MarshalBSON()
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(docBytes, v)
+}
+
+// upgradeDocument runs the UpgradeFuncs for upgrading the document from its
+// stored schema-version to the current one, and returns the stored version.
+// The documents with newer schema-versions (such as written by a newer
+// deployment) are left as is.
+func (c *Collection) upgradeDocument(doc *bson.Document) (int64, error) {
+	key := c.tags.schemaVersion
+	storedVersion := int64(0)
+	if value := doc.Lookup(key); !isZeroValue(value) {
+		version, ok := versionValue(value)
+		if !ok {
+			return 0, fmt.Errorf("Schema-version in field: %s must be an integer", key)
+		}
+		storedVersion = version
+	}
+
+	current := c.tags.currentSchemaVersion
+	if storedVersion >= current {
+		return storedVersion, nil
+	}
+	for version := storedVersion + 1; version <= current; version++ {
+		err := c.upgrades[version](doc)
+		if err != nil {
+			return 0, errors.Wrapf(
+				err,
+				"Error Upgrading Document to Schema-Version: %d", version,
+			)
+		}
+	}
+	doc.Set(bson.EC.Int64(key, current))
+	return storedVersion, nil
+}
+
+// writeBackUpgrade stores the fields changed by upgrading the document.
+// Only the changed fields are written, so the fields excluded by projections
+// are retained.
+func (c *Collection) writeBackUpgrade(
+	op *Operation,
+	original *bson.Document,
+	upgraded *bson.Document,
+	storedVersion int64,
+) error {
+	idElem := upgraded.LookupElement("_id")
+	if idElem == nil {
+		return errors.New("Upgrade Write-Back Error: document has no _id")
+	}
+
+	setDoc := bson.NewDocument()
+	iter := upgraded.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		previous := original.LookupElement(elem.Key())
+		if previous == nil || !sameElement(previous, elem) {
+			setDoc.Append(elem)
+		}
+	}
+	if iter.Err() != nil {
+		return errors.Wrap(iter.Err(), "Upgrade Write-Back Error")
+	}
+	unsetDoc := bson.NewDocument()
+	iter = original.Iterator()
+	for iter.Next() {
+		key := iter.Element().Key()
+		if upgraded.Lookup(key) == nil {
+			unsetDoc.Append(bson.EC.String(key, ""))
+		}
+	}
+	if iter.Err() != nil {
+		return errors.Wrap(iter.Err(), "Upgrade Write-Back Error")
+	}
+
+	err := c.encryptor.encryptDocument(setDoc)
+	if err != nil {
+		return errors.Wrap(err, "Upgrade Write-Back Encryption Error")
+	}
+	updateDoc := bson.NewDocument(bson.EC.SubDocument("$set", setDoc))
+	if unsetDoc.Len() > 0 {
+		updateDoc.Append(bson.EC.SubDocument("$unset", unsetDoc))
+	}
+
+	// The write-back only applies if the document was not upgraded or
+	// replaced concurrently. With OptimisticLocking, the document must also
+	// still be at the version that was read.
+	filterDoc := bson.NewDocument(
+		idElem,
+		storedVersionFilter(c.tags.schemaVersion, storedVersion),
+	)
+	if c.OptimisticLocking {
+		version := int64(0)
+		if value := original.Lookup(c.tags.version); !isZeroValue(value) {
+			v, ok := versionValue(value)
+			if !ok {
+				return fmt.Errorf(
+					"Upgrade Write-Back Error: version in field: %s must be an integer",
+					c.tags.version,
+				)
+			}
+			version = v
+		}
+		filterDoc.Append(storedVersionFilter(c.tags.version, version))
+	}
+
+	ctx, cancel := newOpTimeoutContext(op, c.Connection.Timeout)
+	defer cancel()
+	result, err := c.Collection().UpdateOne(ctx, filterDoc, updateDoc)
+	if err != nil {
+		return errors.Wrap(err, "Upgrade Write-Back Error")
+	}
+	if result.MatchedCount == 0 {
+		return ErrUpgradeConflict
+	}
+	return nil
+}
+
+// storedVersionFilter returns the filter-element matching the version-field
+// with the stored version. As the absent field is considered to be at
+// version 0, the null-filter is also used to match it.
+func storedVersionFilter(key string, version int64) *bson.Element {
+	if version == 0 {
+		return bson.EC.SubDocumentFromElements(
+			key,
+			bson.EC.ArrayFromElements("$in", bson.VC.Null(), bson.VC.Int64(0)),
+		)
+	}
+	return bson.EC.Int64(key, version)
+}
+
+// sameElement checks if the elements are equal by comparing their
+// BSON-encodings.
+func sameElement(a *bson.Element, b *bson.Element) bool {
+	aBytes, err := a.MarshalBSON()
+	if err != nil {
+		return false
+	}
+	bBytes, err := b.MarshalBSON()
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(aBytes, bBytes)
+}
+
+// copyDocument returns a deep copy of the document.
+func copyDocument(doc *bson.Document) (*bson.Document, error) {
+	docBytes, err := doc.MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+	return bson.ReadDocument(docBytes)
+}
@@ -0,0 +1,245 @@
+package mongo
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("SchemaUpgrades", func() {
+	type person struct {
+		ID            objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		FullName      string            `bson:"fullName,omitempty" json:"fullName,omitempty"`
+		Email         string            `bson:"email,omitempty" json:"email,omitempty"`
+		SchemaVersion int64             `bson:"schemaVersion" mongoutils:"schemaVersion"`
+	}
+	type untagged struct {
+		ID       objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		FullName string            `bson:"fullName,omitempty" json:"fullName,omitempty"`
+	}
+
+	// Version 1 renamed "name" to "fullName", and version 2 lowercased "email"
+	newUpgrades := func() *SchemaUpgrades {
+		upgrades := &SchemaUpgrades{}
+		upgrades.Register(1, func(doc *bson.Document) error {
+			if name := doc.Lookup("name"); name != nil {
+				doc.Set(bson.EC.String("fullName", name.StringValue()))
+				doc.Delete("name")
+			}
+			return nil
+		})
+		upgrades.Register(2, func(doc *bson.Document) error {
+			if email := doc.Lookup("email"); email != nil {
+				doc.Set(bson.EC.String("email", strings.ToLower(email.StringValue())))
+			}
+			return nil
+		})
+		return upgrades
+	}
+
+	Describe("funcs", func() {
+		It("should return the UpgradeFuncs by version", func() {
+			upgrades := newUpgrades()
+			funcs, err := upgrades.funcs()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(funcs).To(HaveLen(2))
+			Expect(upgrades.CurrentVersion()).To(Equal(int64(2)))
+		})
+
+		It("should return error if a version is missing", func() {
+			upgrades := newUpgrades()
+			upgrades.Register(4, func(doc *bson.Document) error { return nil })
+			_, err := upgrades.funcs()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error if a version is registered twice", func() {
+			upgrades := newUpgrades()
+			upgrades.Register(2, func(doc *bson.Document) error { return nil })
+			_, err := upgrades.funcs()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("upgradeDocument", func() {
+		var c *Collection
+
+		BeforeEach(func() {
+			tags, err := parseSchemaTags(&person{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(tags.schemaVersion).To(Equal("schemaVersion"))
+
+			upgrades := newUpgrades()
+			funcs, err := upgrades.funcs()
+			Expect(err).ToNot(HaveOccurred())
+			tags.currentSchemaVersion = upgrades.CurrentVersion()
+			c = &Collection{
+				SchemaUpgrades: upgrades,
+				tags:           tags,
+				upgrades:       funcs,
+			}
+		})
+
+		It("should upgrade documents without schema-version from version 0", func() {
+			doc := bson.NewDocument(
+				bson.EC.String("name", "Some Name"),
+				bson.EC.String("email", "User@Example.com"),
+			)
+			storedVersion, err := c.upgradeDocument(doc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(storedVersion).To(Equal(int64(0)))
+
+			Expect(doc.Lookup("name")).To(BeNil())
+			Expect(doc.Lookup("fullName").StringValue()).To(Equal("Some Name"))
+			Expect(doc.Lookup("email").StringValue()).To(Equal("user@example.com"))
+			Expect(doc.Lookup("schemaVersion").Int64()).To(Equal(int64(2)))
+		})
+
+		It("should only run the UpgradeFuncs after stored version", func() {
+			doc := bson.NewDocument(
+				bson.EC.String("name", "Some Name"),
+				bson.EC.String("email", "User@Example.com"),
+				bson.EC.Int32("schemaVersion", 1),
+			)
+			storedVersion, err := c.upgradeDocument(doc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(storedVersion).To(Equal(int64(1)))
+
+			Expect(doc.Lookup("name").StringValue()).To(Equal("Some Name"))
+			Expect(doc.Lookup("email").StringValue()).To(Equal("user@example.com"))
+		})
+
+		It("should leave the documents with newer schema-versions as is", func() {
+			doc := bson.NewDocument(
+				bson.EC.String("email", "User@Example.com"),
+				bson.EC.Int64("schemaVersion", 3),
+			)
+			storedVersion, err := c.upgradeDocument(doc)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(storedVersion).To(Equal(int64(3)))
+			Expect(doc.Lookup("email").StringValue()).To(Equal("User@Example.com"))
+		})
+	})
+
+	Describe("Collection", func() {
+		var c *Collection
+
+		BeforeEach(func() {
+			hosts := os.Getenv("MONGO_TEST_HOSTS")
+			username := os.Getenv("MONGO_TEST_USERNAME")
+			password := os.Getenv("MONGO_TEST_PASSWORD")
+			resourceTimeoutStr := os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS")
+			testDatabase := os.Getenv("MONGO_TEST_DATABASE")
+
+			resourceTimeoutInt, err := strconv.Atoi(resourceTimeoutStr)
+			if err != nil {
+				err = errors.Wrap(
+					err,
+					"error getting RESOURCE_TIMEOUT from env, will use 3000",
+				)
+				log.Println(err)
+				resourceTimeoutInt = 3000
+			}
+			resourceTimeout := uint32(resourceTimeoutInt)
+
+			client, err := NewClient(ClientConfig{
+				Hosts:               *commonutil.ParseHosts(hosts),
+				Username:            username,
+				Password:            password,
+				TimeoutMilliseconds: resourceTimeout,
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			dbCtx, dbCancel := newTimeoutContext(resourceTimeout)
+			err = client.Database(testDatabase).Drop(dbCtx)
+			dbCancel()
+			Expect(err).ToNot(HaveOccurred())
+
+			upgrades := newUpgrades()
+			upgrades.WriteBack = true
+			c, err = EnsureCollection(&Collection{
+				Connection: &ConnectionConfig{
+					Client:  client,
+					Timeout: resourceTimeout,
+				},
+				Database:       testDatabase,
+				Name:           "test_collection",
+				SchemaStruct:   &person{},
+				SchemaUpgrades: upgrades,
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			err := c.Connection.Client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		findRaw := func() *bson.Document {
+			ctx, cancel := newTimeoutContext(c.Connection.Timeout)
+			defer cancel()
+			raw := bson.NewDocument()
+			err := c.Collection().FindOne(ctx, bson.NewDocument()).Decode(raw)
+			Expect(err).ToNot(HaveOccurred())
+			return raw
+		}
+
+		It("should return error if schemaVersion-field is not tagged", func() {
+			_, err := EnsureCollection(&Collection{
+				Connection:     c.Connection,
+				Database:       c.Database,
+				Name:           "test_collection",
+				SchemaStruct:   &untagged{},
+				SchemaUpgrades: newUpgrades(),
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should set the current schema-version on insert", func() {
+			data := &person{
+				FullName: "Some Name",
+			}
+			_, err := c.InsertOne(data)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(data.SchemaVersion).To(Equal(int64(2)))
+			Expect(findRaw().Lookup("schemaVersion").Int64()).To(Equal(int64(2)))
+		})
+
+		It("should upgrade older documents on read, and write these back", func() {
+			ctx, cancel := newTimeoutContext(c.Connection.Timeout)
+			_, err := c.Collection().InsertOne(ctx, bson.NewDocument(
+				bson.EC.String("name", "Some Name"),
+				bson.EC.String("email", "User@Example.com"),
+			))
+			cancel()
+			Expect(err).ToNot(HaveOccurred())
+
+			results, err := c.Find(map[string]interface{}{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(results).To(HaveLen(1))
+			found := results[0].(*person)
+			Expect(found.FullName).To(Equal("Some Name"))
+			Expect(found.Email).To(Equal("user@example.com"))
+			Expect(found.SchemaVersion).To(Equal(int64(2)))
+
+			raw := findRaw()
+			Expect(raw.Lookup("name")).To(BeNil())
+			Expect(raw.Lookup("fullName").StringValue()).To(Equal("Some Name"))
+			Expect(raw.Lookup("schemaVersion").Int64()).To(Equal(int64(2)))
+
+			result, err := c.FindOne(&person{
+				FullName: "Some Name",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.(*person).Email).To(Equal("user@example.com"))
+		})
+	})
+})
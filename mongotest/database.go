@@ -0,0 +1,386 @@
+package mongotest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/TerrexTech/go-mongoutils/extjson"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/pkg/errors"
+)
+
+// Database is an isolated database created for a test.
+//
+// The assertions read the documents as stored, without decoding these into
+// the SchemaStruct, so the encrypted fields are compared as encrypted.
+// The filters and expected documents are specified as maps, structs,
+// *bson.Documents, or strings with Extended JSON (or YAML flow-style)
+// documents, such as: `{active: true}`.
+type Database struct {
+	// Name of the database, unique to the test.
+	Name string
+
+	t           T
+	harness     *Harness
+	collections map[string]*mongo.Collection
+}
+
+// Collection returns the Collection created from the definition in
+// Config.Collections. The test is failed using T.Fatalf if there is no
+// such definition.
+func (d *Database) Collection(name string) *mongo.Collection {
+	if helper, ok := d.t.(helperT); ok {
+		helper.Helper()
+	}
+	c, exists := d.collections[name]
+	if !exists {
+		d.t.Fatalf("mongotest: Collection %s is not defined in Config", name)
+	}
+	return c
+}
+
+// Drop drops the database. This can be called more than once.
+func (d *Database) Drop() error {
+	return d.harness.dropDatabase(d.Name)
+}
+
+// Documents returns the stored documents matching the filter, in natural
+// order. The test is failed using T.Fatalf if this fails.
+func (d *Database) Documents(collection string, filter interface{}) []*bson.Document {
+	if helper, ok := d.t.(helperT); ok {
+		helper.Helper()
+	}
+	docs, err := d.find(collection, filter)
+	if err != nil {
+		d.t.Fatalf("mongotest: %s: %s", collection, err)
+	}
+	return docs
+}
+
+// AssertCount checks that the expected number of documents match the filter.
+func (d *Database) AssertCount(
+	collection string,
+	filter interface{},
+	expected int64,
+) bool {
+	if helper, ok := d.t.(helperT); ok {
+		helper.Helper()
+	}
+	query, err := toDocument(filter)
+	if err != nil {
+		d.t.Errorf("mongotest: %s: Invalid Filter: %s", collection, err)
+		return false
+	}
+	count, err := d.count(collection, query)
+	if err != nil {
+		d.t.Errorf("mongotest: %s: %s", collection, err)
+		return false
+	}
+	if count != expected {
+		d.t.Errorf(
+			"mongotest: %s: expected %d document(s) matching %s, found %d",
+			collection, expected, render(query), count,
+		)
+		return false
+	}
+	return true
+}
+
+// AssertIndex checks that the collection has an index with the name.
+func (d *Database) AssertIndex(collection string, name string) bool {
+	if helper, ok := d.t.(helperT); ok {
+		helper.Helper()
+	}
+	names, err := d.indexNames(collection)
+	if err != nil {
+		d.t.Errorf("mongotest: %s: %s", collection, err)
+		return false
+	}
+	for _, indexName := range names {
+		if indexName == name {
+			return true
+		}
+	}
+	d.t.Errorf(
+		"mongotest: %s: expected index %s, found: %s",
+		collection, name, strings.Join(names, ", "),
+	)
+	return false
+}
+
+// AssertNoIndex checks that the collection has no index with the name.
+func (d *Database) AssertNoIndex(collection string, name string) bool {
+	if helper, ok := d.t.(helperT); ok {
+		helper.Helper()
+	}
+	names, err := d.indexNames(collection)
+	if err != nil {
+		d.t.Errorf("mongotest: %s: %s", collection, err)
+		return false
+	}
+	for _, indexName := range names {
+		if indexName == name {
+			d.t.Errorf("mongotest: %s: expected no index %s", collection, name)
+			return false
+		}
+	}
+	return true
+}
+
+// AssertDocument checks that exactly one document matches the filter, and
+// that it has the fields of expected document with equal values. The other
+// fields of matched document are not checked. The keys can be dotted paths,
+// such as "address.city", and the numbers are compared regardless of their
+// BSON-types.
+func (d *Database) AssertDocument(
+	collection string,
+	filter interface{},
+	expected interface{},
+) bool {
+	if helper, ok := d.t.(helperT); ok {
+		helper.Helper()
+	}
+	query, err := toDocument(filter)
+	if err != nil {
+		d.t.Errorf("mongotest: %s: Invalid Filter: %s", collection, err)
+		return false
+	}
+	expectedDoc, err := toDocument(expected)
+	if err != nil {
+		d.t.Errorf("mongotest: %s: Invalid Expected Document: %s", collection, err)
+		return false
+	}
+	docs, err := d.find(collection, query)
+	if err != nil {
+		d.t.Errorf("mongotest: %s: %s", collection, err)
+		return false
+	}
+	if len(docs) != 1 {
+		d.t.Errorf(
+			"mongotest: %s: expected 1 document matching %s, found %d",
+			collection, render(query), len(docs),
+		)
+		return false
+	}
+
+	doc := docs[0]
+	mismatches := []string{}
+	iter := expectedDoc.Iterator()
+	for iter.Next() {
+		elem := iter.Element()
+		actual := doc.Lookup(strings.Split(elem.Key(), ".")...)
+		if actual == nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s is missing", elem.Key()))
+			continue
+		}
+		if !valuesEqual(elem.Value(), actual) {
+			mismatches = append(mismatches, fmt.Sprintf(
+				"%s: expected %s, found %s",
+				elem.Key(), renderValue(elem.Value()), renderValue(actual),
+			))
+		}
+	}
+	if len(mismatches) > 0 {
+		d.t.Errorf(
+			"mongotest: %s: document %s does not match:\n  %s",
+			collection, render(doc), strings.Join(mismatches, "\n  "),
+		)
+		return false
+	}
+	return true
+}
+
+func (d *Database) database() *mgo.Database {
+	return d.harness.config.Connection.Client.Database(d.Name)
+}
+
+func (d *Database) find(collection string, filter interface{}) ([]*bson.Document, error) {
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid Filter")
+	}
+	ctx, cancel := d.harness.timeoutContext()
+	defer cancel()
+
+	cur, err := d.database().Collection(collection).Find(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "Find Error")
+	}
+	docs := []*bson.Document{}
+	for cur.Next(ctx) {
+		doc := bson.NewDocument()
+		err = cur.Decode(doc)
+		if err != nil {
+			cur.Close(context.Background())
+			return nil, errors.Wrap(err, "Find - Decoding Error")
+		}
+		docs = append(docs, doc)
+	}
+	err = cur.Err()
+	if err != nil {
+		cur.Close(context.Background())
+		return nil, errors.Wrap(err, "Find - Cursor Error")
+	}
+	err = cur.Close(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "Find - Cursor Close Error")
+	}
+	return docs, nil
+}
+
+func (d *Database) count(collection string, query *bson.Document) (int64, error) {
+	ctx, cancel := d.harness.timeoutContext()
+	defer cancel()
+
+	reader, err := d.database().RunCommand(ctx, bson.NewDocument(
+		bson.EC.String("count", collection),
+		bson.EC.SubDocument("query", query),
+	))
+	if err != nil {
+		return 0, errors.Wrap(err, "Count Error")
+	}
+	result, err := bson.ReadDocument(reader)
+	if err != nil {
+		return 0, errors.Wrap(err, "Count - Result Decoding Error")
+	}
+	n := result.Lookup("n")
+	if n == nil || !isNumber(n) {
+		return 0, fmt.Errorf("Count - Invalid Result: %s", render(result))
+	}
+	count, _ := numberValue(n)
+	return int64(count), nil
+}
+
+// indexNames returns the names of collection's indexes. This returns an
+// error if the collection does not exist.
+func (d *Database) indexNames(collection string) ([]string, error) {
+	ctx, cancel := d.harness.timeoutContext()
+	defer cancel()
+
+	reader, err := d.database().RunCommand(ctx, bson.NewDocument(
+		bson.EC.String("listIndexes", collection),
+	))
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Listing Indexes")
+	}
+	result, err := bson.ReadDocument(reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Decoding Indexes")
+	}
+	batch := result.Lookup("cursor", "firstBatch")
+	if batch == nil || batch.Type() != bson.TypeArray {
+		return nil, fmt.Errorf("Invalid Index-List: %s", render(result))
+	}
+
+	names := []string{}
+	arr := batch.MutableArray()
+	for i := 0; i < arr.Len(); i++ {
+		v, err := arr.Lookup(uint(i))
+		if err != nil || v.Type() != bson.TypeEmbeddedDocument {
+			continue
+		}
+		name := v.MutableDocument().Lookup("name")
+		if name != nil && name.Type() == bson.TypeString {
+			names = append(names, name.StringValue())
+		}
+	}
+	return names, nil
+}
+
+// toDocument converts the filter or expected document to BSON. The zero
+// ObjectID _id is removed, as for the inserts of SchemaStructs.
+func toDocument(v interface{}) (*bson.Document, error) {
+	switch value := v.(type) {
+	case nil:
+		return bson.NewDocument(), nil
+	case *bson.Document:
+		return value, nil
+	case string:
+		return extjson.ParseDocument([]byte(value))
+	}
+
+	doc, err := bson.NewDocumentEncoder().EncodeDocument(v)
+	if err != nil {
+		return nil, err
+	}
+	id := doc.Lookup("_id")
+	if id != nil && id.Type() == bson.TypeObjectID {
+		isZeroID := id.ObjectID() == objectid.ObjectID{}
+		if isZeroID {
+			doc.Delete("_id")
+		}
+	}
+	return doc, nil
+}
+
+// valuesEqual compares the values as relaxed Extended JSON, except for
+// numbers, which are compared by their values.
+func valuesEqual(expected *bson.Value, actual *bson.Value) bool {
+	if isNumber(expected) && isNumber(actual) {
+		e, eIsInt := numberValue(expected)
+		a, aIsInt := numberValue(actual)
+		if eIsInt && aIsInt {
+			// Compared as integers, since the float64 loses precision
+			return toInt64(expected) == toInt64(actual)
+		}
+		return e == a
+	}
+	e, err := extjson.MarshalValue(expected, false)
+	if err != nil {
+		return false
+	}
+	a, err := extjson.MarshalValue(actual, false)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(e, a)
+}
+
+func isNumber(v *bson.Value) bool {
+	switch v.Type() {
+	case bson.TypeInt32, bson.TypeInt64, bson.TypeDouble:
+		return true
+	}
+	return false
+}
+
+// numberValue returns the number as float64, along with whether it is an
+// integer-type.
+func numberValue(v *bson.Value) (float64, bool) {
+	switch v.Type() {
+	case bson.TypeInt32:
+		return float64(v.Int32()), true
+	case bson.TypeInt64:
+		return float64(v.Int64()), true
+	}
+	return v.Double(), false
+}
+
+func toInt64(v *bson.Value) int64 {
+	if v.Type() == bson.TypeInt32 {
+		return int64(v.Int32())
+	}
+	return v.Int64()
+}
+
+// render renders the document as relaxed Extended JSON for failure-messages.
+func render(doc *bson.Document) string {
+	rendered, err := extjson.MarshalDocument(doc, false)
+	if err != nil {
+		return doc.String()
+	}
+	return string(rendered)
+}
+
+func renderValue(v *bson.Value) string {
+	rendered, err := extjson.MarshalValue(v, false)
+	if err != nil {
+		return fmt.Sprintf("%v", v.Interface())
+	}
+	return string(rendered)
+}
@@ -0,0 +1,301 @@
+// Package mongotest provisions an isolated database for every test, so the
+// tests can run in parallel, including with "go test -parallel" and across
+// the packages tested concurrently.
+//
+// A Harness creates the uniquely named databases using a Client, creates the
+// configured collections in each of those, and drops the databases when the
+// tests are done:
+//
+//	harness, err := mongotest.New(mongotest.Config{
+//		Connection:  &mongo.ConnectionConfig{Client: client, Timeout: 3000},
+//		Collections: []*mongo.Collection{{Name: "users", SchemaStruct: &User{}}},
+//	})
+//
+//	func TestUsers(t *testing.T) {
+//		t.Parallel()
+//		db := harness.Database(t)
+//		defer db.Drop()
+//
+//		users := db.Collection("users")
+//		...
+//		db.AssertCount("users", map[string]interface{}{"active": true}, 2)
+//	}
+//
+// The tests report the failures using the T interface, which is satisfied
+// by *testing.T, *testing.B, and ginkgo.GinkgoT().
+package mongotest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/pkg/errors"
+)
+
+// DefaultPrefix is the prefix of database-names when Config.Prefix is
+// not specified.
+const DefaultPrefix = "mongotest"
+
+// maxNameLength is the maximum length of database-names allowed by MongoDB.
+const maxNameLength = 63
+
+// suffixLength is the length of random hex-suffix of database-names.
+const suffixLength = 12
+
+// T reports the test-failures. This is satisfied by *testing.T, *testing.B,
+// and ginkgo.GinkgoT().
+type T interface {
+	Errorf(format string, args ...interface{})
+	Fatalf(format string, args ...interface{})
+}
+
+// Config defines the configuration for a Harness.
+type Config struct {
+	Connection *mongo.ConnectionConfig
+	// Prefix of the database-names. Defaults to DefaultPrefix.
+	// The databases having this prefix are dropped by Harness.DropAll, so
+	// this must not be shared with any databases used otherwise.
+	Prefix string
+	// Collections to create in every database. These are only definitions,
+	// and their Connection and Database are ignored. The exported fields are
+	// copied for each database, and the Collections themselves are not
+	// modified.
+	Collections []*mongo.Collection
+}
+
+// Harness creates the isolated test-databases. This is safe for concurrent
+// use by parallel tests.
+type Harness struct {
+	config Config
+
+	mutex sync.Mutex
+	// databases are the databases created and not yet dropped, by name
+	databases map[string]*Database
+}
+
+// New creates a Harness. The Collection-definitions are validated when
+// creating each database.
+func New(config Config) (*Harness, error) {
+	if config.Connection == nil {
+		return nil, errors.New("Config.Connection cannot be nil")
+	}
+	if config.Connection.Client == nil {
+		return nil, errors.New("Config.Connection.Client cannot be nil")
+	}
+	if config.Prefix == "" {
+		config.Prefix = DefaultPrefix
+	}
+	if strings.ContainsAny(config.Prefix, invalidNameChars) {
+		return nil, fmt.Errorf(
+			"Config.Prefix cannot contain any of: %q", invalidNameChars,
+		)
+	}
+	if len(config.Prefix) > maxNameLength-suffixLength-1 {
+		return nil, fmt.Errorf(
+			"Config.Prefix cannot be longer than %d characters",
+			maxNameLength-suffixLength-1,
+		)
+	}
+	names := map[string]bool{}
+	for _, c := range config.Collections {
+		if c == nil || c.Name == "" {
+			return nil, errors.New("Config.Collections must have a Name")
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("Collection %s is defined more than once", c.Name)
+		}
+		names[c.Name] = true
+	}
+
+	return &Harness{
+		config:    config,
+		databases: map[string]*Database{},
+	}, nil
+}
+
+// Database creates a uniquely named database for the test, along with the
+// configured collections. The test is failed using T.Fatalf if this fails.
+//
+// The database is dropped when the test completes if T supports
+// registering cleanup-functions (as *testing.T does since Go 1.14).
+// Otherwise it must be dropped using Database.Drop, or Harness.Close.
+func (h *Harness) Database(t T) *Database {
+	if helper, ok := t.(helperT); ok {
+		helper.Helper()
+	}
+
+	name, err := h.databaseName(t)
+	if err != nil {
+		t.Fatalf("mongotest: Error Generating Database-Name: %s", err)
+		return nil
+	}
+	db := &Database{
+		Name:        name,
+		t:           t,
+		harness:     h,
+		collections: map[string]*mongo.Collection{},
+	}
+	h.mutex.Lock()
+	h.databases[name] = db
+	h.mutex.Unlock()
+
+	if cleaner, ok := t.(interface{ Cleanup(func()) }); ok {
+		cleaner.Cleanup(func() {
+			err := db.Drop()
+			if err != nil {
+				t.Errorf("mongotest: %s", err)
+			}
+		})
+	}
+
+	for _, def := range h.config.Collections {
+		c, err := mongo.EnsureCollection(&mongo.Collection{
+			Connection:        h.config.Connection,
+			Database:          name,
+			Name:              def.Name,
+			Indexes:           def.Indexes,
+			Options:           def.Options,
+			SchemaStruct:      def.SchemaStruct,
+			OptimisticLocking: def.OptimisticLocking,
+			Middlewares:       def.Middlewares,
+			Auditor:           def.Auditor,
+			KeyProvider:       def.KeyProvider,
+			SchemaUpgrades:    def.SchemaUpgrades,
+		})
+		if err != nil {
+			t.Fatalf(
+				"mongotest: Error Creating Collection %s.%s: %s", name, def.Name, err,
+			)
+			return db
+		}
+		db.collections[def.Name] = c
+	}
+	return db
+}
+
+// Close drops the databases which were not yet dropped. This can be used
+// after all tests have run, such as in TestMain or ginkgo's AfterSuite,
+// when the databases are not dropped by the tests themselves.
+func (h *Harness) Close() error {
+	h.mutex.Lock()
+	databases := make([]*Database, 0, len(h.databases))
+	for _, db := range h.databases {
+		databases = append(databases, db)
+	}
+	h.mutex.Unlock()
+
+	for _, db := range databases {
+		err := db.Drop()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropAll drops all databases having the Harness' Prefix, including the ones
+// left behind by earlier interrupted test-runs. This must not be used while
+// other test-processes using the same Prefix might be running.
+func (h *Harness) DropAll() error {
+	ctx, cancel := h.timeoutContext()
+	defer cancel()
+
+	names, err := h.config.Connection.Client.DriverClient().ListDatabaseNames(
+		ctx, nil,
+	)
+	if err != nil {
+		return errors.Wrap(err, "Error Listing Databases")
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, h.config.Prefix+"_") {
+			continue
+		}
+		err = h.dropDatabase(name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *Harness) dropDatabase(name string) error {
+	ctx, cancel := h.timeoutContext()
+	defer cancel()
+
+	err := h.config.Connection.Client.Database(name).Drop(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "Error Dropping Database %s", name)
+	}
+	h.mutex.Lock()
+	delete(h.databases, name)
+	h.mutex.Unlock()
+	return nil
+}
+
+func (h *Harness) timeoutContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(
+		context.Background(),
+		time.Duration(h.config.Connection.Timeout)*time.Millisecond,
+	)
+}
+
+// invalidNameChars are the characters not allowed in database-names.
+const invalidNameChars = "/\\. \"$*<>:|?"
+
+// databaseName generates a unique database-name, as:
+// "<prefix>_<test-name>_<random-suffix>". The test-name is only included
+// if T provides it, and is shortened to fit within MongoDB's limit.
+func (h *Harness) databaseName(t T) (string, error) {
+	random := make([]byte, suffixLength/2)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+	suffix := hex.EncodeToString(random)
+
+	testName := ""
+	if named, ok := t.(interface{ Name() string }); ok {
+		testName = sanitizeName(named.Name())
+	}
+	available := maxNameLength - len(h.config.Prefix) - suffixLength - 2
+	if available < len(testName) {
+		testName = testName[:maxInt(available, 0)]
+	}
+	if testName == "" {
+		return h.config.Prefix + "_" + suffix, nil
+	}
+	return h.config.Prefix + "_" + testName + "_" + suffix, nil
+}
+
+// sanitizeName replaces the characters of test-name which are not allowed,
+// or are inconvenient, in database-names.
+func sanitizeName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// helperT is implemented by the Ts supporting marking the test-helpers,
+// so the failures are reported at the caller's line.
+type helperT interface {
+	Helper()
+}
@@ -0,0 +1,287 @@
+package mongotest
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+// recorder is a T recording the failures, for testing the assertions.
+type recorder struct {
+	mutex  sync.Mutex
+	name   string
+	errors []string
+	fatals []string
+}
+
+func (r *recorder) Name() string {
+	return r.name
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.fatals = append(r.fatals, fmt.Sprintf(format, args...))
+}
+
+var _ = Describe("MongoTest", func() {
+	type user struct {
+		ID     objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		Name   string            `bson:"name,omitempty" json:"name,omitempty"`
+		Email  string            `bson:"email,omitempty" json:"email,omitempty"`
+		Age    int64             `bson:"age,omitempty" json:"age,omitempty"`
+		Active bool              `bson:"active" json:"active"`
+	}
+
+	var (
+		client  *mongo.Client
+		harness *Harness
+	)
+
+	BeforeEach(func() {
+		hosts := os.Getenv("MONGO_TEST_HOSTS")
+		username := os.Getenv("MONGO_TEST_USERNAME")
+		password := os.Getenv("MONGO_TEST_PASSWORD")
+		resourceTimeoutStr := os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS")
+
+		resourceTimeout, err := strconv.Atoi(resourceTimeoutStr)
+		if err != nil {
+			err = errors.Wrap(
+				err,
+				"error getting RESOURCE_TIMEOUT from env, will use 3000",
+			)
+			log.Println(err)
+			resourceTimeout = 3000
+		}
+
+		client, err = mongo.NewClient(mongo.ClientConfig{
+			Hosts:               *commonutil.ParseHosts(hosts),
+			Username:            username,
+			Password:            password,
+			TimeoutMilliseconds: uint32(resourceTimeout),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		harness, err = New(Config{
+			Connection: &mongo.ConnectionConfig{
+				Client:  client,
+				Timeout: uint32(resourceTimeout),
+			},
+			Prefix: "mongotest_lib",
+			Collections: []*mongo.Collection{
+				{
+					Name:         "users",
+					SchemaStruct: &user{},
+					Indexes: []mongo.IndexConfig{
+						{
+							ColumnConfig: []mongo.IndexColumnConfig{{Name: "email"}},
+							IsUnique:     true,
+							Name:         "email_index",
+						},
+					},
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := harness.Close()
+		Expect(err).ToNot(HaveOccurred())
+		err = client.Disconnect()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("New", func() {
+		It("should return error if Connection is nil", func() {
+			_, err := New(Config{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error for invalid Prefix", func() {
+			_, err := New(Config{
+				Connection: &mongo.ConnectionConfig{Client: client},
+				Prefix:     "test.db",
+			})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return error for duplicate Collections", func() {
+			_, err := New(Config{
+				Connection: &mongo.ConnectionConfig{Client: client},
+				Collections: []*mongo.Collection{
+					{Name: "users", SchemaStruct: &user{}},
+					{Name: "users", SchemaStruct: &user{}},
+				},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("databaseName", func() {
+		It("should include the sanitized test-name, and be unique", func() {
+			t := &recorder{name: "TestUsers/insert a.user"}
+			name1, err := harness.databaseName(t)
+			Expect(err).ToNot(HaveOccurred())
+			name2, err := harness.databaseName(t)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(name1).To(HavePrefix("mongotest_lib_TestUsers_insert_a_user_"))
+			Expect(name1).ToNot(Equal(name2))
+		})
+
+		It("should shorten the long test-names", func() {
+			t := &recorder{name: strings.Repeat("long", 30)}
+			name, err := harness.databaseName(t)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(name)).To(Equal(maxNameLength))
+		})
+	})
+
+	Describe("Database", func() {
+		It("should create isolated databases with the collections", func() {
+			db1 := harness.Database(GinkgoT())
+			db2 := harness.Database(GinkgoT())
+			Expect(db1.Name).ToNot(Equal(db2.Name))
+
+			_, err := db1.Collection("users").InsertOne(&user{
+				Name:  "alice",
+				Email: "alice@example.com",
+			})
+			Expect(err).ToNot(HaveOccurred())
+
+			db1.AssertCount("users", nil, 1)
+			db2.AssertCount("users", nil, 0)
+			db1.AssertIndex("users", "email_index")
+			db2.AssertIndex("users", "email_index")
+		})
+
+		It("should create the databases concurrently", func() {
+			wg := sync.WaitGroup{}
+			names := make([]string, 5)
+			for i := range names {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					names[i] = harness.Database(GinkgoT()).Name
+				}(i)
+			}
+			wg.Wait()
+
+			unique := map[string]bool{}
+			for _, name := range names {
+				unique[name] = true
+			}
+			Expect(unique).To(HaveLen(5))
+		})
+
+		It("should fail the test for undefined collections", func() {
+			t := &recorder{}
+			db := harness.Database(t)
+			Expect(db.Collection("posts")).To(BeNil())
+			Expect(t.fatals).To(HaveLen(1))
+		})
+
+		It("should drop the databases", func() {
+			db := harness.Database(GinkgoT())
+			_, err := db.Collection("users").InsertOne(&user{Name: "alice"})
+			Expect(err).ToNot(HaveOccurred())
+
+			err = db.Drop()
+			Expect(err).ToNot(HaveOccurred())
+			err = db.Drop()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(harness.databases).To(BeEmpty())
+
+			t := &recorder{}
+			db.t = t
+			Expect(db.AssertCount("users", nil, 0)).To(BeTrue())
+		})
+	})
+
+	Describe("Assertions", func() {
+		var (
+			t  *recorder
+			db *Database
+		)
+
+		BeforeEach(func() {
+			t = &recorder{}
+			db = harness.Database(t)
+			_, err := db.Collection("users").InsertMany([]interface{}{
+				&user{Name: "alice", Email: "alice@example.com", Age: 30, Active: true},
+				&user{Name: "bob", Email: "bob@example.com", Age: 25, Active: true},
+				&user{Name: "carol", Email: "carol@example.com", Age: 35},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should assert the document-counts", func() {
+			Expect(db.AssertCount("users", `{active: true}`, 2)).To(BeTrue())
+			Expect(db.AssertCount(
+				"users", map[string]interface{}{"name": "alice"}, 1,
+			)).To(BeTrue())
+			Expect(t.errors).To(BeEmpty())
+
+			Expect(db.AssertCount("users", &user{Name: "bob"}, 2)).To(BeFalse())
+			Expect(t.errors).To(HaveLen(1))
+			Expect(t.errors[0]).To(ContainSubstring("found 1"))
+		})
+
+		It("should assert the indexes", func() {
+			Expect(db.AssertIndex("users", "email_index")).To(BeTrue())
+			Expect(db.AssertNoIndex("users", "name_index")).To(BeTrue())
+			Expect(t.errors).To(BeEmpty())
+
+			Expect(db.AssertIndex("users", "name_index")).To(BeFalse())
+			Expect(db.AssertNoIndex("users", "email_index")).To(BeFalse())
+			Expect(db.AssertIndex("posts", "email_index")).To(BeFalse())
+			Expect(t.errors).To(HaveLen(3))
+		})
+
+		It("should assert the document-contents", func() {
+			Expect(db.AssertDocument(
+				"users", `{name: alice}`, `{email: alice@example.com, age: 30}`,
+			)).To(BeTrue())
+			// The numbers are compared regardless of their types
+			Expect(db.AssertDocument(
+				"users", `{name: bob}`, map[string]interface{}{"age": 25.0},
+			)).To(BeTrue())
+			Expect(t.errors).To(BeEmpty())
+
+			Expect(db.AssertDocument(
+				"users", `{name: alice}`, `{age: 31, missing: true}`,
+			)).To(BeFalse())
+			Expect(t.errors).To(HaveLen(1))
+			Expect(t.errors[0]).To(ContainSubstring("age: expected 31, found 30"))
+			Expect(t.errors[0]).To(ContainSubstring("missing is missing"))
+
+			// Exactly one document must match
+			Expect(db.AssertDocument("users", `{active: true}`, `{}`)).To(BeFalse())
+			Expect(t.errors).To(HaveLen(2))
+		})
+
+		It("should return the stored documents", func() {
+			docs := db.Documents("users", `{age: {$gte: 30}}`)
+			Expect(docs).To(HaveLen(2))
+			Expect(docs[0].Lookup("name").StringValue()).To(Equal("alice"))
+		})
+	})
+})
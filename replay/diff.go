@@ -0,0 +1,51 @@
+package replay
+
+import (
+	"strings"
+)
+
+// lineDiff returns the line-diff between the texts, with the lines only in
+// expected prefixed by "-", the lines only in actual by "+", and the common
+// lines by a space. The number of differing lines is also returned.
+func lineDiff(expected string, actual string) (string, int) {
+	a := strings.Split(expected, "\n")
+	b := strings.Split(actual, "\n")
+
+	// lcs[i][j] is the length of longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []string{}
+	changes := 0
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			lines = append(lines, "  "+a[i])
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			lines = append(lines, "- "+a[i])
+			changes++
+			i++
+		default:
+			lines = append(lines, "+ "+b[j])
+			changes++
+			j++
+		}
+	}
+	return strings.Join(lines, "\n"), changes
+}
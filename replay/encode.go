@@ -0,0 +1,384 @@
+package replay
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/pkg/errors"
+)
+
+// encodeRequest converts the Operation's arguments into a document, with
+// the ignored fields removed.
+func encodeRequest(op *mongo.Operation, ignore []string) (*bson.Document, error) {
+	request := bson.NewDocument()
+	if op.Filter != nil {
+		elem, err := toElement("filter", op.Filter)
+		if err != nil {
+			return nil, errors.Wrap(err, "Filter")
+		}
+		request.Append(elem)
+	}
+	if op.Update != nil {
+		elem, err := toElement("update", op.Update)
+		if err != nil {
+			return nil, errors.Wrap(err, "Update")
+		}
+		request.Append(elem)
+	}
+	if op.Documents != nil {
+		elem, err := toElement("documents", op.Documents)
+		if err != nil {
+			return nil, errors.Wrap(err, "Documents")
+		}
+		request.Append(elem)
+	}
+	if op.Pipeline != nil {
+		elem, err := toElement("pipeline", op.Pipeline)
+		if err != nil {
+			return nil, errors.Wrap(err, "Pipeline")
+		}
+		request.Append(elem)
+	}
+
+	options, err := encodeOptions(op.Options)
+	if err != nil {
+		return nil, errors.Wrap(err, "Options")
+	}
+	if options.Len() > 0 {
+		request.Append(bson.EC.Array("options", options))
+	}
+
+	for _, path := range ignore {
+		removeField(request, strings.Split(path, "."))
+	}
+	return request, nil
+}
+
+// encodeOptions converts the slice of options, such as []findopt.Find, into
+// an array of documents like: {"findopt.OptLimit": 10}. The options are
+// driver-types without a BSON-representation, so these are identified by
+// their Go-types.
+func encodeOptions(options interface{}) (*bson.Array, error) {
+	arr := bson.NewArray()
+	if options == nil {
+		return arr, nil
+	}
+	rv := reflect.ValueOf(options)
+	if rv.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("expected a slice of options, got %T", options)
+	}
+	for i := 0; i < rv.Len(); i++ {
+		opt := rv.Index(i).Interface()
+		if opt == nil {
+			continue
+		}
+		value := opt
+		// Options such as findopt.OptSort{Sort: ...} wrap a single value
+		optValue := reflect.ValueOf(opt)
+		if optValue.Kind() == reflect.Struct && optValue.NumField() == 1 {
+			if optValue.Type().Field(0).PkgPath == "" {
+				value = optValue.Field(0).Interface()
+			}
+		}
+		elem, err := toElement(fmt.Sprintf("%T", opt), value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%T", opt)
+		}
+		arr.Append(bson.VC.DocumentFromElements(elem))
+	}
+	return arr, nil
+}
+
+// encodeResponse converts the result of an operation into a document.
+func encodeResponse(result interface{}) (*bson.Document, error) {
+	var elems []*bson.Element
+	var err error
+
+	switch r := result.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		var elem *bson.Element
+		elem, err = toElement("documents", r)
+		elems = []*bson.Element{elem}
+	case *mgo.InsertOneResult:
+		var elem *bson.Element
+		elem, err = toElement("insertedId", r.InsertedID)
+		elems = []*bson.Element{elem}
+	case *[]mgo.InsertOneResult:
+		ids := []interface{}{}
+		for _, inserted := range *r {
+			ids = append(ids, inserted.InsertedID)
+		}
+		var elem *bson.Element
+		elem, err = toElement("insertedIds", ids)
+		elems = []*bson.Element{elem}
+	case *mgo.UpdateResult:
+		var elem *bson.Element
+		elem, err = toElement("upsertedId", r.UpsertedID)
+		elems = []*bson.Element{
+			bson.EC.Int64("matchedCount", r.MatchedCount),
+			bson.EC.Int64("modifiedCount", r.ModifiedCount),
+			elem,
+		}
+	case *mgo.DeleteResult:
+		elems = []*bson.Element{bson.EC.Int64("deletedCount", r.DeletedCount)}
+	default:
+		// FindOne returns a single SchemaStruct
+		var elem *bson.Element
+		elem, err = toElement("document", r)
+		elems = []*bson.Element{elem}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bson.NewDocument(elems...), nil
+}
+
+// decodeResponse converts the recorded response into the result returned by
+// the operation, such as []interface{} of SchemaStructs for OpFind.
+func decodeResponse(op *mongo.Operation, response *bson.Document) (interface{}, error) {
+	if response == nil {
+		return nil, nil
+	}
+
+	switch op.Name {
+	case mongo.OpFind, mongo.OpAggregate:
+		docs := response.Lookup("documents")
+		if docs == nil || docs.Type() != bson.TypeArray {
+			return nil, errors.New("documents are missing from response")
+		}
+		items := make([]interface{}, 0)
+		arr := docs.MutableArray()
+		for i := 0; i < arr.Len(); i++ {
+			v, err := arr.Lookup(uint(i))
+			if err != nil {
+				return nil, err
+			}
+			item, err := decodeItem(op, v)
+			if err != nil {
+				return nil, errors.Wrapf(err, "document at index %d", i)
+			}
+			items = append(items, item)
+		}
+		return items, nil
+
+	case mongo.OpFindOne:
+		doc := response.Lookup("document")
+		if doc == nil {
+			return nil, errors.New("document is missing from response")
+		}
+		return decodeItem(op, doc)
+
+	case mongo.OpInsertOne:
+		return &mgo.InsertOneResult{
+			InsertedID: interfaceValue(response.Lookup("insertedId")),
+		}, nil
+
+	case mongo.OpInsertMany:
+		results := []mgo.InsertOneResult{}
+		ids := response.Lookup("insertedIds")
+		if ids == nil || ids.Type() != bson.TypeArray {
+			return nil, errors.New("insertedIds are missing from response")
+		}
+		arr := ids.MutableArray()
+		for i := 0; i < arr.Len(); i++ {
+			v, err := arr.Lookup(uint(i))
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, mgo.InsertOneResult{InsertedID: v.Interface()})
+		}
+		return &results, nil
+
+	case mongo.OpUpdateMany, mongo.OpReplaceOne:
+		return &mgo.UpdateResult{
+			MatchedCount:  int64Value(response.Lookup("matchedCount")),
+			ModifiedCount: int64Value(response.Lookup("modifiedCount")),
+			UpsertedID:    interfaceValue(response.Lookup("upsertedId")),
+		}, nil
+
+	case mongo.OpDeleteMany:
+		return &mgo.DeleteResult{
+			DeletedCount: int64Value(response.Lookup("deletedCount")),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown operation: %s", op.Name)
+}
+
+// decodeItem decodes a document into the Collection's SchemaStruct, or into
+// a map for aggregations.
+func decodeItem(op *mongo.Operation, v *bson.Value) (interface{}, error) {
+	if v.Type() == bson.TypeNull {
+		return nil, nil
+	}
+	if v.Type() != bson.TypeEmbeddedDocument {
+		return nil, fmt.Errorf("expected a document, got %s", v.Type())
+	}
+	docBytes, err := v.MutableDocument().MarshalBSON()
+	if err != nil {
+		return nil, err
+	}
+
+	if op.Name == mongo.OpAggregate {
+		item := map[string]interface{}{}
+		err = bson.Unmarshal(docBytes, item)
+		return item, err
+	}
+	if op.Collection == nil || op.Collection.SchemaStruct == nil {
+		return nil, errors.New("Collection's SchemaStruct is required for decoding")
+	}
+	itemType := reflect.TypeOf(op.Collection.SchemaStruct)
+	if itemType.Kind() == reflect.Ptr {
+		itemType = itemType.Elem()
+	}
+	item := reflect.New(itemType).Interface()
+	err = bson.Unmarshal(docBytes, item)
+	return item, err
+}
+
+func interfaceValue(v *bson.Value) interface{} {
+	if v == nil || v.Type() == bson.TypeNull {
+		return nil
+	}
+	return v.Interface()
+}
+
+func int64Value(v *bson.Value) int64 {
+	if v == nil {
+		return 0
+	}
+	switch v.Type() {
+	case bson.TypeInt32:
+		return int64(v.Int32())
+	case bson.TypeInt64:
+		return v.Int64()
+	case bson.TypeDouble:
+		return int64(v.Double())
+	}
+	return 0
+}
+
+// toElement converts the Go-value into a BSON-element. The structs are
+// encoded as by the driver, and the maps are encoded with sorted keys, so
+// the same requests always result in same documents.
+func toElement(key string, v interface{}) (*bson.Element, error) {
+	switch value := v.(type) {
+	case nil:
+		return bson.EC.Null(key), nil
+	case *bson.Document:
+		return bson.EC.SubDocument(key, value), nil
+	case *bson.Array:
+		return bson.EC.Array(key, value), nil
+	case objectid.ObjectID:
+		return bson.EC.ObjectID(key, value), nil
+	case time.Time:
+		return bson.EC.DateTime(key, value.UnixNano()/int64(time.Millisecond)), nil
+	case []byte:
+		return bson.EC.Binary(key, value), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return bson.EC.Null(key), nil
+		}
+		if rv.Elem().Kind() == reflect.Struct {
+			return structElement(key, v)
+		}
+		return toElement(key, rv.Elem().Interface())
+
+	case reflect.Struct:
+		return structElement(key, v)
+
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map-keys must be strings, got %T", v)
+		}
+		keys := []string{}
+		values := map[string]reflect.Value{}
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+			values[k.String()] = rv.MapIndex(k)
+		}
+		sort.Strings(keys)
+		doc := bson.NewDocument()
+		for _, k := range keys {
+			elem, err := toElement(k, values[k].Interface())
+			if err != nil {
+				return nil, err
+			}
+			doc.Append(elem)
+		}
+		return bson.EC.SubDocument(key, doc), nil
+
+	case reflect.Slice, reflect.Array:
+		arr := bson.NewArray()
+		for i := 0; i < rv.Len(); i++ {
+			elem, err := toElement("", rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			arr.Append(elem.Value())
+		}
+		return bson.EC.Array(key, arr), nil
+
+	case reflect.Bool:
+		return bson.EC.Boolean(key, rv.Bool()), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return bson.EC.Int32(key, int32(rv.Int())), nil
+	case reflect.Int, reflect.Int64:
+		return bson.EC.Int64(key, rv.Int()), nil
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint, reflect.Uint64:
+		return bson.EC.Int64(key, int64(rv.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return bson.EC.Double(key, rv.Float()), nil
+	case reflect.String:
+		return bson.EC.String(key, rv.String()), nil
+	}
+	return nil, fmt.Errorf("unsupported type: %T", v)
+}
+
+func structElement(key string, v interface{}) (*bson.Element, error) {
+	doc, err := bson.NewDocumentEncoder().EncodeDocument(v)
+	if err != nil {
+		return nil, err
+	}
+	return bson.EC.SubDocument(key, doc), nil
+}
+
+// removeField removes the dotted path from the document, including from
+// the documents in arrays on the path.
+func removeField(doc *bson.Document, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	if len(path) == 1 {
+		doc.Delete(path[0])
+		return
+	}
+	v := doc.Lookup(path[0])
+	if v == nil {
+		return
+	}
+	switch v.Type() {
+	case bson.TypeEmbeddedDocument:
+		removeField(v.MutableDocument(), path[1:])
+	case bson.TypeArray:
+		arr := v.MutableArray()
+		for i := 0; i < arr.Len(); i++ {
+			item, err := arr.Lookup(uint(i))
+			if err == nil && item.Type() == bson.TypeEmbeddedDocument {
+				removeField(item.MutableDocument(), path[1:])
+			}
+		}
+	}
+}
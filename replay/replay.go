@@ -0,0 +1,508 @@
+// Package replay records the operations on Collections, along with their
+// responses, into golden-files, and replays those responses in later
+// test-runs without a MongoDB server. This makes the tests deterministic
+// and fast, while the recordings are refreshed against a real server when
+// required.
+//
+// A Session is registered as a Middleware on the Client, so every operation
+// on its Collections is recorded or replayed:
+//
+//	mode := replay.ModeReplay
+//	if *record {
+//		mode = replay.ModeRecord
+//	}
+//	session, err := replay.New(replay.Config{
+//		File: "testdata/users.golden.json",
+//		Mode: mode,
+//	})
+//	client.Use(session.Middleware())
+//	users, err := session.EnsureCollection(&mongo.Collection{...})
+//	...
+//	err = session.Close()
+//
+// In replay-mode, an operation is served the response of the first unused
+// recorded interaction with the same operation, collection, and request
+// (filter, update, documents, pipeline, and options). If there is none, the
+// operation returns a MismatchError, describing the difference from the
+// closest recorded request. The operations can hence be replayed in any
+// order, such as from parallel goroutines.
+//
+// The requests are compared as relaxed Extended JSON, so the requests must be
+// deterministic, or their varying fields (such as timestamps) be listed in
+// Config.IgnoreFields. The responses are stored as canonical Extended JSON to
+// retain their BSON-types. The recordings contain the documents unredacted,
+// and (for encrypted fields) as decrypted.
+package replay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/TerrexTech/go-mongoutils/extjson"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/core/command"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/pkg/errors"
+)
+
+// Mode is the mode of a Session.
+type Mode int
+
+// The modes of a Session.
+const (
+	// ModeReplay serves the operations from the golden-file, without
+	// running them on server.
+	ModeReplay Mode = iota
+	// ModeRecord runs the operations on server, and records these into
+	// the golden-file when the Session is closed.
+	ModeRecord
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeReplay:
+		return "replay"
+	case ModeRecord:
+		return "record"
+	}
+	return fmt.Sprintf("Mode(%d)", int(m))
+}
+
+// The kinds of recorded errors, which are replayed as errors with the same
+// cause, so the checks such as mongo.IsNotFound, mongo.IsDuplicateKey,
+// mongo.IsTimeout, and mongo.IsNetworkError behave the same.
+const (
+	errorKindNotFound        = "notFound"
+	errorKindVersionConflict = "versionConflict"
+	errorKindDuplicateKey    = "duplicateKey"
+	errorKindTimeout         = "timeout"
+	errorKindNetwork         = "network"
+	errorKindNetworkTimeout  = "networkTimeout"
+)
+
+// Config defines the configuration for a Session.
+type Config struct {
+	// File is the golden-file to record the interactions into, or to replay
+	// these from.
+	File string
+	Mode Mode
+	// IgnoreFields are the dotted paths in requests which are not compared,
+	// such as "documents.createdAt" or "update.updatedAt". The paths start
+	// with one of: "filter", "update", "documents", "pipeline", or "options".
+	IgnoreFields []string
+}
+
+// interaction is a recorded operation, and its response.
+type interaction struct {
+	Operation  string          `json:"operation"`
+	Collection string          `json:"collection"`
+	Request    json.RawMessage `json:"request"`
+	Response   json.RawMessage `json:"response,omitempty"`
+	Error      *recordedError  `json:"error,omitempty"`
+
+	// used is set when the interaction has been replayed
+	used bool
+}
+
+type recordedError struct {
+	Message string `json:"message"`
+	Kind    string `json:"kind,omitempty"`
+}
+
+// Session records, or replays, the operations.
+// This is safe for concurrent use.
+type Session struct {
+	config Config
+
+	mutex        sync.Mutex
+	interactions []*interaction
+}
+
+// MismatchError is returned in replay-mode by the operations for which no
+// matching interaction was recorded.
+type MismatchError struct {
+	Operation  string
+	Collection string
+	// Request is the operation's request, as indented Extended JSON.
+	Request string
+	// Diff is the line-diff from the closest recorded request, with the
+	// recorded lines prefixed by "-", and the actual lines by "+".
+	// This is empty if no interaction was recorded for the operation
+	// on collection.
+	Diff string
+}
+
+func (e *MismatchError) Error() string {
+	if e.Diff == "" {
+		return fmt.Sprintf(
+			"Replay - No Recorded %s on %s for Request:\n%s",
+			e.Operation, e.Collection, e.Request,
+		)
+	}
+	return fmt.Sprintf(
+		"Replay - Request for %s on %s differs from Recording:\n%s",
+		e.Operation, e.Collection, e.Diff,
+	)
+}
+
+// IsMismatch checks if the error is caused by an operation not matching
+// any recorded interaction.
+func IsMismatch(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, isMismatch := errors.Cause(err).(*MismatchError)
+	return isMismatch
+}
+
+// New creates a Session. In replay-mode, the golden-file is read, and must
+// exist.
+func New(config Config) (*Session, error) {
+	if config.File == "" {
+		return nil, errors.New("Config.File cannot be blank")
+	}
+	s := &Session{config: config}
+	if config.Mode != ModeReplay {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(config.File)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Reading Golden-File")
+	}
+	err = json.Unmarshal(data, &s.interactions)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Parsing Golden-File")
+	}
+	for i, recorded := range s.interactions {
+		recorded.Request, err = normalizeJSON(recorded.Request)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid Request at Index: %d", i)
+		}
+	}
+	return s, nil
+}
+
+// Mode returns the Session's mode.
+func (s *Session) Mode() Mode {
+	return s.config.Mode
+}
+
+// Middleware returns the Middleware recording, or replaying, the operations.
+// This should be registered using Client.Use, so the operations on all of
+// the Client's Collections are included. In replay-mode, the Middlewares
+// after this one, and the operations themselves, are not run.
+func (s *Session) Middleware() mongo.Middleware {
+	return func(next mongo.Handler) mongo.Handler {
+		return func(op *mongo.Operation) (interface{}, error) {
+			request, err := encodeRequest(op, s.config.IgnoreFields)
+			if err != nil {
+				return nil, errors.Wrap(err, "Replay - Request Encoding Error")
+			}
+			requestJSON, err := extjson.MarshalDocument(request, false)
+			if err == nil {
+				requestJSON, err = normalizeJSON(requestJSON)
+			}
+			if err != nil {
+				return nil, errors.Wrap(err, "Replay - Request Encoding Error")
+			}
+
+			if s.config.Mode == ModeReplay {
+				return s.replay(op, requestJSON)
+			}
+			result, opErr := next(op)
+			err = s.record(op, requestJSON, result, opErr)
+			if err != nil {
+				return result, errors.Wrap(err, "Replay - Recording Error")
+			}
+			return result, opErr
+		}
+	}
+}
+
+// EnsureCollection is mongo.EnsureCollection for the Sessions in replay-mode,
+// which ensures the Collection without creating its Indexes and collection-
+// Options, since that requires a server. In record-mode, this is the same as
+// mongo.EnsureCollection.
+func (s *Session) EnsureCollection(c *mongo.Collection) (*mongo.Collection, error) {
+	if s.config.Mode != ModeReplay || c == nil {
+		return mongo.EnsureCollection(c)
+	}
+
+	indexes := c.Indexes
+	options := c.Options
+	c.Indexes = nil
+	c.Options = nil
+	collection, err := mongo.EnsureCollection(c)
+	c.Indexes = indexes
+	c.Options = options
+	return collection, err
+}
+
+// Close writes the recorded interactions into the golden-file in
+// record-mode. In replay-mode, this returns an error if any recorded
+// interactions were not replayed.
+func (s *Session) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.config.Mode == ModeReplay {
+		unused := []string{}
+		for _, recorded := range s.interactions {
+			if !recorded.used {
+				unused = append(
+					unused,
+					fmt.Sprintf("%s on %s", recorded.Operation, recorded.Collection),
+				)
+			}
+		}
+		if len(unused) > 0 {
+			return fmt.Errorf(
+				"Replay - %d Recorded Interaction(s) were not Replayed: %s",
+				len(unused), strings.Join(unused, ", "),
+			)
+		}
+		return nil
+	}
+
+	interactions := s.interactions
+	if interactions == nil {
+		interactions = []*interaction{}
+	}
+	data, err := json.MarshalIndent(interactions, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "Error Encoding Golden-File")
+	}
+	err = os.MkdirAll(filepath.Dir(s.config.File), 0755)
+	if err != nil {
+		return errors.Wrap(err, "Error Creating Golden-File Directory")
+	}
+	err = ioutil.WriteFile(s.config.File, append(data, '\n'), 0644)
+	if err != nil {
+		return errors.Wrap(err, "Error Writing Golden-File")
+	}
+	return nil
+}
+
+func (s *Session) record(
+	op *mongo.Operation,
+	request []byte,
+	result interface{},
+	opErr error,
+) error {
+	recorded := &interaction{
+		Operation:  op.Name,
+		Collection: namespace(op),
+		Request:    request,
+	}
+
+	response, err := encodeResponse(result)
+	if err != nil {
+		return errors.Wrap(err, "Response Encoding Error")
+	}
+	if response != nil {
+		recorded.Response, err = extjson.MarshalDocument(response, true)
+		if err != nil {
+			return errors.Wrap(err, "Response Encoding Error")
+		}
+	}
+	if opErr != nil {
+		recorded.Error = &recordedError{Message: opErr.Error()}
+		switch errors.Cause(opErr) {
+		case mgo.ErrNoDocuments:
+			recorded.Error.Kind = errorKindNotFound
+		case mongo.ErrVersionConflict:
+			recorded.Error.Kind = errorKindVersionConflict
+		default:
+			recorded.Error.Kind = errorKind(opErr)
+		}
+	}
+
+	s.mutex.Lock()
+	s.interactions = append(s.interactions, recorded)
+	s.mutex.Unlock()
+	return nil
+}
+
+func (s *Session) replay(op *mongo.Operation, request []byte) (interface{}, error) {
+	ns := namespace(op)
+
+	s.mutex.Lock()
+	var matched *interaction
+	candidates := []*interaction{}
+	for _, recorded := range s.interactions {
+		if recorded.used || recorded.Operation != op.Name || recorded.Collection != ns {
+			continue
+		}
+		if bytes.Equal(recorded.Request, request) {
+			matched = recorded
+			matched.used = true
+			break
+		}
+		candidates = append(candidates, recorded)
+	}
+	s.mutex.Unlock()
+
+	if matched == nil {
+		mismatch := &MismatchError{
+			Operation:  op.Name,
+			Collection: ns,
+			Request:    indentJSON(request),
+		}
+		// The closest recording is the one with fewest differing lines
+		minChanges := -1
+		for _, candidate := range candidates {
+			diff, changes := lineDiff(indentJSON(candidate.Request), mismatch.Request)
+			if minChanges == -1 || changes < minChanges {
+				mismatch.Diff = diff
+				minChanges = changes
+			}
+		}
+		return nil, mismatch
+	}
+
+	var response *bson.Document
+	if len(matched.Response) > 0 {
+		var err error
+		response, err = extjson.ParseDocument(matched.Response)
+		if err != nil {
+			return nil, errors.Wrap(err, "Replay - Response Parsing Error")
+		}
+	}
+	result, err := decodeResponse(op, response)
+	if err != nil {
+		return nil, errors.Wrap(err, "Replay - Response Decoding Error")
+	}
+	return result, recordedErr(matched.Error)
+}
+
+// recordedErr recreates the recorded error, retaining its cause where the
+// checks depend on that.
+func recordedErr(recorded *recordedError) error {
+	if recorded == nil {
+		return nil
+	}
+	switch recorded.Kind {
+	case errorKindNotFound:
+		message := strings.TrimSuffix(
+			recorded.Message, ": "+mgo.ErrNoDocuments.Error(),
+		)
+		return errors.Wrap(mgo.ErrNoDocuments, message)
+	case errorKindVersionConflict:
+		return mongo.ErrVersionConflict
+	case errorKindDuplicateKey:
+		// The message is retained as is, for mongo.IsDuplicateKey to
+		// extract the index and key from it
+		return &replayedError{
+			message: recorded.Message,
+			cause: command.Error{
+				Code:    codeDuplicateKey,
+				Message: recorded.Message,
+			},
+		}
+	case errorKindTimeout:
+		return &replayedError{
+			message: recorded.Message,
+			cause:   context.DeadlineExceeded,
+		}
+	case errorKindNetwork, errorKindNetworkTimeout:
+		return &replayedError{
+			message: recorded.Message,
+			cause: &networkError{
+				message: recorded.Message,
+				timeout: recorded.Kind == errorKindNetworkTimeout,
+			},
+		}
+	}
+	return errors.New(recorded.Message)
+}
+
+// errorKind returns the kind of the error checked by mongo.IsDuplicateKey,
+// mongo.IsTimeout, or mongo.IsNetworkError, or "" if it is none of these.
+func errorKind(err error) string {
+	if _, isDuplicate := mongo.IsDuplicateKey(err); isDuplicate {
+		return errorKindDuplicateKey
+	}
+	isTimeout := mongo.IsTimeout(err)
+	isNetwork := mongo.IsNetworkError(err)
+	switch {
+	case isTimeout && isNetwork:
+		return errorKindNetworkTimeout
+	case isTimeout:
+		return errorKindTimeout
+	case isNetwork:
+		return errorKindNetwork
+	}
+	return ""
+}
+
+// codeDuplicateKey is the server error-code for duplicate-key errors.
+const codeDuplicateKey = 11000
+
+// replayedError is a replayed error with the recorded message, and the cause
+// which is checked by the functions such as mongo.IsTimeout.
+type replayedError struct {
+	message string
+	cause   error
+}
+
+func (e *replayedError) Error() string {
+	return e.message
+}
+
+// Cause returns the cause for errors.Cause.
+func (e *replayedError) Cause() error {
+	return e.cause
+}
+
+// networkError is the replayed cause of network errors.
+type networkError struct {
+	message string
+	timeout bool
+}
+
+var _ net.Error = &networkError{}
+
+func (e *networkError) Error() string {
+	return e.message
+}
+
+func (e *networkError) Timeout() bool {
+	return e.timeout
+}
+
+func (e *networkError) Temporary() bool {
+	return true
+}
+
+// namespace returns the "<database>.<collection>" of the operation.
+func namespace(op *mongo.Operation) string {
+	if op.Collection == nil {
+		return ""
+	}
+	return op.Collection.Database + "." + op.Collection.Name
+}
+
+// normalizeJSON compacts the JSON, and escapes it the same as when written
+// into golden-files, so the requests can be compared as bytes.
+func normalizeJSON(data []byte) ([]byte, error) {
+	return json.Marshal(json.RawMessage(data))
+}
+
+func indentJSON(data []byte) string {
+	buf := &bytes.Buffer{}
+	err := json.Indent(buf, data, "", "  ")
+	if err != nil {
+		return string(data)
+	}
+	return buf.String()
+}
@@ -0,0 +1,329 @@
+package replay
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/core/command"
+	mgo "github.com/mongodb/mongo-go-driver/mongo"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Replay", func() {
+	// CreatedAt is in milliseconds since epoch, since the driver does not
+	// decode the BSON-datetimes into time.Time.
+	type user struct {
+		ID        objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+		Name      string            `bson:"name,omitempty" json:"name,omitempty"`
+		Age       int64             `bson:"age,omitempty" json:"age,omitempty"`
+		CreatedAt int64             `bson:"createdAt,omitempty" json:"createdAt,omitempty"`
+	}
+
+	var (
+		dir     string
+		file    string
+		aliceID objectid.ObjectID
+		alice   *user
+	)
+
+	// failures are the errors returned by server for inserting the users
+	// with these names.
+	failures := map[string]error{
+		"dave": errors.Wrap(command.Error{
+			Code: 11000,
+			Message: "E11000 duplicate key error collection: db.users " +
+				`index: name_1 dup key: { : "dave" }`,
+		}, "InsertOne Error"),
+		"erin":  errors.Wrap(context.DeadlineExceeded, "InsertOne Error"),
+		"frank": errors.Wrap(io.ErrUnexpectedEOF, "InsertOne Error"),
+	}
+
+	// server is a Middleware standing in for the server while recording.
+	// It serves the operations on alice, and fails the others.
+	server := func(next mongo.Handler) mongo.Handler {
+		return func(op *mongo.Operation) (interface{}, error) {
+			if op.Name == mongo.OpInsertOne {
+				if err, failed := failures[op.Documents[0].(*user).Name]; failed {
+					return nil, err
+				}
+			}
+			switch op.Name {
+			case mongo.OpFind:
+				return []interface{}{alice}, nil
+			case mongo.OpFindOne:
+				if op.Filter.(*user).Name != "alice" {
+					return nil, errors.Wrap(mgo.ErrNoDocuments, "FindOne Decoding Error")
+				}
+				return alice, nil
+			case mongo.OpInsertOne:
+				return &mgo.InsertOneResult{InsertedID: aliceID}, nil
+			case mongo.OpUpdateMany:
+				return &mgo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}, nil
+			case mongo.OpAggregate:
+				return []interface{}{
+					map[string]interface{}{"_id": "alice", "count": int32(2)},
+				}, nil
+			}
+			return nil, errors.New("Unexpected Operation")
+		}
+	}
+
+	// newCollection creates a Collection using the Session, with the server
+	// used while recording. No Connection is required, since the operations
+	// never reach the driver.
+	newCollection := func(session *Session) *mongo.Collection {
+		c := &mongo.Collection{
+			Database:     "db",
+			Name:         "users",
+			SchemaStruct: &user{},
+		}
+		c.Use(session.Middleware())
+		if session.Mode() == ModeRecord {
+			c.Use(server)
+		}
+		return c
+	}
+
+	expectAlice := func(item interface{}) {
+		u, ok := item.(*user)
+		Expect(ok).To(BeTrue())
+		Expect(u.ID).To(Equal(aliceID))
+		Expect(u.Name).To(Equal("alice"))
+		Expect(u.Age).To(Equal(int64(30)))
+		Expect(u.CreatedAt).To(Equal(alice.CreatedAt))
+	}
+
+	// runOperations runs the operations which are recorded and replayed.
+	runOperations := func(c *mongo.Collection) {
+		found, err := c.Find(&user{Name: "alice"}, findopt.Limit(1))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(found).To(HaveLen(1))
+		expectAlice(found[0])
+
+		one, err := c.FindOne(&user{Name: "alice"})
+		Expect(err).ToNot(HaveOccurred())
+		expectAlice(one)
+
+		_, err = c.FindOne(&user{Name: "bob"})
+		Expect(mongo.IsNotFound(err)).To(BeTrue())
+
+		inserted, err := c.InsertOne(&user{
+			Name:      "carol",
+			CreatedAt: milliseconds(time.Now()),
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(inserted.InsertedID).To(Equal(aliceID))
+
+		updated, err := c.UpdateMany(
+			map[string]interface{}{"name": "alice"},
+			map[string]interface{}{"age": 31, "active": true},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(*updated).To(Equal(mgo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}))
+
+		results, err := c.Aggregate([]interface{}{
+			map[string]interface{}{"$group": map[string]interface{}{"_id": "$name"}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].(map[string]interface{})["count"]).To(Equal(int32(2)))
+	}
+
+	// record records the operations into golden-file.
+	record := func() {
+		session, err := New(Config{
+			File:         file,
+			Mode:         ModeRecord,
+			IgnoreFields: []string{"documents.createdAt"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		runOperations(newCollection(session))
+		err = session.Close()
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	newReplaySession := func() *Session {
+		session, err := New(Config{
+			File:         file,
+			Mode:         ModeReplay,
+			IgnoreFields: []string{"documents.createdAt"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		return session
+	}
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "mongoutils-replay")
+		Expect(err).ToNot(HaveOccurred())
+		file = filepath.Join(dir, "testdata", "users.golden.json")
+
+		aliceID = objectid.New()
+		alice = &user{
+			ID:        aliceID,
+			Name:      "alice",
+			Age:       30,
+			CreatedAt: milliseconds(time.Date(2018, 9, 1, 10, 0, 0, 0, time.UTC)),
+		}
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(dir)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should return error if File is blank, or missing in replay-mode", func() {
+		_, err := New(Config{Mode: ModeRecord})
+		Expect(err).To(HaveOccurred())
+		_, err = New(Config{File: file, Mode: ModeReplay})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should replay the recorded responses", func() {
+		record()
+		session := newReplaySession()
+		runOperations(newCollection(session))
+		err := session.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should replay the errors with the same kind", func() {
+		// insertFailures inserts the users failed by server
+		insertFailures := func(c *mongo.Collection) {
+			_, err := c.InsertOne(&user{Name: "dave"})
+			dupErr, isDuplicate := mongo.IsDuplicateKey(err)
+			Expect(isDuplicate).To(BeTrue())
+			Expect(dupErr.Index).To(Equal("name_1"))
+			Expect(dupErr.Key).To(Equal(`{ : "dave" }`))
+
+			_, err = c.InsertOne(&user{Name: "erin"})
+			Expect(mongo.IsTimeout(err)).To(BeTrue())
+			Expect(mongo.IsNetworkError(err)).To(BeFalse())
+
+			_, err = c.InsertOne(&user{Name: "frank"})
+			Expect(mongo.IsNetworkError(err)).To(BeTrue())
+			Expect(mongo.IsTimeout(err)).To(BeFalse())
+		}
+
+		session, err := New(Config{File: file, Mode: ModeRecord})
+		Expect(err).ToNot(HaveOccurred())
+		insertFailures(newCollection(session))
+		err = session.Close()
+		Expect(err).ToNot(HaveOccurred())
+
+		session = newReplaySession()
+		insertFailures(newCollection(session))
+		err = session.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should replay using the Client Middleware and EnsureCollection", func() {
+		record()
+
+		session := newReplaySession()
+		client, err := mongo.NewClient(mongo.ClientConfig{
+			Hosts:            []string{"localhost:27017"},
+			NoDefaultConnect: true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		client.Use(session.Middleware())
+
+		// The Indexes are not created, so this does not require a server
+		c, err := session.EnsureCollection(&mongo.Collection{
+			Connection: &mongo.ConnectionConfig{
+				Client:  client,
+				Timeout: 1000,
+			},
+			Database:     "db",
+			Name:         "users",
+			SchemaStruct: &user{},
+			Indexes: []mongo.IndexConfig{
+				{
+					ColumnConfig: []mongo.IndexColumnConfig{{Name: "name"}},
+					IsUnique:     true,
+					Name:         "name_1",
+				},
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Indexes).To(HaveLen(1))
+
+		runOperations(c)
+		err = session.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should return MismatchError with diff for different requests", func() {
+		record()
+		c := newCollection(newReplaySession())
+
+		_, err := c.Find(&user{Name: "bob"}, findopt.Limit(1))
+		Expect(IsMismatch(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring(`-     "name": "alice"`))
+		Expect(err.Error()).To(ContainSubstring(`+     "name": "bob"`))
+
+		_, err = c.DeleteMany(&user{Name: "alice"})
+		Expect(IsMismatch(err)).To(BeTrue())
+		Expect(errors.Cause(err).(*MismatchError).Diff).To(BeEmpty())
+	})
+
+	It("should replay each recorded interaction once", func() {
+		record()
+		session := newReplaySession()
+		c := newCollection(session)
+
+		_, err := c.FindOne(&user{Name: "alice"})
+		Expect(err).ToNot(HaveOccurred())
+		_, err = c.FindOne(&user{Name: "alice"})
+		Expect(IsMismatch(err)).To(BeTrue())
+
+		// The other recorded interactions were not replayed
+		err = session.Close()
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func milliseconds(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+var _ = Describe("decodeResponse", func() {
+	It("should decode the aggregation results into maps", func() {
+		response, err := encodeResponse([]interface{}{
+			map[string]interface{}{
+				"_id":   "alice",
+				"count": int32(2),
+				"stats": map[string]interface{}{"maxAge": int64(31)},
+			},
+			nil,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		op := &mongo.Operation{Name: mongo.OpAggregate}
+		results, err := decodeResponse(op, response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(results).To(HaveLen(2))
+		result := results.([]interface{})[0].(map[string]interface{})
+		Expect(result["_id"]).To(Equal("alice"))
+		Expect(result["count"]).To(Equal(int32(2)))
+		Expect(result["stats"]).To(HaveKeyWithValue("maxAge", int64(31)))
+		Expect(results.([]interface{})[1]).To(BeNil())
+	})
+})
+
+var _ = Describe("lineDiff", func() {
+	It("should mark the removed and added lines", func() {
+		diff, changes := lineDiff("a\nb\nc", "a\nx\nc\nd")
+		Expect(diff).To(Equal("  a\n- b\n+ x\n  c\n+ d"))
+		Expect(changes).To(Equal(3))
+	})
+})
@@ -0,0 +1,291 @@
+// Package sequence generates monotonically increasing numbers, such as
+// invoice or ticket numbers, backed by a MongoDB counters-collection.
+//
+// Numbers are reserved using atomic find-and-modify $inc operations, so
+// multiple processes can safely share the same sequences.
+package sequence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/mongodb/mongo-go-driver/mongo/findopt"
+	"github.com/mongodb/mongo-go-driver/mongo/mongoopt"
+	"github.com/pkg/errors"
+)
+
+// DefaultCollection is the collection used for storing counters when
+// Config.Collection is not specified.
+const DefaultCollection = "counters"
+
+// ResetPeriod defines the period after which a sequence restarts
+// from its Start value.
+type ResetPeriod int
+
+// The supported ResetPeriods.
+const (
+	ResetNever ResetPeriod = iota
+	ResetDaily
+	ResetMonthly
+	ResetYearly
+)
+
+// period returns the period-identifier for the specified time.
+func (r ResetPeriod) period(t time.Time) string {
+	switch r {
+	case ResetDaily:
+		return t.Format("20060102")
+	case ResetMonthly:
+		return t.Format("200601")
+	case ResetYearly:
+		return t.Format("2006")
+	default:
+		return ""
+	}
+}
+
+// Value is a number generated from a sequence.
+type Value struct {
+	Name   string
+	Tenant string
+	// Period identifies the reset-period the number belongs to, such as
+	// "20181018" for daily resets. This is blank if the sequence never resets.
+	Period string
+	Number int64
+	// Formatted is the Number as formatted by Config.Formatter.
+	Formatted string
+}
+
+// Formatter formats a generated Value into its human-readable form.
+type Formatter func(v Value) string
+
+// PaddedFormatter creates a Formatter that prefixes the zero-padded number,
+// along with period (if any), such as:
+//  INV-20181018-000042
+func PaddedFormatter(prefix string, width int) Formatter {
+	return func(v Value) string {
+		parts := []string{}
+		if prefix != "" {
+			parts = append(parts, prefix)
+		}
+		if v.Period != "" {
+			parts = append(parts, v.Period)
+		}
+		parts = append(parts, fmt.Sprintf("%0*d", width, v.Number))
+		return strings.Join(parts, "-")
+	}
+}
+
+// counterDoc is the document stored for every counter.
+type counterDoc struct {
+	ID     objectid.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	Key    string            `bson:"key" json:"key"`
+	Name   string            `bson:"name" json:"name"`
+	Tenant string            `bson:"tenant" json:"tenant"`
+	Period string            `bson:"period" json:"period"`
+	Value  int64             `bson:"value" json:"value"`
+}
+
+// Config defines the configuration for a Generator.
+type Config struct {
+	Connection *mongo.ConnectionConfig
+	Database   string
+	// Collection to store counters in. Defaults to DefaultCollection.
+	Collection string
+	// Start is the first number generated by a sequence. Defaults to 1.
+	Start int64
+	// BlockSize is the count of numbers reserved from database at once.
+	// The reserved numbers are then handed out locally, which improves
+	// throughput at the cost of gaps in sequence when the process exits
+	// without using its reserved block. Defaults to 1 (no gaps).
+	BlockSize int64
+	// Reset defines when the sequences restart from Start.
+	Reset ResetPeriod
+	// Location is used for determining the reset-period boundaries.
+	// Defaults to UTC.
+	Location *time.Location
+	// Formatter formats the generated numbers. Defaults to plain numbers.
+	Formatter Formatter
+}
+
+// block is a locally reserved range of numbers.
+type block struct {
+	next int64
+	last int64
+}
+
+// Generator generates numbers from named sequences.
+type Generator struct {
+	collection *mongo.Collection
+	config     Config
+
+	mutex  sync.Mutex
+	blocks map[string]*block
+	now    func() time.Time
+}
+
+// NewGenerator creates a new Generator, and ensures the counters-collection
+// along with its indexes.
+func NewGenerator(config Config) (*Generator, error) {
+	if config.Connection == nil {
+		return nil, errors.New("Config.Connection cannot be nil")
+	}
+	if config.Database == "" {
+		return nil, errors.New("Config.Database cannot be blank")
+	}
+	if config.BlockSize < 0 {
+		return nil, errors.New("Config.BlockSize cannot be negative")
+	}
+	if config.Collection == "" {
+		config.Collection = DefaultCollection
+	}
+	if config.Start == 0 {
+		config.Start = 1
+	}
+	if config.BlockSize == 0 {
+		config.BlockSize = 1
+	}
+	if config.Location == nil {
+		config.Location = time.UTC
+	}
+	if config.Formatter == nil {
+		config.Formatter = func(v Value) string {
+			return strconv.FormatInt(v.Number, 10)
+		}
+	}
+
+	c, err := mongo.EnsureCollection(&mongo.Collection{
+		Connection:   config.Connection,
+		Database:     config.Database,
+		Name:         config.Collection,
+		SchemaStruct: &counterDoc{},
+		Indexes: []mongo.IndexConfig{
+			mongo.IndexConfig{
+				ColumnConfig: []mongo.IndexColumnConfig{
+					mongo.IndexColumnConfig{
+						Name: "key",
+					},
+				},
+				IsUnique: true,
+				Name:     "counter_key_index",
+			},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Ensuring Counters-Collection")
+	}
+
+	return &Generator{
+		collection: c,
+		config:     config,
+		blocks:     map[string]*block{},
+		now:        time.Now,
+	}, nil
+}
+
+// Next returns the next number from the named sequence.
+func (g *Generator) Next(name string) (Value, error) {
+	return g.NextForTenant(name, "")
+}
+
+// NextForTenant returns the next number from the named sequence of the
+// specified tenant. Each tenant gets its own independent sequence.
+func (g *Generator) NextForTenant(name string, tenant string) (Value, error) {
+	if name == "" {
+		return Value{}, errors.New("Next - Sequence-Name cannot be blank")
+	}
+
+	value := Value{
+		Name:   name,
+		Tenant: tenant,
+		Period: g.config.Reset.period(g.now().In(g.config.Location)),
+	}
+	key := strings.Join([]string{name, tenant, value.Period}, "|")
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	b := g.blocks[key]
+	if b == nil || b.next > b.last {
+		counter, err := g.reserve(key, value)
+		if err != nil {
+			return Value{}, errors.Wrap(err, "Next - Error Reserving Numbers")
+		}
+		b = &block{
+			next: counter - g.config.BlockSize + 1,
+			last: counter,
+		}
+		g.blocks[key] = b
+		g.evictStaleBlocks(name, tenant, key)
+	}
+
+	value.Number = b.next + g.config.Start - 1
+	b.next++
+	value.Formatted = g.config.Formatter(value)
+	return value, nil
+}
+
+// evictStaleBlocks removes the blocks from previous periods of a sequence,
+// so these don't accumulate over time.
+func (g *Generator) evictStaleBlocks(name, tenant, currentKey string) {
+	if g.config.Reset == ResetNever {
+		return
+	}
+	prefix := name + "|" + tenant + "|"
+	for key := range g.blocks {
+		if key != currentKey && strings.HasPrefix(key, prefix) {
+			delete(g.blocks, key)
+		}
+	}
+}
+
+// reserve atomically increments the counter by BlockSize and returns
+// the incremented value. The counter is created if it does not exist.
+func (g *Generator) reserve(key string, value Value) (int64, error) {
+	filter := map[string]interface{}{
+		"key": key,
+	}
+	update := map[string]interface{}{
+		"$inc": map[string]interface{}{
+			"value": g.config.BlockSize,
+		},
+		"$setOnInsert": map[string]interface{}{
+			"name":   value.Name,
+			"tenant": value.Tenant,
+			"period": value.Period,
+		},
+	}
+
+	var err error
+	// Concurrent upserts for a new counter can race, in which case the
+	// losing upsert fails on unique-index and is simply retried.
+	for attempt := 0; attempt < 2; attempt++ {
+		ctx, cancel := context.WithTimeout(
+			context.Background(),
+			time.Duration(g.collection.Connection.Timeout)*time.Millisecond,
+		)
+		counter := &counterDoc{}
+		err = g.collection.Collection().FindOneAndUpdate(
+			ctx,
+			filter,
+			update,
+			findopt.Upsert(true),
+			findopt.ReturnDocument(mongoopt.After),
+		).Decode(counter)
+		cancel()
+
+		if err == nil {
+			return counter.Value, nil
+		}
+		if _, isDuplicate := mongo.IsDuplicateKey(err); !isDuplicate {
+			break
+		}
+	}
+	return 0, err
+}
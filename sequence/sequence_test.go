@@ -0,0 +1,168 @@
+package sequence
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TerrexTech/go-commonutils/commonutil"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pkg/errors"
+)
+
+var _ = Describe("Generator", func() {
+	var (
+		conn         *mongo.ConnectionConfig
+		testDatabase string
+	)
+
+	newGenerator := func(config Config) *Generator {
+		config.Connection = conn
+		config.Database = testDatabase
+		g, err := NewGenerator(config)
+		Expect(err).ToNot(HaveOccurred())
+		return g
+	}
+
+	BeforeEach(func() {
+		hosts := os.Getenv("MONGO_TEST_HOSTS")
+		username := os.Getenv("MONGO_TEST_USERNAME")
+		password := os.Getenv("MONGO_TEST_PASSWORD")
+		resourceTimeoutStr := os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS")
+		testDatabase = os.Getenv("MONGO_TEST_DATABASE")
+
+		resourceTimeout, err := strconv.Atoi(resourceTimeoutStr)
+		if err != nil {
+			err = errors.Wrap(
+				err,
+				"error getting RESOURCE_TIMEOUT from env, will use 3000",
+			)
+			log.Println(err)
+			resourceTimeout = 3000
+		}
+
+		client, err := mongo.NewClient(mongo.ClientConfig{
+			Hosts:               *commonutil.ParseHosts(hosts),
+			Username:            username,
+			Password:            password,
+			TimeoutMilliseconds: uint32(resourceTimeout),
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		dbCtx, dbCancel := context.WithTimeout(
+			context.Background(),
+			time.Duration(resourceTimeout)*time.Millisecond,
+		)
+		err = client.Database(testDatabase).Drop(dbCtx)
+		dbCancel()
+		Expect(err).ToNot(HaveOccurred())
+
+		conn = &mongo.ConnectionConfig{
+			Client:  client,
+			Timeout: uint32(resourceTimeout),
+		}
+	})
+
+	AfterEach(func() {
+		err := conn.Client.Disconnect()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("should generate increasing numbers starting from 1", func() {
+		g := newGenerator(Config{})
+		for i := int64(1); i <= 3; i++ {
+			v, err := g.Next("invoice")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(v.Number).To(Equal(i))
+			Expect(v.Formatted).To(Equal(strconv.FormatInt(i, 10)))
+		}
+	})
+
+	It("should keep independent sequences per name and tenant", func() {
+		g := newGenerator(Config{})
+		_, err := g.Next("invoice")
+		Expect(err).ToNot(HaveOccurred())
+
+		v, err := g.Next("ticket")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v.Number).To(Equal(int64(1)))
+
+		v, err = g.NextForTenant("invoice", "tenant1")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v.Number).To(Equal(int64(1)))
+	})
+
+	It("should start from the specified Start value", func() {
+		g := newGenerator(Config{
+			Start: 1000,
+		})
+		v, err := g.Next("invoice")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v.Number).To(Equal(int64(1000)))
+	})
+
+	It("should not repeat numbers across generators with block-allocation", func() {
+		g1 := newGenerator(Config{
+			BlockSize: 5,
+		})
+		g2 := newGenerator(Config{
+			BlockSize: 5,
+		})
+
+		numbers := map[int64]bool{}
+		mutex := sync.Mutex{}
+		wg := sync.WaitGroup{}
+		for _, g := range []*Generator{g1, g2} {
+			wg.Add(1)
+			go func(g *Generator) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				for i := 0; i < 12; i++ {
+					v, err := g.Next("invoice")
+					Expect(err).ToNot(HaveOccurred())
+					mutex.Lock()
+					Expect(numbers[v.Number]).To(BeFalse())
+					numbers[v.Number] = true
+					mutex.Unlock()
+				}
+			}(g)
+		}
+		wg.Wait()
+		Expect(numbers).To(HaveLen(24))
+	})
+
+	It("should restart the sequence when the reset-period changes", func() {
+		g := newGenerator(Config{
+			Reset:     ResetDaily,
+			Formatter: PaddedFormatter("INV", 4),
+		})
+		g.now = func() time.Time {
+			return time.Date(2018, 10, 18, 10, 0, 0, 0, time.UTC)
+		}
+		_, err := g.Next("invoice")
+		Expect(err).ToNot(HaveOccurred())
+		v, err := g.Next("invoice")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v.Formatted).To(Equal("INV-20181018-0002"))
+
+		g.now = func() time.Time {
+			return time.Date(2018, 10, 19, 10, 0, 0, 0, time.UTC)
+		}
+		v, err = g.Next("invoice")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v.Number).To(Equal(int64(1)))
+		Expect(v.Formatted).To(Equal("INV-20181019-0001"))
+	})
+
+	Describe("PaddedFormatter", func() {
+		It("should format the number without period if not resetting", func() {
+			f := PaddedFormatter("TCK", 6)
+			Expect(f(Value{Number: 42})).To(Equal("TCK-000042"))
+		})
+	})
+})
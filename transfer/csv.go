@@ -0,0 +1,290 @@
+package transfer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/extjson"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/pkg/errors"
+)
+
+// schemaColumns returns the BSON-keys of SchemaStruct fields, in order of
+// the fields. The fields with "-" as bson-tag are skipped.
+func schemaColumns(schemaStruct interface{}) []string {
+	columns := []string{}
+	for _, field := range schemaFields(schemaStruct) {
+		columns = append(columns, field.key)
+	}
+	return columns
+}
+
+type schemaField struct {
+	key       string
+	fieldType reflect.Type
+}
+
+func schemaFields(schemaStruct interface{}) []schemaField {
+	if schemaStruct == nil {
+		return nil
+	}
+	schemaType := reflect.TypeOf(schemaStruct)
+	if schemaType.Kind() == reflect.Ptr {
+		schemaType = schemaType.Elem()
+	}
+	if schemaType.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := []schemaField{}
+	for i := 0; i < schemaType.NumField(); i++ {
+		field := schemaType.Field(i)
+		key := strings.Split(field.Tag.Get("bson"), ",")[0]
+		if key == "-" || field.PkgPath != "" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(field.Name)
+		}
+		fields = append(fields, schemaField{key: key, fieldType: field.Type})
+	}
+	return fields
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	columns []string
+}
+
+func newCSVWriter(w io.Writer, columns []string) (*csvWriter, error) {
+	writer := &csvWriter{
+		w:       csv.NewWriter(w),
+		columns: columns,
+	}
+	err := writer.w.Write(columns)
+	if err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+func (c *csvWriter) write(doc *bson.Document) error {
+	record := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		value := doc.Lookup(strings.Split(column, ".")...)
+		if value == nil {
+			// The missing fields are left empty
+			continue
+		}
+		var err error
+		record[i], err = formatCell(value)
+		if err != nil {
+			return errors.Wrapf(err, "Error Formatting Column: %s", column)
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// formatCell formats the value for a CSV-cell. The strings, numbers, and
+// ObjectIDs are written as is, the dates in RFC3339 format, and the other
+// values as relaxed Extended JSON.
+func formatCell(v *bson.Value) (string, error) {
+	switch v.Type() {
+	case bson.TypeNull:
+		return "", nil
+	case bson.TypeString:
+		return v.StringValue(), nil
+	case bson.TypeBoolean:
+		return strconv.FormatBool(v.Boolean()), nil
+	case bson.TypeInt32:
+		return strconv.FormatInt(int64(v.Int32()), 10), nil
+	case bson.TypeInt64:
+		return strconv.FormatInt(v.Int64(), 10), nil
+	case bson.TypeDouble:
+		return strconv.FormatFloat(v.Double(), 'g', -1, 64), nil
+	case bson.TypeDecimal128:
+		return v.Decimal128().String(), nil
+	case bson.TypeObjectID:
+		return v.ObjectID().Hex(), nil
+	case bson.TypeDateTime:
+		return v.Time().UTC().Format(time.RFC3339Nano), nil
+	}
+	cell, err := extjson.MarshalValue(v, false)
+	return string(cell), err
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []string
+	// types are the SchemaStruct field-types by column, which are used
+	// for parsing the cells
+	types map[string]reflect.Type
+}
+
+// newCSVReader reads the header-row for column-names. The columns can be
+// dotted paths into nested documents.
+func newCSVReader(r io.Reader, schemaStruct interface{}) (*csvReader, error) {
+	reader := &csvReader{
+		r:     csv.NewReader(r),
+		types: map[string]reflect.Type{},
+	}
+	for _, field := range schemaFields(schemaStruct) {
+		reader.types[field.key] = field.fieldType
+	}
+
+	var err error
+	reader.columns, err = reader.r.Read()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error Reading CSV Header")
+	}
+	return reader, nil
+}
+
+func (c *csvReader) read() (*bson.Document, error) {
+	record, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.NewDocument()
+	for i, column := range c.columns {
+		// The empty cells are missing fields
+		if i >= len(record) || record[i] == "" {
+			continue
+		}
+		path := strings.Split(column, ".")
+		elem, err := parseCell(path[len(path)-1], record[i], c.types[column])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error Parsing Column: %s", column)
+		}
+		setPath(doc, path[:len(path)-1], elem)
+	}
+	return doc, nil
+}
+
+// setPath sets the element in the nested document at path, creating the
+// nested documents as required.
+func setPath(doc *bson.Document, path []string, elem *bson.Element) {
+	for _, key := range path {
+		nested := doc.Lookup(key)
+		if nested == nil || nested.Type() != bson.TypeEmbeddedDocument {
+			doc.Set(bson.EC.SubDocument(key, bson.NewDocument()))
+			nested = doc.Lookup(key)
+		}
+		doc = nested.MutableDocument()
+	}
+	doc.Set(elem)
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIDType = reflect.TypeOf(objectid.ObjectID{})
+)
+
+// parseCell parses the cell as per the SchemaStruct field-type, or infers
+// the type if fieldType is nil, as done by mongoimport.
+func parseCell(key string, cell string, fieldType reflect.Type) (*bson.Element, error) {
+	if fieldType != nil && fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	if fieldType == nil {
+		return inferCell(key, cell), nil
+	}
+
+	switch {
+	case fieldType == timeType:
+		t, err := time.Parse(time.RFC3339Nano, cell)
+		if err != nil {
+			return nil, err
+		}
+		return bson.EC.Time(key, t), nil
+	case fieldType == objectIDType:
+		oid, err := objectid.FromHex(cell)
+		if err != nil {
+			return nil, err
+		}
+		return bson.EC.ObjectID(key, oid), nil
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		return bson.EC.String(key, cell), nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(cell)
+		if err != nil {
+			return nil, err
+		}
+		return bson.EC.Boolean(key, b), nil
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+		i, err := strconv.ParseInt(cell, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return bson.EC.Int64(key, i), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+		i, err := strconv.ParseInt(cell, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		return bson.EC.Int32(key, int32(i)), nil
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return nil, err
+		}
+		return bson.EC.Double(key, f), nil
+	}
+	// Such as the nested structs, maps, and slices
+	return parseJSONCell(key, cell)
+}
+
+// inferCell parses the booleans, numbers, and Extended JSON documents and
+// arrays, and the rest of cells as strings.
+func inferCell(key string, cell string) *bson.Element {
+	if cell == "true" || cell == "false" {
+		return bson.EC.Boolean(key, cell == "true")
+	}
+	// The strings such as "NaN" are not parsed as numbers
+	if strings.ContainsAny(cell[:1], "0123456789+-.") {
+		if i, err := strconv.ParseInt(cell, 10, 64); err == nil {
+			if int64(int32(i)) == i {
+				return bson.EC.Int32(key, int32(i))
+			}
+			return bson.EC.Int64(key, i)
+		}
+		if f, err := strconv.ParseFloat(cell, 64); err == nil {
+			return bson.EC.Double(key, f)
+		}
+	}
+	if strings.HasPrefix(cell, "{") || strings.HasPrefix(cell, "[") {
+		if elem, err := parseJSONCell(key, cell); err == nil {
+			return elem
+		}
+	}
+	return bson.EC.String(key, cell)
+}
+
+// parseJSONCell parses the cell as an Extended JSON value.
+func parseJSONCell(key string, cell string) (*bson.Element, error) {
+	jsonKey, err := json.Marshal(key)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := extjson.ParseDocument([]byte(fmt.Sprintf(`{%s: %s}`, jsonKey, cell)))
+	if err != nil {
+		return nil, err
+	}
+	return doc.ElementAt(0), nil
+}
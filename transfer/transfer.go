@@ -0,0 +1,433 @@
+// Package transfer exports and imports the documents of Collections as
+// Extended JSON, CSV, or BSON, without requiring the MongoDB tools such as
+// mongoexport and mongodump.
+//
+// The documents are streamed as stored, and the imports are written in
+// batches directly to the collection, bypassing the Collection's schema-tags.
+// Since the Middlewares (such as the tenant-scoping of TenantFactory), the
+// Auditor, and the field-encryption would also be bypassed, the Collections
+// using these are rejected, see mongo.Collection.IsIntercepted. Use a
+// Collection without these, such as a separate one for the same collection,
+// for transferring the documents as stored.
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/TerrexTech/go-mongoutils/extjson"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	"github.com/pkg/errors"
+)
+
+// Format is the file-format of exports and imports.
+type Format string
+
+// The supported Formats.
+const (
+	// FormatJSON is Extended JSON, with one document per line,
+	// as written by mongoexport.
+	FormatJSON Format = "json"
+	// FormatCSV has a header-row with the column-names, which are the
+	// BSON-keys. The nested documents and arrays are written as relaxed
+	// Extended JSON.
+	FormatCSV Format = "csv"
+	// FormatBSON is the concatenated BSON-documents, as in the .bson
+	// data-files written by mongodump.
+	FormatBSON Format = "bson"
+)
+
+// ParseFormat returns the Format with specified name.
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(name); format {
+	case FormatJSON, FormatCSV, FormatBSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("Unknown Format: %s, must be json, csv, or bson", name)
+}
+
+// DefaultBatchSize is the number of documents written per command when
+// importing, if ImportOptions.BatchSize is not specified.
+const DefaultBatchSize = 1000
+
+// maxBatchBytes limits the size of batches, so the commands remain within
+// the server's limit of 16MB.
+const maxBatchBytes = 8 * 1024 * 1024
+
+// verifyCollection rejects the Collections whose operations are changed
+// by Middlewares, an Auditor, or field-encryption.
+func verifyCollection(c *mongo.Collection) error {
+	if c == nil {
+		return errors.New("Collection cannot be nil")
+	}
+	if c.IsIntercepted() {
+		return errors.New(
+			"Collections with Middlewares, an Auditor, or encrypted fields " +
+				"are not supported",
+		)
+	}
+	return nil
+}
+
+// ExportOptions defines the options for Export.
+type ExportOptions struct {
+	// Format defaults to FormatJSON.
+	Format Format
+	// Canonical writes canonical Extended JSON, which retains the
+	// BSON-types. Defaults to relaxed Extended JSON.
+	Canonical bool
+	// Filter selects the documents to export, as a map, struct, or
+	// *bson.Document. All documents are exported by default.
+	Filter interface{}
+	// Columns are the BSON-keys to export as CSV-columns, which can be
+	// dotted paths into nested documents. Defaults to the keys of
+	// SchemaStruct fields.
+	Columns []string
+}
+
+// Export writes the documents from Collection to w, and returns the number
+// of documents written. The Collection must not have Middlewares, an Auditor,
+// or encrypted fields.
+func Export(
+	ctx context.Context,
+	c *mongo.Collection,
+	w io.Writer,
+	opts ExportOptions,
+) (int64, error) {
+	err := verifyCollection(c)
+	if err != nil {
+		return 0, errors.Wrap(err, "Export Error")
+	}
+	filter := bson.NewDocument()
+	switch f := opts.Filter.(type) {
+	case nil:
+	case *bson.Document:
+		filter = f
+	default:
+		filter, err = bson.NewDocumentEncoder().EncodeDocument(f)
+		if err != nil {
+			return 0, errors.Wrap(err, "Export - BSON Convert Error for filter")
+		}
+	}
+	writer, err := newDocumentWriter(w, c, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	cur, err := c.Collection().Find(ctx, filter)
+	if err != nil {
+		return 0, errors.Wrap(err, "Export - Find Error")
+	}
+	defer cur.Close(ctx)
+
+	count := int64(0)
+	for cur.Next(ctx) {
+		doc := bson.NewDocument()
+		err = cur.Decode(doc)
+		if err != nil {
+			return count, errors.Wrap(err, "Export - Cursor Decode Error")
+		}
+		err = writer.write(doc)
+		if err != nil {
+			return count, errors.Wrapf(err, "Export - Error Writing Document: %d", count)
+		}
+		count++
+	}
+	if cur.Err() != nil {
+		return count, errors.Wrap(cur.Err(), "Export - Cursor Error")
+	}
+	return count, errors.Wrap(writer.flush(), "Export - Flush Error")
+}
+
+// documentWriter writes the documents in a Format.
+type documentWriter interface {
+	write(doc *bson.Document) error
+	flush() error
+}
+
+func newDocumentWriter(
+	w io.Writer,
+	c *mongo.Collection,
+	opts ExportOptions,
+) (documentWriter, error) {
+	switch opts.Format {
+	case FormatJSON, "":
+		return &jsonWriter{w: bufio.NewWriter(w), canonical: opts.Canonical}, nil
+	case FormatCSV:
+		columns := opts.Columns
+		if len(columns) == 0 {
+			columns = schemaColumns(c.SchemaStruct)
+		}
+		if len(columns) == 0 {
+			return nil, errors.New("Export - Columns are required for CSV")
+		}
+		return newCSVWriter(w, columns)
+	case FormatBSON:
+		return &bsonWriter{w: bufio.NewWriter(w)}, nil
+	}
+	return nil, fmt.Errorf("Export - Unknown Format: %s", opts.Format)
+}
+
+type jsonWriter struct {
+	w         *bufio.Writer
+	canonical bool
+}
+
+func (j *jsonWriter) write(doc *bson.Document) error {
+	line, err := extjson.MarshalDocument(doc, j.canonical)
+	if err != nil {
+		return err
+	}
+	_, err = j.w.Write(append(line, '\n'))
+	return err
+}
+
+func (j *jsonWriter) flush() error {
+	return j.w.Flush()
+}
+
+type bsonWriter struct {
+	w *bufio.Writer
+}
+
+func (b *bsonWriter) write(doc *bson.Document) error {
+	docBytes, err := doc.MarshalBSON()
+	if err != nil {
+		return err
+	}
+	_, err = b.w.Write(docBytes)
+	return err
+}
+
+func (b *bsonWriter) flush() error {
+	return b.w.Flush()
+}
+
+// ImportOptions defines the options for Import.
+type ImportOptions struct {
+	// Format defaults to FormatJSON.
+	Format Format
+	// Upsert replaces the existing documents with same _id. Otherwise,
+	// the import fails on duplicate _ids.
+	Upsert bool
+	// BatchSize is the number of documents written per command.
+	// Defaults to DefaultBatchSize.
+	BatchSize int
+}
+
+// ImportResult is the number of documents written by Import.
+type ImportResult struct {
+	Inserted int64
+	// Replaced is the number of existing documents replaced with Upsert.
+	Replaced int64
+}
+
+// Import reads the documents from r, and writes these to Collection.
+// The documents without _id are assigned a generated ObjectID.
+// The batches written before an error are retained, and are included in
+// the returned ImportResult. The Collection must not have Middlewares, an
+// Auditor, or encrypted fields.
+func Import(
+	ctx context.Context,
+	c *mongo.Collection,
+	r io.Reader,
+	opts ImportOptions,
+) (*ImportResult, error) {
+	err := verifyCollection(c)
+	if err != nil {
+		return nil, errors.Wrap(err, "Import Error")
+	}
+	reader, err := newDocumentReader(r, c, opts.Format)
+	if err != nil {
+		return nil, err
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	result := &ImportResult{}
+	batch := []*bson.Document{}
+	batchBytes := 0
+	flush := func() error {
+		err := writeBatch(ctx, c, batch, opts.Upsert, result)
+		batch = batch[:0]
+		batchBytes = 0
+		return err
+	}
+	for {
+		doc, err := reader.read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, errors.Wrap(err, "Import - Error Reading Document")
+		}
+		if doc.Lookup("_id") == nil {
+			doc.Prepend(bson.EC.ObjectID("_id", objectid.New()))
+		}
+		docBytes, err := doc.MarshalBSON()
+		if err != nil {
+			return result, errors.Wrap(err, "Import - BSON Convert Error")
+		}
+
+		// The batch is written before the document would exceed its size
+		if len(batch) > 0 && batchBytes+len(docBytes) > maxBatchBytes {
+			err = flush()
+			if err != nil {
+				return result, err
+			}
+		}
+		batch = append(batch, doc)
+		batchBytes += len(docBytes)
+		if len(batch) >= batchSize || batchBytes >= maxBatchBytes {
+			err = flush()
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+	if len(batch) > 0 {
+		err = flush()
+	}
+	return result, err
+}
+
+// writeBatch writes the documents using a single insert-command, or an
+// update-command with upserts on _id.
+func writeBatch(
+	ctx context.Context,
+	c *mongo.Collection,
+	batch []*bson.Document,
+	upsert bool,
+	result *ImportResult,
+) error {
+	values := make([]*bson.Value, len(batch))
+	for i, doc := range batch {
+		if upsert {
+			doc = bson.NewDocument(
+				bson.EC.SubDocumentFromElements("q", doc.LookupElement("_id")),
+				bson.EC.SubDocument("u", doc),
+				bson.EC.Boolean("upsert", true),
+			)
+		}
+		values[i] = bson.VC.Document(doc)
+	}
+
+	command := bson.NewDocument(bson.EC.String("insert", c.Name))
+	command.Append(bson.EC.Array("documents", bson.NewArray(values...)))
+	if upsert {
+		command = bson.NewDocument(
+			bson.EC.String("update", c.Name),
+			bson.EC.Array("updates", bson.NewArray(values...)),
+		)
+	}
+	command.Append(bson.EC.Boolean("ordered", true))
+
+	database := c.Connection.Client.Database(c.Database)
+	reader, err := database.RunCommand(ctx, command)
+	if err != nil {
+		return errors.Wrap(err, "Import - Write Error")
+	}
+	response, err := bson.ReadDocument(reader)
+	if err != nil {
+		return errors.Wrap(err, "Import - Error Reading Response")
+	}
+
+	n := int64(0)
+	if v := response.Lookup("n"); v != nil && v.Type() == bson.TypeInt32 {
+		n = int64(v.Int32())
+	}
+	if !upsert {
+		result.Inserted += n
+	} else {
+		upserted := int64(0)
+		if v := response.Lookup("upserted"); v != nil && v.Type() == bson.TypeArray {
+			upserted = int64(v.MutableArray().Len())
+		}
+		result.Inserted += upserted
+		result.Replaced += n - upserted
+	}
+
+	if v := response.Lookup("writeErrors"); v != nil && v.Type() == bson.TypeArray {
+		return errors.Errorf("Import - Write Error: %s", v.MutableArray().String())
+	}
+	return nil
+}
+
+// documentReader reads the documents in a Format, and returns io.EOF
+// after the last document.
+type documentReader interface {
+	read() (*bson.Document, error)
+}
+
+func newDocumentReader(
+	r io.Reader,
+	c *mongo.Collection,
+	format Format,
+) (documentReader, error) {
+	switch format {
+	case FormatJSON, "":
+		scanner := bufio.NewScanner(r)
+		// The documents can be up to 16MB
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		return &jsonReader{scanner: scanner}, nil
+	case FormatCSV:
+		return newCSVReader(r, c.SchemaStruct)
+	case FormatBSON:
+		return &bsonReader{r: bufio.NewReader(r)}, nil
+	}
+	return nil, fmt.Errorf("Import - Unknown Format: %s", format)
+}
+
+type jsonReader struct {
+	scanner *bufio.Scanner
+}
+
+func (j *jsonReader) read() (*bson.Document, error) {
+	for j.scanner.Scan() {
+		line := j.scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		return extjson.ParseDocument(line)
+	}
+	if j.scanner.Err() != nil {
+		return nil, j.scanner.Err()
+	}
+	return nil, io.EOF
+}
+
+type bsonReader struct {
+	r *bufio.Reader
+}
+
+// read reads the document's length, which is its first 4 bytes,
+// and then the rest of document.
+func (b *bsonReader) read() (*bson.Document, error) {
+	lengthBytes := make([]byte, 4)
+	_, err := io.ReadFull(b.r, lengthBytes)
+	if err != nil {
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(lengthBytes)
+	if length < 5 || length > 16*1024*1024 {
+		return nil, fmt.Errorf("invalid document-length: %d", length)
+	}
+
+	docBytes := make([]byte, length)
+	copy(docBytes, lengthBytes)
+	_, err = io.ReadFull(b.r, docBytes[4:])
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return bson.ReadDocument(docBytes)
+}
@@ -0,0 +1,243 @@
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TerrexTech/go-mongoutils/config"
+	"github.com/TerrexTech/go-mongoutils/mongo"
+	"github.com/mongodb/mongo-go-driver/bson"
+	"github.com/mongodb/mongo-go-driver/bson/objectid"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Transfer", func() {
+	type address struct {
+		City string `bson:"city,omitempty"`
+	}
+	type user struct {
+		ID      objectid.ObjectID `bson:"_id,omitempty"`
+		Name    string            `bson:"name,omitempty"`
+		Hits    int64             `bson:"hits,omitempty"`
+		Address address           `bson:"address,omitempty"`
+		Secret  string            `bson:"-"`
+	}
+
+	Describe("CSV", func() {
+		It("should use the SchemaStruct's BSON-keys as columns", func() {
+			Expect(schemaColumns(&user{})).To(Equal(
+				[]string{"_id", "name", "hits", "address"},
+			))
+		})
+
+		It("should write and read back the cells", func() {
+			doc := bson.NewDocument(
+				bson.EC.String("name", "Alice, A."),
+				bson.EC.Int64("hits", 42),
+				bson.EC.SubDocumentFromElements("address", bson.EC.String("city", "Oslo")),
+				bson.EC.Time("date", time.Date(2018, 7, 27, 10, 30, 0, 0, time.UTC)),
+			)
+			buf := &bytes.Buffer{}
+			writer, err := newCSVWriter(buf, []string{"name", "hits", "address.city", "tags"})
+			Expect(err).ToNot(HaveOccurred())
+			err = writer.write(doc)
+			Expect(err).ToNot(HaveOccurred())
+			err = writer.flush()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(buf.String()).To(Equal(
+				"name,hits,address.city,tags\n\"Alice, A.\",42,Oslo,\n",
+			))
+
+			reader, err := newCSVReader(buf, &user{})
+			Expect(err).ToNot(HaveOccurred())
+			read, err := reader.read()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read.Lookup("name").StringValue()).To(Equal("Alice, A."))
+			Expect(read.Lookup("hits").Int64()).To(Equal(int64(42)))
+			Expect(read.Lookup("address", "city").StringValue()).To(Equal("Oslo"))
+			Expect(read.Lookup("tags")).To(BeNil())
+
+			_, err = reader.read()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("should infer the cell-types without SchemaStruct", func() {
+			Expect(inferCell("k", "true").Value().Boolean()).To(BeTrue())
+			Expect(inferCell("k", "12").Value().Int32()).To(Equal(int32(12)))
+			Expect(inferCell("k", "1e12").Value().Double()).To(Equal(1e12))
+			Expect(inferCell("k", "NaN").Value().StringValue()).To(Equal("NaN"))
+			Expect(inferCell("k", `{"$oid": "5b5b7d4e8d0a2b1c3e4f5a6b"}`).Value().Type()).To(
+				Equal(bson.TypeObjectID),
+			)
+			Expect(inferCell("k", "{oops").Value().StringValue()).To(Equal("{oops"))
+		})
+	})
+
+	Describe("BSON", func() {
+		It("should read back the concatenated documents", func() {
+			buf := &bytes.Buffer{}
+			writer := &bsonWriter{w: bufio.NewWriter(buf)}
+			for i := 0; i < 3; i++ {
+				err := writer.write(bson.NewDocument(bson.EC.Int32("n", int32(i))))
+				Expect(err).ToNot(HaveOccurred())
+			}
+			err := writer.flush()
+			Expect(err).ToNot(HaveOccurred())
+
+			reader := &bsonReader{r: bufio.NewReader(buf)}
+			for i := 0; i < 3; i++ {
+				doc, err := reader.read()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(doc.Lookup("n").Int32()).To(Equal(int32(i)))
+			}
+			_, err = reader.read()
+			Expect(err).To(Equal(io.EOF))
+		})
+
+		It("should return error for truncated documents", func() {
+			docBytes, err := bson.NewDocument(bson.EC.Int32("n", 1)).MarshalBSON()
+			Expect(err).ToNot(HaveOccurred())
+			reader := &bsonReader{r: bufio.NewReader(bytes.NewReader(docBytes[:8]))}
+			_, err = reader.read()
+			Expect(err).To(Equal(io.ErrUnexpectedEOF))
+		})
+	})
+
+	It("should reject the Collections with Middlewares or an Auditor", func() {
+		c := &mongo.Collection{
+			Name:         "users",
+			SchemaStruct: &user{},
+		}
+		c.Use(func(next mongo.Handler) mongo.Handler {
+			return next
+		})
+		_, err := Export(context.Background(), c, &bytes.Buffer{}, ExportOptions{})
+		Expect(err).To(HaveOccurred())
+
+		c = &mongo.Collection{
+			Name:         "users",
+			SchemaStruct: &user{},
+			Auditor:      &mongo.Auditor{},
+		}
+		_, err = Import(context.Background(), c, &bytes.Buffer{}, ImportOptions{})
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("Export and Import", func() {
+		var (
+			client *mongo.Client
+			c      *mongo.Collection
+		)
+
+		BeforeEach(func() {
+			settings, err := config.Load(config.Options{
+				EnvPrefix: "MONGO_TEST",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			client, err = mongo.NewClient(settings.Client.ClientConfig())
+			Expect(err).ToNot(HaveOccurred())
+
+			timeout, err := strconv.Atoi(os.Getenv("MONGO_TEST_RESOURCE_TIMEOUT_MS"))
+			if err != nil {
+				timeout = 3000
+			}
+			c, err = mongo.EnsureCollection(&mongo.Collection{
+				Connection: &mongo.ConnectionConfig{
+					Client:  client,
+					Timeout: uint32(timeout),
+				},
+				Database:     os.Getenv("MONGO_TEST_DATABASE"),
+				Name:         "transfer_users",
+				SchemaStruct: &user{},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = c.Collection().DeleteMany(context.Background(), bson.NewDocument())
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			err := client.Disconnect()
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("should export the filtered documents, and import these back", func() {
+			input := strings.Join([]string{
+				`{"_id": {"$oid": "5b5b7d4e8d0a2b1c3e4f5a6b"}, "name": "Alice", "hits": 1}`,
+				`{"name": "Bob", "hits": {"$numberLong": "2"}}`,
+				``,
+			}, "\n")
+			result, err := Import(
+				context.Background(), c, strings.NewReader(input), ImportOptions{},
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*result).To(Equal(ImportResult{Inserted: 2}))
+
+			buf := &bytes.Buffer{}
+			count, err := Export(context.Background(), c, buf, ExportOptions{
+				Canonical: true,
+				Filter:    map[string]interface{}{"name": "Alice"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(count).To(Equal(int64(1)))
+			Expect(buf.String()).To(Equal(
+				`{"_id":{"$oid":"5b5b7d4e8d0a2b1c3e4f5a6b"},"name":"Alice",` +
+					`"hits":{"$numberInt":"1"}}` + "\n",
+			))
+
+			// The existing documents fail without upsert
+			_, err = Import(context.Background(), c, bytes.NewReader(buf.Bytes()), ImportOptions{})
+			Expect(err).To(HaveOccurred())
+
+			result, err = Import(context.Background(), c, buf, ImportOptions{Upsert: true})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(*result).To(Equal(ImportResult{Replaced: 1}))
+		})
+
+		It("should round-trip the documents as BSON", func() {
+			_, err := c.InsertOne(&user{Name: "Alice", Hits: 3})
+			Expect(err).ToNot(HaveOccurred())
+
+			buf := &bytes.Buffer{}
+			_, err = Export(context.Background(), c, buf, ExportOptions{Format: FormatBSON})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = c.Collection().DeleteMany(context.Background(), bson.NewDocument())
+			Expect(err).ToNot(HaveOccurred())
+
+			result, err := Import(context.Background(), c, buf, ImportOptions{
+				Format:    FormatBSON,
+				BatchSize: 1,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Inserted).To(Equal(int64(1)))
+
+			found, err := c.FindOne(&user{Name: "Alice"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(found.(*user).Hits).To(Equal(int64(3)))
+		})
+
+		It("should write the large documents in separate batches", func() {
+			// Together, these exceed the server's limit of 16MB per command
+			input := &bytes.Buffer{}
+			for _, size := range []int{7 * 1024 * 1024, 12 * 1024 * 1024} {
+				docBytes, err := bson.NewDocument(
+					bson.EC.String("name", strings.Repeat("a", size)),
+				).MarshalBSON()
+				Expect(err).ToNot(HaveOccurred())
+				input.Write(docBytes)
+			}
+
+			result, err := Import(context.Background(), c, input, ImportOptions{
+				Format: FormatBSON,
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result.Inserted).To(Equal(int64(2)))
+		})
+	})
+})